@@ -0,0 +1,22 @@
+package admin
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// dashboardFiles holds the static dashboard assets compiled into the binary
+//
+//go:embed ui
+var dashboardFiles embed.FS
+
+// dashboardHandler serves the embedded dashboard. The assets are public;
+// the data they show is loaded from the authenticated API.
+func dashboardHandler() http.Handler {
+	files, err := fs.Sub(dashboardFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(files))
+}
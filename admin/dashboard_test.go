@@ -0,0 +1,91 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/models"
+)
+
+func TestDashboardAssets(t *testing.T) {
+	s, _, _ := newTestServer(t)
+
+	tests := []struct {
+		path        string
+		contentType string
+	}{
+		{"/", "text/html"},
+		{"/app.js", "javascript"},
+		{"/style.css", "text/css"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Content-Type"), tt.contentType) {
+			t.Errorf("%s = %d %q, want 200 %s", tt.path, w.Code, w.Header().Get("Content-Type"), tt.contentType)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	s, store, _ := newMessagesServer(t)
+	s.Reload(&config.Config{
+		Admin: &config.AdminConfig{Token: testToken},
+		Routes: []config.RouteConfig{
+			{Path: "/webhook/orders", Queue: "orders"},
+			{Path: "/webhook/idle", Queue: "idle"},
+		},
+	})
+
+	// msg-2 was delivered; fail msg-1 into the dead-letter queue
+	if err := store.DeleteMessage("msg-2"); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	if _, err := store.UpdateDeliveryStatus("msg-1", kafkaTarget, models.StatusFailed, "rejected", time.Time{}); err != nil {
+		t.Fatalf("UpdateDeliveryStatus: %v", err)
+	}
+	if _, err := store.MoveToDeadLetter("msg-1"); err != nil {
+		t.Fatalf("MoveToDeadLetter: %v", err)
+	}
+
+	var stats struct {
+		Messages    map[string]int         `json:"messages"`
+		DeadLetters int                    `json:"dead_letters"`
+		Routes      []*models.RouteStats   `json:"routes"`
+		Window      string                 `json:"window"`
+		Worker      map[string]interface{} `json:"worker"`
+	}
+	w := serve(s, http.MethodGet, "/api/stats?window=30m")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	decode(t, w, &stats)
+
+	if stats.Messages["retrying"] != 1 || stats.DeadLetters != 1 || stats.Window != "30m0s" {
+		t.Errorf("stats = %v messages, %d dead letters, window %s, want 1 retrying, 1 dead letter, 30m0s",
+			stats.Messages, stats.DeadLetters, stats.Window)
+	}
+
+	// Routes are sorted and include configured routes without messages
+	if len(stats.Routes) != 2 || stats.Routes[0].Path != "/webhook/idle" || stats.Routes[1].Path != "/webhook/orders" {
+		t.Fatalf("routes = %+v, want /webhook/idle and /webhook/orders", stats.Routes)
+	}
+	if orders := stats.Routes[1]; orders.Retrying != 1 || orders.DeadLetters != 1 || orders.FailedAttempts != 1 {
+		t.Errorf("orders route = %+v, want 1 retrying, 1 dead letter and 1 failed attempt", orders)
+	}
+
+	// Worker settings are included without the duplicated message counts
+	if stats.Worker["running"] != true || stats.Worker["message_stats"] != nil {
+		t.Errorf("worker = %v, want its stats without message_stats", stats.Worker)
+	}
+
+	for _, window := range []string{"soon", "0s", "-1h"} {
+		if w := serve(s, http.MethodGet, "/api/stats?window="+window); w.Code != http.StatusBadRequest {
+			t.Errorf("window %s status = %d, want %d", window, w.Code, http.StatusBadRequest)
+		}
+	}
+}
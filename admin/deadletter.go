@@ -0,0 +1,200 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// listDeadLettersHandler lists dead letters matching the query filter
+func (s *Server) listDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseDeadLetterFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit, offset, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	deadLetters, err := s.storage.ListDeadLetters(filter, limit, offset)
+	if err != nil {
+		logger.Error("Failed to list dead letters", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list dead letters")
+		return
+	}
+
+	total, err := s.storage.CountDeadLetters(filter)
+	if err != nil {
+		logger.Error("Failed to count dead letters", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to count dead letters")
+		return
+	}
+
+	if deadLetters == nil {
+		deadLetters = []*models.DeadLetter{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"dead_letters": deadLetters,
+		"total":        total,
+		"limit":        limit,
+		"offset":       offset,
+	})
+}
+
+// getDeadLetterHandler returns a single dead letter with body, headers,
+// deliveries and attempt history
+func (s *Server) getDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	deadLetter, err := s.storage.GetDeadLetter(id)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "dead letter not found")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to get dead letter", "message_id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get dead letter")
+		return
+	}
+
+	attempts, err := s.storage.GetAttempts(id)
+	if err != nil {
+		logger.Error("Failed to get delivery attempts", "message_id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get attempts")
+		return
+	}
+	if attempts == nil {
+		attempts = []*models.Attempt{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"dead_letter": deadLetter,
+		"attempts":    attempts,
+	})
+}
+
+// replayDeadLetterHandler moves a single dead letter back to the queue
+func (s *Server) replayDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	s.replay(w, models.DeadLetterFilter{IDs: []string{mux.Vars(r)["id"]}})
+}
+
+// replayDeadLettersHandler moves dead letters matching the query filter back to the queue
+func (s *Server) replayDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseBulkDeadLetterFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.replay(w, filter)
+}
+
+// replay replays dead letters and reports the replayed IDs
+func (s *Server) replay(w http.ResponseWriter, filter models.DeadLetterFilter) {
+	replayed, err := s.storage.ReplayDeadLetters(filter)
+	if len(replayed) > 0 {
+		logger.Info("Replayed dead letters", "count", len(replayed))
+		s.notify()
+	}
+	if err != nil {
+		logger.Error("Failed to replay dead letters", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
+			"error":    "failed to replay dead letters",
+			"replayed": replayed,
+		})
+		return
+	}
+
+	if len(filter.IDs) == 1 && len(replayed) == 0 {
+		writeError(w, http.StatusNotFound, "dead letter not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"replayed": replayed,
+		"count":    len(replayed),
+	})
+}
+
+// deleteDeadLetterHandler permanently removes a single dead letter
+func (s *Server) deleteDeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	s.deleteDeadLetters(w, models.DeadLetterFilter{IDs: []string{mux.Vars(r)["id"]}})
+}
+
+// deleteDeadLettersHandler permanently removes dead letters matching the query filter
+func (s *Server) deleteDeadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseBulkDeadLetterFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.deleteDeadLetters(w, filter)
+}
+
+// deleteDeadLetters removes dead letters and reports how many were removed
+func (s *Server) deleteDeadLetters(w http.ResponseWriter, filter models.DeadLetterFilter) {
+	count, err := s.storage.DeleteDeadLetters(filter)
+	if err != nil {
+		logger.Error("Failed to delete dead letters", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to delete dead letters")
+		return
+	}
+
+	if len(filter.IDs) == 1 && count == 0 {
+		writeError(w, http.StatusNotFound, "dead letter not found")
+		return
+	}
+
+	logger.Info("Deleted dead letters", "count", count)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"deleted": count,
+	})
+}
+
+// parseDeadLetterFilter reads a dead-letter filter from query parameters:
+// id (repeatable), route, from, to (RFC3339) and error (substring)
+func parseDeadLetterFilter(r *http.Request) (models.DeadLetterFilter, error) {
+	query := r.URL.Query()
+
+	filter := models.DeadLetterFilter{
+		IDs:          query["id"],
+		Path:         query.Get("route"),
+		ErrorPattern: query.Get("error"),
+	}
+
+	var err error
+	if filter.From, err = parseTime(query.Get("from")); err != nil {
+		return filter, fmt.Errorf("invalid from: %w", err)
+	}
+	if filter.To, err = parseTime(query.Get("to")); err != nil {
+		return filter, fmt.Errorf("invalid to: %w", err)
+	}
+
+	return filter, nil
+}
+
+// parseBulkDeadLetterFilter reads a filter for bulk operations, which must
+// either narrow the selection or explicitly ask for all=true
+func parseBulkDeadLetterFilter(r *http.Request) (models.DeadLetterFilter, error) {
+	filter, err := parseDeadLetterFilter(r)
+	if err != nil {
+		return filter, err
+	}
+
+	if filter.IsEmpty() && r.URL.Query().Get("all") != "true" {
+		return filter, fmt.Errorf("a filter (id, route, from, to, error) or all=true is required")
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,230 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// listMessagesHandler lists queued messages matching the query filter
+func (s *Server) listMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseMessageFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit, offset, err := parsePage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	messages, err := s.storage.ListMessages(filter, limit, offset)
+	if err != nil {
+		logger.Error("Failed to list messages", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to list messages")
+		return
+	}
+
+	total, err := s.storage.CountMessages(filter)
+	if err != nil {
+		logger.Error("Failed to count messages", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to count messages")
+		return
+	}
+
+	if messages == nil {
+		messages = []*models.PendingMessage{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"messages": messages,
+		"total":    total,
+		"limit":    limit,
+		"offset":   offset,
+	})
+}
+
+// getMessageHandler returns a single message with body, headers, deliveries
+// and attempt history. The body is also returned as text when it is valid UTF-8.
+func (s *Server) getMessageHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	msg, err := s.storage.GetMessage(id)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "message not found")
+		return
+	}
+	if err != nil {
+		logger.Error("Failed to get message", "message_id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get message")
+		return
+	}
+
+	attempts, err := s.storage.GetAttempts(id)
+	if err != nil {
+		logger.Error("Failed to get delivery attempts", "message_id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get attempts")
+		return
+	}
+	if attempts == nil {
+		attempts = []*models.Attempt{}
+	}
+
+	response := map[string]interface{}{
+		"message":  msg,
+		"attempts": attempts,
+	}
+	if utf8.Valid(msg.Body) {
+		response["body_text"] = string(msg.Body)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// retryMessageHandler makes the undelivered destinations of a message due immediately
+func (s *Server) retryMessageHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	status, err := s.storage.RetryMessage(id)
+	if !checkMessageChange(w, id, "retry", err) {
+		return
+	}
+
+	logger.Info("Forced retry of message", "message_id", id)
+	s.notify()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":     id,
+		"status": status,
+	})
+}
+
+// cancelMessageHandler stops delivery of a message
+func (s *Server) cancelMessageHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	status, err := s.storage.CancelMessage(id)
+	if !checkMessageChange(w, id, "cancel", err) {
+		return
+	}
+
+	logger.Info("Cancelled message", "message_id", id)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":     id,
+		"status": status,
+	})
+}
+
+// checkMessageChange writes the error response of a retry or cancel
+// operation and reports whether it succeeded
+func checkMessageChange(w http.ResponseWriter, id, operation string, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, storage.ErrNotFound):
+		writeError(w, http.StatusNotFound, "message not found")
+	case errors.Is(err, storage.ErrNothingChanged):
+		writeError(w, http.StatusConflict, fmt.Sprintf("message has no deliveries to %s", operation))
+	default:
+		logger.Error("Failed to change message", "operation", operation, "message_id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("failed to %s message", operation))
+	}
+	return false
+}
+
+// deleteMessageHandler permanently removes a message and its deliveries
+func (s *Server) deleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	count, err := s.storage.CountMessages(models.MessageFilter{IDs: []string{id}})
+	if err != nil {
+		logger.Error("Failed to delete message", "message_id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to delete message")
+		return
+	}
+	if count == 0 {
+		writeError(w, http.StatusNotFound, "message not found")
+		return
+	}
+
+	if err := s.storage.DeleteMessage(id); err != nil {
+		logger.Error("Failed to delete message", "message_id", id, "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to delete message")
+		return
+	}
+
+	logger.Info("Deleted message", "message_id", id)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"deleted": id,
+	})
+}
+
+// requeueMessagesHandler restarts delivery of messages matching the query filter
+func (s *Server) requeueMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseMessageFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if filter.IsEmpty() && r.URL.Query().Get("all") != "true" {
+		writeError(w, http.StatusBadRequest, "a filter (id, route, queue, status, from, to) or all=true is required")
+		return
+	}
+
+	requeued, err := s.storage.RequeueMessages(filter)
+	if len(requeued) > 0 {
+		logger.Info("Requeued messages", "count", len(requeued))
+		s.notify()
+	}
+	if err != nil {
+		logger.Error("Failed to requeue messages", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]interface{}{
+			"error":    "failed to requeue messages",
+			"requeued": requeued,
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"requeued": requeued,
+		"count":    len(requeued),
+	})
+}
+
+// parseMessageFilter reads a message filter from query parameters:
+// id (repeatable), route, queue, status, from and to (RFC3339 receive time)
+func parseMessageFilter(r *http.Request) (models.MessageFilter, error) {
+	query := r.URL.Query()
+
+	filter := models.MessageFilter{
+		IDs:    query["id"],
+		Path:   query.Get("route"),
+		Queue:  query.Get("queue"),
+		Status: models.MessageStatus(query.Get("status")),
+	}
+
+	switch filter.Status {
+	case "", models.StatusPending, models.StatusRetrying, models.StatusSent, models.StatusFailed, models.StatusCancelled:
+	default:
+		return filter, fmt.Errorf("invalid status: %s", filter.Status)
+	}
+
+	var err error
+	if filter.From, err = parseTime(query.Get("from")); err != nil {
+		return filter, fmt.Errorf("invalid from: %w", err)
+	}
+	if filter.To, err = parseTime(query.Get("to")); err != nil {
+		return filter, fmt.Errorf("invalid to: %w", err)
+	}
+
+	return filter, nil
+}
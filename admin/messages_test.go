@@ -0,0 +1,236 @@
+package admin
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/storage"
+)
+
+var kafkaTarget = models.DeliveryTarget{Type: models.TargetKafka, Topic: "orders"}
+
+// saveMessage stores a message for the orders route received age ago
+func saveMessage(t *testing.T, s storage.Storage, id string, age time.Duration) {
+	t.Helper()
+
+	received := time.Now().Add(-age).UTC()
+	if err := s.SaveMessage(&models.WebhookMessage{
+		ID:           id,
+		Path:         "/webhook/orders",
+		Queue:        "orders",
+		Body:         []byte(`{"id":"` + id + `"}`),
+		Headers:      map[string]string{"Content-Type": "application/json"},
+		Timestamp:    received,
+		Status:       models.StatusPending,
+		Destinations: []models.DeliveryTarget{kafkaTarget},
+		CreatedAt:    received,
+		UpdatedAt:    received,
+	}); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+}
+
+// newMessagesServer creates a server with a pending message msg-1, a sent
+// message msg-2 and a retrying message msg-3 with one failed attempt
+func newMessagesServer(t *testing.T) (*Server, storage.Storage, *recordingWorker) {
+	t.Helper()
+
+	s, store, worker := newTestServer(t)
+	saveMessage(t, store, "msg-1", 3*time.Minute)
+	saveMessage(t, store, "msg-2", 2*time.Minute)
+	saveMessage(t, store, "msg-3", time.Minute)
+
+	if _, err := store.UpdateDeliveryStatus("msg-2", kafkaTarget, models.StatusSent, "", time.Time{}); err != nil {
+		t.Fatalf("UpdateDeliveryStatus: %v", err)
+	}
+	if _, err := store.UpdateDeliveryStatus("msg-3", kafkaTarget, models.StatusRetrying, "timeout", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("UpdateDeliveryStatus: %v", err)
+	}
+	if err := store.RecordAttempt(&models.Attempt{
+		MessageID:   "msg-3",
+		Path:        "/webhook/orders",
+		Target:      kafkaTarget,
+		AttemptedAt: time.Now(),
+		Error:       "timeout",
+	}); err != nil {
+		t.Fatalf("RecordAttempt: %v", err)
+	}
+	return s, store, worker
+}
+
+func TestListMessages(t *testing.T) {
+	s, _, _ := newMessagesServer(t)
+
+	var page struct {
+		Messages []*models.PendingMessage `json:"messages"`
+		Total    int                      `json:"total"`
+		Limit    int                      `json:"limit"`
+		Offset   int                      `json:"offset"`
+	}
+	w := serve(s, http.MethodGet, "/api/messages?route=/webhook/orders&limit=1&offset=1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	decode(t, w, &page)
+	if page.Total != 3 || page.Limit != 1 || page.Offset != 1 || len(page.Messages) != 1 {
+		t.Errorf("page = %d messages of %d, limit %d offset %d, want 1 of 3, limit 1 offset 1",
+			len(page.Messages), page.Total, page.Limit, page.Offset)
+	}
+
+	w = serve(s, http.MethodGet, "/api/messages?status=retrying")
+	decode(t, w, &page)
+	if page.Total != 1 || len(page.Messages) != 1 || page.Messages[0].ID != "msg-3" {
+		t.Errorf("retrying messages = %d of %d, want msg-3", len(page.Messages), page.Total)
+	}
+
+	// An empty result is a list, not null
+	w = serve(s, http.MethodGet, "/api/messages?queue=unknown")
+	if w.Code != http.StatusOK || !jsonContains(w, `"messages":[]`) {
+		t.Errorf("empty list = %d %s, want an empty messages list", w.Code, w.Body.String())
+	}
+
+	for _, query := range []string{"status=unknown", "limit=0", "offset=-1", "from=yesterday"} {
+		if w := serve(s, http.MethodGet, "/api/messages?"+query); w.Code != http.StatusBadRequest {
+			t.Errorf("%s status = %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestGetMessage(t *testing.T) {
+	s, _, _ := newMessagesServer(t)
+
+	var response struct {
+		Message  *models.PendingMessage `json:"message"`
+		Attempts []*models.Attempt      `json:"attempts"`
+		BodyText string                 `json:"body_text"`
+	}
+	w := serve(s, http.MethodGet, "/api/messages/msg-3")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	decode(t, w, &response)
+	switch {
+	case response.Message.ID != "msg-3" || response.Message.Status != models.StatusRetrying:
+		t.Errorf("message = %s %s, want retrying msg-3", response.Message.ID, response.Message.Status)
+	case len(response.Message.Deliveries) != 1 || response.Message.Deliveries[0].Error != "timeout":
+		t.Errorf("deliveries = %v, want the retrying delivery", response.Message.Deliveries)
+	case len(response.Attempts) != 1 || response.Attempts[0].Error != "timeout":
+		t.Errorf("attempts = %v, want the failed attempt", response.Attempts)
+	case response.BodyText != `{"id":"msg-3"}`:
+		t.Errorf("body text = %q, want the body", response.BodyText)
+	}
+
+	// Messages without attempts return an empty list
+	w = serve(s, http.MethodGet, "/api/messages/msg-1")
+	if w.Code != http.StatusOK || !jsonContains(w, `"attempts":[]`) {
+		t.Errorf("msg-1 = %d %s, want no attempts", w.Code, w.Body.String())
+	}
+
+	if w := serve(s, http.MethodGet, "/api/messages/unknown"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown message status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestRetryAndCancelMessage(t *testing.T) {
+	s, store, worker := newMessagesServer(t)
+
+	tests := []struct {
+		name       string
+		target     string
+		want       int
+		wantStatus models.MessageStatus
+	}{
+		{"retry scheduled", "/api/messages/msg-3/retry", http.StatusOK, models.StatusRetrying},
+		{"retry delivered", "/api/messages/msg-2/retry", http.StatusConflict, ""},
+		{"retry unknown", "/api/messages/unknown/retry", http.StatusNotFound, ""},
+		{"cancel pending", "/api/messages/msg-1/cancel", http.StatusOK, models.StatusCancelled},
+		{"cancel cancelled", "/api/messages/msg-1/cancel", http.StatusConflict, ""},
+		{"cancel delivered", "/api/messages/msg-2/cancel", http.StatusConflict, ""},
+		{"cancel unknown", "/api/messages/unknown/cancel", http.StatusNotFound, ""},
+		{"retry cancelled", "/api/messages/msg-1/retry", http.StatusOK, models.StatusPending},
+	}
+	for _, tt := range tests {
+		w := serve(s, http.MethodPost, tt.target)
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.want, w.Body.String())
+			continue
+		}
+		if tt.wantStatus == "" {
+			continue
+		}
+		var response struct {
+			Status models.MessageStatus `json:"status"`
+		}
+		decode(t, w, &response)
+		if response.Status != tt.wantStatus {
+			t.Errorf("%s: message status = %s, want %s", tt.name, response.Status, tt.wantStatus)
+		}
+	}
+
+	// A retried message is due immediately and the worker is woken up
+	msg, err := store.GetMessage("msg-3")
+	if err != nil || !msg.Deliveries[0].Due(time.Now()) {
+		t.Errorf("msg-3 = %v, %v, want a due delivery", msg, err)
+	}
+	if n := worker.notifications(); n != 2 {
+		t.Errorf("worker notified %d times, want once per retry", n)
+	}
+}
+
+func TestRequeueMessages(t *testing.T) {
+	s, store, worker := newMessagesServer(t)
+
+	// Requeuing every message must be asked for explicitly
+	if w := serve(s, http.MethodPost, "/api/messages/requeue"); w.Code != http.StatusBadRequest {
+		t.Errorf("unfiltered status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := serve(s, http.MethodPost, "/api/messages/requeue?status=unknown"); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid filter status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if n := worker.notifications(); n != 0 {
+		t.Errorf("worker notified %d times for rejected requests", n)
+	}
+
+	var response struct {
+		Requeued []string `json:"requeued"`
+		Count    int      `json:"count"`
+	}
+	w := serve(s, http.MethodPost, "/api/messages/requeue?status=retrying")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	decode(t, w, &response)
+	if response.Count != 1 || len(response.Requeued) != 1 || response.Requeued[0] != "msg-3" {
+		t.Errorf("requeued %v (%d), want msg-3", response.Requeued, response.Count)
+	}
+	msg, err := store.GetMessage("msg-3")
+	if err != nil || msg.Status != models.StatusPending || msg.Deliveries[0].Retries != 0 {
+		t.Errorf("msg-3 = %v, %v, want pending with no retries", msg, err)
+	}
+	if n := worker.notifications(); n != 1 {
+		t.Errorf("worker notified %d times, want once", n)
+	}
+
+	// Delivered messages are not requeued
+	w = serve(s, http.MethodPost, "/api/messages/requeue?all=true")
+	decode(t, w, &response)
+	if response.Count != 2 {
+		t.Errorf("requeued %v, want msg-1 and msg-3", response.Requeued)
+	}
+}
+
+func TestDeleteMessage(t *testing.T) {
+	s, store, _ := newMessagesServer(t)
+
+	if w := serve(s, http.MethodDelete, "/api/messages/msg-2"); w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := store.GetMessage("msg-2"); err != storage.ErrNotFound {
+		t.Errorf("GetMessage after delete = %v, want %v", err, storage.ErrNotFound)
+	}
+	if w := serve(s, http.MethodDelete, "/api/messages/msg-2"); w.Code != http.StatusNotFound {
+		t.Errorf("second delete status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
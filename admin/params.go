@@ -0,0 +1,50 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Pagination limits for list endpoints
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// parsePage reads limit and offset query parameters
+func parsePage(r *http.Request) (int, int, error) {
+	query := r.URL.Query()
+
+	limit := defaultPageSize
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return 0, 0, fmt.Errorf("invalid limit: %s", value)
+		}
+		limit = parsed
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	offset := 0
+	if value := query.Get("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return 0, 0, fmt.Errorf("invalid offset: %s", value)
+		}
+		offset = parsed
+	}
+
+	return limit, offset, nil
+}
+
+// parseTime parses an optional RFC3339 timestamp
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,34 @@
+package admin
+
+import (
+	"net/http"
+)
+
+// reloadHandler re-reads the configuration file and applies it. An invalid
+// configuration is rejected and the running one is kept.
+func (s *Server) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if s.reloader == nil {
+		writeError(w, http.StatusNotImplemented, "configuration reload is not available")
+		return
+	}
+
+	changes, err := s.reloader.Reload()
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":   err.Error(),
+			"changes": changes,
+		})
+		return
+	}
+
+	restartRequired := false
+	for _, change := range changes {
+		restartRequired = restartRequired || change.RestartRequired
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"reloaded":         true,
+		"changes":          changes,
+		"restart_required": restartRequired,
+	})
+}
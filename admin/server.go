@@ -0,0 +1,158 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/logging"
+	"github.com/expai/messagebridge/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// logger is the admin API component logger
+var logger = logging.For("admin")
+
+// Worker is the part of the delivery worker used by the admin API. It is
+// notified when messages have been put back into the queue.
+type Worker interface {
+	Notify()
+	GetStats() (map[string]interface{}, error)
+}
+
+// Reloader re-reads the configuration file and applies it to the running
+// process, returning the settings that changed
+type Reloader interface {
+	Reload() ([]config.Change, error)
+}
+
+// Server represents the authenticated admin API listener
+type Server struct {
+	server   *http.Server
+	router   *mux.Router
+	config   atomic.Pointer[config.Config]
+	storage  storage.Storage
+	worker   Worker
+	reloader Reloader
+}
+
+// NewServer creates a new admin API server
+func NewServer(cfg *config.Config, storage storage.Storage, worker Worker) *Server {
+	router := mux.NewRouter()
+
+	server := &Server{
+		router:  router,
+		storage: storage,
+		worker:  worker,
+	}
+	server.config.Store(cfg)
+
+	server.setupRoutes()
+
+	server.server = &http.Server{
+		Addr:         fmt.Sprintf("%s:%d", cfg.Admin.Host, cfg.Admin.Port),
+		Handler:      router,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+
+	return server
+}
+
+// setupRoutes configures admin API routes
+func (s *Server) setupRoutes() {
+	api := s.router.PathPrefix("/api").Subrouter()
+	api.Use(s.authMiddleware)
+
+	// Queued messages
+	api.HandleFunc("/messages", s.listMessagesHandler).Methods("GET")
+	api.HandleFunc("/messages/requeue", s.requeueMessagesHandler).Methods("POST")
+	api.HandleFunc("/messages/{id}", s.getMessageHandler).Methods("GET")
+	api.HandleFunc("/messages/{id}", s.deleteMessageHandler).Methods("DELETE")
+	api.HandleFunc("/messages/{id}/retry", s.retryMessageHandler).Methods("POST")
+	api.HandleFunc("/messages/{id}/cancel", s.cancelMessageHandler).Methods("POST")
+
+	// Dead-letter queue
+	api.HandleFunc("/dlq", s.listDeadLettersHandler).Methods("GET")
+	api.HandleFunc("/dlq", s.deleteDeadLettersHandler).Methods("DELETE")
+	api.HandleFunc("/dlq/replay", s.replayDeadLettersHandler).Methods("POST")
+	api.HandleFunc("/dlq/{id}", s.getDeadLetterHandler).Methods("GET")
+	api.HandleFunc("/dlq/{id}", s.deleteDeadLetterHandler).Methods("DELETE")
+	api.HandleFunc("/dlq/{id}/replay", s.replayDeadLetterHandler).Methods("POST")
+
+	// Dashboard data
+	api.HandleFunc("/stats", s.statsHandler).Methods("GET")
+
+	// Configuration
+	api.HandleFunc("/reload", s.reloadHandler).Methods("POST")
+
+	// Dashboard UI, which asks for the token and calls the API above
+	s.router.PathPrefix("/").Handler(dashboardHandler())
+}
+
+// authMiddleware requires the configured admin token as a bearer token
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Load().Admin.Token)) != 1 {
+			logger.Warn("Unauthorized request", "method", r.Method, "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SetReloader sets the component that reloads the configuration
+func (s *Server) SetReloader(reloader Reloader) {
+	s.reloader = reloader
+}
+
+// Reload replaces the admin token and the configured routes. The listener
+// settings of cfg are ignored.
+func (s *Server) Reload(cfg *config.Config) {
+	s.config.Store(cfg)
+}
+
+// notify wakes up the worker after messages were requeued
+func (s *Server) notify() {
+	if s.worker != nil {
+		s.worker.Notify()
+	}
+}
+
+// Start starts the admin API server
+func (s *Server) Start() error {
+	cfg := s.config.Load()
+	logger.Info("Starting admin API", "host", cfg.Admin.Host, "port", cfg.Admin.Port)
+	return s.server.ListenAndServe()
+}
+
+// Shutdown gracefully shuts down the admin API server
+func (s *Server) Shutdown(ctx context.Context) error {
+	logger.Info("Shutting down admin API")
+	return s.server.Shutdown(ctx)
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": message,
+	})
+}
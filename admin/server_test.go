@@ -0,0 +1,119 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/storage"
+)
+
+const testToken = "admin-s3cret"
+
+// recordingWorker counts the notifications sent to the worker
+type recordingWorker struct {
+	mu       sync.Mutex
+	notified int
+}
+
+// Notify records a notification
+func (w *recordingWorker) Notify() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.notified++
+}
+
+// GetStats returns the statistics of a running worker
+func (w *recordingWorker) GetStats() (map[string]interface{}, error) {
+	return map[string]interface{}{"running": true, "message_stats": map[string]int{}}, nil
+}
+
+// notifications returns the number of notifications
+func (w *recordingWorker) notifications() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.notified
+}
+
+// newTestServer creates an admin server on in-memory storage
+func newTestServer(t *testing.T) (*Server, storage.Storage, *recordingWorker) {
+	t.Helper()
+
+	store := storage.NewMemoryStorage()
+	worker := &recordingWorker{}
+	s := NewServer(&config.Config{
+		Admin: &config.AdminConfig{Host: "127.0.0.1", Port: 0, Token: testToken},
+	}, store, worker)
+	return s, store, worker
+}
+
+// serve sends an authenticated request through the server's router
+func serve(s *Server, method, target string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	r.Header.Set("Authorization", "Bearer "+testToken)
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+	return w
+}
+
+// decode decodes the JSON response body of w into v
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	s, _, _ := newTestServer(t)
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"missing", "", http.StatusUnauthorized},
+		{"token without scheme", testToken, http.StatusUnauthorized},
+		{"basic scheme", "Basic " + testToken, http.StatusUnauthorized},
+		{"lowercase scheme", "bearer " + testToken, http.StatusUnauthorized},
+		{"wrong token", "Bearer other", http.StatusUnauthorized},
+		{"empty token", "Bearer ", http.StatusUnauthorized},
+		{"valid", "Bearer " + testToken, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/messages", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			s.router.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+
+	// A reloaded token replaces the previous one
+	s.Reload(&config.Config{Admin: &config.AdminConfig{Token: "rotated"}})
+	if w := serve(s, http.MethodGet, "/api/messages"); w.Code != http.StatusUnauthorized {
+		t.Errorf("old token status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// The dashboard itself is served without a token
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("dashboard status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+// jsonContains reports whether the response body of w contains fragment
+func jsonContains(w *httptest.ResponseRecorder, fragment string) bool {
+	return strings.Contains(w.Body.String(), fragment)
+}
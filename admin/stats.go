@@ -0,0 +1,85 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/expai/messagebridge/models"
+)
+
+// defaultStatsWindow is the period delivery activity is reported for
+const defaultStatsWindow = time.Hour
+
+// statsHandler returns queue counts, per-route activity and worker settings
+// for the dashboard
+func (s *Server) statsHandler(w http.ResponseWriter, r *http.Request) {
+	window := defaultStatsWindow
+	if value := r.URL.Query().Get("window"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid window: %s", value))
+			return
+		}
+		window = parsed
+	}
+
+	messageStats, err := s.storage.GetMessageStats()
+	if err != nil {
+		logger.Error("Failed to get message stats", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get message stats")
+		return
+	}
+
+	deadLetters, err := s.storage.CountDeadLetters(models.DeadLetterFilter{})
+	if err != nil {
+		logger.Error("Failed to count dead letters", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to count dead letters")
+		return
+	}
+
+	routes, err := s.storage.GetRouteStats(time.Now().Add(-window))
+	if err != nil {
+		logger.Error("Failed to get route stats", "error", err)
+		writeError(w, http.StatusInternalServerError, "failed to get route stats")
+		return
+	}
+
+	response := map[string]interface{}{
+		"messages":     messageStats,
+		"dead_letters": deadLetters,
+		"routes":       s.withConfiguredRoutes(routes),
+		"window":       window.String(),
+	}
+
+	if s.worker != nil {
+		workerStats, err := s.worker.GetStats()
+		if err != nil {
+			logger.Error("Failed to get worker stats", "error", err)
+		} else {
+			delete(workerStats, "message_stats")
+			response["worker"] = workerStats
+		}
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// withConfiguredRoutes adds configured routes without any stored data, so
+// idle routes are listed too
+func (s *Server) withConfiguredRoutes(routes []*models.RouteStats) []*models.RouteStats {
+	known := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		known[route.Path] = true
+	}
+
+	for _, route := range s.config.Load().Routes {
+		if !known[route.Path] {
+			routes = append(routes, &models.RouteStats{Path: route.Path})
+		}
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Path < routes[j].Path })
+
+	return routes
+}
@@ -0,0 +1,242 @@
+'use strict';
+
+// Dashboard for the MessageBridge admin API. The token is kept in session
+// storage and sent as a bearer token with every request.
+
+const REFRESH_INTERVAL = 5000;
+const RECENT_FAILURES = 20;
+const TOKEN_KEY = 'messagebridge-admin-token';
+
+let refreshTimer = null;
+
+function $(id) {
+  return document.getElementById(id);
+}
+
+function token() {
+  return sessionStorage.getItem(TOKEN_KEY);
+}
+
+async function api(method, path) {
+  const response = await fetch('api' + path, {
+    method: method,
+    headers: { 'Authorization': 'Bearer ' + token() },
+  });
+
+  if (response.status === 401) {
+    logout('Invalid token');
+    throw new Error('unauthorized');
+  }
+
+  const body = await response.json();
+  if (!response.ok) {
+    throw new Error(body.error || response.statusText);
+  }
+  return body;
+}
+
+function cell(text, className) {
+  const td = document.createElement('td');
+  td.textContent = text;
+  if (className) {
+    td.className = className;
+  }
+  return td;
+}
+
+function button(label, onClick, className) {
+  const b = document.createElement('button');
+  b.type = 'button';
+  b.textContent = label;
+  if (className) {
+    b.className = className;
+  }
+  b.addEventListener('click', onClick);
+  return b;
+}
+
+function isSet(time) {
+  return time && !time.startsWith('0001-');
+}
+
+function age(time) {
+  if (!isSet(time)) {
+    return '-';
+  }
+
+  const seconds = Math.max(0, Math.round((Date.now() - new Date(time).getTime()) / 1000));
+  if (seconds < 60) {
+    return seconds + 's';
+  }
+  if (seconds < 3600) {
+    return Math.floor(seconds / 60) + 'm ' + (seconds % 60) + 's';
+  }
+  return Math.floor(seconds / 3600) + 'h ' + Math.floor((seconds % 3600) / 60) + 'm';
+}
+
+function windowMinutes(window) {
+  const match = /^(?:(\d+)h)?(?:(\d+)m)?(?:(\d+)s)?$/.exec(window);
+  if (!match) {
+    return 60;
+  }
+  return (Number(match[1] || 0) * 60) + Number(match[2] || 0) + (Number(match[3] || 0) / 60);
+}
+
+function showError(message) {
+  const error = $('error');
+  error.textContent = message;
+  error.hidden = !message;
+}
+
+async function action(confirmation, method, path) {
+  if (confirmation && !confirm(confirmation)) {
+    return;
+  }
+
+  try {
+    await api(method, path);
+    showError('');
+  } catch (err) {
+    showError(err.message);
+  }
+  refresh();
+}
+
+function renderStats(stats) {
+  const messages = stats.messages || {};
+  $('pending').textContent = messages.pending || 0;
+  $('retrying').textContent = messages.retrying || 0;
+  $('cancelled').textContent = messages.cancelled || 0;
+  $('dead-letters').textContent = stats.dead_letters;
+
+  if (stats.worker) {
+    $('worker').textContent = (stats.worker.running ? 'running' : 'stopped') +
+      ' ×' + stats.worker.concurrency;
+  }
+
+  const minutes = windowMinutes(stats.window);
+  const rows = $('routes');
+  rows.replaceChildren();
+
+  for (const route of stats.routes) {
+    const query = '?route=' + encodeURIComponent(route.path);
+    const tr = document.createElement('tr');
+
+    tr.append(
+      cell(route.path),
+      cell(route.pending),
+      cell(route.retrying),
+      cell(age(route.oldest_pending)),
+      cell((route.delivered / minutes).toFixed(2)),
+      cell(route.failed_attempts, route.failed_attempts ? 'error' : ''),
+      cell(route.delivered + route.failed_attempts ? route.avg_duration_ms + ' ms' : '-'),
+      cell(route.dead_letters, route.dead_letters ? 'error' : ''),
+    );
+
+    const actions = cell('', 'actions');
+    if (route.dead_letters) {
+      actions.append(
+        button('Replay', () => action(
+          'Replay ' + route.dead_letters + ' dead letters of ' + route.path + '?', 'POST', '/dlq/replay' + query)),
+        ' ',
+        button('Purge', () => action(
+          'Permanently delete ' + route.dead_letters + ' dead letters of ' + route.path + '?', 'DELETE', '/dlq' + query),
+          'danger'),
+      );
+    }
+    tr.append(actions);
+
+    rows.append(tr);
+  }
+}
+
+function renderFailures(result) {
+  const rows = $('failures');
+  rows.replaceChildren();
+
+  if (result.dead_letters.length === 0) {
+    const tr = document.createElement('tr');
+    const td = cell('No dead letters', 'muted');
+    td.colSpan = 6;
+    tr.append(td);
+    rows.append(tr);
+    return;
+  }
+
+  for (const deadLetter of result.dead_letters) {
+    const path = '/dlq/' + encodeURIComponent(deadLetter.id);
+    const tr = document.createElement('tr');
+
+    tr.append(
+      cell(new Date(deadLetter.failed_at).toLocaleString()),
+      cell(deadLetter.path),
+      cell(deadLetter.id),
+      cell(deadLetter.retries),
+      cell(deadLetter.error || '', 'error'),
+    );
+
+    const actions = cell('', 'actions');
+    actions.append(
+      button('Replay', () => action('', 'POST', path + '/replay')),
+      ' ',
+      button('Delete', () => action('Permanently delete ' + deadLetter.id + '?', 'DELETE', path), 'danger'),
+    );
+    tr.append(actions);
+
+    rows.append(tr);
+  }
+}
+
+async function refresh() {
+  clearTimeout(refreshTimer);
+
+  try {
+    const [stats, failures] = await Promise.all([
+      api('GET', '/stats?window=' + encodeURIComponent($('window').value)),
+      api('GET', '/dlq?limit=' + RECENT_FAILURES),
+    ]);
+
+    renderStats(stats);
+    renderFailures(failures);
+    $('updated').textContent = 'Updated ' + new Date().toLocaleTimeString();
+  } catch (err) {
+    if (!token()) {
+      return;
+    }
+    showError(err.message);
+  }
+
+  refreshTimer = setTimeout(refresh, REFRESH_INTERVAL);
+}
+
+function show(signedIn) {
+  $('login').hidden = signedIn;
+  $('controls').hidden = !signedIn;
+  $('dashboard').hidden = !signedIn;
+}
+
+function logout(message) {
+  clearTimeout(refreshTimer);
+  sessionStorage.removeItem(TOKEN_KEY);
+  show(false);
+  showError(message || '');
+}
+
+$('login').addEventListener('submit', (event) => {
+  event.preventDefault();
+  sessionStorage.setItem(TOKEN_KEY, $('token').value);
+  $('token').value = '';
+  show(true);
+  showError('');
+  refresh();
+});
+
+$('logout').addEventListener('click', () => logout());
+$('window').addEventListener('change', refresh);
+
+if (token()) {
+  show(true);
+  refresh();
+} else {
+  show(false);
+}
@@ -0,0 +1,246 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/logging"
+	"github.com/expai/messagebridge/metrics"
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/retry"
+	"github.com/expai/messagebridge/tracing"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// logger is the AMQP component logger
+var logger = logging.For("amqp")
+
+// errUnroutable is returned for messages no queue is bound to receive. Such
+// a message is returned by the broker every time it is published, so it is
+// a permanent failure.
+var errUnroutable = errors.New("message is unroutable")
+
+// Producer publishes messages to an AMQP 0-9-1 broker such as RabbitMQ.
+// Messages are published one at a time on a single confirm-mode channel, so
+// a returned message and a publisher confirm always belong to the message
+// being published.
+type Producer struct {
+	config *config.AMQPConfig
+
+	mu      sync.Mutex
+	conn    *amqp091.Connection
+	channel *amqp091.Channel
+	returns chan amqp091.Return
+	closes  chan *amqp091.Error
+}
+
+// NewProducer creates a new AMQP producer. The connection is opened on first
+// use and reopened after it or the channel is closed, so messages are queued
+// for retry while the broker is down.
+func NewProducer(cfg *config.AMQPConfig) *Producer {
+	return &Producer{config: cfg}
+}
+
+// Send publishes a message to the exchange of target with its routing key,
+// or with the message queue as routing key when the target has none
+func (p *Producer) Send(ctx context.Context, target models.DeliveryTarget, msg *models.WebhookMessage) (*models.DeliveryResult, error) {
+	routingKey := target.RoutingKey
+	if routingKey == "" {
+		routingKey = msg.Queue
+	}
+	return p.PublishMessage(ctx, target.Topic, routingKey, msg)
+}
+
+// PublishMessage publishes a persistent message to exchange and waits for
+// the broker to confirm it. Messages are published as mandatory, so a
+// message no queue receives fails permanently instead of being dropped. A
+// message the broker rejects (negative confirm) is retried. Headers are
+// passed on as AMQP headers, together with the trace context of ctx.
+func (p *Producer) PublishMessage(ctx context.Context, exchange, routingKey string, msg *models.WebhookMessage) (result *models.DeliveryResult, err error) {
+	ctx, span := tracing.Start(ctx, "amqp.publish "+exchange,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.destination.name", exchange),
+			attribute.String("messaging.rabbitmq.destination.routing_key", routingKey),
+			attribute.String("messaging.message.id", msg.ID),
+		),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	headers := make(tracing.HeaderCarrier, len(msg.Headers)+1)
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+	tracing.Inject(ctx, headers)
+
+	publishing := amqp091.Publishing{
+		Headers:      make(amqp091.Table, len(headers)+2),
+		DeliveryMode: amqp091.Persistent,
+		MessageId:    msg.ID,
+		Timestamp:    msg.Timestamp,
+		AppId:        "messagebridge",
+		Body:         msg.Body,
+	}
+	for key, value := range headers {
+		publishing.Headers[key] = value
+		if strings.EqualFold(key, "Content-Type") {
+			publishing.ContentType = value
+		}
+	}
+	publishing.Headers["X-Webhook-ID"] = msg.ID
+	publishing.Headers["X-Webhook-Path"] = msg.Path
+
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+
+	if err := p.publish(ctx, exchange, routingKey, publishing); err != nil {
+		metrics.ClientErrors.WithLabelValues("amqp", "send").Inc()
+		err = fmt.Errorf("failed to publish message to AMQP exchange %q: %w", exchange, err)
+		if isPermanent(err) {
+			return nil, retry.Permanent(err)
+		}
+		return nil, err
+	}
+
+	logger.Debug("Message published to AMQP",
+		"message_id", msg.ID, "destination", exchange, "routing_key", routingKey)
+
+	return &models.DeliveryResult{}, nil
+}
+
+// publish publishes a message and waits for its confirm. The channel is
+// closed after a failure so the next message is published on a fresh one.
+func (p *Producer) publish(ctx context.Context, exchange, routingKey string, publishing amqp091.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.open(); err != nil {
+		return err
+	}
+
+	confirmation, err := p.channel.PublishWithDeferredConfirmWithContext(ctx, exchange, routingKey, true, false, publishing)
+	if err != nil {
+		p.closeChannel()
+		return err
+	}
+
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		// A confirm that arrives late would be taken for the next message
+		p.closeChannel()
+		return fmt.Errorf("no publisher confirm: %w", err)
+	}
+
+	// The broker sends a returned message before its confirm, and a channel
+	// error before the pending confirms are dropped
+	select {
+	case returned, ok := <-p.returns:
+		if ok {
+			return fmt.Errorf("%w: %d %s", errUnroutable, returned.ReplyCode, returned.ReplyText)
+		}
+	default:
+	}
+	if !acked {
+		select {
+		case closeErr, ok := <-p.closes:
+			if ok && closeErr != nil {
+				p.closeChannel()
+				return closeErr
+			}
+		default:
+		}
+		p.closeChannel()
+		return fmt.Errorf("message was rejected by the broker")
+	}
+	return nil
+}
+
+// open connects to the broker and opens a confirm-mode channel unless both
+// are open already
+func (p *Producer) open() error {
+	if p.channel != nil && !p.channel.IsClosed() {
+		return nil
+	}
+
+	if p.conn == nil || p.conn.IsClosed() {
+		properties := amqp091.NewConnectionProperties()
+		properties.SetClientConnectionName("messagebridge")
+
+		conn, err := amqp091.DialConfig(p.config.URL, amqp091.Config{
+			Dial:       amqp091.DefaultDial(p.config.Timeout),
+			Properties: properties,
+			Locale:     "en_US",
+		})
+		if err != nil {
+			return fmt.Errorf("failed to connect: %w", err)
+		}
+		p.conn = conn
+		logger.Info("Connected to AMQP broker", "url", config.RedactURL(p.config.URL))
+	}
+
+	channel, err := p.conn.Channel()
+	if err != nil {
+		p.conn.Close()
+		return fmt.Errorf("failed to open channel: %w", err)
+	}
+	if err := channel.Confirm(false); err != nil {
+		channel.Close()
+		return fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	p.channel = channel
+	p.returns = channel.NotifyReturn(make(chan amqp091.Return, 1))
+	p.closes = channel.NotifyClose(make(chan *amqp091.Error, 1))
+	return nil
+}
+
+// closeChannel closes the channel so the next publish opens a new one
+func (p *Producer) closeChannel() {
+	if p.channel != nil {
+		p.channel.Close()
+		p.channel = nil
+	}
+}
+
+// isPermanent reports whether the broker refused the message itself, e.g.
+// because it exceeds the maximum message size, or returned it as unroutable.
+// Missing exchanges and refused access are retried since an operator can fix
+// them.
+func isPermanent(err error) bool {
+	if errors.Is(err, errUnroutable) {
+		return true
+	}
+	var amqpErr *amqp091.Error
+	return errors.As(err, &amqpErr) && amqpErr.Code == amqp091.PreconditionFailed
+}
+
+// HealthCheck checks if the broker is available, connecting if needed
+func (p *Producer) HealthCheck() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.open(); err != nil {
+		return fmt.Errorf("AMQP health check failed: %w", err)
+	}
+	return nil
+}
+
+// Close closes the connection to the broker
+func (p *Producer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closeChannel()
+	if p.conn != nil && !p.conn.IsClosed() {
+		return p.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/retry"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+)
+
+func TestIsPermanent(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"unroutable", fmt.Errorf("%w: 312 NO_ROUTE", errUnroutable), true},
+		{"precondition failed", &amqp091.Error{Code: amqp091.PreconditionFailed, Reason: "message size exceeded"}, true},
+		{"missing exchange", &amqp091.Error{Code: amqp091.NotFound, Reason: "no exchange"}, false},
+		{"access refused", &amqp091.Error{Code: amqp091.AccessRefused, Reason: "access refused"}, false},
+		{"rejected", errors.New("message was rejected by the broker"), false},
+		{"connection error", amqp091.ErrClosed, false},
+	}
+	for _, tt := range tests {
+		err := fmt.Errorf("failed to publish message: %w", tt.err)
+		if got := isPermanent(err); got != tt.want {
+			t.Errorf("%s: isPermanent(%v) = %v, want %v", tt.name, err, got, tt.want)
+		}
+	}
+}
+
+// brokerURL returns the URL of the broker used by tests, skipping the test
+// unless MESSAGEBRIDGE_TEST_AMQP_URL is set
+func brokerURL(t *testing.T) string {
+	t.Helper()
+
+	url := os.Getenv("MESSAGEBRIDGE_TEST_AMQP_URL")
+	if url == "" {
+		t.Skip("MESSAGEBRIDGE_TEST_AMQP_URL is not set")
+	}
+	return url
+}
+
+// declareExchange declares a direct exchange with a queue bound by the
+// orders routing key. The queue holds one message and rejects further ones,
+// so the broker nacks them. Both are deleted when the test ends.
+func declareExchange(t *testing.T, url string) (*amqp091.Channel, string, string) {
+	t.Helper()
+
+	conn, err := amqp091.Dial(url)
+	if err != nil {
+		t.Fatalf("failed to connect to AMQP: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	channel, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suffix := fmt.Sprintf("%d", time.Now().UnixNano())
+	exchange, queue := "messagebridge-test-"+suffix, "messagebridge-test-orders-"+suffix
+	if err := channel.ExchangeDeclare(exchange, amqp091.ExchangeDirect, false, true, false, false, nil); err != nil {
+		t.Fatalf("failed to declare exchange: %v", err)
+	}
+	if _, err := channel.QueueDeclare(queue, false, false, true, false, amqp091.Table{
+		"x-max-length": int32(1),
+		"x-overflow":   "reject-publish",
+	}); err != nil {
+		t.Fatalf("failed to declare queue: %v", err)
+	}
+	if err := channel.QueueBind(queue, "orders", exchange, false, nil); err != nil {
+		t.Fatalf("failed to bind queue: %v", err)
+	}
+	t.Cleanup(func() {
+		channel.QueueDelete(queue, false, false, false)
+		channel.ExchangeDelete(exchange, false, false)
+	})
+	return channel, exchange, queue
+}
+
+// testMessage returns a message for the orders queue
+func testMessage(id string) *models.WebhookMessage {
+	return &models.WebhookMessage{
+		ID:        id,
+		Path:      "/webhook/orders",
+		Queue:     "orders",
+		Body:      []byte(`{"order":42}`),
+		Headers:   map[string]string{"Content-Type": "application/json"},
+		Timestamp: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+func TestPublishToBroker(t *testing.T) {
+	url := brokerURL(t)
+	channel, exchange, queue := declareExchange(t, url)
+
+	producer := NewProducer(&config.AMQPConfig{URL: url, Timeout: 5 * time.Second})
+	t.Cleanup(func() { producer.Close() })
+	ctx := context.Background()
+
+	// A confirmed message is in the queue with its headers
+	if _, err := producer.Send(ctx, models.DeliveryTarget{Type: models.TargetAMQP, Topic: exchange}, testMessage("msg-1")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	delivery, ok, err := channel.Get(queue, true)
+	if err != nil || !ok {
+		t.Fatalf("Get = %v, %v, want the published message", ok, err)
+	}
+	if string(delivery.Body) != `{"order":42}` || delivery.MessageId != "msg-1" || delivery.ContentType != "application/json" {
+		t.Errorf("delivery = %s %q %q, want the message", delivery.Body, delivery.MessageId, delivery.ContentType)
+	}
+	if delivery.DeliveryMode != amqp091.Persistent {
+		t.Errorf("delivery mode = %d, want persistent", delivery.DeliveryMode)
+	}
+	if delivery.Headers["X-Webhook-ID"] != "msg-1" || delivery.Headers["X-Webhook-Path"] != "/webhook/orders" {
+		t.Errorf("headers = %v, want X-Webhook-ID and X-Webhook-Path", delivery.Headers)
+	}
+
+	// A message no queue is bound to receive is returned and not retried
+	_, err = producer.PublishMessage(ctx, exchange, "nobody", testMessage("msg-2"))
+	if !errors.Is(err, errUnroutable) || !retry.IsPermanent(err) {
+		t.Errorf("unroutable error = %v, want a permanent %v", err, errUnroutable)
+	}
+
+	// A message the full queue refuses is nacked and retried
+	if _, err := producer.PublishMessage(ctx, exchange, "orders", testMessage("msg-3")); err != nil {
+		t.Fatalf("PublishMessage: %v", err)
+	}
+	_, err = producer.PublishMessage(ctx, exchange, "orders", testMessage("msg-4"))
+	if err == nil || retry.IsPermanent(err) {
+		t.Errorf("nacked error = %v, want a retried error", err)
+	}
+
+	// A missing exchange closes the channel and is retried; the next message
+	// is published on a new channel
+	_, err = producer.PublishMessage(ctx, exchange+"-missing", "orders", testMessage("msg-5"))
+	if err == nil || retry.IsPermanent(err) {
+		t.Errorf("missing exchange error = %v, want a retried error", err)
+	}
+	if _, ok, err := channel.Get(queue, true); err != nil || !ok {
+		t.Fatalf("Get = %v, %v, want msg-3", ok, err)
+	}
+	if _, err := producer.PublishMessage(ctx, exchange, "orders", testMessage("msg-6")); err != nil {
+		t.Errorf("PublishMessage after a channel error: %v", err)
+	}
+}
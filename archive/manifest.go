@@ -0,0 +1,254 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// manifestFormat is the format of archive files given in their manifests
+const manifestFormat = "ndjson+gzip"
+
+// Manifest describes a completed archive file. It is written next to the
+// file as <file>.manifest.json.
+type Manifest struct {
+	File          string    `json:"file"` // path relative to the archive root
+	Route         string    `json:"route"`
+	Date          string    `json:"date"`
+	Format        string    `json:"format"`
+	Records       int       `json:"records"`
+	Bytes         int64     `json:"bytes"`
+	SHA256        string    `json:"sha256"`
+	FirstReceived time.Time `json:"first_received"` // earliest message timestamp
+	LastReceived  time.Time `json:"last_received"`  // latest message timestamp
+	CreatedAt     time.Time `json:"created_at"`
+	CompletedAt   time.Time `json:"completed_at"`
+}
+
+// manifestPath returns the path of the manifest of an archive file
+func manifestPath(path string) string {
+	return strings.TrimSuffix(path, fileSuffix) + manifestSuffix
+}
+
+// writeManifest writes the manifest of the archive file at path
+func writeManifest(path string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(manifestPath(path), append(data, '\n'))
+}
+
+// writeFileAtomic writes data to a temporary file and renames it to path, so
+// path never holds a partly written file
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// recover completes the files a previous run left behind: partial files are
+// completed from their complete lines, completed files without a manifest get
+// one and temporary files are removed
+func (w *Writer) recover() error {
+	var partial, unlisted []string
+	err := filepath.WalkDir(w.config.Directory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch name := entry.Name(); {
+		case entry.IsDir():
+		case strings.HasSuffix(name, ".tmp"):
+			// Left behind by a crash before the rename
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		case strings.HasSuffix(name, fileSuffix+partialSuffix):
+			partial = append(partial, path)
+		case strings.HasSuffix(name, fileSuffix):
+			if _, err := os.Stat(manifestPath(path)); errors.Is(err, fs.ErrNotExist) {
+				unlisted = append(unlisted, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, path := range partial {
+		if err := w.recoverPartial(path); err != nil {
+			return fmt.Errorf("%s: %w", w.relative(path), err)
+		}
+	}
+	for _, path := range unlisted {
+		if err := w.writeMissingManifest(path); err != nil {
+			return fmt.Errorf("%s: %w", w.relative(path), err)
+		}
+	}
+	return nil
+}
+
+// recoverPartial completes a partial file from the lines that were written
+// completely; a line cut off by a crash was never reported as archived.
+// Partial files without a complete line are removed.
+func (w *Writer) recoverPartial(partialPath string) error {
+	source, err := os.Open(partialPath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	var lines [][]byte
+	if reader, err := gzip.NewReader(source); err == nil {
+		// Reading ends with an error where the file was cut off
+		buffered := bufio.NewReader(reader)
+		for {
+			line, err := buffered.ReadBytes('\n')
+			if err != nil {
+				break
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	path := strings.TrimSuffix(partialPath, partialSuffix)
+	if len(lines) > 0 {
+		if err := writeLines(path, lines); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(partialPath); err != nil {
+		return err
+	}
+	if len(lines) == 0 {
+		logger.Warn("Removed archive file without complete records", "destination", w.relative(partialPath))
+		return nil
+	}
+
+	logger.Warn("Recovered partial archive file", "destination", w.relative(path), "records", len(lines))
+	return w.writeMissingManifest(path)
+}
+
+// writeLines writes lines to a new gzip-compressed file at path
+func writeLines(path string, lines [][]byte) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := gzip.NewWriter(file)
+	for _, line := range lines {
+		if _, err := writer.Write(line); err != nil {
+			return err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// writeMissingManifest writes the manifest of a completed file from its
+// contents
+func (w *Writer) writeMissingManifest(path string) error {
+	m, err := inspect(path)
+	if err != nil {
+		return err
+	}
+	m.File = w.relative(path)
+	m.Date = strings.TrimPrefix(filepath.Base(filepath.Dir(path)), "date=")
+	return writeManifest(path, m)
+}
+
+// inspect reads an archive file and returns its manifest without file and
+// date
+func inspect(path string) (*Manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{
+		Format:      manifestFormat,
+		Bytes:       info.Size(),
+		CreatedAt:   info.ModTime().UTC(),
+		CompletedAt: info.ModTime().UTC(),
+	}
+	if created, err := time.Parse(fileTimeLayout, strings.SplitN(filepath.Base(path), "-", 2)[0]); err == nil {
+		m.CreatedAt = created
+	}
+
+	hash := sha256.New()
+	reader, err := gzip.NewReader(io.TeeReader(file, hash))
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64<<20)
+	for scanner.Scan() {
+		var record struct {
+			Path      string    `json:"path"`
+			Timestamp time.Time `json:"timestamp"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("record %d: %w", m.Records+1, err)
+		}
+
+		m.Records++
+		m.Route = record.Path
+		if m.FirstReceived.IsZero() || record.Timestamp.Before(m.FirstReceived) {
+			m.FirstReceived = record.Timestamp.UTC()
+		}
+		if record.Timestamp.After(m.LastReceived) {
+			m.LastReceived = record.Timestamp.UTC()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// The gzip trailer may be followed by bytes the reader did not need
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	m.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	return m, nil
+}
@@ -0,0 +1,193 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/metrics"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// uploadTimeout limits the upload of a single file
+const uploadTimeout = 5 * time.Minute
+
+// uploader uploads completed archive files to an S3-compatible bucket
+type uploader struct {
+	client *minio.Client
+	config *config.ArchiveS3Config
+}
+
+// newUploader creates an uploader for the bucket. Without an access key the
+// credentials are taken from the AWS_* environment variables, the shared
+// credentials file or the instance role.
+func newUploader(cfg *config.ArchiveS3Config) (*uploader, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+
+	creds := credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, "")
+	if cfg.AccessKeyID == "" {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		})
+	}
+
+	client, err := minio.New(endpoint.Host, &minio.Options{
+		Creds:  creds,
+		Secure: endpoint.Scheme == "https",
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	return &uploader{client: client, config: cfg}, nil
+}
+
+// upload uploads a file to the object named after its path relative to the
+// archive directory
+func (u *uploader) upload(ctx context.Context, file, name, contentType string) error {
+	ctx, cancel := context.WithTimeout(ctx, uploadTimeout)
+	defer cancel()
+
+	_, err := u.client.FPutObject(ctx, u.config.Bucket, u.objectName(name), file, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	return err
+}
+
+// objectName returns the object name of a file relative to the archive
+// directory
+func (u *uploader) objectName(name string) string {
+	if u.config.Prefix == "" {
+		return name
+	}
+	return path.Join(u.config.Prefix, name)
+}
+
+// check checks that the bucket exists and is accessible
+func (u *uploader) check() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	exists, err := u.client.BucketExists(ctx, u.config.Bucket)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("bucket %s does not exist", u.config.Bucket)
+	}
+	return nil
+}
+
+// uploadLoop uploads completed files when notified, and retries failed
+// uploads every minute, until the writer is closed
+func (w *Writer) uploadLoop() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		case <-w.uploads:
+		}
+		w.uploadCompleted(context.Background())
+	}
+}
+
+// uploadCompleted uploads the completed files in the archive directory with
+// their manifests and removes them. Files still missing a manifest are left
+// for the next start to finish.
+func (w *Writer) uploadCompleted(ctx context.Context) {
+	var files []string
+	err := filepath.WalkDir(w.config.Directory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), fileSuffix) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error("Failed to list archive files", "error", err)
+		return
+	}
+
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return
+		}
+		if _, err := os.Stat(manifestPath(file)); err != nil {
+			continue
+		}
+		if err := w.uploadFile(ctx, file); err != nil {
+			metrics.ClientErrors.WithLabelValues("archive", "upload").Inc()
+			logger.Error("Failed to upload archive file, retrying later",
+				"destination", w.relative(file), "bucket", w.uploader.config.Bucket, "error", err)
+			return
+		}
+	}
+}
+
+// uploadFile uploads a completed file and then its manifest, so a manifest
+// in the bucket means its file is there, too. Both are removed afterwards.
+func (w *Writer) uploadFile(ctx context.Context, file string) error {
+	name := w.relative(file)
+	manifest := manifestPath(file)
+
+	if err := w.uploader.upload(ctx, file, name, "application/gzip"); err != nil {
+		return err
+	}
+	if err := w.uploader.upload(ctx, manifest, w.relative(manifest), "application/json"); err != nil {
+		return err
+	}
+
+	// The manifest goes first: a file left without one gets a new manifest
+	// on the next start and is uploaded again, which does no harm
+	if err := os.Remove(manifest); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	w.removeEmptyDirs(filepath.Dir(file))
+
+	logger.Info("Archive file uploaded", "destination", w.uploader.objectName(name), "bucket", w.uploader.config.Bucket)
+	return nil
+}
+
+// removeEmptyDirs removes dir and its parents below the archive directory
+// as long as they are empty. It holds w.mu so no file is created in them
+// meanwhile.
+func (w *Writer) removeEmptyDirs(dir string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	root := filepath.Clean(w.config.Directory)
+	for dir != root && strings.HasPrefix(dir, root) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
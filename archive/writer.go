@@ -0,0 +1,558 @@
+package archive
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/logging"
+	"github.com/expai/messagebridge/metrics"
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// logger is the archive component logger
+var logger = logging.For("archive")
+
+const (
+	// fileSuffix is the suffix of completed archive files
+	fileSuffix = ".ndjson.gz"
+	// partialSuffix is appended to files that are still being written
+	partialSuffix = ".partial"
+	// manifestSuffix replaces fileSuffix in the name of a file's manifest
+	manifestSuffix = ".manifest.json"
+	// fileTimeLayout is the layout of the time a file name starts with
+	fileTimeLayout = "20060102T150405Z"
+)
+
+// errClosed is returned for messages sent after the writer was closed
+var errClosed = errors.New("archive writer is closed")
+
+// Writer archives messages as gzip-compressed NDJSON files partitioned by
+// route and date: <directory>/route=<path>/date=<YYYY-MM-DD>/<file>.ndjson.gz.
+// Each line is a message in the format of "messagebridge export", so archive
+// files can be re-ingested with "messagebridge import". A file is written as
+// <file>.ndjson.gz.partial and completed once it reaches the maximum size or
+// age; completing it writes a <file>.manifest.json describing it and, with S3
+// configured, uploads both and removes them from the directory.
+type Writer struct {
+	config   *config.ArchiveConfig
+	uploader *uploader
+
+	mu    sync.Mutex
+	files map[string]*archiveFile // by partition
+
+	syncs   chan struct{}
+	uploads chan struct{}
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// archiveFile is an archive file being written
+type archiveFile struct {
+	path      string // final path, without partialSuffix
+	route     string
+	date      string
+	createdAt time.Time
+
+	file  *os.File
+	gzip  *gzip.Writer
+	hash  hash.Hash
+	bytes int64 // compressed bytes written
+	count int
+	first time.Time
+	last  time.Time
+
+	syncMu  sync.Mutex // held while the file is synced without w.mu
+	pending *batch     // lines written since the last flush
+}
+
+// batch is a group of lines synced to disk together. The senders of the
+// lines wait for done and fail with err if it is set.
+type batch struct {
+	done chan struct{}
+	err  error
+}
+
+// finish reports the result of syncing the batch to its senders
+func (b *batch) finish(err error) {
+	b.err = err
+	close(b.done)
+}
+
+// NewWriter creates a new archive writer. Files left behind by a previous
+// run are completed first: records of a partial file up to the first
+// incomplete line are kept, and completed files missing a manifest or not
+// uploaded yet are finished.
+func NewWriter(cfg *config.ArchiveConfig) (*Writer, error) {
+	if err := os.MkdirAll(cfg.Directory, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	w := &Writer{
+		config:  cfg,
+		files:   make(map[string]*archiveFile),
+		syncs:   make(chan struct{}, 1),
+		uploads: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+
+	if cfg.S3 != nil {
+		u, err := newUploader(cfg.S3)
+		if err != nil {
+			return nil, err
+		}
+		w.uploader = u
+	}
+
+	if err := w.recover(); err != nil {
+		return nil, fmt.Errorf("failed to recover archive files: %w", err)
+	}
+
+	w.wg.Add(1)
+	go w.run()
+	if w.uploader != nil {
+		w.wg.Add(1)
+		go w.uploadLoop()
+		w.notifyUpload()
+	}
+
+	return w, nil
+}
+
+// Send appends a message to the open file of its route and date and waits
+// until the line is synced to disk, so a delivered message survives a crash.
+// Lines written while a sync runs are synced together by the next one.
+func (w *Writer) Send(ctx context.Context, target models.DeliveryTarget, msg *models.WebhookMessage) (result *models.DeliveryResult, err error) {
+	_, span := tracing.Start(ctx, "archive.write",
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(attribute.String("messaging.message.id", msg.ID)),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	line, err := json.Marshal(archiveRecord(target, msg))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode message: %w", err)
+	}
+	line = append(line, '\n')
+
+	name, synced, err := w.write(msg, line)
+	if err != nil {
+		metrics.ClientErrors.WithLabelValues("archive", "send").Inc()
+		return nil, err
+	}
+
+	// A message whose sync fails or is not waited for is retried, so it may
+	// be archived twice but is never lost
+	select {
+	case <-synced.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if synced.err != nil {
+		metrics.ClientErrors.WithLabelValues("archive", "send").Inc()
+		return nil, synced.err
+	}
+
+	logger.Debug("Message archived", "message_id", msg.ID, "route", msg.Path, "destination", name)
+	return &models.DeliveryResult{Response: name}, nil
+}
+
+// write appends line to the open file of the partition of msg and returns
+// the name of the file and the batch the line is synced with
+func (w *Writer) write(msg *models.WebhookMessage, line []byte) (string, *batch, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.files == nil {
+		return "", nil, errClosed
+	}
+
+	f, err := w.openFile(msg)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := f.write(line, msg.Timestamp); err != nil {
+		err = fmt.Errorf("failed to write archive file %s: %w", f.path, err)
+		w.abandon(f, err)
+		return "", nil, err
+	}
+
+	if f.pending == nil {
+		f.pending = &batch{done: make(chan struct{})}
+	}
+	synced := f.pending
+	name := w.relative(f.path)
+
+	if f.bytes >= w.config.MaxFileSize {
+		w.complete(f)
+	} else {
+		w.notifySync()
+	}
+	return name, synced, nil
+}
+
+// archiveRecord returns the archived form of msg: the message with its
+// deliveries, the archive delivery marked as sent and all others pending, so
+// importing the record redelivers the message everywhere but the archive
+func archiveRecord(target models.DeliveryTarget, msg *models.WebhookMessage) *models.PendingMessage {
+	now := time.Now().UTC()
+	targets := msg.Destinations
+	if len(targets) == 0 {
+		targets = []models.DeliveryTarget{target}
+	}
+
+	record := &models.PendingMessage{WebhookMessage: msg}
+	for _, t := range targets {
+		delivery := &models.Delivery{
+			MessageID: msg.ID,
+			Target:    t,
+			Status:    models.StatusPending,
+			UpdatedAt: now,
+		}
+		if t.Key() == target.Key() {
+			delivery.Status = models.StatusSent
+		}
+		record.Deliveries = append(record.Deliveries, delivery)
+	}
+	return record
+}
+
+// openFile returns the open file of the partition of msg, creating a new
+// one if there is none. Callers must hold w.mu.
+func (w *Writer) openFile(msg *models.WebhookMessage) (*archiveFile, error) {
+	route := "route=" + url.PathEscape(strings.TrimPrefix(msg.Path, "/"))
+	date := "date=" + msg.Timestamp.UTC().Format(time.DateOnly)
+	partition := filepath.Join(route, date)
+
+	if f, ok := w.files[partition]; ok {
+		return f, nil
+	}
+
+	dir := filepath.Join(w.config.Directory, partition)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	now := time.Now().UTC()
+	path := filepath.Join(dir, now.Format(fileTimeLayout)+"-"+randomSuffix()+fileSuffix)
+	file, err := os.OpenFile(path+partialSuffix, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive file: %w", err)
+	}
+
+	f := &archiveFile{
+		path:      path,
+		route:     msg.Path,
+		date:      msg.Timestamp.UTC().Format(time.DateOnly),
+		createdAt: now,
+		file:      file,
+		hash:      sha256.New(),
+	}
+	f.gzip = gzip.NewWriter(io.MultiWriter(file, f.hash, (*byteCounter)(&f.bytes)))
+	w.files[partition] = f
+
+	logger.Debug("Archive file created", "route", msg.Path, "destination", w.relative(path))
+	return f, nil
+}
+
+// write appends a line to the file
+func (f *archiveFile) write(line []byte, timestamp time.Time) error {
+	if _, err := f.gzip.Write(line); err != nil {
+		return err
+	}
+
+	f.count++
+	if f.first.IsZero() || timestamp.Before(f.first) {
+		f.first = timestamp
+	}
+	if timestamp.After(f.last) {
+		f.last = timestamp
+	}
+	return nil
+}
+
+// close ends the gzip stream, syncs the file and closes it. The pending
+// lines are synced once it returns without an error.
+func (f *archiveFile) close() error {
+	f.syncMu.Lock()
+	defer f.syncMu.Unlock()
+
+	if err := f.gzip.Close(); err != nil {
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+	if f.pending != nil {
+		f.pending.finish(nil)
+		f.pending = nil
+	}
+	return f.file.Close()
+}
+
+// complete closes a file, renames it to its final name and writes its
+// manifest. Callers must hold w.mu.
+func (w *Writer) complete(f *archiveFile) {
+	if err := f.close(); err != nil {
+		w.abandon(f, err)
+		return
+	}
+	if err := os.Rename(f.path+partialSuffix, f.path); err != nil {
+		w.abandon(f, err)
+		return
+	}
+	w.remove(f)
+
+	m := &Manifest{
+		File:          w.relative(f.path),
+		Route:         f.route,
+		Date:          f.date,
+		Format:        manifestFormat,
+		Records:       f.count,
+		Bytes:         f.bytes,
+		SHA256:        hex.EncodeToString(f.hash.Sum(nil)),
+		FirstReceived: f.first.UTC(),
+		LastReceived:  f.last.UTC(),
+		CreatedAt:     f.createdAt,
+		CompletedAt:   time.Now().UTC(),
+	}
+	if err := writeManifest(f.path, m); err != nil {
+		// The manifest is written again on the next start
+		logger.Error("Failed to write archive manifest", "destination", m.File, "error", err)
+		return
+	}
+
+	logger.Info("Archive file completed", "route", f.route, "destination", m.File, "records", m.Records, "bytes", m.Bytes)
+	w.notifyUpload()
+}
+
+// abandon closes a file that failed to be written, synced or completed, and
+// completes it from the complete lines it holds on disk. The senders of the
+// lines not synced yet fail with err, so their messages are retried in
+// another file. Callers must hold w.mu.
+func (w *Writer) abandon(f *archiveFile, err error) {
+	w.remove(f)
+	f.syncMu.Lock()
+	f.file.Close()
+	f.syncMu.Unlock()
+	if f.pending != nil {
+		f.pending.finish(err)
+		f.pending = nil
+	}
+
+	logger.Error("Failed to write archive file", "destination", w.relative(f.path), "error", err)
+	if err := w.recoverPartial(f.path + partialSuffix); err != nil {
+		logger.Error("Failed to recover archive file, it is recovered on the next start",
+			"destination", w.relative(f.path), "error", err)
+		return
+	}
+	w.notifyUpload()
+}
+
+// remove removes f from the open files. Callers must hold w.mu.
+func (w *Writer) remove(f *archiveFile) {
+	for partition, open := range w.files {
+		if open == f {
+			delete(w.files, partition)
+		}
+	}
+}
+
+// run syncs written lines when notified and completes files that reach the
+// maximum age until the writer is closed
+func (w *Writer) run() {
+	defer w.wg.Done()
+
+	ticker := time.NewTicker(min(w.config.MaxFileAge, time.Minute))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.syncs:
+			w.syncFiles()
+		case <-ticker.C:
+			w.completeExpired()
+		}
+	}
+}
+
+// syncFiles flushes the lines written to open files since the last sync
+// and syncs the files to disk. Only the flush holds w.mu, so messages are
+// written meanwhile and synced by the next call. A file that fails to sync
+// is completed from the lines it holds on disk and the senders waiting for
+// it fail.
+func (w *Writer) syncFiles() {
+	type flushed struct {
+		file  *archiveFile
+		batch *batch
+		err   error
+	}
+	var files []*flushed
+
+	w.mu.Lock()
+	for _, f := range w.files {
+		if f.pending == nil {
+			continue
+		}
+		if err := f.gzip.Flush(); err != nil {
+			metrics.ClientErrors.WithLabelValues("archive", "sync").Inc()
+			w.abandon(f, fmt.Errorf("failed to flush archive file %s: %w", f.path, err))
+			continue
+		}
+		// Completing the file waits for the sync to finish
+		f.syncMu.Lock()
+		files = append(files, &flushed{file: f, batch: f.pending})
+		f.pending = nil
+	}
+	w.mu.Unlock()
+
+	for _, flushed := range files {
+		flushed.err = flushed.file.file.Sync()
+		flushed.file.syncMu.Unlock()
+	}
+
+	for _, flushed := range files {
+		f := flushed.file
+		if flushed.err == nil {
+			flushed.batch.finish(nil)
+			continue
+		}
+
+		metrics.ClientErrors.WithLabelValues("archive", "sync").Inc()
+		err := fmt.Errorf("failed to sync archive file %s: %w", f.path, flushed.err)
+		flushed.batch.finish(err)
+
+		w.mu.Lock()
+		if w.isOpen(f) {
+			w.abandon(f, err)
+		}
+		w.mu.Unlock()
+	}
+}
+
+// isOpen reports whether f is one of the open files. Callers must hold w.mu.
+func (w *Writer) isOpen(f *archiveFile) bool {
+	for _, open := range w.files {
+		if open == f {
+			return true
+		}
+	}
+	return false
+}
+
+// notifySync wakes up the sync of written lines without blocking
+func (w *Writer) notifySync() {
+	select {
+	case w.syncs <- struct{}{}:
+	default:
+	}
+}
+
+// completeExpired completes the files that are older than the maximum age
+func (w *Writer) completeExpired() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, f := range w.files {
+		if time.Since(f.createdAt) >= w.config.MaxFileAge {
+			w.complete(f)
+		}
+	}
+}
+
+// notifyUpload wakes up the upload of completed files without blocking
+func (w *Writer) notifyUpload() {
+	if w.uploader == nil {
+		return
+	}
+	select {
+	case w.uploads <- struct{}{}:
+	default:
+	}
+}
+
+// relative returns path relative to the archive directory, with slashes
+func (w *Writer) relative(path string) string {
+	rel, err := filepath.Rel(w.config.Directory, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// HealthCheck checks that the archive directory is writable and, with S3
+// configured, that the bucket is accessible
+func (w *Writer) HealthCheck() error {
+	file, err := os.CreateTemp(w.config.Directory, ".health-*")
+	if err != nil {
+		return fmt.Errorf("archive health check failed: %w", err)
+	}
+	file.Close()
+	os.Remove(file.Name())
+
+	if w.uploader != nil {
+		if err := w.uploader.check(); err != nil {
+			return fmt.Errorf("archive health check failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close completes all open files and, with S3 configured, makes a last
+// attempt to upload them. Files that fail to upload stay in the directory
+// and are uploaded after the next start.
+func (w *Writer) Close() error {
+	close(w.stop)
+	w.wg.Wait()
+
+	w.mu.Lock()
+	for _, f := range w.files {
+		w.complete(f)
+	}
+	w.files = nil
+	w.mu.Unlock()
+
+	if w.uploader != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		w.uploadCompleted(ctx)
+	}
+	return nil
+}
+
+// byteCounter counts the bytes written to it
+type byteCounter int64
+
+// Write adds the length of p to the count
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// randomSuffix returns 8 random hex digits that keep file names unique
+// across instances sharing a bucket
+func randomSuffix() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
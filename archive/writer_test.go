@@ -0,0 +1,537 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/models"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+var (
+	archiveTarget = models.DeliveryTarget{Type: models.TargetArchive}
+	kafkaTarget   = models.DeliveryTarget{Type: models.TargetKafka, Topic: "orders"}
+)
+
+// testConfig returns a configuration archiving to a new directory that
+// does not rotate files during a test unless it changes it
+func testConfig(t *testing.T) *config.ArchiveConfig {
+	return &config.ArchiveConfig{
+		Directory:   t.TempDir(),
+		MaxFileSize: 64 << 20,
+		MaxFileAge:  time.Hour,
+	}
+}
+
+// newTestWriter returns a writer for cfg that is closed when the test ends
+// unless the test closed it
+func newTestWriter(t *testing.T, cfg *config.ArchiveConfig) *Writer {
+	t.Helper()
+
+	w, err := NewWriter(cfg)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	t.Cleanup(func() {
+		w.mu.Lock()
+		closed := w.files == nil
+		w.mu.Unlock()
+		if !closed {
+			w.Close()
+		}
+	})
+	return w
+}
+
+// testMessage returns a message for path received at timestamp
+func testMessage(id, path string, timestamp time.Time) *models.WebhookMessage {
+	return &models.WebhookMessage{
+		ID:           id,
+		Path:         path,
+		Queue:        "orders",
+		Body:         []byte(`{"id":"` + id + `"}`),
+		Timestamp:    timestamp,
+		Destinations: []models.DeliveryTarget{kafkaTarget, archiveTarget},
+	}
+}
+
+// send archives msg
+func send(t *testing.T, w *Writer, msg *models.WebhookMessage) {
+	t.Helper()
+
+	if _, err := w.Send(context.Background(), archiveTarget, msg); err != nil {
+		t.Fatalf("Send %s: %v", msg.ID, err)
+	}
+}
+
+// listFiles returns the files below dir relative to it, sorted
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		files = append(files, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(files)
+	return files
+}
+
+// filesWithSuffix returns the files below dir ending in suffix
+func filesWithSuffix(t *testing.T, dir, suffix string) []string {
+	t.Helper()
+
+	var matching []string
+	for _, file := range listFiles(t, dir) {
+		if strings.HasSuffix(file, suffix) {
+			matching = append(matching, file)
+		}
+	}
+	return matching
+}
+
+// readRecords returns the complete records of the archive file at path,
+// which may be partly written
+func readRecords(t *testing.T, path string) []models.PendingMessage {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var records []models.PendingMessage
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return records
+	}
+	buffered := bufio.NewReader(reader)
+	for {
+		line, err := buffered.ReadBytes('\n')
+		if err != nil {
+			return records
+		}
+		var record models.PendingMessage
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatalf("invalid record %s: %v", line, err)
+		}
+		records = append(records, record)
+	}
+}
+
+// readManifest returns the manifest of the completed file at dir/name and
+// checks that it describes the file
+func readManifest(t *testing.T, dir, name string) *Manifest {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	data, err := os.ReadFile(manifestPath(path))
+	if err != nil {
+		t.Fatalf("manifest of %s: %v", name, err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("invalid manifest %s: %v", data, err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	if m.File != name || m.Format != manifestFormat || m.Bytes != int64(len(content)) || m.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("manifest = %s, want %s with %d bytes and SHA-256 %x", data, name, len(content), sum)
+	}
+	if records := readRecords(t, path); m.Records != len(records) {
+		t.Errorf("manifest of %s counts %d records, file holds %d", name, m.Records, len(records))
+	}
+	return &m
+}
+
+// waitFor polls condition until it holds or five seconds passed
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWriterPartitionsAndManifests(t *testing.T) {
+	cfg := testConfig(t)
+	w := newTestWriter(t, cfg)
+
+	evening := time.Date(2026, 10, 15, 23, 59, 0, 0, time.UTC)
+	morning := time.Date(2026, 10, 16, 0, 1, 0, 0, time.UTC)
+	send(t, w, testMessage("msg-2", "/webhook/orders", evening))
+	send(t, w, testMessage("msg-1", "/webhook/orders", evening.Add(-time.Hour)))
+	send(t, w, testMessage("msg-3", "/webhook/orders", morning))
+	send(t, w, testMessage("msg-4", "/webhook/stripe", morning))
+
+	if partial := filesWithSuffix(t, cfg.Directory, partialSuffix); len(partial) != 3 {
+		t.Fatalf("open files = %v, want one per route and date", partial)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := w.Send(context.Background(), archiveTarget, testMessage("msg-5", "/webhook/orders", morning)); err != errClosed {
+		t.Errorf("Send after Close = %v, want %v", err, errClosed)
+	}
+
+	files := filesWithSuffix(t, cfg.Directory, fileSuffix)
+	if len(files) != 3 || len(filesWithSuffix(t, cfg.Directory, partialSuffix)) != 0 {
+		t.Fatalf("files = %v, want three completed files", listFiles(t, cfg.Directory))
+	}
+
+	manifests := make(map[string]*Manifest)
+	for _, file := range files {
+		m := readManifest(t, cfg.Directory, file)
+		manifests[strings.SplitN(file, "/", 2)[0]+" "+m.Date] = m
+
+		for _, record := range readRecords(t, filepath.Join(cfg.Directory, file)) {
+			if len(record.Deliveries) != 2 || record.Deliveries[0].Status != models.StatusPending || record.Deliveries[1].Status != models.StatusSent {
+				t.Errorf("%s: deliveries of %s = %v, want kafka pending and archive sent", file, record.ID, record.Deliveries)
+			}
+		}
+	}
+
+	orders := manifests["route=webhook%2Forders 2026-10-15"]
+	if orders == nil {
+		t.Fatalf("manifests = %v, want one for /webhook/orders on 2026-10-15", manifests)
+	}
+	if orders.Route != "/webhook/orders" || orders.Records != 2 {
+		t.Errorf("manifest = %+v, want 2 records of /webhook/orders", orders)
+	}
+	if !orders.FirstReceived.Equal(evening.Add(-time.Hour)) || !orders.LastReceived.Equal(evening) {
+		t.Errorf("manifest received %v to %v, want %v to %v", orders.FirstReceived, orders.LastReceived, evening.Add(-time.Hour), evening)
+	}
+	for _, key := range []string{"route=webhook%2Forders 2026-10-16", "route=webhook%2Fstripe 2026-10-16"} {
+		if m := manifests[key]; m == nil || m.Records != 1 {
+			t.Errorf("manifest %s = %+v, want 1 record", key, m)
+		}
+	}
+}
+
+func TestWriterRotatesBySize(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MaxFileSize = 1
+	w := newTestWriter(t, cfg)
+
+	now := time.Now().UTC()
+	for _, id := range []string{"msg-1", "msg-2"} {
+		send(t, w, testMessage(id, "/webhook/orders", now))
+	}
+
+	// Each message fills a file, which is completed right away
+	files := filesWithSuffix(t, cfg.Directory, fileSuffix)
+	if len(files) != 2 || len(filesWithSuffix(t, cfg.Directory, partialSuffix)) != 0 {
+		t.Fatalf("files = %v, want two completed files", listFiles(t, cfg.Directory))
+	}
+	for _, file := range files {
+		if m := readManifest(t, cfg.Directory, file); m.Records != 1 {
+			t.Errorf("%s holds %d records, want 1", file, m.Records)
+		}
+	}
+}
+
+func TestWriterRotatesByAge(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MaxFileAge = 50 * time.Millisecond
+	w := newTestWriter(t, cfg)
+
+	now := time.Now().UTC()
+	send(t, w, testMessage("msg-1", "/webhook/orders", now))
+	waitFor(t, "the file to be completed", func() bool {
+		return len(filesWithSuffix(t, cfg.Directory, manifestSuffix)) == 1
+	})
+
+	// The next message starts a new file
+	send(t, w, testMessage("msg-2", "/webhook/orders", now))
+	if partial := filesWithSuffix(t, cfg.Directory, partialSuffix); len(partial) != 1 {
+		t.Errorf("open files = %v, want a new one", partial)
+	}
+	files := filesWithSuffix(t, cfg.Directory, fileSuffix)
+	if len(files) != 1 {
+		t.Fatalf("completed files = %v, want 1", files)
+	}
+	if m := readManifest(t, cfg.Directory, files[0]); m.Records != 1 {
+		t.Errorf("completed file holds %d records, want 1", m.Records)
+	}
+}
+
+func TestWriterSync(t *testing.T) {
+	cfg := testConfig(t)
+	w := newTestWriter(t, cfg)
+
+	// Concurrent messages are each on disk when Send returns
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := w.Send(context.Background(), archiveTarget, testMessage(fmt.Sprintf("msg-%d", i), "/webhook/orders", time.Now().UTC()))
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+
+	partial := filesWithSuffix(t, cfg.Directory, partialSuffix)
+	if len(partial) != 1 {
+		t.Fatalf("open files = %v, want 1", partial)
+	}
+	if records := readRecords(t, filepath.Join(cfg.Directory, partial[0])); len(records) != 50 {
+		t.Errorf("file holds %d records, want 50", len(records))
+	}
+}
+
+func TestWriterSyncFailure(t *testing.T) {
+	cfg := testConfig(t)
+	w := newTestWriter(t, cfg)
+	send(t, w, testMessage("msg-1", "/webhook/orders", time.Now().UTC()))
+
+	// The next sync of the file fails
+	w.mu.Lock()
+	for _, f := range w.files {
+		f.file.Close()
+	}
+	w.mu.Unlock()
+
+	if _, err := w.Send(context.Background(), archiveTarget, testMessage("msg-2", "/webhook/orders", time.Now().UTC())); err == nil {
+		t.Fatal("Send succeeded although the sync failed")
+	}
+
+	// The file is completed from the synced lines and the next message
+	// starts a new one
+	send(t, w, testMessage("msg-3", "/webhook/orders", time.Now().UTC()))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	var ids []string
+	for _, file := range filesWithSuffix(t, cfg.Directory, fileSuffix) {
+		readManifest(t, cfg.Directory, file)
+		for _, record := range readRecords(t, filepath.Join(cfg.Directory, file)) {
+			ids = append(ids, record.ID)
+		}
+	}
+	slices.Sort(ids)
+	if !slices.Equal(ids, []string{"msg-1", "msg-3"}) {
+		t.Errorf("archived %v, want msg-1 and msg-3", ids)
+	}
+}
+
+func TestWriterRecoversPartialFiles(t *testing.T) {
+	// Write a partial file as a crashed run leaves it behind, noting its
+	// size after each line
+	source := testConfig(t)
+	w := newTestWriter(t, source)
+
+	var sizes []int
+	var partial string
+	for _, id := range []string{"msg-1", "msg-2"} {
+		send(t, w, testMessage(id, "/webhook/orders", time.Now().UTC()))
+		partial = filesWithSuffix(t, source.Directory, partialSuffix)[0]
+		info, err := os.Stat(filepath.Join(source.Directory, partial))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, int(info.Size()))
+	}
+	content, err := os.ReadFile(filepath.Join(source.Directory, partial))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		size    int
+		records []string
+	}{
+		{"complete lines", sizes[1], []string{"msg-1", "msg-2"}},
+		{"last line cut off", sizes[0] + (sizes[1]-sizes[0])/2, []string{"msg-1"}},
+		{"no complete line", 10, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			path := filepath.Join(cfg.Directory, partial)
+			if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, content[:tt.size], 0o640); err != nil {
+				t.Fatal(err)
+			}
+			// A manifest whose write was interrupted
+			if err := os.WriteFile(manifestPath(strings.TrimSuffix(path, partialSuffix))+".tmp", []byte("{"), 0o640); err != nil {
+				t.Fatal(err)
+			}
+
+			newTestWriter(t, cfg)
+
+			name := strings.TrimSuffix(partial, partialSuffix)
+			if tt.records == nil {
+				if files := listFiles(t, cfg.Directory); len(files) != 0 {
+					t.Errorf("files = %v, want the partial file removed", files)
+				}
+				return
+			}
+			if files := listFiles(t, cfg.Directory); !slices.Equal(files, []string{strings.TrimSuffix(name, fileSuffix) + manifestSuffix, name}) {
+				t.Fatalf("files = %v, want %s and its manifest", files, name)
+			}
+			m := readManifest(t, cfg.Directory, name)
+			var ids []string
+			for _, record := range readRecords(t, filepath.Join(cfg.Directory, name)) {
+				ids = append(ids, record.ID)
+			}
+			if !slices.Equal(ids, tt.records) || m.Route != "/webhook/orders" {
+				t.Errorf("recovered %v of route %s, want %v of /webhook/orders", ids, m.Route, tt.records)
+			}
+		})
+	}
+}
+
+// startS3 starts an in-memory S3 server with the bucket archive
+func startS3(t *testing.T) (*config.ArchiveS3Config, gofakes3.Backend) {
+	t.Helper()
+
+	backend := s3mem.New()
+	if err := backend.CreateBucket("archive"); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(gofakes3.New(backend).Server())
+	t.Cleanup(server.Close)
+
+	return &config.ArchiveS3Config{
+		Endpoint:        server.URL,
+		Region:          "us-east-1",
+		Bucket:          "archive",
+		Prefix:          "messagebridge/",
+		AccessKeyID:     "messagebridge",
+		SecretAccessKey: "secret",
+	}, backend
+}
+
+// objectNames returns the names of the objects in the archive bucket
+func objectNames(t *testing.T, backend gofakes3.Backend) []string {
+	t.Helper()
+
+	list, err := backend.ListBucket("archive", &gofakes3.Prefix{}, gofakes3.ListBucketPage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, object := range list.Contents {
+		names = append(names, object.Key)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestWriterUploadsToS3(t *testing.T) {
+	s3, backend := startS3(t)
+	cfg := testConfig(t)
+	cfg.MaxFileSize = 1
+	cfg.S3 = s3
+	w := newTestWriter(t, cfg)
+
+	if err := w.HealthCheck(); err != nil {
+		t.Fatalf("HealthCheck: %v", err)
+	}
+
+	send(t, w, testMessage("msg-1", "/webhook/orders", time.Now().UTC()))
+	name := filesWithSuffix(t, cfg.Directory, fileSuffix)
+	if len(name) != 1 {
+		t.Fatalf("completed files = %v, want 1", name)
+	}
+	m := readManifest(t, cfg.Directory, name[0])
+
+	// The file and its manifest are uploaded, then removed locally
+	want := []string{"messagebridge/" + strings.TrimSuffix(name[0], fileSuffix) + manifestSuffix, "messagebridge/" + name[0]}
+	waitFor(t, "the upload", func() bool {
+		return len(listFiles(t, cfg.Directory)) == 0
+	})
+	if objects := objectNames(t, backend); !slices.Equal(objects, want) {
+		t.Fatalf("objects = %v, want %v", objects, want)
+	}
+
+	object, err := backend.GetObject("archive", want[1], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer object.Contents.Close()
+	hash := sha256.New()
+	if _, err := bufio.NewReader(object.Contents).WriteTo(hash); err != nil {
+		t.Fatal(err)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != m.SHA256 {
+		t.Errorf("uploaded file has SHA-256 %s, want %s", sum, m.SHA256)
+	}
+}
+
+func TestWriterUploadsAfterRestart(t *testing.T) {
+	s3, backend := startS3(t)
+
+	// While the bucket is unavailable completed files stay in the directory
+	unavailable := *s3
+	unavailable.Bucket = "missing"
+	cfg := testConfig(t)
+	cfg.S3 = &unavailable
+	w := newTestWriter(t, cfg)
+
+	if err := w.HealthCheck(); err == nil {
+		t.Error("HealthCheck succeeded without the bucket")
+	}
+	send(t, w, testMessage("msg-1", "/webhook/orders", time.Now().UTC()))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if files := listFiles(t, cfg.Directory); len(files) != 2 {
+		t.Fatalf("files = %v, want a completed file and its manifest", files)
+	}
+
+	// The next start uploads them
+	cfg.S3 = s3
+	newTestWriter(t, cfg)
+	waitFor(t, "the upload", func() bool {
+		return len(listFiles(t, cfg.Directory)) == 0
+	})
+	if objects := objectNames(t, backend); len(objects) != 2 {
+		t.Errorf("objects = %v, want the file and its manifest", objects)
+	}
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadConfig writes content to a temporary file and loads it
+func loadConfig(t *testing.T, content string) (*Config, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return LoadConfig(path)
+}
+
+func TestRetryPolicyInheritance(t *testing.T) {
+	cfg, err := loadConfig(t, `
+server:
+  host: "127.0.0.1"
+  port: 8080
+worker:
+  max_retries: 7
+  retry_policy:
+    initial_delay: 1m
+    jitter: 0.2
+    max_age: 24h
+routes:
+  - path: "/webhook/inherit"
+    queue: "inherit"
+    retry_policy:
+      multiplier: 3
+  - path: "/webhook/zero"
+    queue: "zero"
+    retry_policy:
+      jitter: 0
+      max_attempts: 0
+      max_age: 0s
+  - path: "/webhook/default"
+    queue: "default"
+`)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	defaults := DefaultRetryPolicy()
+	worker := cfg.RetryPolicyFor("/webhook/default")
+	if worker.InitialDelay != time.Minute || worker.Jitter != 0.2 || worker.MaxAge != 24*time.Hour {
+		t.Errorf("worker policy = %+v, want the configured values", worker)
+	}
+	if worker.MaxAttempts != 7 {
+		t.Errorf("worker max_attempts = %d, want worker.max_retries", worker.MaxAttempts)
+	}
+	if worker.Multiplier != defaults.Multiplier || worker.MaxDelay != defaults.MaxDelay {
+		t.Errorf("worker policy = %+v, want the default multiplier and max_delay", worker)
+	}
+
+	inherited := cfg.RetryPolicyFor("/webhook/inherit")
+	if inherited.Multiplier != 3 {
+		t.Errorf("route multiplier = %v, want 3", inherited.Multiplier)
+	}
+	if inherited.InitialDelay != time.Minute || inherited.Jitter != 0.2 || inherited.MaxAttempts != 7 || inherited.MaxAge != 24*time.Hour {
+		t.Errorf("route policy = %+v, want unset fields from the worker policy", inherited)
+	}
+
+	// Explicit zeros turn jitter off and remove the limits
+	zero := cfg.RetryPolicyFor("/webhook/zero")
+	if zero.Jitter != 0 || zero.MaxAttempts != 0 || zero.MaxAge != 0 {
+		t.Errorf("route policy = %+v, want explicit zeros kept", zero)
+	}
+	if zero.InitialDelay != time.Minute {
+		t.Errorf("route initial_delay = %v, want it inherited", zero.InitialDelay)
+	}
+}
+
+func TestRetryPolicyExplicitZeroMaxAttempts(t *testing.T) {
+	cfg, err := loadConfig(t, `
+server:
+  host: "127.0.0.1"
+  port: 8080
+worker:
+  max_retries: 5
+  retry_policy:
+    max_attempts: 0
+routes:
+  - path: "/webhook"
+    queue: "events"
+`)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	// max_attempts: 0 means unlimited and wins over max_retries
+	if got := cfg.Worker.RetryPolicy.MaxAttempts; got != 0 {
+		t.Errorf("max_attempts = %d, want 0", got)
+	}
+}
+
+func TestValidateRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes string
+		want   string
+	}{
+		{"distinct paths", `
+  - path: "/webhook/a"
+    queue: "a"
+  - path: "/webhook/b"
+    queue: "a"`, ""},
+		{"duplicate path", `
+  - path: "/webhook/a"
+    queue: "a"
+  - path: "/webhook/b"
+    queue: "b"
+  - path: "/webhook/a"
+    queue: "c"`, "route[2].path /webhook/a is already used by route[0]"},
+		{"twilio with url", `
+  - path: "/webhook/sms"
+    queue: "sms"
+    provider: "twilio"
+    signature:
+      secret: "token"
+      url: "https://hooks.example.com/webhook/sms"`, ""},
+		{"twilio without url", `
+  - path: "/webhook/sms"
+    queue: "sms"
+    provider: "twilio"
+    signature:
+      secret: "token"`, "route[0].signature: url is required for provider twilio"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig(t, `
+server:
+  host: "127.0.0.1"
+  port: 8080
+routes:`+tt.routes+"\n")
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("LoadConfig: %v", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("LoadConfig = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// restartSettings only take effect when the process is restarted: they
+// configure listeners, connections and clients created at startup
+var restartSettings = []string{
+	"server",
+	"kafka",
+	"redis",
+	"nats",
+	"amqp",
+	"mqtt",
+	"archive",
+	"sqlite",
+	"postgres",
+	"nginx",
+	"tracing",
+	"remote_url.timeout",
+	"admin.host",
+	"admin.port",
+}
+
+// Change describes a setting that differs between two configurations.
+// Values are not included since many settings are secrets.
+type Change struct {
+	Setting         string `json:"setting"`
+	Change          string `json:"change"` // added, removed, changed
+	RestartRequired bool   `json:"restart_required,omitempty"`
+}
+
+// String formats the change for logs
+func (c Change) String() string {
+	if c.RestartRequired {
+		return fmt.Sprintf("%s %s (restart required)", c.Setting, c.Change)
+	}
+	return fmt.Sprintf("%s %s", c.Setting, c.Change)
+}
+
+// Reload prepares next to replace the running configuration c. Settings that
+// require a restart keep their current values in the returned configuration
+// and are flagged in the list of changes. An error is returned when next
+// cannot be applied without a restart.
+func (c *Config) Reload(next *Config) (*Config, []Change, error) {
+	var changes []Change
+	diffValues("", reflect.ValueOf(c).Elem(), reflect.ValueOf(next).Elem(), &changes)
+	for i := range changes {
+		changes[i].RestartRequired = requiresRestart(changes[i].Setting)
+	}
+
+	merged := *next
+	merged.Server = c.Server
+	merged.Kafka = c.Kafka
+	merged.Redis = c.Redis
+	merged.NATS = c.NATS
+	merged.AMQP = c.AMQP
+	merged.MQTT = c.MQTT
+	merged.Archive = c.Archive
+	merged.SQLite = c.SQLite
+	merged.Postgres = c.Postgres
+	merged.Nginx = c.Nginx
+	merged.Tracing = c.Tracing
+
+	// The HTTP client is created at startup, only the default URL can change
+	switch {
+	case c.RemoteURL == nil:
+		merged.RemoteURL = nil
+	case next.RemoteURL == nil:
+		remoteURL := *c.RemoteURL
+		remoteURL.URL = ""
+		merged.RemoteURL = &remoteURL
+	default:
+		remoteURL := *next.RemoteURL
+		remoteURL.Timeout = c.RemoteURL.Timeout
+		merged.RemoteURL = &remoteURL
+	}
+
+	// The admin listener stays up until restart, only the token can change
+	switch {
+	case c.Admin == nil:
+		merged.Admin = nil
+	case next.Admin == nil:
+		merged.Admin = c.Admin
+	default:
+		admin := *next.Admin
+		admin.Host = c.Admin.Host
+		admin.Port = c.Admin.Port
+		merged.Admin = &admin
+	}
+
+	if err := merged.Validate(); err != nil {
+		return nil, changes, fmt.Errorf("config cannot be applied without restart: %w", err)
+	}
+	if merged.RemoteURL == nil && merged.HasDestinationType("remote_url") {
+		return nil, changes, fmt.Errorf("config cannot be applied without restart: remote_url destinations require remote_url configuration at startup")
+	}
+
+	return &merged, changes, nil
+}
+
+// requiresRestart reports whether a changed setting only takes effect on restart
+func requiresRestart(setting string) bool {
+	// Adding or removing these sections creates or stops clients and listeners
+	if setting == "remote_url" || setting == "admin" {
+		return true
+	}
+	for _, restart := range restartSettings {
+		if setting == restart || strings.HasPrefix(setting, restart+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// diffValues appends the settings that differ between a and b to changes.
+// Settings are named by their YAML keys; routes are identified by path.
+func diffValues(name string, a, b reflect.Value, changes *[]Change) {
+	switch a.Kind() {
+	case reflect.Ptr:
+		switch {
+		case a.IsNil() && b.IsNil():
+		case a.IsNil():
+			*changes = append(*changes, Change{Setting: name, Change: "added"})
+		case b.IsNil():
+			*changes = append(*changes, Change{Setting: name, Change: "removed"})
+		default:
+			diffValues(name, a.Elem(), b.Elem(), changes)
+		}
+
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			key := strings.Split(a.Type().Field(i).Tag.Get("yaml"), ",")[0]
+			if key == "" || key == "-" {
+				continue
+			}
+			if name != "" {
+				key = name + "." + key
+			}
+			diffValues(key, a.Field(i), b.Field(i), changes)
+		}
+
+	case reflect.Slice:
+		if routes, ok := a.Interface().([]RouteConfig); ok {
+			diffRoutes(name, routes, b.Interface().([]RouteConfig), changes)
+			return
+		}
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			*changes = append(*changes, Change{Setting: name, Change: "changed"})
+		}
+
+	default:
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			*changes = append(*changes, Change{Setting: name, Change: "changed"})
+		}
+	}
+}
+
+// diffRoutes appends the routes added, removed or changed between a and b
+func diffRoutes(name string, a, b []RouteConfig, changes *[]Change) {
+	current := make(map[string]*RouteConfig, len(a))
+	for i := range a {
+		current[a[i].Path] = &a[i]
+	}
+
+	for i := range b {
+		setting := fmt.Sprintf("%s[%s]", name, b[i].Path)
+		route, ok := current[b[i].Path]
+		if !ok {
+			*changes = append(*changes, Change{Setting: setting, Change: "added"})
+			continue
+		}
+		delete(current, b[i].Path)
+		diffValues(setting, reflect.ValueOf(route).Elem(), reflect.ValueOf(&b[i]).Elem(), changes)
+	}
+
+	for i := range a {
+		if _, removed := current[a[i].Path]; removed {
+			*changes = append(*changes, Change{Setting: fmt.Sprintf("%s[%s]", name, a[i].Path), Change: "removed"})
+		}
+	}
+}
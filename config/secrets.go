@@ -0,0 +1,172 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redactedValue replaces secrets in output
+const redactedValue = "[REDACTED]"
+
+// envPattern matches ${VAR} and ${VAR:-default}. A leading $ escapes the
+// reference, so $${VAR} stands for the literal text ${VAR}.
+var envPattern = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// secretKeys can be read from a file by appending _file to the key, e.g.
+// sasl_password_file. Files are typically Docker or Kubernetes secrets. url
+// is included because AMQP and remote URLs embed credentials.
+var secretKeys = map[string]bool{
+	"sasl_password":     true,
+	"password":          true,
+	"secret":            true,
+	"verify_token":      true,
+	"token":             true,
+	"dsn":               true,
+	"secret_access_key": true,
+	"url":               true,
+}
+
+// resolveNode expands environment variables in all scalar values of node
+// and replaces *_file secret keys with the contents of the files they name
+func resolveNode(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			if err := resolveNode(child); err != nil {
+				return err
+			}
+		}
+
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if err := resolveNode(value); err != nil {
+				return err
+			}
+			if err := readSecretFile(node, key, value); err != nil {
+				return err
+			}
+		}
+
+	case yaml.ScalarNode:
+		expanded, err := expandEnv(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		// A plain scalar is typed by what it expands to, so port: ${PORT}
+		// decodes into an int. Quoted scalars stay strings.
+		if envPattern.MatchString(node.Value) && node.Style&^yaml.FlowStyle == 0 {
+			node.Tag = ""
+		}
+		node.Value = expanded
+	}
+
+	return nil
+}
+
+// expandEnv replaces ${VAR} and ${VAR:-default} references in value. A
+// variable that is not set and has no default is an error.
+func expandEnv(value string) (string, error) {
+	var err error
+	expanded := envPattern.ReplaceAllStringFunc(value, func(match string) string {
+		if strings.HasPrefix(match, "$$") {
+			return match[1:]
+		}
+
+		groups := envPattern.FindStringSubmatch(match)
+		if env, ok := os.LookupEnv(groups[1]); ok && (env != "" || groups[2] == "") {
+			return env
+		}
+		if groups[2] != "" {
+			return groups[3]
+		}
+		if err == nil {
+			err = fmt.Errorf("environment variable %s is not set", groups[1])
+		}
+		return ""
+	})
+	return expanded, err
+}
+
+// readSecretFile turns a secret key with the _file suffix of mapping into the
+// plain key holding the file contents. Trailing newlines are removed.
+func readSecretFile(mapping, key, value *yaml.Node) error {
+	name, ok := strings.CutSuffix(key.Value, "_file")
+	if !ok || !secretKeys[name] {
+		return nil
+	}
+
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == name {
+			return fmt.Errorf("line %d: %s and %s are mutually exclusive", key.Line, name, key.Value)
+		}
+	}
+
+	data, err := os.ReadFile(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: failed to read %s: %w", key.Line, key.Value, err)
+	}
+
+	key.Value = name
+	value.Value = strings.TrimRight(string(data), "\r\n")
+	value.Tag = "!!str"
+	value.Style = 0
+	return nil
+}
+
+// RedactURL masks the password and query parameter values of rawURL, which
+// often carry credentials
+func RedactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return redactedValue
+	}
+
+	if parsed.User != nil {
+		if _, ok := parsed.User.Password(); ok {
+			parsed.User = url.UserPassword(parsed.User.Username(), redactedValue)
+		}
+	}
+
+	query := parsed.Query()
+	for key := range query {
+		query.Set(key, redactedValue)
+	}
+	parsed.RawQuery = query.Encode()
+
+	// Keep the placeholder readable instead of percent-encoded
+	return strings.NewReplacer("%5BREDACTED%5D", redactedValue).Replace(parsed.String())
+}
+
+// Redacted returns a copy of the route that is safe to expose: secrets are
+// cleared and credentials in destination URLs are masked
+func (r RouteConfig) Redacted() RouteConfig {
+	if r.Signature != nil {
+		signature := *r.Signature
+		signature.Secret = redactedValue
+		r.Signature = &signature
+	}
+	if r.Handshake != nil {
+		handshake := *r.Handshake
+		if handshake.VerifyToken != "" {
+			handshake.VerifyToken = redactedValue
+		}
+		r.Handshake = &handshake
+	}
+
+	destinations := make([]DestinationConfig, len(r.Destinations))
+	for i, dest := range r.Destinations {
+		if dest.URL != "" {
+			dest.URL = RedactURL(dest.URL)
+		}
+		destinations[i] = dest
+	}
+	r.Destinations = destinations
+
+	return r
+}
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// TemplatePlaceholder matches a {name} placeholder of a destination template
+var TemplatePlaceholder = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// validateTemplate checks that every placeholder of a destination template is
+// one of {queue}, {path}, {id}, {header.<name>} and {payload.<field path>}
+func validateTemplate(template string) error {
+	for _, match := range TemplatePlaceholder.FindAllStringSubmatch(template, -1) {
+		name := match[1]
+		switch {
+		case name == "queue", name == "path", name == "id":
+		case strings.HasPrefix(name, "header.") && len(name) > len("header."):
+		case strings.HasPrefix(name, "payload.") && len(name) > len("payload."):
+		default:
+			return fmt.Errorf("unsupported placeholder: {%s}", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/storage"
+)
+
+const dlqUsage = `Usage: messagebridge dlq <command> -config /path/to/config.yaml [options]
+
+Commands:
+  list     List dead letters
+  show     Show a dead letter with body, headers, deliveries and attempts (-id)
+  replay   Move dead letters back to the queue
+  delete   Permanently remove dead letters
+
+Filters (list, replay, delete):
+  -id      Dead letter ID
+  -route   Route path, e.g. /webhook/payment
+  -from    Failed at or after (RFC3339)
+  -to      Failed at or before (RFC3339)
+  -error   Substring of the last error
+  -all     Required by replay and delete when no other filter is given
+`
+
+// runDLQCommand implements the "dlq" subcommand
+func runDLQCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Print(dlqUsage)
+		return ExitFailure
+	}
+
+	command := args[0]
+	fs := flag.NewFlagSet("dlq "+command, flag.ContinueOnError)
+	configFile := fs.String("config", "", "Path to configuration file (required)")
+	id := fs.String("id", "", "Dead letter ID")
+	route := fs.String("route", "", "Route path")
+	from := fs.String("from", "", "Failed at or after (RFC3339)")
+	to := fs.String("to", "", "Failed at or before (RFC3339)")
+	errorPattern := fs.String("error", "", "Substring of the last error")
+	all := fs.Bool("all", false, "Select all dead letters")
+	limit := fs.Int("limit", 50, "Maximum number of dead letters to list")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return ExitFailure
+	}
+
+	if *configFile == "" {
+		fmt.Println("Error: -config flag is required")
+		fmt.Print(dlqUsage)
+		return ExitFailure
+	}
+
+	filter := models.DeadLetterFilter{
+		Path:         *route,
+		ErrorPattern: *errorPattern,
+	}
+	if *id != "" {
+		filter.IDs = []string{*id}
+	}
+
+	var err error
+	if filter.From, err = parseCLITime(*from); err != nil {
+		fmt.Printf("Error: invalid -from: %v\n", err)
+		return ExitFailure
+	}
+	if filter.To, err = parseCLITime(*to); err != nil {
+		fmt.Printf("Error: invalid -to: %v\n", err)
+		return ExitFailure
+	}
+
+	store, err := openStorage(*configFile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return ExitFailure
+	}
+	defer store.Close()
+
+	switch command {
+	case "list":
+		err = dlqList(store, filter, *limit)
+	case "show":
+		if *id == "" {
+			err = fmt.Errorf("-id is required")
+			break
+		}
+		err = dlqShow(store, *id)
+	case "replay":
+		if filter.IsEmpty() && !*all {
+			err = fmt.Errorf("a filter or -all is required")
+			break
+		}
+		var replayed []string
+		replayed, err = store.ReplayDeadLetters(filter)
+		fmt.Printf("Replayed %d dead letters\n", len(replayed))
+	case "delete":
+		if filter.IsEmpty() && !*all {
+			err = fmt.Errorf("a filter or -all is required")
+			break
+		}
+		var deleted int
+		deleted, err = store.DeleteDeadLetters(filter)
+		fmt.Printf("Deleted %d dead letters\n", deleted)
+	default:
+		fmt.Printf("Error: unknown dlq command: %s\n", command)
+		fmt.Print(dlqUsage)
+		return ExitFailure
+	}
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return ExitFailure
+	}
+
+	return ExitSuccess
+}
+
+// runReplayCommand implements the "replay" subcommand, a shorthand for
+// "dlq replay"
+func runReplayCommand(args []string) int {
+	return runDLQCommand(append([]string{"replay"}, args...))
+}
+
+// dlqList prints dead letters as a table
+func dlqList(store storage.Storage, filter models.DeadLetterFilter, limit int) error {
+	deadLetters, err := store.ListDeadLetters(filter, limit, 0)
+	if err != nil {
+		return err
+	}
+
+	total, err := store.CountDeadLetters(filter)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tROUTE\tFAILED AT\tRETRIES\tERROR")
+	for _, deadLetter := range deadLetters {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", deadLetter.ID, deadLetter.Path,
+			deadLetter.FailedAt.Format(time.RFC3339), deadLetter.Retries, truncate(deadLetter.Error, 80))
+	}
+	tw.Flush()
+
+	fmt.Printf("\nShowing %d of %d dead letters\n", len(deadLetters), total)
+	return nil
+}
+
+// dlqShow prints a single dead letter as JSON
+func dlqShow(store storage.Storage, id string) error {
+	deadLetter, err := store.GetDeadLetter(id)
+	if err != nil {
+		return fmt.Errorf("failed to get dead letter %s: %w", id, err)
+	}
+
+	attempts, err := store.GetAttempts(id)
+	if err != nil {
+		return fmt.Errorf("failed to get attempts of %s: %w", id, err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string]interface{}{
+		"dead_letter": deadLetter,
+		"body":        string(deadLetter.Body),
+		"attempts":    attempts,
+	})
+}
+
+// openStorage opens the SQLite or PostgreSQL storage configured in configFile
+func openStorage(configFile string) (storage.Storage, error) {
+	cfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if cfg.StorageBackend() == "" {
+		return nil, fmt.Errorf("neither sqlite nor postgres is configured")
+	}
+
+	return storage.Open(cfg)
+}
+
+// parseCLITime parses an optional RFC3339 timestamp
+func parseCLITime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// truncate shortens s to at most n characters
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
@@ -1,125 +1,217 @@
 package handler
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"strings"
+	"sync/atomic"
 
 	"github.com/expai/messagebridge/config"
 	"github.com/expai/messagebridge/httpclient"
 	"github.com/expai/messagebridge/kafka"
+	"github.com/expai/messagebridge/logging"
 	"github.com/expai/messagebridge/models"
 	"github.com/expai/messagebridge/storage"
+	"github.com/expai/messagebridge/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// logger is the handler component logger
+var logger = logging.For("handler")
+
+// Notifier is notified when a new message has been stored
+type Notifier interface {
+	Notify()
+}
+
+// HealthChecker reports whether a destination is reachable
+type HealthChecker interface {
+	HealthCheck() error
+}
+
 // MessageHandler processes webhook messages
 type MessageHandler struct {
-	config        *config.Config
+	config        atomic.Pointer[config.Config]
 	kafkaProducer *kafka.Producer
 	httpClient    *httpclient.Client
-	storage       *storage.SQLiteStorage
+	storage       storage.Storage
+	notifier      Notifier
+	healthChecks  map[string]HealthChecker
 }
 
 // NewMessageHandler creates a new message handler
-func NewMessageHandler(cfg *config.Config, kafkaProducer *kafka.Producer, httpClient *httpclient.Client, storage *storage.SQLiteStorage) *MessageHandler {
-	return &MessageHandler{
-		config:        cfg,
+func NewMessageHandler(cfg *config.Config, kafkaProducer *kafka.Producer, httpClient *httpclient.Client, storage storage.Storage) *MessageHandler {
+	h := &MessageHandler{
 		kafkaProducer: kafkaProducer,
 		httpClient:    httpClient,
 		storage:       storage,
 	}
+	h.config.Store(cfg)
+	return h
 }
 
-// ProcessWebhook processes incoming webhook messages
-func (h *MessageHandler) ProcessWebhookOld(msg *models.WebhookMessage) error {
-	log.Printf("Processing webhook message %s for queue %s", msg.ID, msg.Queue)
-
-	// Determine delivery strategy
-	target := h.getDeliveryTarget()
-
-	var err error
-	switch target.Type {
-	case models.TargetKafka:
-		err = h.sendToKafka(msg)
-	case models.TargetRemoteURL:
-		err = h.sendToRemoteURL(msg)
-	default:
-		err = h.sendToKafka(msg) // Default to Kafka
-	}
-
-	// If sending failed and SQLite is configured, save for retry
-	if err != nil && h.storage != nil {
-		log.Printf("Failed to send message %s immediately, saving to storage: %v", msg.ID, err)
-		msg.Status = models.StatusPending
-		msg.Error = err.Error()
-
-		if saveErr := h.storage.SaveMessage(msg); saveErr != nil {
-			log.Printf("Failed to save message %s to storage: %v", msg.ID, saveErr)
-			return fmt.Errorf("failed to send message and save to storage: send error: %w, save error: %v", err, saveErr)
-		}
+// Reload replaces the routes and destinations used for new messages
+func (h *MessageHandler) Reload(cfg *config.Config) {
+	h.config.Store(cfg)
+}
 
-		log.Printf("Message %s saved to storage for retry", msg.ID)
-		return nil // Return success since we saved it for retry
-	}
+// SetNotifier sets the component to wake up once a message has been stored
+func (h *MessageHandler) SetNotifier(notifier Notifier) {
+	h.notifier = notifier
+}
 
-	if err != nil {
-		return fmt.Errorf("failed to send message %s: %w", msg.ID, err)
+// AddHealthCheck adds a destination to the health report under name
+func (h *MessageHandler) AddHealthCheck(name string, checker HealthChecker) {
+	if h.healthChecks == nil {
+		h.healthChecks = make(map[string]HealthChecker)
 	}
-
-	msg.Status = models.StatusSent
-	log.Printf("Message %s sent successfully", msg.ID)
-	return nil
+	h.healthChecks[name] = checker
 }
 
 // ProcessWebhook processes incoming webhook messages and stores them in database
-func (h *MessageHandler) ProcessWebhook(msg *models.WebhookMessage) error {
-	log.Printf("Processing webhook message %s for queue %s", msg.ID, msg.Queue)
+func (h *MessageHandler) ProcessWebhook(ctx context.Context, msg *models.WebhookMessage) error {
+	logger.Debug("Processing webhook message", "message_id", msg.ID, "route", msg.Path, "queue", msg.Queue)
 
 	if h.storage == nil {
 		return fmt.Errorf("storage is not configured")
 	}
 
 	msg.Status = models.StatusPending
+	if len(msg.Destinations) == 0 {
+		msg.Destinations = h.getDeliveryTargets(msg)
+	}
+	if msg.OrderingKey == "" {
+		msg.OrderingKey = h.getOrderingKey(msg)
+	}
 
-	if err := h.storage.SaveMessage(msg); err != nil {
-		log.Printf("Failed to save message %s to storage: %v", msg.ID, err)
+	_, span := tracing.Start(ctx, "storage.save_message",
+		trace.WithAttributes(attribute.String("messaging.message.id", msg.ID)))
+	err := h.storage.SaveMessage(msg)
+	tracing.End(span, err)
+	if err != nil {
+		logger.Error("Failed to save message to storage", "message_id", msg.ID, "route", msg.Path, "error", err)
 		return fmt.Errorf("failed to save message to storage: %w", err)
 	}
 
-	log.Printf("Message %s saved to storage for processing by worker", msg.ID)
+	logger.Debug("Message saved to storage for processing by worker", "message_id", msg.ID, "route", msg.Path)
+
+	// The message is durable now, hand it to the worker without waiting for the next poll
+	if h.notifier != nil {
+		h.notifier.Notify()
+	}
+
 	return nil
 }
 
-// sendToKafka sends message to Kafka
-func (h *MessageHandler) sendToKafka(msg *models.WebhookMessage) error {
-	if h.kafkaProducer == nil {
-		return fmt.Errorf("Kafka producer not configured")
+// getDeliveryTargets determines where to send msg
+func (h *MessageHandler) getDeliveryTargets(msg *models.WebhookMessage) []models.DeliveryTarget {
+	cfg := h.config.Load()
+	route := cfg.FindRoute(msg.Path)
+
+	// Route-specific destinations take precedence
+	if route != nil && len(route.Destinations) > 0 {
+		targets := make([]models.DeliveryTarget, 0, len(route.Destinations))
+		for _, dest := range route.Destinations {
+			target := models.DeliveryTarget{
+				Type:  models.TargetType(dest.Type),
+				Topic: dest.Topic,
+				URL:   dest.URL,
+			}
+			if (target.Type == models.TargetKafka || target.Type == models.TargetRedis) && target.Topic == "" {
+				target.Topic = route.Queue
+			}
+			if target.Type == models.TargetNATS && target.Topic == "" && cfg.NATS != nil {
+				target.Topic = cfg.NATS.SubjectPrefix + route.Queue
+			}
+			if target.Type == models.TargetMQTT {
+				target.Topic = route.Queue
+				if dest.Topic != "" {
+					target.Topic = expandTemplate(dest.Topic, msg, mqttTopicEscaper.Replace)
+				}
+			}
+			if target.Type == models.TargetAMQP {
+				if target.Topic == "" && cfg.AMQP != nil {
+					target.Topic = cfg.AMQP.Exchange
+				}
+				target.RoutingKey = route.Queue
+				if dest.RoutingKey != "" {
+					target.RoutingKey = expandTemplate(dest.RoutingKey, msg, amqpRoutingKeyEscaper.Replace)
+				}
+			}
+			if target.Type == models.TargetRemoteURL && target.URL == "" {
+				target.URL = cfg.RemoteURL.URL
+			}
+			targets = append(targets, target)
+		}
+		return targets
+	}
+
+	// If remote URL is configured, prefer it
+	if cfg.RemoteURL != nil && cfg.RemoteURL.URL != "" {
+		return []models.DeliveryTarget{{
+			Type: models.TargetRemoteURL,
+			URL:  cfg.RemoteURL.URL,
+		}}
 	}
 
-	return h.kafkaProducer.SendMessageWithRetry(msg)
+	// Default to Kafka
+	target := models.DeliveryTarget{Type: models.TargetKafka}
+	if route != nil {
+		target.Topic = route.Queue
+	}
+	return []models.DeliveryTarget{target}
 }
 
-// sendToRemoteURL sends message to remote URL
-func (h *MessageHandler) sendToRemoteURL(msg *models.WebhookMessage) error {
-	if h.httpClient == nil {
-		return fmt.Errorf("HTTP client not configured")
+// getOrderingKey determines which messages must be delivered in sequence with msg
+func (h *MessageHandler) getOrderingKey(msg *models.WebhookMessage) string {
+	route := h.config.Load().FindRoute(msg.Path)
+	if route == nil || route.OrderingKey == "" {
+		return msg.Path
+	}
+
+	value, ok := payloadField(msg.Body, route.OrderingKey)
+	if !ok {
+		logger.Warn("Ordering key not found in message, ordering by route",
+			"message_id", msg.ID, "route", msg.Path, "ordering_key", route.OrderingKey)
+		return msg.Path
 	}
 
-	return h.httpClient.SendMessageWithRetry(msg)
+	return msg.Path + "|" + value
 }
 
-// getDeliveryTarget determines where to send the message
-func (h *MessageHandler) getDeliveryTarget() *models.DeliveryTarget {
-	// If remote URL is configured, prefer it
-	if h.config.RemoteURL != nil && h.config.RemoteURL.URL != "" {
-		return &models.DeliveryTarget{
-			Type: models.TargetRemoteURL,
-			URL:  h.config.RemoteURL.URL,
+// payloadField extracts a scalar value at a dot-separated path from a JSON body
+func payloadField(body []byte, path string) (string, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return "", false
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if value, ok = object[key]; !ok {
+			return "", false
 		}
 	}
 
-	// Default to Kafka
-	return &models.DeliveryTarget{
-		Type: models.TargetKafka,
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return fmt.Sprint(v), true
+	default:
+		return "", false
 	}
 }
 
@@ -163,6 +255,20 @@ func (h *MessageHandler) HealthCheck() map[string]interface{} {
 		}
 	}
 
+	// Check destinations delivered by sinks
+	for name, checker := range h.healthChecks {
+		if err := checker.HealthCheck(); err != nil {
+			health[name] = map[string]interface{}{
+				"status": "unhealthy",
+				"error":  err.Error(),
+			}
+		} else {
+			health[name] = map[string]interface{}{
+				"status": "healthy",
+			}
+		}
+	}
+
 	// Check Storage
 	if h.storage != nil {
 		stats, err := h.storage.GetMessageStats()
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/storage"
+)
+
+// countingNotifier counts the notifications it receives
+type countingNotifier struct {
+	notified int
+}
+
+// Notify records a notification
+func (n *countingNotifier) Notify() {
+	n.notified++
+}
+
+// failingStorage fails to save messages
+type failingStorage struct {
+	storage.Storage
+}
+
+// SaveMessage returns an error
+func (failingStorage) SaveMessage(*models.WebhookMessage) error {
+	return errors.New("disk full")
+}
+
+func TestProcessWebhookNotifiesOnceStored(t *testing.T) {
+	cfg := &config.Config{Routes: []config.RouteConfig{{Path: "/webhook/devices", Queue: "devices"}}}
+	store := storage.NewMemoryStorage()
+	h := NewMessageHandler(cfg, nil, nil, store)
+	notifier := &countingNotifier{}
+	h.SetNotifier(notifier)
+
+	msg := templateMessage(`{"device_id":"sensor-7"}`)
+	if err := h.ProcessWebhook(context.Background(), msg); err != nil {
+		t.Fatalf("ProcessWebhook: %v", err)
+	}
+	if notifier.notified != 1 {
+		t.Errorf("notified %d times, want once", notifier.notified)
+	}
+	if stored, err := store.GetMessage(msg.ID); err != nil || stored.Status != models.StatusPending {
+		t.Errorf("stored message = %v, %v, want it pending", stored, err)
+	}
+
+	// Nothing is handed to the worker when the message could not be stored
+	h = NewMessageHandler(cfg, nil, nil, failingStorage{store})
+	h.SetNotifier(notifier)
+	if err := h.ProcessWebhook(context.Background(), templateMessage(`{}`)); err == nil {
+		t.Fatal("ProcessWebhook succeeded without storage")
+	}
+	if notifier.notified != 1 {
+		t.Errorf("notified %d times after a failed save, want once", notifier.notified)
+	}
+}
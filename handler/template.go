@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"strings"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/models"
+)
+
+// mqttTopicEscaper percent-encodes the characters that would let a value
+// filled in from a message add levels to an MQTT topic or turn it into a
+// filter, so {payload.device_id} always fills exactly one level
+var mqttTopicEscaper = strings.NewReplacer("%", "%25", "/", "%2F", "+", "%2B", "#", "%23", "\x00", "%00")
+
+// amqpRoutingKeyEscaper percent-encodes the characters that would let a
+// value filled in from a message add words to an AMQP routing key or match
+// other bindings of a topic exchange, so {payload.type} always fills exactly
+// one word
+var amqpRoutingKeyEscaper = strings.NewReplacer("%", "%25", ".", "%2E", "*", "%2A", "#", "%23")
+
+// expandTemplate fills in the placeholders of a destination template from
+// msg. Values missing from the message are left empty. Values taken from the
+// message itself, i.e. all but {queue} and {path}, are passed through escape
+// unless it is nil.
+func expandTemplate(template string, msg *models.WebhookMessage, escape func(string) string) string {
+	return config.TemplatePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		value, ok := templateValue(name, msg)
+		if !ok {
+			logger.Warn("Template value not found in message, leaving it empty",
+				"message_id", msg.ID, "route", msg.Path, "placeholder", placeholder)
+		}
+		if escape != nil && name != "queue" && name != "path" {
+			value = escape(value)
+		}
+		return value
+	})
+}
+
+// templateValue returns the value of a template placeholder for msg
+func templateValue(name string, msg *models.WebhookMessage) (string, bool) {
+	switch {
+	case name == "queue":
+		return msg.Queue, true
+	case name == "path":
+		return msg.Path, true
+	case name == "id":
+		return msg.ID, true
+	case strings.HasPrefix(name, "header."):
+		header := strings.TrimPrefix(name, "header.")
+		for key, value := range msg.Headers {
+			if strings.EqualFold(key, header) {
+				return value, true
+			}
+		}
+		return "", false
+	case strings.HasPrefix(name, "payload."):
+		return payloadField(msg.Body, strings.TrimPrefix(name, "payload."))
+	default:
+		return "", false
+	}
+}
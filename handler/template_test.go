@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/models"
+)
+
+// templateMessage returns a message with headers and a nested JSON body
+func templateMessage(body string) *models.WebhookMessage {
+	return &models.WebhookMessage{
+		ID:      "0b4c6e1a-1f7e-4d1c-9a57-0d2b6c9e1f00",
+		Path:    "/webhook/devices",
+		Queue:   "devices",
+		Body:    []byte(body),
+		Headers: map[string]string{"X-Event-Type": "telemetry", "X-Tenant": "acme/eu"},
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	msg := templateMessage(`{"type":"order.created","device_id":"sensor-7","count":3,"test":true,"data":{"object":{"id":"pi_1"}},"list":[1]}`)
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"orders", "orders"},
+		{"{queue}.{id}", "devices.0b4c6e1a-1f7e-4d1c-9a57-0d2b6c9e1f00"},
+		{"hooks{path}", "hooks/webhook/devices"},
+		{"events.{header.x-event-type}", "events.telemetry"},
+		{"orders.{payload.type}", "orders.order.created"},
+		{"{payload.data.object.id}", "pi_1"},
+		{"{payload.count}.{payload.test}", "3.true"},
+		{"missing.{payload.nope}.{header.nope}", "missing.."},
+		{"not-a-scalar.{payload.list}.{payload.data}", "not-a-scalar.."},
+	}
+	for _, tt := range tests {
+		if got := expandTemplate(tt.template, msg, nil); got != tt.want {
+			t.Errorf("expandTemplate(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+
+	// A body that is not JSON leaves payload values empty
+	if got := expandTemplate("x.{payload.type}", templateMessage("not json"), nil); got != "x." {
+		t.Errorf("expandTemplate with a non-JSON body = %q, want %q", got, "x.")
+	}
+}
+
+func TestExpandMQTTTopic(t *testing.T) {
+	msg := templateMessage(`{"device_id":"../../admin/#","room":"a+b","note":"100%","ok":"sensor-7"}`)
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"devices/{payload.ok}/events", "devices/sensor-7/events"},
+		{"devices/{payload.device_id}/events", "devices/..%2F..%2Fadmin%2F%23/events"},
+		{"rooms/{payload.room}", "rooms/a%2Bb"},
+		{"notes/{payload.note}", "notes/100%25"},
+		{"tenants/{header.x-tenant}", "tenants/acme%2Feu"},
+		// Values from the configuration are used as they are
+		{"bridge{path}/{queue}", "bridge/webhook/devices/devices"},
+	}
+	for _, tt := range tests {
+		if got := expandTemplate(tt.template, msg, mqttTopicEscaper.Replace); got != tt.want {
+			t.Errorf("expandTemplate(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestExpandAMQPRoutingKey(t *testing.T) {
+	msg := templateMessage(`{"device_id":"lab.7.#","type":"*.created","note":"100%","ok":"sensor-7"}`)
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"devices.{payload.ok}.events", "devices.sensor-7.events"},
+		{"devices.{payload.device_id}.events", "devices.lab%2E7%2E%23.events"},
+		{"orders.{payload.type}", "orders.%2A%2Ecreated"},
+		{"notes.{payload.note}", "notes.100%25"},
+		{"tenants.{header.x-tenant}", "tenants.acme/eu"},
+		// Values from the configuration are used as they are
+		{"{queue}.{path}", "devices./webhook/devices"},
+	}
+	for _, tt := range tests {
+		if got := expandTemplate(tt.template, msg, amqpRoutingKeyEscaper.Replace); got != tt.want {
+			t.Errorf("expandTemplate(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestMQTTDeliveryTargets(t *testing.T) {
+	h := NewMessageHandler(&config.Config{
+		Routes: []config.RouteConfig{{
+			Path:  "/webhook/devices",
+			Queue: "devices",
+			Destinations: []config.DestinationConfig{
+				{Type: "mqtt", Topic: "devices/{payload.device_id}/events"},
+				{Type: "mqtt"},
+			},
+		}},
+	}, nil, nil, nil)
+
+	targets := h.getDeliveryTargets(templateMessage(`{"device_id":"lab/7"}`))
+	if len(targets) != 2 {
+		t.Fatalf("got %d targets, want 2", len(targets))
+	}
+	if targets[0].Topic != "devices/lab%2F7/events" {
+		t.Errorf("mqtt topic = %q, want devices/lab%%2F7/events", targets[0].Topic)
+	}
+	if targets[1].Topic != "devices" {
+		t.Errorf("mqtt topic without template = %q, want the route queue", targets[1].Topic)
+	}
+}
+
+func TestAMQPDeliveryTargets(t *testing.T) {
+	h := NewMessageHandler(&config.Config{
+		AMQP: &config.AMQPConfig{Exchange: "webhooks"},
+		Routes: []config.RouteConfig{{
+			Path:  "/webhook/devices",
+			Queue: "devices",
+			Destinations: []config.DestinationConfig{
+				{Type: "amqp", RoutingKey: "devices.{payload.device_id}.{header.x-event-type}"},
+				{Type: "amqp", Topic: "audit"},
+			},
+		}},
+	}, nil, nil, nil)
+
+	targets := h.getDeliveryTargets(templateMessage(`{"device_id":"lab.7"}`))
+	if len(targets) != 2 {
+		t.Fatalf("got %d targets, want 2", len(targets))
+	}
+
+	// A value from the message fills a single word of the routing key
+	if targets[0].Topic != "webhooks" || targets[0].RoutingKey != "devices.lab%2E7.telemetry" {
+		t.Errorf("amqp target = %q %q, want webhooks devices.lab%%2E7.telemetry", targets[0].Topic, targets[0].RoutingKey)
+	}
+	if targets[1].Topic != "audit" || targets[1].RoutingKey != "devices" {
+		t.Errorf("amqp target without routing key = %q %q, want audit devices", targets[1].Topic, targets[1].RoutingKey)
+	}
+}
@@ -5,14 +5,26 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"time"
 
 	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/logging"
+	"github.com/expai/messagebridge/metrics"
 	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// logger is the HTTP client component logger
+var logger = logging.For("http")
+
+// maxResponseBody limits how much of a response body is read
+const maxResponseBody = 64 * 1024
+
 // Client represents HTTP client for sending webhooks
 type Client struct {
 	client *http.Client
@@ -34,11 +46,29 @@ func NewClient(cfg *config.RemoteURLConfig) *Client {
 	}
 }
 
-// SendMessage sends a webhook message to remote URL
+// SendMessage sends a webhook message to the configured remote URL
 func (c *Client) SendMessage(msg *models.WebhookMessage) error {
-	req, err := http.NewRequest("POST", c.config.URL, bytes.NewReader(msg.Body))
+	_, err := c.SendMessageToURL(tracing.MessageContext(msg), c.config.URL, msg)
+	return err
+}
+
+// SendMessageToURL sends a webhook message to the given URL and returns the
+// response status and body. The result is also returned for rejected requests.
+// The trace context of ctx is passed on in the traceparent header.
+func (c *Client) SendMessageToURL(ctx context.Context, url string, msg *models.WebhookMessage) (result *models.DeliveryResult, err error) {
+	ctx, span := tracing.Start(ctx, "http.send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", "POST"),
+			attribute.String("url.full", destination(url)),
+			attribute.String("messaging.message.id", msg.ID),
+		),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(msg.Body))
 	if err != nil {
-		return fmt.Errorf("failed to create HTTP request: %w", err)
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
 	}
 
 	// Set headers from original webhook
@@ -57,56 +87,47 @@ func (c *Client) SendMessage(msg *models.WebhookMessage) error {
 		req.Header.Set("Content-Type", "application/json")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), c.config.Timeout)
+	// Replaces the traceparent the webhook was received with
+	tracing.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
 	defer cancel()
 
 	req = req.WithContext(ctx)
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send HTTP request: %w", err)
+		metrics.ClientErrors.WithLabelValues("http", "request").Inc()
+		return nil, fmt.Errorf("failed to send HTTP request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Read response body for logging
-	body, _ := io.ReadAll(resp.Body)
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("HTTP request failed with status %d: %s", resp.StatusCode, string(body))
+	result = &models.DeliveryResult{
+		StatusCode: resp.StatusCode,
+		Response:   string(body),
 	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 
-	log.Printf("Message %s sent to remote URL successfully - Status: %d", msg.ID, resp.StatusCode)
-	return nil
-}
-
-// SendMessageWithRetry sends a message with built-in retry logic
-func (c *Client) SendMessageWithRetry(msg *models.WebhookMessage) error {
-	var lastErr error
-
-	for attempt := 0; attempt <= c.config.Retries; attempt++ {
-		if attempt > 0 {
-			backoff := time.Duration(attempt*attempt) * time.Second
-			log.Printf("Retrying HTTP send for message %s, attempt %d/%d after %v",
-				msg.ID, attempt, c.config.Retries, backoff)
-			time.Sleep(backoff)
-		}
-
-		err := c.SendMessage(msg)
-		if err == nil {
-			return nil
-		}
-
-		lastErr = err
-		log.Printf("Failed to send message %s to remote URL (attempt %d/%d): %v",
-			msg.ID, attempt+1, c.config.Retries+1, err)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		metrics.ClientErrors.WithLabelValues("http", "status").Inc()
+		return result, fmt.Errorf("HTTP request failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
-	return fmt.Errorf("failed to send message after %d attempts: %w",
-		c.config.Retries+1, lastErr)
+	logger.Debug("Message sent to remote URL",
+		"message_id", msg.ID, "destination", destination(url), "status_code", resp.StatusCode)
+	return result, nil
 }
 
 // HealthCheck checks if remote URL is available
 func (c *Client) HealthCheck() error {
+	// Only per-route URLs are configured, there is no single endpoint to probe
+	if c.config.URL == "" {
+		return nil
+	}
+
 	req, err := http.NewRequest("HEAD", c.config.URL, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create health check request: %w", err)
@@ -129,4 +150,8 @@ func (c *Client) HealthCheck() error {
 
 	return nil
 }
- 
\ No newline at end of file
+
+// destination returns url without credentials and query for logs and spans
+func destination(url string) string {
+	return metrics.Destination(models.DeliveryTarget{Type: models.TargetRemoteURL, URL: url})
+}
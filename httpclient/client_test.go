@@ -0,0 +1,66 @@
+package httpclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/models"
+)
+
+// testMessage returns a message for the orders route
+func testMessage() *models.WebhookMessage {
+	return &models.WebhookMessage{
+		ID:        "msg-1",
+		Path:      "/webhook/orders",
+		Queue:     "orders",
+		Body:      []byte(`{"order":42}`),
+		Headers:   map[string]string{"X-Event": "paid"},
+		Timestamp: time.Now(),
+	}
+}
+
+func TestSendMessageToURLReturnsResponse(t *testing.T) {
+	var received http.Header
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(strings.Repeat("x", maxResponseBody+100)))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"queued":true}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(&config.RemoteURLConfig{Timeout: 5 * time.Second})
+
+	result, err := client.SendMessageToURL(context.Background(), server.URL, testMessage())
+	if err != nil {
+		t.Fatalf("SendMessageToURL: %v", err)
+	}
+	if result.StatusCode != http.StatusAccepted || result.Response != `{"queued":true}` {
+		t.Errorf("result = %+v, want 202 with the response body", result)
+	}
+	if body != `{"order":42}` || received.Get("X-Event") != "paid" || received.Get("X-Webhook-ID") != "msg-1" {
+		t.Errorf("request = %q %v, want the message body and headers", body, received)
+	}
+
+	// Rejected requests still report what the destination replied, truncated
+	result, err = client.SendMessageToURL(context.Background(), server.URL+"?fail=1", testMessage())
+	if err == nil || !strings.Contains(err.Error(), "status 503") {
+		t.Errorf("SendMessageToURL = %v, want a status 503 error", err)
+	}
+	if result == nil || result.StatusCode != http.StatusServiceUnavailable || len(result.Response) != maxResponseBody {
+		t.Errorf("result = %v, want 503 with %d bytes of response", result, maxResponseBody)
+	}
+}
@@ -1,17 +1,25 @@
 package kafka
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
-	"log"
 	"time"
 
 	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/logging"
+	"github.com/expai/messagebridge/metrics"
 	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/tracing"
 
 	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// logger is the Kafka component logger
+var logger = logging.For("kafka")
+
 // Producer represents a Kafka producer
 type Producer struct {
 	producer     sarama.SyncProducer
@@ -86,18 +94,42 @@ func NewProducer(cfg *config.KafkaConfig) (*Producer, error) {
 	}, nil
 }
 
-// SendMessage sends a message to Kafka
+// SendMessage sends a message to Kafka using its queue as the topic
 func (p *Producer) SendMessage(msg *models.WebhookMessage) error {
+	_, err := p.SendMessageToTopic(tracing.MessageContext(msg), msg.Queue, msg)
+	return err
+}
+
+// SendMessageToTopic sends a message to the given Kafka topic and returns
+// the partition and offset it was written to. The trace context of ctx is
+// passed on in the traceparent record header.
+func (p *Producer) SendMessageToTopic(ctx context.Context, topic string, msg *models.WebhookMessage) (result *models.DeliveryResult, err error) {
+	ctx, span := tracing.Start(ctx, "kafka.send "+topic,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", topic),
+			attribute.String("messaging.message.id", msg.ID),
+		),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	headers := make(tracing.HeaderCarrier, len(msg.Headers)+1)
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+	tracing.Inject(ctx, headers)
+
 	kafkaMessage := &sarama.ProducerMessage{
-		Topic:     msg.Queue,
+		Topic:     topic,
 		Key:       sarama.StringEncoder(msg.ID),
 		Value:     sarama.ByteEncoder(msg.Body),
-		Headers:   make([]sarama.RecordHeader, 0, len(msg.Headers)+2),
+		Headers:   make([]sarama.RecordHeader, 0, len(headers)+2),
 		Timestamp: msg.Timestamp,
 	}
 
 	// Add original headers
-	for key, value := range msg.Headers {
+	for key, value := range headers {
 		kafkaMessage.Headers = append(kafkaMessage.Headers, sarama.RecordHeader{
 			Key:   []byte(key),
 			Value: []byte(value),
@@ -118,39 +150,37 @@ func (p *Producer) SendMessage(msg *models.WebhookMessage) error {
 
 	partition, offset, err := p.producer.SendMessage(kafkaMessage)
 	if err != nil {
-		return fmt.Errorf("failed to send message to Kafka: %w", err)
+		metrics.ClientErrors.WithLabelValues("kafka", "send").Inc()
+		return nil, fmt.Errorf("failed to send message to Kafka: %w", err)
 	}
 
-	log.Printf("Message sent to Kafka successfully - Topic: %s, Partition: %d, Offset: %d",
-		msg.Queue, partition, offset)
+	logger.Debug("Message sent to Kafka",
+		"message_id", msg.ID, "destination", topic, "partition", partition, "offset", offset)
+	span.SetAttributes(
+		attribute.Int64("messaging.destination.partition.id", int64(partition)),
+		attribute.Int64("messaging.kafka.offset", offset),
+	)
 
-	return nil
+	return &models.DeliveryResult{
+		Partition: &partition,
+		Offset:    &offset,
+	}, nil
 }
 
-// SendMessageWithRetry sends a message with built-in retry logic
-func (p *Producer) SendMessageWithRetry(msg *models.WebhookMessage) error {
-	var lastErr error
-
-	for attempt := 0; attempt <= p.config.RetryMax; attempt++ {
-		if attempt > 0 {
-			backoff := time.Duration(attempt) * p.config.RetryBackoff
-			log.Printf("Retrying Kafka send for message %s, attempt %d/%d after %v",
-				msg.ID, attempt, p.config.RetryMax, backoff)
-			time.Sleep(backoff)
-		}
-
-		err := p.SendMessage(msg)
-		if err == nil {
-			return nil
-		}
-
-		lastErr = err
-		log.Printf("Failed to send message %s to Kafka (attempt %d/%d): %v",
-			msg.ID, attempt+1, p.config.RetryMax+1, err)
+// SendDeadLetter publishes a dead letter to the dead-letter topic. The failure
+// reason and the original route travel as headers.
+func (p *Producer) SendDeadLetter(topic string, deadLetter *models.DeadLetter) error {
+	msg := *deadLetter.WebhookMessage
+	msg.Headers = make(map[string]string, len(deadLetter.Headers)+3)
+	for key, value := range deadLetter.Headers {
+		msg.Headers[key] = value
 	}
+	msg.Headers["X-Dead-Letter-Error"] = deadLetter.Error
+	msg.Headers["X-Dead-Letter-Queue"] = deadLetter.Queue
+	msg.Headers["X-Dead-Letter-Failed-At"] = deadLetter.FailedAt.Format(time.RFC3339)
 
-	return fmt.Errorf("failed to send message after %d attempts: %w",
-		p.config.RetryMax+1, lastErr)
+	_, err := p.SendMessageToTopic(tracing.MessageContext(&msg), topic, &msg)
+	return err
 }
 
 // HealthCheck checks if Kafka is available
@@ -193,4 +223,3 @@ func (p *Producer) GetTopics() ([]string, error) {
 
 	return topics, nil
 }
- 
\ No newline at end of file
@@ -0,0 +1,222 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/expai/messagebridge/config"
+)
+
+// redactedValue replaces the value of sensitive headers
+const redactedValue = "[REDACTED]"
+
+// defaultRedactedHeaders are never logged. Signature headers of the
+// built-in providers are included so payload signatures don't leak.
+var defaultRedactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"Stripe-Signature",
+	"X-Hub-Signature",
+	"X-Hub-Signature-256",
+	"X-Shopify-Hmac-Sha256",
+	"X-Slack-Signature",
+	"X-Twilio-Signature",
+}
+
+var (
+	mu sync.Mutex
+
+	// output is the handler all loggers write through; generation changes
+	// whenever Setup replaces it so component loggers pick it up
+	output     atomic.Pointer[slog.Handler]
+	generation atomic.Uint64
+
+	defaultLevel = new(slog.LevelVar)
+	levels       = make(map[string]*slog.LevelVar)
+	overrides    = make(map[string]slog.Level)
+
+	redacted atomic.Pointer[map[string]bool]
+)
+
+func init() {
+	setOutput(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	setRedacted(nil)
+
+	// Route the standard library logger, used by dependencies, through the
+	// same output
+	slog.SetDefault(slog.New(&componentHandler{level: defaultLevel}))
+}
+
+// Setup configures the log format, levels and redacted headers. Loggers
+// created before Setup follow the new settings.
+func Setup(cfg *config.Config) error {
+	level, err := config.ParseLogLevel(cfg.Logging.Level)
+	if err != nil {
+		return err
+	}
+
+	componentLevels := make(map[string]slog.Level, len(cfg.Logging.Components))
+	for component, name := range cfg.Logging.Components {
+		if componentLevels[component], err = config.ParseLogLevel(name); err != nil {
+			return err
+		}
+	}
+
+	// Levels are filtered per component, so the output accepts everything
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}
+	if cfg.Logging.Format == "json" {
+		setOutput(slog.NewJSONHandler(os.Stderr, options))
+	} else {
+		setOutput(slog.NewTextHandler(os.Stderr, options))
+	}
+
+	mu.Lock()
+	defaultLevel.Set(level)
+	overrides = componentLevels
+	for component, levelVar := range levels {
+		levelVar.Set(levelOf(component))
+	}
+	mu.Unlock()
+
+	headers := append([]string{}, cfg.Logging.RedactHeaders...)
+	for _, route := range cfg.Routes {
+		if route.Signature != nil && route.Signature.Header != "" {
+			headers = append(headers, route.Signature.Header)
+		}
+		if route.Signature != nil && route.Signature.TimestampHeader != "" {
+			headers = append(headers, route.Signature.TimestampHeader)
+		}
+	}
+	setRedacted(headers)
+
+	return nil
+}
+
+// For returns the logger of a component. Every record carries the component
+// name and is filtered by the component's level.
+func For(component string) *slog.Logger {
+	mu.Lock()
+	levelVar, ok := levels[component]
+	if !ok {
+		levelVar = new(slog.LevelVar)
+		levelVar.Set(levelOf(component))
+		levels[component] = levelVar
+	}
+	mu.Unlock()
+
+	handler := &componentHandler{level: levelVar}
+	return slog.New(handler).With("component", component)
+}
+
+// levelOf returns the configured level of a component. mu must be held.
+func levelOf(component string) slog.Level {
+	if level, ok := overrides[component]; ok {
+		return level
+	}
+	return defaultLevel.Level()
+}
+
+// setOutput replaces the handler all loggers write through
+func setOutput(handler slog.Handler) {
+	output.Store(&handler)
+	generation.Add(1)
+}
+
+// setRedacted sets the headers hidden in addition to the default ones
+func setRedacted(headers []string) {
+	set := make(map[string]bool, len(defaultRedactedHeaders)+len(headers))
+	for _, header := range append(defaultRedactedHeaders, headers...) {
+		set[strings.ToLower(header)] = true
+	}
+	redacted.Store(&set)
+}
+
+// Headers wraps message headers for logging with sensitive values redacted
+type Headers map[string]string
+
+// LogValue implements slog.LogValuer
+func (h Headers) LogValue() slog.Value {
+	sensitive := *redacted.Load()
+
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]slog.Attr, 0, len(keys))
+	for _, key := range keys {
+		value := h[key]
+		if sensitive[strings.ToLower(key)] {
+			value = redactedValue
+		}
+		attrs = append(attrs, slog.String(key, value))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// componentHandler filters records by a component level and writes them
+// through the current output handler
+type componentHandler struct {
+	level slog.Leveler
+	// ops replays With and WithGroup calls on the output handler
+	ops []func(slog.Handler) slog.Handler
+
+	resolved atomic.Pointer[resolvedHandler]
+}
+
+// resolvedHandler caches ops applied to a given output generation
+type resolvedHandler struct {
+	generation uint64
+	handler    slog.Handler
+}
+
+// Enabled implements slog.Handler
+func (h *componentHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle implements slog.Handler
+func (h *componentHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.handler().Handle(ctx, record)
+}
+
+// WithAttrs implements slog.Handler
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+// WithGroup implements slog.Handler
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+// with returns a copy of the handler with op appended
+func (h *componentHandler) with(op func(slog.Handler) slog.Handler) slog.Handler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &componentHandler{level: h.level, ops: append(ops, op)}
+}
+
+// handler returns the output handler with ops applied
+func (h *componentHandler) handler() slog.Handler {
+	current := generation.Load()
+	if cached := h.resolved.Load(); cached != nil && cached.generation == current {
+		return cached.handler
+	}
+
+	handler := *output.Load()
+	for _, op := range h.ops {
+		handler = op(handler)
+	}
+	h.resolved.Store(&resolvedHandler{generation: current, handler: handler})
+	return handler
+}
@@ -0,0 +1,126 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/expai/messagebridge/config"
+)
+
+// setup applies cfg and captures the JSON records of all loggers until the
+// end of the test
+func setup(t *testing.T, cfg *config.Config) *bytes.Buffer {
+	t.Helper()
+
+	if err := Setup(cfg); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	var buf bytes.Buffer
+	setOutput(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	t.Cleanup(func() {
+		if err := Setup(&config.Config{Logging: config.LoggingConfig{Level: "info"}}); err != nil {
+			t.Error(err)
+		}
+	})
+	return &buf
+}
+
+// records decodes the JSON records written to buf
+func records(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	var result []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid record %q: %v", line, err)
+		}
+		result = append(result, record)
+	}
+	return result
+}
+
+func TestComponentLevels(t *testing.T) {
+	// Loggers created before Setup follow it
+	worker := For("test-worker").With("lane", 1)
+	handler := For("test-handler")
+
+	buf := setup(t, &config.Config{Logging: config.LoggingConfig{
+		Level:      "info",
+		Components: map[string]string{"test-worker": "debug"},
+	}})
+
+	worker.Debug("worker debug")
+	handler.Debug("handler debug")
+	handler.Info("handler info")
+
+	got := records(t, buf)
+	if len(got) != 2 {
+		t.Fatalf("records = %v, want worker debug and handler info", got)
+	}
+	if got[0]["msg"] != "worker debug" || got[0]["component"] != "test-worker" || got[0]["lane"] != 1.0 {
+		t.Errorf("record = %v, want worker debug with its component and attributes", got[0])
+	}
+	if got[1]["msg"] != "handler info" || got[1]["component"] != "test-handler" {
+		t.Errorf("record = %v, want handler info with its component", got[1])
+	}
+
+	// A new level applies to existing loggers
+	if err := Setup(&config.Config{Logging: config.LoggingConfig{Level: "error"}}); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	if worker.Enabled(context.Background(), slog.LevelWarn) || !handler.Enabled(context.Background(), slog.LevelError) {
+		t.Error("component levels were not replaced")
+	}
+}
+
+func TestSetupRejectsUnknownLevels(t *testing.T) {
+	for _, cfg := range []config.LoggingConfig{
+		{Level: "verbose"},
+		{Level: "info", Components: map[string]string{"worker": "chatty"}},
+	} {
+		if err := Setup(&config.Config{Logging: cfg}); err == nil {
+			t.Errorf("Setup(%+v) succeeded, want an unknown level error", cfg)
+		}
+	}
+}
+
+func TestHeadersRedaction(t *testing.T) {
+	buf := setup(t, &config.Config{
+		Logging: config.LoggingConfig{Level: "info", RedactHeaders: []string{"X-Internal-Token"}},
+		Routes: []config.RouteConfig{{
+			Path:      "/webhook/signed",
+			Signature: &config.SignatureConfig{Header: "X-Signature", TimestampHeader: "X-Timestamp"},
+		}},
+	})
+
+	For("test-server").Info("Webhook received", "headers", Headers{
+		"authorization":    "Bearer s3cret",
+		"Stripe-Signature": "t=1,v1=abc",
+		"X-Signature":      "sha256=abc",
+		"X-Timestamp":      "1700000000",
+		"x-internal-token": "token",
+		"Content-Type":     "application/json",
+	})
+
+	got := records(t, buf)
+	if len(got) != 1 {
+		t.Fatalf("records = %v, want one", got)
+	}
+	headers, _ := got[0]["headers"].(map[string]interface{})
+	for _, key := range []string{"authorization", "Stripe-Signature", "X-Signature", "X-Timestamp", "x-internal-token"} {
+		if headers[key] != redactedValue {
+			t.Errorf("%s = %v, want it redacted", key, headers[key])
+		}
+	}
+	if headers["Content-Type"] != "application/json" {
+		t.Errorf("Content-Type = %v, want it logged", headers["Content-Type"])
+	}
+}
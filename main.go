@@ -2,24 +2,39 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/expai/messagebridge/admin"
+	"github.com/expai/messagebridge/amqp"
+	"github.com/expai/messagebridge/archive"
 	"github.com/expai/messagebridge/config"
 	"github.com/expai/messagebridge/handler"
 	"github.com/expai/messagebridge/httpclient"
 	"github.com/expai/messagebridge/kafka"
+	"github.com/expai/messagebridge/logging"
+	"github.com/expai/messagebridge/metrics"
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/mqtt"
+	"github.com/expai/messagebridge/nats"
+	"github.com/expai/messagebridge/redis"
+	"github.com/expai/messagebridge/retry"
 	"github.com/expai/messagebridge/server"
 	"github.com/expai/messagebridge/storage"
+	"github.com/expai/messagebridge/tracing"
 	"github.com/expai/messagebridge/worker"
 )
 
+// logger is the application component logger
+var logger = logging.For("main")
+
 const (
 	ExitSuccess = 0
 	ExitFailure = 1
@@ -32,20 +47,51 @@ var (
 	buildTime  = "unknown"
 )
 
+// commands are the subcommands, which operate on the configuration and the
+// stored messages instead of running the server
+var commands = map[string]func(args []string) int{
+	"validate": runValidateCommand,
+	"messages": runMessagesCommand,
+	"dlq":      runDLQCommand,
+	"replay":   runReplayCommand,
+	"stats":    runStatsCommand,
+	"export":   runExportCommand,
+	"import":   runImportCommand,
+}
+
+const usage = `Usage: messagebridge -config /path/to/config.yaml
+       messagebridge <command> -config /path/to/config.yaml [options]
+
+Commands:
+  validate   Validate the configuration and check connectivity
+  messages   List, show, retry, cancel or delete queued messages
+  dlq        List, show, replay or delete dead letters
+  replay     Move dead letters back to the queue
+  stats      Print queue and delivery statistics
+  export     Write queued messages as NDJSON
+  import     Read messages written by export
+`
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
+
+	flag.Usage = func() { fmt.Fprint(flag.CommandLine.Output(), usage) }
 	flag.Parse()
 
 	if *configPath == "" {
 		fmt.Println("Error: -config flag is required")
-		fmt.Println("Usage: messagebridge -config /path/to/config.yaml")
+		fmt.Print(usage)
 		os.Exit(ExitFailure)
 	}
 
 	// Set up panic recovery
 	defer func() {
 		if r := recover(); r != nil {
-			log.Printf("PANIC: %v", r)
-			log.Println("Application will restart...")
+			logger.Error("Panic, application will restart", "error", r)
 			os.Exit(ExitRestart)
 		}
 	}()
@@ -55,24 +101,30 @@ func main() {
 }
 
 func run(configPath string) int {
-	log.Printf("Starting messagebridge v%s (built at %s)", version, buildTime)
-	log.Printf("Loading configuration from: %s", configPath)
+	logger.Info("Starting messagebridge", "version", version, "build_time", buildTime)
+	logger.Info("Loading configuration", "path", configPath)
 
 	// Load configuration
 	cfg, err := config.LoadConfig(configPath)
 	if err != nil {
-		log.Printf("Failed to load configuration: %v", err)
+		logger.Error("Failed to load configuration", "error", err)
+		return ExitFailure
+	}
+
+	if err := logging.Setup(cfg); err != nil {
+		logger.Error("Failed to configure logging", "error", err)
 		return ExitFailure
 	}
 
-	log.Println("Configuration loaded successfully")
+	logger.Info("Configuration loaded successfully")
 
 	// Initialize components
 	app, err := initializeApplication(cfg)
 	if err != nil {
-		log.Printf("Failed to initialize application: %v", err)
+		logger.Error("Failed to initialize application", "error", err)
 		return ExitRestart // Try to restart on initialization failure
 	}
+	app.configPath = configPath
 
 	// Start application
 	ctx, cancel := context.WithCancel(context.Background())
@@ -84,38 +136,48 @@ func run(configPath string) int {
 
 	// Start components
 	if err := app.Start(ctx); err != nil {
-		log.Printf("Failed to start application: %v", err)
+		logger.Error("Failed to start application", "error", err)
 		return ExitRestart
 	}
 
-	log.Println("Application started successfully")
+	logger.Info("Application started successfully")
 
-	// Wait for shutdown signal
-	sig := <-sigChan
-	log.Printf("Received signal: %v", sig)
+	// Wait for shutdown signal, reloading the configuration on SIGHUP
+	for sig := range sigChan {
+		logger.Info("Received signal", "signal", sig.String())
 
-	// Handle different signals
-	switch sig {
-	case syscall.SIGHUP:
-		log.Println("Received SIGHUP, restarting...")
-		app.Shutdown()
-		return ExitRestart
-	default:
-		log.Println("Shutting down gracefully...")
+		if sig == syscall.SIGHUP {
+			// Errors are logged, the running configuration stays in effect
+			app.Reload()
+			continue
+		}
+
+		logger.Info("Shutting down gracefully")
 		app.Shutdown()
 		return ExitSuccess
 	}
+
+	return ExitSuccess
 }
 
 // Application represents the main application
 type Application struct {
 	config        *config.Config
+	configPath    string
+	reloadMu      sync.Mutex
 	server        *server.Server
+	admin         *admin.Server
 	worker        *worker.Worker
 	handler       *handler.MessageHandler
 	kafkaProducer *kafka.Producer
 	httpClient    *httpclient.Client
-	storage       *storage.SQLiteStorage
+	redisProducer *redis.Producer
+	natsProducer  *nats.Producer
+	amqpProducer  *amqp.Producer
+	mqttProducer  *mqtt.Producer
+	archiveWriter *archive.Writer
+	storage       storage.Storage
+	stopTracing   func(context.Context) error
 	wg            sync.WaitGroup
 }
 
@@ -125,14 +187,29 @@ func initializeApplication(cfg *config.Config) (*Application, error) {
 		config: cfg,
 	}
 
-	// Initialize SQLite storage if configured
-	if cfg.SQLite != nil {
-		storage, err := storage.NewSQLiteStorage(cfg.SQLite.DatabasePath)
+	// Initialize tracing first so every component records spans
+	stopTracing, err := tracing.Init(cfg.Tracing)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize tracing: %w", err)
+	}
+	app.stopTracing = stopTracing
+	if cfg.Tracing != nil {
+		logger.Info("Tracing initialized", "endpoint", cfg.Tracing.Endpoint)
+	}
+
+	// Initialize SQLite or PostgreSQL storage if configured
+	if backend := cfg.StorageBackend(); backend != "" {
+		storage, err := storage.Open(cfg)
 		if err != nil {
-			return nil, fmt.Errorf("failed to initialize SQLite storage: %w", err)
+			return nil, fmt.Errorf("failed to initialize %s storage: %w", backend, err)
 		}
+		storage.SetRetryPolicies(retry.NewPolicies(cfg))
 		app.storage = storage
-		log.Println("SQLite storage initialized")
+		logger.Info("Storage initialized", "backend", backend)
+
+		if err := metrics.RegisterQueue(storage); err != nil {
+			return nil, fmt.Errorf("failed to register queue metrics: %w", err)
+		}
 	}
 
 	// Initialize Kafka producer
@@ -142,28 +219,118 @@ func initializeApplication(cfg *config.Config) (*Application, error) {
 			return nil, fmt.Errorf("failed to initialize Kafka producer: %w", err)
 		}
 		app.kafkaProducer = producer
-		log.Println("Kafka producer initialized")
+		logger.Info("Kafka producer initialized")
 	}
 
 	// Initialize HTTP client for remote URL if configured
 	if cfg.RemoteURL != nil {
 		client := httpclient.NewClient(cfg.RemoteURL)
 		app.httpClient = client
-		log.Println("HTTP client initialized")
+		logger.Info("HTTP client initialized")
+	}
+
+	// Initialize Redis Streams producer if configured
+	if cfg.Redis != nil {
+		app.redisProducer = redis.NewProducer(cfg.Redis)
+		logger.Info("Redis producer initialized", "address", cfg.Redis.Address)
+	}
+
+	// Initialize NATS JetStream producer if configured
+	if cfg.NATS != nil {
+		producer, err := nats.NewProducer(cfg.NATS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize NATS producer: %w", err)
+		}
+		app.natsProducer = producer
+		logger.Info("NATS producer initialized", "servers", cfg.NATS.Servers)
+	}
+
+	// Initialize AMQP producer if configured
+	if cfg.AMQP != nil {
+		app.amqpProducer = amqp.NewProducer(cfg.AMQP)
+		logger.Info("AMQP producer initialized", "url", config.RedactURL(cfg.AMQP.URL))
+	}
+
+	// Initialize MQTT producer if configured
+	if cfg.MQTT != nil {
+		producer, err := mqtt.NewProducer(cfg.MQTT)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize MQTT producer: %w", err)
+		}
+		app.mqttProducer = producer
+		logger.Info("MQTT producer initialized",
+			"broker", config.RedactURL(cfg.MQTT.Broker), "protocol_version", cfg.MQTT.ProtocolVersion)
+	}
+
+	// Initialize archive writer if configured
+	if cfg.Archive != nil {
+		writer, err := archive.NewWriter(cfg.Archive)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize archive writer: %w", err)
+		}
+		app.archiveWriter = writer
+		if cfg.Archive.S3 != nil {
+			logger.Info("Archive writer initialized",
+				"directory", cfg.Archive.Directory, "bucket", cfg.Archive.S3.Bucket)
+		} else {
+			logger.Info("Archive writer initialized", "directory", cfg.Archive.Directory)
+		}
 	}
 
 	// Initialize message handler
 	app.handler = handler.NewMessageHandler(cfg, app.kafkaProducer, app.httpClient, app.storage)
-	log.Println("Message handler initialized")
+	if app.redisProducer != nil {
+		app.handler.AddHealthCheck("redis", app.redisProducer)
+	}
+	if app.natsProducer != nil {
+		app.handler.AddHealthCheck("nats", app.natsProducer)
+	}
+	if app.amqpProducer != nil {
+		app.handler.AddHealthCheck("amqp", app.amqpProducer)
+	}
+	if app.mqttProducer != nil {
+		app.handler.AddHealthCheck("mqtt", app.mqttProducer)
+	}
+	if app.archiveWriter != nil {
+		app.handler.AddHealthCheck("archive", app.archiveWriter)
+	}
+	logger.Info("Message handler initialized")
 
 	// Initialize HTTP server
-	app.server = server.NewServer(cfg, app.handler)
-	log.Println("HTTP server initialized")
+	srv, err := server.NewServer(cfg, app.handler)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize HTTP server: %w", err)
+	}
+	app.server = srv
+	logger.Info("HTTP server initialized")
 
 	// Initialize worker if storage is available
 	if app.storage != nil {
 		app.worker = worker.NewWorker(cfg, app.storage, app.kafkaProducer, app.httpClient)
-		log.Println("Worker initialized")
+		if app.redisProducer != nil {
+			app.worker.SetSink(models.TargetRedis, app.redisProducer)
+		}
+		if app.natsProducer != nil {
+			app.worker.SetSink(models.TargetNATS, app.natsProducer)
+		}
+		if app.amqpProducer != nil {
+			app.worker.SetSink(models.TargetAMQP, app.amqpProducer)
+		}
+		if app.mqttProducer != nil {
+			app.worker.SetSink(models.TargetMQTT, app.mqttProducer)
+		}
+		if app.archiveWriter != nil {
+			app.worker.SetSink(models.TargetArchive, app.archiveWriter)
+		}
+		app.handler.SetNotifier(app.worker)
+		logger.Info("Worker initialized")
+	}
+
+	// Initialize admin API if configured
+	if cfg.Admin != nil {
+		app.admin = admin.NewServer(cfg, app.storage, app.worker)
+		app.admin.SetReloader(app)
+		logger.Info("Admin API initialized")
 	}
 
 	return app, nil
@@ -184,11 +351,22 @@ func (app *Application) Start(ctx context.Context) error {
 	app.wg.Add(1)
 	go func() {
 		defer app.wg.Done()
-		if err := app.server.Start(); err != nil {
-			log.Printf("HTTP server error: %v", err)
+		if err := app.server.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("HTTP server error", "error", err)
 		}
 	}()
 
+	// Start admin API
+	if app.admin != nil {
+		app.wg.Add(1)
+		go func() {
+			defer app.wg.Done()
+			if err := app.admin.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("Admin API error", "error", err)
+			}
+		}()
+	}
+
 	// Health monitoring
 	app.wg.Add(1)
 	go func() {
@@ -210,7 +388,7 @@ func (app *Application) Start(ctx context.Context) error {
 
 // Shutdown gracefully shuts down the application
 func (app *Application) Shutdown() {
-	log.Println("Initiating graceful shutdown...")
+	logger.Info("Initiating graceful shutdown")
 
 	// Create shutdown context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -219,7 +397,14 @@ func (app *Application) Shutdown() {
 	// Stop HTTP server
 	if app.server != nil {
 		if err := app.server.Shutdown(ctx); err != nil {
-			log.Printf("Error shutting down server: %v", err)
+			logger.Error("Error shutting down server", "error", err)
+		}
+	}
+
+	// Stop admin API
+	if app.admin != nil {
+		if err := app.admin.Shutdown(ctx); err != nil {
+			logger.Error("Error shutting down admin API", "error", err)
 		}
 	}
 
@@ -237,28 +422,67 @@ func (app *Application) Shutdown() {
 
 	select {
 	case <-done:
-		log.Println("All components stopped")
+		logger.Info("All components stopped")
 	case <-ctx.Done():
-		log.Println("Shutdown timeout reached")
+		logger.Warn("Shutdown timeout reached")
 	}
 
 	// Close resources
 	app.closeResources()
 
-	log.Println("Shutdown completed")
+	logger.Info("Shutdown completed")
 }
 
 // closeResources closes all open resources
 func (app *Application) closeResources() {
 	if app.kafkaProducer != nil {
 		if err := app.kafkaProducer.Close(); err != nil {
-			log.Printf("Error closing Kafka producer: %v", err)
+			logger.Error("Error closing Kafka producer", "error", err)
+		}
+	}
+
+	if app.redisProducer != nil {
+		if err := app.redisProducer.Close(); err != nil {
+			logger.Error("Error closing Redis producer", "error", err)
+		}
+	}
+
+	if app.natsProducer != nil {
+		if err := app.natsProducer.Close(); err != nil {
+			logger.Error("Error closing NATS producer", "error", err)
+		}
+	}
+
+	if app.amqpProducer != nil {
+		if err := app.amqpProducer.Close(); err != nil {
+			logger.Error("Error closing AMQP producer", "error", err)
+		}
+	}
+
+	if app.mqttProducer != nil {
+		if err := app.mqttProducer.Close(); err != nil {
+			logger.Error("Error closing MQTT producer", "error", err)
+		}
+	}
+
+	if app.archiveWriter != nil {
+		if err := app.archiveWriter.Close(); err != nil {
+			logger.Error("Error closing archive writer", "error", err)
 		}
 	}
 
 	if app.storage != nil {
 		if err := app.storage.Close(); err != nil {
-			log.Printf("Error closing storage: %v", err)
+			logger.Error("Error closing storage", "error", err)
+		}
+	}
+
+	// Flush spans of the work done during shutdown
+	if app.stopTracing != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := app.stopTracing(ctx); err != nil {
+			logger.Error("Error flushing traces", "error", err)
 		}
 	}
 }
@@ -282,7 +506,7 @@ func (app *Application) healthMonitor(ctx context.Context) {
 func (app *Application) performHealthCheck() {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Printf("Health check panic: %v", r)
+			logger.Error("Health check panic", "error", r)
 		}
 	}()
 
@@ -293,18 +517,18 @@ func (app *Application) performHealthCheck() {
 	for component, status := range health {
 		if statusMap, ok := status.(map[string]interface{}); ok {
 			if statusMap["status"] == "unhealthy" && component == "kafka" {
-				log.Printf("Critical component %s is unhealthy: %v", component, statusMap["error"])
+				logger.Error("Critical component is unhealthy", "check", component, "error", statusMap["error"])
 				criticalFailure = true
 			}
 		}
 	}
 
 	if criticalFailure {
-		log.Println("Critical failure detected, application should restart")
+		logger.Error("Critical failure detected, application should restart")
 		// Don't panic here, let the monitoring system handle restart
 	}
 
-	log.Printf("Health check completed: %d components checked", len(health))
+	logger.Debug("Health check completed", "components", len(health))
 }
 
 // cleanupRoutine performs periodic cleanup
@@ -319,7 +543,7 @@ func (app *Application) cleanupRoutine(ctx context.Context) {
 		case <-ticker.C:
 			if app.worker != nil {
 				if err := app.worker.Cleanup(); err != nil {
-					log.Printf("Cleanup error: %v", err)
+					logger.Error("Cleanup error", "error", err)
 				}
 			}
 		}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/storage"
+)
+
+const messagesUsage = `Usage: messagebridge messages <command> -config /path/to/config.yaml [options]
+
+Commands:
+  list     List queued messages
+  show     Show a message with body, headers, deliveries and attempts (-id)
+  retry    Make the undelivered destinations of a message due now (-id)
+  cancel   Stop delivering a message (-id)
+  delete   Permanently remove a message (-id)
+
+Filters (list):
+  -id      Message ID
+  -route   Route path, e.g. /webhook/payment
+  -queue   Queue name
+  -status  pending, retrying, sent, failed or cancelled
+  -from    Received at or after (RFC3339)
+  -to      Received at or before (RFC3339)
+`
+
+// messageFlags holds the message filter flags shared by subcommands
+type messageFlags struct {
+	id, route, queue, status, from, to *string
+}
+
+// newMessageFlags registers the message filter flags on fs
+func newMessageFlags(fs *flag.FlagSet) *messageFlags {
+	return &messageFlags{
+		id:     fs.String("id", "", "Message ID"),
+		route:  fs.String("route", "", "Route path"),
+		queue:  fs.String("queue", "", "Queue name"),
+		status: fs.String("status", "", "Message status"),
+		from:   fs.String("from", "", "Received at or after (RFC3339)"),
+		to:     fs.String("to", "", "Received at or before (RFC3339)"),
+	}
+}
+
+// filter builds the message filter from the parsed flags
+func (f *messageFlags) filter() (models.MessageFilter, error) {
+	filter := models.MessageFilter{
+		Path:   *f.route,
+		Queue:  *f.queue,
+		Status: models.MessageStatus(*f.status),
+	}
+	if *f.id != "" {
+		filter.IDs = []string{*f.id}
+	}
+
+	switch filter.Status {
+	case "", models.StatusPending, models.StatusRetrying, models.StatusSent, models.StatusFailed, models.StatusCancelled:
+	default:
+		return filter, fmt.Errorf("invalid -status: %s", filter.Status)
+	}
+
+	var err error
+	if filter.From, err = parseCLITime(*f.from); err != nil {
+		return filter, fmt.Errorf("invalid -from: %w", err)
+	}
+	if filter.To, err = parseCLITime(*f.to); err != nil {
+		return filter, fmt.Errorf("invalid -to: %w", err)
+	}
+
+	return filter, nil
+}
+
+// runMessagesCommand implements the "messages" subcommand
+func runMessagesCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Print(messagesUsage)
+		return ExitFailure
+	}
+
+	command := args[0]
+	fs := flag.NewFlagSet("messages "+command, flag.ContinueOnError)
+	configFile := fs.String("config", "", "Path to configuration file (required)")
+	filterFlags := newMessageFlags(fs)
+	limit := fs.Int("limit", 50, "Maximum number of messages to list")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return ExitFailure
+	}
+
+	if *configFile == "" {
+		fmt.Println("Error: -config flag is required")
+		fmt.Print(messagesUsage)
+		return ExitFailure
+	}
+
+	filter, err := filterFlags.filter()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return ExitFailure
+	}
+
+	id := *filterFlags.id
+	if command != "list" && id == "" {
+		fmt.Println("Error: -id is required")
+		return ExitFailure
+	}
+
+	store, err := openStorage(*configFile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return ExitFailure
+	}
+	defer store.Close()
+
+	switch command {
+	case "list":
+		err = messagesList(store, filter, *limit)
+	case "show":
+		err = messagesShow(store, id)
+	case "retry":
+		var status models.MessageStatus
+		if status, err = store.RetryMessage(id); err == nil {
+			fmt.Printf("Message %s scheduled for retry, status: %s\n", id, status)
+		}
+	case "cancel":
+		var status models.MessageStatus
+		if status, err = store.CancelMessage(id); err == nil {
+			fmt.Printf("Message %s cancelled, status: %s\n", id, status)
+		}
+	case "delete":
+		err = messagesDelete(store, id)
+	default:
+		fmt.Printf("Error: unknown messages command: %s\n", command)
+		fmt.Print(messagesUsage)
+		return ExitFailure
+	}
+
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		fmt.Printf("Error: message %s not found\n", id)
+		return ExitFailure
+	case errors.Is(err, storage.ErrNothingChanged):
+		fmt.Printf("Error: message %s has no deliveries to %s\n", id, command)
+		return ExitFailure
+	case err != nil:
+		fmt.Printf("Error: %v\n", err)
+		return ExitFailure
+	}
+
+	return ExitSuccess
+}
+
+// messagesList prints queued messages as a table
+func messagesList(store storage.Storage, filter models.MessageFilter, limit int) error {
+	messages, err := store.ListMessages(filter, limit, 0)
+	if err != nil {
+		return err
+	}
+
+	total, err := store.CountMessages(filter)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tROUTE\tQUEUE\tSTATUS\tRECEIVED AT\tRETRIES\tERROR")
+	for _, msg := range messages {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", msg.ID, msg.Path, msg.Queue, msg.Status,
+			msg.Timestamp.Format(time.RFC3339), msg.Retries, truncate(msg.Error, 80))
+	}
+	tw.Flush()
+
+	fmt.Printf("\nShowing %d of %d messages\n", len(messages), total)
+	return nil
+}
+
+// messagesShow prints a single message as JSON
+func messagesShow(store storage.Storage, id string) error {
+	msg, err := store.GetMessage(id)
+	if err != nil {
+		return err
+	}
+
+	attempts, err := store.GetAttempts(id)
+	if err != nil {
+		return fmt.Errorf("failed to get attempts of %s: %w", id, err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(map[string]interface{}{
+		"message":  msg,
+		"body":     string(msg.Body),
+		"attempts": attempts,
+	})
+}
+
+// messagesDelete permanently removes a message
+func messagesDelete(store storage.Storage, id string) error {
+	count, err := store.CountMessages(models.MessageFilter{IDs: []string{id}})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return storage.ErrNotFound
+	}
+
+	if err := store.DeleteMessage(id); err != nil {
+		return err
+	}
+
+	fmt.Printf("Deleted message %s\n", id)
+	return nil
+}
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/expai/messagebridge/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "messagebridge"
+
+var (
+	// WebhooksReceived counts webhook requests by route and response code
+	WebhooksReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhooks_received_total",
+		Help:      "Webhook requests received, by route and HTTP status code.",
+	}, []string{"route", "code"})
+
+	// IngestDuration observes the time from request to response on webhook routes
+	IngestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ingest_duration_seconds",
+		Help:      "Time to receive, verify and store a webhook, by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route"})
+
+	// StorageWriteDuration observes SQLite write latency by operation
+	StorageWriteDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "storage_write_duration_seconds",
+		Help:      "Time spent writing to storage, by operation.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation"})
+
+	// DeliveryAttempts counts delivery attempts by destination and result
+	DeliveryAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "delivery_attempts_total",
+		Help:      "Delivery attempts, by destination type, destination and result (success or error).",
+	}, []string{"type", "destination", "result"})
+
+	// Deliveries counts final delivery outcomes by destination
+	Deliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "deliveries_total",
+		Help:      "Completed deliveries, by destination type, destination and status (sent or failed).",
+	}, []string{"type", "destination", "status"})
+
+	// DeliveryDuration observes the duration of delivery attempts
+	DeliveryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "delivery_duration_seconds",
+		Help:      "Duration of delivery attempts, by destination type and destination.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"type", "destination"})
+
+	// ClientErrors counts errors returned by the Kafka and HTTP clients
+	ClientErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "client_errors_total",
+		Help:      "Errors of the outbound clients, by client (kafka or http) and reason.",
+	}, []string{"client", "reason"})
+)
+
+// Handler returns the HTTP handler exposing all registered metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveStorageWrite records the duration of a storage write started at start
+func ObserveStorageWrite(operation string, start time.Time) {
+	StorageWriteDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// ObserveAttempt records a delivery attempt to target started at start
+func ObserveAttempt(target models.DeliveryTarget, start time.Time, err error) {
+	destination := Destination(target)
+
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+
+	DeliveryAttempts.WithLabelValues(string(target.Type), destination, result).Inc()
+	DeliveryDuration.WithLabelValues(string(target.Type), destination).Observe(time.Since(start).Seconds())
+}
+
+// ObserveDelivery records the final outcome of a delivery to target
+func ObserveDelivery(target models.DeliveryTarget, status models.MessageStatus) {
+	Deliveries.WithLabelValues(string(target.Type), Destination(target), string(status)).Inc()
+}
+
+// ObserveWebhook records a webhook request handled for route
+func ObserveWebhook(route string, code int, start time.Time) {
+	WebhooksReceived.WithLabelValues(route, strconv.Itoa(code)).Inc()
+	IngestDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+}
+
+// Destination returns the label identifying a delivery target: the Kafka
+// topic, or the URL without credentials and query, which may carry tokens
+func Destination(target models.DeliveryTarget) string {
+	if target.Type != models.TargetRemoteURL {
+		return target.Topic
+	}
+
+	parsed, err := url.Parse(target.URL)
+	if err != nil {
+		return "invalid"
+	}
+	parsed.User = nil
+	parsed.RawQuery = ""
+	parsed.Fragment = ""
+	return parsed.String()
+}
@@ -15,31 +15,68 @@ type WebhookMessage struct {
 	Retries   int               `json:"retries" db:"retries"`
 	Status    MessageStatus     `json:"status" db:"status"`
 	Error     string            `json:"error,omitempty" db:"error"`
-	CreatedAt time.Time         `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time         `json:"updated_at" db:"updated_at"`
+	// Destinations is resolved from the route when the message is received
+	// so retries keep going to the same place after a config change
+	Destinations []DeliveryTarget `json:"destinations,omitempty" db:"destinations"`
+	// OrderingKey groups messages that must be delivered in sequence
+	OrderingKey string `json:"ordering_key,omitempty" db:"ordering_key"`
+	// TraceParent is the W3C trace context of the request the message was
+	// received with, so delivery spans join the ingest trace
+	TraceParent string    `json:"traceparent,omitempty" db:"traceparent"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
 }
 
 // MessageStatus represents the status of a message
 type MessageStatus string
 
 const (
-	StatusPending  MessageStatus = "pending"
-	StatusSent     MessageStatus = "sent"
-	StatusFailed   MessageStatus = "failed"
-	StatusRetrying MessageStatus = "retrying"
+	StatusPending   MessageStatus = "pending"
+	StatusSent      MessageStatus = "sent"
+	StatusFailed    MessageStatus = "failed"
+	StatusRetrying  MessageStatus = "retrying"
+	StatusCancelled MessageStatus = "cancelled"
 )
 
 // PendingMessage represents a message pending for retry
 type PendingMessage struct {
 	*WebhookMessage
 	NextRetryAt time.Time `json:"next_retry_at" db:"next_retry_at"`
+	// Deliveries holds the destinations that have not been delivered yet
+	Deliveries []*Delivery `json:"deliveries,omitempty"`
 }
 
 // DeliveryTarget represents where to deliver the message
 type DeliveryTarget struct {
-	Type  TargetType `json:"type"`
-	Topic string     `json:"topic,omitempty"`
-	URL   string     `json:"url,omitempty"`
+	Type       TargetType `json:"type"`
+	Topic      string     `json:"topic,omitempty"`
+	URL        string     `json:"url,omitempty"`
+	RoutingKey string     `json:"routing_key,omitempty"` // AMQP destinations
+}
+
+// Key returns a stable identifier of the target within a message
+func (t DeliveryTarget) Key() string {
+	key := string(t.Type) + "|" + t.Topic + "|" + t.URL
+	if t.RoutingKey != "" {
+		key += "|" + t.RoutingKey
+	}
+	return key
+}
+
+// Delivery tracks the delivery of a message to a single destination
+type Delivery struct {
+	MessageID   string         `json:"message_id" db:"message_id"`
+	Target      DeliveryTarget `json:"target" db:"target"`
+	Status      MessageStatus  `json:"status" db:"status"`
+	Retries     int            `json:"retries" db:"retries"`
+	Error       string         `json:"error,omitempty" db:"error"`
+	NextRetryAt time.Time      `json:"next_retry_at,omitempty" db:"next_retry_at"`
+	UpdatedAt   time.Time      `json:"updated_at" db:"updated_at"`
+}
+
+// Due reports whether the delivery should be attempted at now
+func (d *Delivery) Due(now time.Time) bool {
+	return d.NextRetryAt.IsZero() || !d.NextRetryAt.After(now)
 }
 
 // TargetType represents the type of delivery target
@@ -48,4 +85,85 @@ type TargetType string
 const (
 	TargetKafka     TargetType = "kafka"
 	TargetRemoteURL TargetType = "remote_url"
+	TargetRedis     TargetType = "redis"
+	TargetNATS      TargetType = "nats"
+	TargetAMQP      TargetType = "amqp"
+	TargetMQTT      TargetType = "mqtt"
+	TargetArchive   TargetType = "archive"
 )
+
+// MessageFilter selects queued messages for inspection and bulk operations.
+// Empty fields match everything.
+type MessageFilter struct {
+	IDs    []string      `json:"ids,omitempty"`
+	Path   string        `json:"route,omitempty"`
+	Queue  string        `json:"queue,omitempty"`
+	Status MessageStatus `json:"status,omitempty"`
+	From   time.Time     `json:"from,omitempty"` // received at or after
+	To     time.Time     `json:"to,omitempty"`   // received at or before
+}
+
+// IsEmpty reports whether the filter matches every message
+func (f MessageFilter) IsEmpty() bool {
+	return len(f.IDs) == 0 && f.Path == "" && f.Queue == "" && f.Status == "" && f.From.IsZero() && f.To.IsZero()
+}
+
+// DeliveryResult describes what a destination replied to a delivery attempt
+type DeliveryResult struct {
+	StatusCode int    `json:"status_code,omitempty"` // HTTP destinations
+	Partition  *int32 `json:"partition,omitempty"`   // Kafka destinations
+	Offset     *int64 `json:"offset,omitempty"`      // Kafka offset or JetStream sequence
+	Response   string `json:"response,omitempty"`    // response body, truncated; stream entry ID for Redis, stream for NATS
+}
+
+// Attempt records a single delivery attempt of a message to a destination
+type Attempt struct {
+	ID          int64          `json:"id" db:"id"`
+	MessageID   string         `json:"message_id" db:"message_id"`
+	Path        string         `json:"path,omitempty" db:"path"`
+	Target      DeliveryTarget `json:"target" db:"target"`
+	AttemptedAt time.Time      `json:"attempted_at" db:"attempted_at"`
+	DurationMs  int64          `json:"duration_ms" db:"duration_ms"`
+	Error       string         `json:"error,omitempty" db:"error"`
+	DeliveryResult
+}
+
+// DeadLetter represents a message that exhausted its retries, together with
+// the state of each of its deliveries at the time it failed
+type DeadLetter struct {
+	*WebhookMessage
+	Deliveries []*Delivery `json:"deliveries"`
+	FailedAt   time.Time   `json:"failed_at" db:"failed_at"`
+}
+
+// DeadLetterFilter selects dead letters for inspection and replay.
+// Empty fields match everything.
+type DeadLetterFilter struct {
+	IDs          []string  `json:"ids,omitempty"`
+	Path         string    `json:"route,omitempty"`
+	From         time.Time `json:"from,omitempty"`
+	To           time.Time `json:"to,omitempty"`
+	ErrorPattern string    `json:"error,omitempty"` // substring of the last error
+}
+
+// IsEmpty reports whether the filter matches every dead letter
+func (f DeadLetterFilter) IsEmpty() bool {
+	return len(f.IDs) == 0 && f.Path == "" && f.From.IsZero() && f.To.IsZero() && f.ErrorPattern == ""
+}
+
+// RouteStats summarizes the queue and recent delivery activity of a route
+type RouteStats struct {
+	Path        string `json:"path"`
+	Pending     int    `json:"pending"`
+	Retrying    int    `json:"retrying"`
+	Cancelled   int    `json:"cancelled"`
+	DeadLetters int    `json:"dead_letters"`
+	// OldestPending is when the oldest undelivered message was received
+	OldestPending time.Time `json:"oldest_pending,omitempty"`
+	// Delivered and FailedAttempts count delivery attempts within the
+	// requested window; Messages counts the messages they belong to
+	Delivered      int     `json:"delivered"`
+	FailedAttempts int     `json:"failed_attempts"`
+	Messages       int     `json:"messages"`
+	AvgDurationMs  float64 `json:"avg_duration_ms"`
+}
@@ -0,0 +1,140 @@
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/logging"
+	"github.com/expai/messagebridge/metrics"
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/retry"
+	"github.com/expai/messagebridge/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// logger is the MQTT component logger
+var logger = logging.For("mqtt")
+
+// client publishes messages over one version of the MQTT protocol
+type client interface {
+	// publish publishes payload to topic and waits for the acknowledgement
+	// its QoS calls for. Properties are sent as MQTT 5 user properties.
+	publish(ctx context.Context, topic string, payload []byte, properties []property) error
+	connected() bool
+	close()
+}
+
+// property is a user property of a published message
+type property struct {
+	key, value string
+}
+
+// Producer publishes messages to an MQTT broker
+type Producer struct {
+	client client
+	config *config.MQTTConfig
+}
+
+// NewProducer creates a new MQTT producer. The connection is made and
+// remade in the background, so messages are queued for retry while the
+// broker is down.
+func NewProducer(cfg *config.MQTTConfig) (*Producer, error) {
+	clientID := cfg.ClientID
+	if clientID == "" {
+		hostname, _ := os.Hostname()
+		clientID = fmt.Sprintf("messagebridge-%s-%d", hostname, os.Getpid())
+	}
+
+	var c client
+	var err error
+	if cfg.ProtocolVersion == 4 {
+		c = newV3Client(cfg, clientID)
+	} else {
+		c, err = newV5Client(cfg, clientID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Producer{
+		client: c,
+		config: cfg,
+	}, nil
+}
+
+// Send publishes a message to the topic of target, or to the topic named
+// after the message queue when the target has none
+func (p *Producer) Send(ctx context.Context, target models.DeliveryTarget, msg *models.WebhookMessage) (*models.DeliveryResult, error) {
+	topic := target.Topic
+	if topic == "" {
+		topic = msg.Queue
+	}
+	return p.PublishMessage(ctx, topic, msg)
+}
+
+// PublishMessage publishes a message to the given topic with the configured
+// QoS and retain flag. With MQTT 5 the headers, the trace context of ctx,
+// X-Webhook-ID and X-Webhook-Path are sent as user properties; MQTT 3.1.1
+// carries the body only.
+func (p *Producer) PublishMessage(ctx context.Context, topic string, msg *models.WebhookMessage) (result *models.DeliveryResult, err error) {
+	ctx, span := tracing.Start(ctx, "mqtt.publish "+topic,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "mqtt"),
+			attribute.String("messaging.destination.name", topic),
+			attribute.String("messaging.message.id", msg.ID),
+		),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	// Values filled in from a message can turn a topic into a filter
+	if topic == "" || strings.ContainsAny(topic, "+#\x00") {
+		return nil, retry.Permanent(fmt.Errorf("invalid MQTT topic %q", topic))
+	}
+
+	headers := make(tracing.HeaderCarrier, len(msg.Headers)+1)
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+	tracing.Inject(ctx, headers)
+
+	properties := make([]property, 0, len(headers)+2)
+	for key, value := range headers {
+		properties = append(properties, property{key, value})
+	}
+	properties = append(properties,
+		property{"X-Webhook-ID", msg.ID},
+		property{"X-Webhook-Path", msg.Path},
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+
+	if err := p.client.publish(ctx, topic, msg.Body, properties); err != nil {
+		metrics.ClientErrors.WithLabelValues("mqtt", "send").Inc()
+		return nil, fmt.Errorf("failed to publish message to MQTT: %w", err)
+	}
+
+	logger.Debug("Message published to MQTT", "message_id", msg.ID, "destination", topic, "qos", *p.config.QoS)
+
+	return &models.DeliveryResult{}, nil
+}
+
+// HealthCheck checks if the connection to the broker is established
+func (p *Producer) HealthCheck() error {
+	if !p.client.connected() {
+		return fmt.Errorf("MQTT health check failed: not connected to %s", config.RedactURL(p.config.Broker))
+	}
+	return nil
+}
+
+// Close disconnects from the broker
+func (p *Producer) Close() error {
+	p.client.close()
+	return nil
+}
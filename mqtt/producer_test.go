@@ -0,0 +1,116 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/retry"
+)
+
+// fakeClient records published messages instead of sending them
+type fakeClient struct {
+	topics     []string
+	properties []property
+	err        error
+}
+
+// publish records topic and properties and returns c.err
+func (c *fakeClient) publish(_ context.Context, topic string, _ []byte, properties []property) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.topics = append(c.topics, topic)
+	c.properties = properties
+	return nil
+}
+
+// connected reports a connected client
+func (c *fakeClient) connected() bool { return true }
+
+// close does nothing
+func (c *fakeClient) close() {}
+
+// newTestProducer returns a producer publishing to a fake client
+func newTestProducer() (*Producer, *fakeClient) {
+	qos := 1
+	c := &fakeClient{}
+	return &Producer{client: c, config: &config.MQTTConfig{QoS: &qos, Timeout: time.Second}}, c
+}
+
+func TestPublishTopicValidation(t *testing.T) {
+	msg := &models.WebhookMessage{ID: "msg-1", Path: "/webhook/devices", Queue: "devices", Body: []byte("{}")}
+
+	tests := []struct {
+		topic string
+		valid bool
+	}{
+		{"devices/sensor-7/events", true},
+		{"devices/lab%2F7/events", true},
+		{"/leading/slash", true},
+		{"", false},
+		{"devices/+/events", false},
+		{"devices/#", false},
+		{"devices/\x00", false},
+	}
+	for _, tt := range tests {
+		producer, client := newTestProducer()
+		_, err := producer.PublishMessage(context.Background(), tt.topic, msg)
+
+		if tt.valid {
+			if err != nil || len(client.topics) != 1 || client.topics[0] != tt.topic {
+				t.Errorf("PublishMessage(%q) = %v, published to %v", tt.topic, err, client.topics)
+			}
+			continue
+		}
+		if !retry.IsPermanent(err) {
+			t.Errorf("PublishMessage(%q) = %v, want a permanent error", tt.topic, err)
+		}
+		if len(client.topics) != 0 {
+			t.Errorf("PublishMessage(%q) published the message", tt.topic)
+		}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	producer, client := newTestProducer()
+	msg := &models.WebhookMessage{
+		ID:      "msg-1",
+		Path:    "/webhook/devices",
+		Queue:   "devices",
+		Body:    []byte("{}"),
+		Headers: map[string]string{"Content-Type": "application/json"},
+	}
+
+	// Without a topic the message goes to its queue
+	if _, err := producer.Send(context.Background(), models.DeliveryTarget{Type: models.TargetMQTT}, msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if client.topics[0] != "devices" {
+		t.Errorf("topic = %q, want the queue", client.topics[0])
+	}
+
+	properties := make(map[string]string)
+	for _, p := range client.properties {
+		properties[p.key] = p.value
+	}
+	for key, want := range map[string]string{
+		"Content-Type":   "application/json",
+		"X-Webhook-ID":   "msg-1",
+		"X-Webhook-Path": "/webhook/devices",
+	} {
+		if properties[key] != want {
+			t.Errorf("property %s = %q, want %q", key, properties[key], want)
+		}
+	}
+
+	// Broker errors are retried
+	client.err = errors.New("connection lost")
+	_, err := producer.Send(context.Background(), models.DeliveryTarget{Type: models.TargetMQTT, Topic: "devices/7"}, msg)
+	if err == nil || retry.IsPermanent(err) {
+		t.Errorf("Send with a broker error = %v, want a retried error", err)
+	}
+}
@@ -0,0 +1,83 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+
+	pahomqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// errNotConnected is returned while the client is not connected. Messages
+// are not left to the client's own store, which could send them after the
+// delivery was already given up and scheduled for retry.
+var errNotConnected = errors.New("not connected to the MQTT broker")
+
+// v3Client publishes over MQTT 3.1.1
+type v3Client struct {
+	client pahomqtt.Client
+	qos    byte
+	retain bool
+}
+
+// newV3Client creates an MQTT 3.1.1 client and starts connecting to the broker
+func newV3Client(cfg *config.MQTTConfig, clientID string) *v3Client {
+	broker := config.RedactURL(cfg.Broker)
+
+	options := pahomqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(clientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetProtocolVersion(4).
+		SetCleanSession(true).
+		SetOrderMatters(false).
+		SetConnectTimeout(cfg.Timeout).
+		SetWriteTimeout(cfg.Timeout).
+		SetAutoReconnect(true).
+		SetMaxReconnectInterval(30 * time.Second).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10 * time.Second).
+		SetOnConnectHandler(func(pahomqtt.Client) {
+			logger.Info("Connected to MQTT broker", "broker", broker, "protocol_version", 4)
+		}).
+		SetConnectionLostHandler(func(_ pahomqtt.Client, err error) {
+			logger.Warn("Disconnected from MQTT broker", "broker", broker, "error", err)
+		})
+
+	client := pahomqtt.NewClient(options)
+	client.Connect()
+
+	return &v3Client{
+		client: client,
+		qos:    byte(*cfg.QoS),
+		retain: cfg.Retain,
+	}
+}
+
+// publish publishes payload to topic; MQTT 3.1.1 has no user properties
+func (c *v3Client) publish(ctx context.Context, topic string, payload []byte, _ []property) error {
+	if !c.client.IsConnectionOpen() {
+		return errNotConnected
+	}
+
+	token := c.client.Publish(topic, c.qos, c.retain, payload)
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// connected reports whether the client is connected to the broker
+func (c *v3Client) connected() bool {
+	return c.client.IsConnectionOpen()
+}
+
+// close disconnects from the broker, waiting briefly for in-flight messages
+func (c *v3Client) close() {
+	c.client.Disconnect(250)
+}
@@ -0,0 +1,124 @@
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/retry"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// rejectedReasonCodes are acknowledgement reason codes of MQTT 5 brokers
+// rejecting the message itself: topic name invalid, packet too large and
+// payload format invalid. Other failures, such as not being authorized or a
+// quota being exceeded, are retried.
+var rejectedReasonCodes = map[byte]bool{0x90: true, 0x95: true, 0x99: true}
+
+// v5Client publishes over MQTT 5
+type v5Client struct {
+	conn   *autopaho.ConnectionManager
+	up     atomic.Bool
+	qos    byte
+	retain bool
+}
+
+// newV5Client creates an MQTT 5 client and starts connecting to the broker
+func newV5Client(cfg *config.MQTTConfig, clientID string) (*v5Client, error) {
+	serverURL, err := url.Parse(cfg.Broker)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MQTT broker URL: %w", err)
+	}
+	broker := config.RedactURL(cfg.Broker)
+
+	c := &v5Client{
+		qos:    byte(*cfg.QoS),
+		retain: cfg.Retain,
+	}
+
+	conn, err := autopaho.NewConnection(context.Background(), autopaho.ClientConfig{
+		ServerUrls:                    []*url.URL{serverURL},
+		KeepAlive:                     30,
+		CleanStartOnInitialConnection: true,
+		ConnectTimeout:                cfg.Timeout,
+		ConnectUsername:               cfg.Username,
+		ConnectPassword:               []byte(cfg.Password),
+		OnConnectionUp: func(*autopaho.ConnectionManager, *paho.Connack) {
+			c.up.Store(true)
+			logger.Info("Connected to MQTT broker", "broker", broker, "protocol_version", 5)
+		},
+		OnConnectError: func(err error) {
+			logger.Warn("Failed to connect to MQTT broker", "broker", broker, "error", err)
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID:      clientID,
+			PacketTimeout: cfg.Timeout,
+			OnClientError: func(err error) {
+				c.up.Store(false)
+				logger.Warn("Disconnected from MQTT broker", "broker", broker, "error", err)
+			},
+			OnServerDisconnect: func(disconnect *paho.Disconnect) {
+				c.up.Store(false)
+				logger.Warn("Disconnected by MQTT broker", "broker", broker, "reason_code", disconnect.ReasonCode)
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MQTT client: %w", err)
+	}
+	c.conn = conn
+
+	return c, nil
+}
+
+// publish publishes payload to topic with properties as user properties.
+// The Content-Type header is also sent as the content type property.
+func (c *v5Client) publish(ctx context.Context, topic string, payload []byte, properties []property) error {
+	publishProperties := &paho.PublishProperties{
+		User: make(paho.UserProperties, 0, len(properties)),
+	}
+	for _, p := range properties {
+		publishProperties.User.Add(p.key, p.value)
+		if strings.EqualFold(p.key, "Content-Type") {
+			publishProperties.ContentType = p.value
+		}
+	}
+
+	response, err := c.conn.Publish(ctx, &paho.Publish{
+		Topic:      topic,
+		QoS:        c.qos,
+		Retain:     c.retain,
+		Payload:    payload,
+		Properties: publishProperties,
+	})
+
+	// A QoS 2 message refused in PUBREC is not reported as an error
+	if response != nil && response.ReasonCode >= 0x80 {
+		err = fmt.Errorf("broker refused the message with reason code 0x%02x", response.ReasonCode)
+		if response.Properties != nil && response.Properties.ReasonString != "" {
+			err = fmt.Errorf("%w: %s", err, response.Properties.ReasonString)
+		}
+		if rejectedReasonCodes[response.ReasonCode] {
+			return retry.Permanent(err)
+		}
+	}
+	return err
+}
+
+// connected reports whether the client is connected to the broker
+func (c *v5Client) connected() bool {
+	return c.up.Load()
+}
+
+// close disconnects from the broker
+func (c *v5Client) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c.conn.Disconnect(ctx)
+}
@@ -0,0 +1,175 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/logging"
+	"github.com/expai/messagebridge/metrics"
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/retry"
+	"github.com/expai/messagebridge/tracing"
+
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// logger is the NATS component logger
+var logger = logging.For("nats")
+
+// Producer publishes messages to NATS JetStream
+type Producer struct {
+	conn   *natsgo.Conn
+	js     jetstream.JetStream
+	config *config.NATSConfig
+}
+
+// NewProducer creates a new JetStream producer. The connection is retried in
+// the background, so messages are queued for retry while NATS is down.
+func NewProducer(cfg *config.NATSConfig) (*Producer, error) {
+	options := []natsgo.Option{
+		natsgo.Name("messagebridge"),
+		natsgo.Timeout(cfg.Timeout),
+		natsgo.RetryOnFailedConnect(true),
+		natsgo.MaxReconnects(-1),
+		natsgo.DisconnectErrHandler(func(_ *natsgo.Conn, err error) {
+			if err != nil {
+				logger.Warn("Disconnected from NATS", "error", err)
+			}
+		}),
+		natsgo.ReconnectHandler(func(conn *natsgo.Conn) {
+			logger.Info("Reconnected to NATS", "server", conn.ConnectedUrlRedacted())
+		}),
+	}
+	if cfg.Username != "" {
+		options = append(options, natsgo.UserInfo(cfg.Username, cfg.Password))
+	}
+	if cfg.Token != "" {
+		options = append(options, natsgo.Token(cfg.Token))
+	}
+	if cfg.CredentialsFile != "" {
+		options = append(options, natsgo.UserCredentials(cfg.CredentialsFile))
+	}
+	if cfg.TLSEnabled {
+		options = append(options, natsgo.Secure())
+	}
+
+	conn, err := natsgo.Connect(strings.Join(cfg.Servers, ","), options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create JetStream context: %w", err)
+	}
+
+	return &Producer{
+		conn:   conn,
+		js:     js,
+		config: cfg,
+	}, nil
+}
+
+// Send publishes a message to the subject of target, or to the subject
+// named after the message queue when the target has none
+func (p *Producer) Send(ctx context.Context, target models.DeliveryTarget, msg *models.WebhookMessage) (*models.DeliveryResult, error) {
+	subject := target.Topic
+	if subject == "" {
+		subject = p.config.SubjectPrefix + msg.Queue
+	}
+	return p.PublishMessage(ctx, subject, msg)
+}
+
+// PublishMessage publishes a message to the given subject and waits for the
+// stream to acknowledge it. The message ID is sent as Nats-Msg-Id, so the
+// stream drops a message published again after a lost acknowledgement. The
+// trace context of ctx is passed on in the traceparent header.
+func (p *Producer) PublishMessage(ctx context.Context, subject string, msg *models.WebhookMessage) (result *models.DeliveryResult, err error) {
+	ctx, span := tracing.Start(ctx, "nats.publish "+subject,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "nats"),
+			attribute.String("messaging.destination.name", subject),
+			attribute.String("messaging.message.id", msg.ID),
+		),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	headers := make(tracing.HeaderCarrier, len(msg.Headers)+1)
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+	tracing.Inject(ctx, headers)
+
+	natsMessage := natsgo.NewMsg(subject)
+	natsMessage.Data = msg.Body
+	for key, value := range headers {
+		natsMessage.Header.Set(key, value)
+	}
+	natsMessage.Header.Set("X-Webhook-ID", msg.ID)
+	natsMessage.Header.Set("X-Webhook-Path", msg.Path)
+
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+
+	ack, err := p.js.PublishMsg(ctx, natsMessage, jetstream.WithMsgID(msg.ID))
+	if err != nil {
+		metrics.ClientErrors.WithLabelValues("nats", "send").Inc()
+		err = fmt.Errorf("failed to publish message to NATS: %w", err)
+		if isPermanent(err) {
+			return nil, retry.Permanent(err)
+		}
+		return nil, err
+	}
+
+	logger.Debug("Message published to NATS",
+		"message_id", msg.ID, "destination", subject, "stream", ack.Stream, "sequence", ack.Sequence, "duplicate", ack.Duplicate)
+	span.SetAttributes(
+		attribute.String("messaging.nats.stream", ack.Stream),
+		attribute.Int64("messaging.nats.sequence", int64(ack.Sequence)),
+	)
+
+	sequence := int64(ack.Sequence)
+	response := ack.Stream
+	if ack.Duplicate {
+		response += " (duplicate)"
+	}
+	return &models.DeliveryResult{
+		Offset:   &sequence,
+		Response: response,
+	}, nil
+}
+
+// isPermanent reports whether the message itself was rejected, e.g. because
+// it exceeds the maximum payload or the subject is invalid. Missing streams,
+// timeouts and permission errors are retried since an operator can fix them.
+func isPermanent(err error) bool {
+	if errors.Is(err, natsgo.ErrMaxPayload) || errors.Is(err, natsgo.ErrBadSubject) {
+		return true
+	}
+
+	var apiErr *jetstream.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusBadRequest
+}
+
+// HealthCheck checks if the connection to NATS is established
+func (p *Producer) HealthCheck() error {
+	if status := p.conn.Status(); status != natsgo.CONNECTED {
+		return fmt.Errorf("NATS health check failed: connection is %s", strings.ToLower(status.String()))
+	}
+	return nil
+}
+
+// Close closes the connection to NATS
+func (p *Producer) Close() error {
+	p.conn.Close()
+	return nil
+}
@@ -0,0 +1,210 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/retry"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// startServer starts an embedded NATS server with JetStream and a stream
+// WEBHOOKS on webhooks.> that accepts messages up to 512 bytes
+func startServer(t *testing.T) (*server.Server, jetstream.Stream) {
+	t.Helper()
+
+	srv, err := server.NewServer(&server.Options{
+		Host:       "127.0.0.1",
+		Port:       -1,
+		JetStream:  true,
+		StoreDir:   t.TempDir(),
+		MaxPayload: 1024,
+		NoLog:      true,
+		NoSigs:     true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create NATS server: %v", err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	t.Cleanup(srv.Shutdown)
+
+	conn, err := natsgo.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("failed to connect to NATS: %v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := js.CreateStream(context.Background(), jetstream.StreamConfig{
+		Name:       "WEBHOOKS",
+		Subjects:   []string{"webhooks.>"},
+		MaxMsgSize: 512,
+	})
+	if err != nil {
+		t.Fatalf("failed to create stream: %v", err)
+	}
+	return srv, stream
+}
+
+// newTestProducer returns a producer connected to srv
+func newTestProducer(t *testing.T, srv *server.Server) *Producer {
+	t.Helper()
+
+	producer, err := NewProducer(&config.NATSConfig{
+		Servers:       []string{srv.ClientURL()},
+		Timeout:       2 * time.Second,
+		SubjectPrefix: "webhooks.",
+	})
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	t.Cleanup(func() { producer.Close() })
+	return producer
+}
+
+// testMessage returns a message for the orders queue
+func testMessage(id string, body string) *models.WebhookMessage {
+	return &models.WebhookMessage{
+		ID:      id,
+		Path:    "/webhook/orders",
+		Queue:   "orders",
+		Body:    []byte(body),
+		Headers: map[string]string{"Content-Type": "application/json"},
+	}
+}
+
+func TestPublishToStream(t *testing.T) {
+	srv, stream := startServer(t)
+	producer := newTestProducer(t, srv)
+	ctx := context.Background()
+
+	if err := producer.HealthCheck(); err != nil {
+		t.Fatalf("HealthCheck: %v", err)
+	}
+
+	// Without a topic the subject is the prefix and the queue
+	result, err := producer.Send(ctx, models.DeliveryTarget{Type: models.TargetNATS}, testMessage("msg-1", `{"order":42}`))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if *result.Offset != 1 || result.Response != "WEBHOOKS" {
+		t.Errorf("result = %d %q, want sequence 1 of WEBHOOKS", *result.Offset, result.Response)
+	}
+
+	stored, err := stream.GetMsg(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetMsg: %v", err)
+	}
+	if stored.Subject != "webhooks.orders" || string(stored.Data) != `{"order":42}` {
+		t.Errorf("stored message = %s %s, want the body on webhooks.orders", stored.Subject, stored.Data)
+	}
+	for key, want := range map[string]string{
+		"Nats-Msg-Id":    "msg-1",
+		"X-Webhook-ID":   "msg-1",
+		"X-Webhook-Path": "/webhook/orders",
+		"Content-Type":   "application/json",
+	} {
+		if got := stored.Header.Get(key); got != want {
+			t.Errorf("header %s = %q, want %q", key, got, want)
+		}
+	}
+
+	// The target topic is the subject
+	result, err = producer.Send(ctx, models.DeliveryTarget{Type: models.TargetNATS, Topic: "webhooks.audit"}, testMessage("msg-2", "{}"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if stored, err := stream.GetMsg(ctx, uint64(*result.Offset)); err != nil || stored.Subject != "webhooks.audit" {
+		t.Errorf("stored message = %v, %v, want it on webhooks.audit", stored, err)
+	}
+}
+
+func TestPublishDeduplicates(t *testing.T) {
+	srv, stream := startServer(t)
+	producer := newTestProducer(t, srv)
+	ctx := context.Background()
+
+	first, err := producer.PublishMessage(ctx, "webhooks.orders", testMessage("msg-1", "{}"))
+	if err != nil {
+		t.Fatalf("PublishMessage: %v", err)
+	}
+
+	// A message published again after a lost acknowledgement is dropped
+	again, err := producer.PublishMessage(ctx, "webhooks.orders", testMessage("msg-1", "{}"))
+	if err != nil {
+		t.Fatalf("PublishMessage again: %v", err)
+	}
+	if *again.Offset != *first.Offset || again.Response != "WEBHOOKS (duplicate)" {
+		t.Errorf("result = %d %q, want sequence %d marked as duplicate", *again.Offset, again.Response, *first.Offset)
+	}
+
+	info, err := stream.Info(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State.Msgs != 1 {
+		t.Errorf("stream holds %d messages, want 1", info.State.Msgs)
+	}
+}
+
+func TestPublishErrors(t *testing.T) {
+	srv, _ := startServer(t)
+	producer := newTestProducer(t, srv)
+	ctx := context.Background()
+
+	// No stream listens on the subject; an operator can add one
+	_, err := producer.PublishMessage(ctx, "other.orders", testMessage("msg-1", "{}"))
+	if !errors.Is(err, jetstream.ErrNoStreamResponse) {
+		t.Errorf("error = %v, want %v", err, jetstream.ErrNoStreamResponse)
+	}
+	if retry.IsPermanent(err) {
+		t.Errorf("missing stream error is permanent, want it retried")
+	}
+
+	// Messages the stream or server never accepts are permanent failures
+	tests := []struct {
+		name    string
+		subject string
+		body    string
+	}{
+		{"larger than the stream allows", "webhooks.orders", strings.Repeat("x", 700)},
+		{"larger than the server allows", "webhooks.orders", strings.Repeat("x", 2000)},
+		{"empty subject", "", "{}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := producer.PublishMessage(ctx, tt.subject, testMessage("msg-2", tt.body))
+			if err == nil || !retry.IsPermanent(err) {
+				t.Errorf("error = %v, want a permanent error", err)
+			}
+		})
+	}
+
+	// While the server is down messages are retried
+	srv.Shutdown()
+	deadline := time.Now().Add(5 * time.Second)
+	for producer.HealthCheck() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("HealthCheck still succeeds with the server down")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, err = producer.PublishMessage(ctx, "webhooks.orders", testMessage("msg-3", "{}"))
+	if err == nil || retry.IsPermanent(err) {
+		t.Errorf("error with the server down = %v, want a retried error", err)
+	}
+}
@@ -0,0 +1,176 @@
+package redis
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/logging"
+	"github.com/expai/messagebridge/metrics"
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/retry"
+	"github.com/expai/messagebridge/tracing"
+
+	goredis "github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// logger is the Redis component logger
+var logger = logging.For("redis")
+
+func init() {
+	goredis.SetLogger(clientLogger{})
+}
+
+// clientLogger passes log output of the Redis client on to the component logger
+type clientLogger struct{}
+
+// Printf logs a message of the Redis client, such as a failed dial
+func (clientLogger) Printf(ctx context.Context, format string, v ...interface{}) {
+	logger.Warn(fmt.Sprintf(format, v...))
+}
+
+// permanentErrors are prefixes of error replies caused by the command
+// itself, which fail however often it is retried: a stream key holding
+// another data type, and invalid stream IDs or arguments. The ERR prefix of
+// generic errors is left out, as goredis.HasErrorPrefix ignores it.
+var permanentErrors = []string{
+	"WRONGTYPE",
+	"Invalid stream ID",
+	"The ID specified in XADD",
+	"syntax error",
+	"wrong number of arguments",
+	"value is not an integer or out of range",
+}
+
+// Producer appends messages to Redis Streams
+type Producer struct {
+	client *goredis.Client
+	config *config.RedisConfig
+}
+
+// NewProducer creates a new Redis Streams producer. Connections are opened
+// on first use, so messages are queued for retry while Redis is down.
+func NewProducer(cfg *config.RedisConfig) *Producer {
+	options := &goredis.Options{
+		Addr:         cfg.Address,
+		Username:     cfg.Username,
+		Password:     cfg.Password,
+		DB:           cfg.Database,
+		DialTimeout:  cfg.Timeout,
+		ReadTimeout:  cfg.Timeout,
+		WriteTimeout: cfg.Timeout,
+		// XADD is not idempotent; a command that timed out may have been
+		// applied, so retries are left to the worker and its retry policy
+		MaxRetries: -1,
+	}
+	if cfg.TLSEnabled {
+		options.TLSConfig = &tls.Config{InsecureSkipVerify: false}
+	}
+
+	return &Producer{
+		client: goredis.NewClient(options),
+		config: cfg,
+	}
+}
+
+// Send appends a message to the stream of target, or to the stream named
+// after the message queue when the target has none
+func (p *Producer) Send(ctx context.Context, target models.DeliveryTarget, msg *models.WebhookMessage) (*models.DeliveryResult, error) {
+	stream := target.Topic
+	if stream == "" {
+		stream = msg.Queue
+	}
+	return p.SendMessageToStream(ctx, stream, msg)
+}
+
+// SendMessageToStream appends a message to the given stream and returns the
+// ID of the new entry. The entry holds the message ID, path, timestamp,
+// headers as a JSON object, and body. The trace context of ctx is passed on
+// in the traceparent header. Streams are trimmed to about redis.max_len
+// entries when it is set.
+func (p *Producer) SendMessageToStream(ctx context.Context, stream string, msg *models.WebhookMessage) (result *models.DeliveryResult, err error) {
+	ctx, span := tracing.Start(ctx, "redis.xadd "+stream,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "redis"),
+			attribute.String("messaging.destination.name", stream),
+			attribute.String("messaging.message.id", msg.ID),
+		),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	headers := make(tracing.HeaderCarrier, len(msg.Headers)+1)
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+	tracing.Inject(ctx, headers)
+
+	headersJSON, err := json.Marshal(headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal headers: %w", err)
+	}
+
+	args := &goredis.XAddArgs{
+		Stream: stream,
+		Values: []interface{}{
+			"id", msg.ID,
+			"path", msg.Path,
+			"timestamp", msg.Timestamp.UTC().Format(time.RFC3339Nano),
+			"headers", string(headersJSON),
+			"body", msg.Body,
+		},
+	}
+	if p.config.MaxLen > 0 {
+		args.MaxLen = p.config.MaxLen
+		args.Approx = true
+	}
+
+	id, err := p.client.XAdd(ctx, args).Result()
+	if err != nil {
+		metrics.ClientErrors.WithLabelValues("redis", "send").Inc()
+		err = fmt.Errorf("failed to add message to Redis stream: %w", err)
+		if isPermanent(err) {
+			return nil, retry.Permanent(err)
+		}
+		return nil, err
+	}
+
+	logger.Debug("Message added to Redis stream", "message_id", msg.ID, "destination", stream, "entry_id", id)
+	span.SetAttributes(attribute.String("messaging.redis.entry_id", id))
+
+	return &models.DeliveryResult{Response: id}, nil
+}
+
+// isPermanent reports whether Redis rejected the command itself, e.g.
+// because the stream key holds another data type. Any other error reply,
+// such as a server that is loading or has too many clients, is retried
+// like connection failures and timeouts.
+func isPermanent(err error) bool {
+	for _, prefix := range permanentErrors {
+		if goredis.HasErrorPrefix(err, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// HealthCheck checks if Redis is available
+func (p *Producer) HealthCheck() error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.Timeout)
+	defer cancel()
+
+	if err := p.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("Redis health check failed: %w", err)
+	}
+	return nil
+}
+
+// Close closes the connections to Redis
+func (p *Producer) Close() error {
+	return p.client.Close()
+}
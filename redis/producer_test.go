@@ -0,0 +1,207 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/retry"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// commandRecorder records the arguments of the commands sent to Redis
+type commandRecorder struct {
+	mu       sync.Mutex
+	commands [][]interface{}
+}
+
+// DialHook passes dialing on unchanged
+func (r *commandRecorder) DialHook(next goredis.DialHook) goredis.DialHook {
+	return next
+}
+
+// ProcessHook records cmd before sending it
+func (r *commandRecorder) ProcessHook(next goredis.ProcessHook) goredis.ProcessHook {
+	return func(ctx context.Context, cmd goredis.Cmder) error {
+		r.mu.Lock()
+		r.commands = append(r.commands, cmd.Args())
+		r.mu.Unlock()
+		return next(ctx, cmd)
+	}
+}
+
+// ProcessPipelineHook passes pipelines on unchanged
+func (r *commandRecorder) ProcessPipelineHook(next goredis.ProcessPipelineHook) goredis.ProcessPipelineHook {
+	return next
+}
+
+// last returns the arguments of the last command as text
+func (r *commandRecorder) last() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.commands) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprintln(r.commands[len(r.commands)-1]...))
+}
+
+// newTestProducer starts an in-memory Redis and returns a producer for it
+func newTestProducer(t *testing.T, maxLen int64) (*Producer, *miniredis.Miniredis, *commandRecorder) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	producer := NewProducer(&config.RedisConfig{Address: server.Addr(), Timeout: time.Second, MaxLen: maxLen})
+	t.Cleanup(func() { producer.Close() })
+
+	recorder := &commandRecorder{}
+	producer.client.AddHook(recorder)
+	return producer, server, recorder
+}
+
+// testMessage returns a message for queue
+func testMessage(id string) *models.WebhookMessage {
+	return &models.WebhookMessage{
+		ID:        id,
+		Path:      "/webhook/orders",
+		Queue:     "orders",
+		Body:      []byte(`{"order":42}`),
+		Headers:   map[string]string{"Content-Type": "application/json"},
+		Timestamp: time.Date(2026, 3, 1, 12, 30, 0, 500, time.FixedZone("CET", 3600)),
+	}
+}
+
+func TestSendAddsStreamEntry(t *testing.T) {
+	producer, server, _ := newTestProducer(t, 0)
+
+	result, err := producer.Send(context.Background(), models.DeliveryTarget{Type: models.TargetRedis}, testMessage("msg-1"))
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	// Without a topic the stream is named after the queue
+	entries, err := server.Stream("orders")
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("stream orders = %v, %v, want one entry", entries, err)
+	}
+	if result.Response != entries[0].ID {
+		t.Errorf("result entry ID = %q, want %q", result.Response, entries[0].ID)
+	}
+
+	fields := make(map[string]string)
+	for i := 0; i+1 < len(entries[0].Values); i += 2 {
+		fields[entries[0].Values[i]] = entries[0].Values[i+1]
+	}
+	want := map[string]string{
+		"id":        "msg-1",
+		"path":      "/webhook/orders",
+		"timestamp": "2026-03-01T11:30:00.0000005Z",
+		"body":      `{"order":42}`,
+	}
+	for key, value := range want {
+		if fields[key] != value {
+			t.Errorf("field %s = %q, want %q", key, fields[key], value)
+		}
+	}
+
+	var headers map[string]string
+	if err := json.Unmarshal([]byte(fields["headers"]), &headers); err != nil {
+		t.Fatalf("headers field %q: %v", fields["headers"], err)
+	}
+	if headers["Content-Type"] != "application/json" {
+		t.Errorf("headers = %v, want the message headers", headers)
+	}
+
+	// The target topic names the stream
+	if _, err := producer.Send(context.Background(), models.DeliveryTarget{Type: models.TargetRedis, Topic: "audit"}, testMessage("msg-2")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if entries, _ := server.Stream("audit"); len(entries) != 1 {
+		t.Errorf("stream audit has %d entries, want 1", len(entries))
+	}
+}
+
+func TestSendTrimsStream(t *testing.T) {
+	producer, server, recorder := newTestProducer(t, 5)
+
+	for i := range 12 {
+		if _, err := producer.SendMessageToStream(context.Background(), "orders", testMessage(fmt.Sprintf("msg-%d", i))); err != nil {
+			t.Fatalf("SendMessageToStream: %v", err)
+		}
+	}
+
+	// Trimming is approximate so Redis can drop whole nodes
+	if got := recorder.last(); !strings.HasPrefix(got, "xadd orders maxlen ~ 5 * ") {
+		t.Errorf("command = %q, want XADD with MAXLEN ~ 5", got)
+	}
+	entries, err := server.Stream("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) < 5 || len(entries) >= 12 {
+		t.Errorf("stream has %d entries, want it trimmed to about 5", len(entries))
+	}
+	if last := entries[len(entries)-1].Values[1]; last != "msg-11" {
+		t.Errorf("last entry = %s, want msg-11", last)
+	}
+
+	// Without max_len the stream is not trimmed
+	unbounded, _, recorder := newTestProducer(t, 0)
+	if _, err := unbounded.SendMessageToStream(context.Background(), "orders", testMessage("msg-1")); err != nil {
+		t.Fatalf("SendMessageToStream: %v", err)
+	}
+	if got := recorder.last(); strings.Contains(got, "maxlen") {
+		t.Errorf("command = %q, want no MAXLEN", got)
+	}
+}
+
+func TestSendErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		setup     func(server *miniredis.Miniredis)
+		permanent bool
+	}{
+		{"wrong key type", func(server *miniredis.Miniredis) { server.Set("orders", "not a stream") }, true},
+		{"invalid stream ID", func(server *miniredis.Miniredis) {
+			server.SetError("ERR Invalid stream ID specified as stream command argument")
+		}, true},
+		{"invalid argument", func(server *miniredis.Miniredis) {
+			server.SetError("ERR value is not an integer or out of range")
+		}, true},
+		{"too many clients", func(server *miniredis.Miniredis) { server.SetError("ERR max number of clients reached") }, false},
+		{"unknown command", func(server *miniredis.Miniredis) { server.SetError("ERR unknown command 'xadd'") }, false},
+		{"loading", func(server *miniredis.Miniredis) {
+			server.SetError("LOADING Redis is loading the dataset in memory")
+		}, false},
+		{"out of memory", func(server *miniredis.Miniredis) {
+			server.SetError("OOM command not allowed when used memory > 'maxmemory'")
+		}, false},
+		{"read only replica", func(server *miniredis.Miniredis) {
+			server.SetError("READONLY You can't write against a read only replica.")
+		}, false},
+		{"authentication required", func(server *miniredis.Miniredis) { server.RequireAuth("secret") }, false},
+		{"server down", func(server *miniredis.Miniredis) { server.Close() }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			producer, server, _ := newTestProducer(t, 0)
+			tt.setup(server)
+
+			_, err := producer.SendMessageToStream(context.Background(), "orders", testMessage("msg-1"))
+			if err == nil {
+				t.Fatal("SendMessageToStream succeeded, want an error")
+			}
+			if retry.IsPermanent(err) != tt.permanent {
+				t.Errorf("IsPermanent(%v) = %v, want %v", err, retry.IsPermanent(err), tt.permanent)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/logging"
+)
+
+// Reload re-reads the configuration file and swaps routes, signature secrets,
+// retry policies and destinations in the running components. Listeners, the
+// storage and client connections are kept; changes to their settings are
+// reported as requiring a restart. An invalid configuration is rejected and
+// the running one stays in effect.
+func (app *Application) Reload() ([]config.Change, error) {
+	app.reloadMu.Lock()
+	defer app.reloadMu.Unlock()
+
+	logger.Info("Reloading configuration", "path", app.configPath)
+
+	changes, err := app.reload()
+	if err != nil {
+		logger.Error("Configuration reload rejected, keeping the running configuration", "error", err)
+		return changes, err
+	}
+
+	for _, change := range changes {
+		if change.RestartRequired {
+			logger.Warn("Configuration change requires a restart", "setting", change.Setting, "change", change.Change)
+		} else {
+			logger.Info("Configuration change applied", "setting", change.Setting, "change", change.Change)
+		}
+	}
+	logger.Info("Configuration reloaded", "changes", len(changes))
+
+	return changes, nil
+}
+
+// reload loads the configuration file and applies it to the components
+func (app *Application) reload() ([]config.Change, error) {
+	next, err := config.LoadConfig(app.configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, changes, err := app.config.Reload(next)
+	if err != nil {
+		return changes, err
+	}
+
+	// The server builds signature verifiers and may still reject the
+	// configuration, so it goes first
+	if err := app.server.Reload(cfg); err != nil {
+		return changes, fmt.Errorf("failed to apply routes: %w", err)
+	}
+	if err := logging.Setup(cfg); err != nil {
+		return changes, fmt.Errorf("failed to configure logging: %w", err)
+	}
+
+	app.handler.Reload(cfg)
+	if app.worker != nil {
+		app.worker.Reload(cfg)
+	}
+	if app.admin != nil {
+		app.admin.Reload(cfg)
+	}
+	app.config = cfg
+
+	return changes, nil
+}
@@ -0,0 +1,130 @@
+package retry
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+)
+
+// Policy computes the retry schedule for failed deliveries
+type Policy struct {
+	InitialDelay time.Duration
+	Multiplier   float64
+	MaxDelay     time.Duration
+	Jitter       float64 // fraction of the delay randomized in both directions, 0..1
+	MaxAttempts  int     // 0 means unlimited
+	MaxAge       time.Duration
+
+	random func() float64
+}
+
+// DefaultPolicy returns the policy used when nothing is configured
+func DefaultPolicy() *Policy {
+	return NewPolicy(config.DefaultRetryPolicy())
+}
+
+// NewPolicy creates a policy from configuration
+func NewPolicy(cfg config.RetryPolicyConfig) *Policy {
+	return &Policy{
+		InitialDelay: cfg.InitialDelay,
+		Multiplier:   cfg.Multiplier,
+		MaxDelay:     cfg.MaxDelay,
+		Jitter:       cfg.Jitter,
+		MaxAttempts:  cfg.MaxAttempts,
+		MaxAge:       cfg.MaxAge,
+		random:       rand.Float64,
+	}
+}
+
+// Policies holds the retry policies of routes that override the default
+type Policies struct {
+	Default *Policy
+	Routes  map[string]*Policy // by route path
+}
+
+// DefaultPolicies returns the policies used when nothing is configured
+func DefaultPolicies() *Policies {
+	return &Policies{Default: DefaultPolicy()}
+}
+
+// NewPolicies creates the policies of the worker and routes in cfg
+func NewPolicies(cfg *config.Config) *Policies {
+	policies := &Policies{Default: NewPolicy(cfg.RetryPolicyFor(""))}
+	for _, route := range cfg.Routes {
+		if route.RetryPolicy == nil {
+			continue
+		}
+		if policies.Routes == nil {
+			policies.Routes = make(map[string]*Policy)
+		}
+		policies.Routes[route.Path] = NewPolicy(*route.RetryPolicy)
+	}
+	return policies
+}
+
+// For returns the policy of messages received on path
+func (p *Policies) For(path string) *Policy {
+	if policy, ok := p.Routes[path]; ok {
+		return policy
+	}
+	return p.Default
+}
+
+// Delay returns the wait before the next attempt after the given number of
+// failed attempts (attempts >= 1)
+func (p *Policy) Delay(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	delay := float64(p.InitialDelay) * math.Pow(multiplier, float64(attempts-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	if p.Jitter > 0 && p.random != nil {
+		delay += (p.random()*2 - 1) * p.Jitter * delay
+	}
+
+	if delay < 0 {
+		return 0
+	}
+	return time.Duration(delay)
+}
+
+// NextRetryAt returns when the next attempt is due after the given number of
+// failed attempts
+func (p *Policy) NextRetryAt(attempts int, now time.Time) time.Time {
+	return now.Add(p.Delay(attempts)).UTC()
+}
+
+// Exhausted reports whether no further attempts should be made for a
+// message first received at createdAt
+func (p *Policy) Exhausted(attempts int, createdAt, now time.Time) bool {
+	if p.MaxAttempts > 0 && attempts >= p.MaxAttempts {
+		return true
+	}
+	if p.MaxAge > 0 && now.Sub(createdAt) >= p.MaxAge {
+		return true
+	}
+	return false
+}
+
+// Schedule returns the delays between the first n attempts, without jitter
+func (p *Policy) Schedule(n int) []time.Duration {
+	noJitter := *p
+	noJitter.Jitter = 0
+
+	delays := make([]time.Duration, 0, n)
+	for attempt := 1; attempt <= n; attempt++ {
+		delays = append(delays, noJitter.Delay(attempt))
+	}
+	return delays
+}
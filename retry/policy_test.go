@@ -0,0 +1,197 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+)
+
+func TestDelayGrowsUpToMaxDelay(t *testing.T) {
+	p := NewPolicy(config.RetryPolicyConfig{
+		InitialDelay: 2 * time.Minute,
+		Multiplier:   2,
+		MaxDelay:     time.Hour,
+	})
+
+	want := []time.Duration{
+		2 * time.Minute,
+		4 * time.Minute,
+		8 * time.Minute,
+		16 * time.Minute,
+		32 * time.Minute,
+		time.Hour, // 64m capped
+		time.Hour,
+	}
+	for i, expected := range want {
+		if got := p.Delay(i + 1); got != expected {
+			t.Errorf("Delay(%d) = %v, want %v", i+1, got, expected)
+		}
+	}
+
+	if got := p.Delay(0); got != 2*time.Minute {
+		t.Errorf("Delay(0) = %v, want the initial delay", got)
+	}
+	if got := p.Delay(1000); got != time.Hour {
+		t.Errorf("Delay(1000) = %v, want the max delay", got)
+	}
+}
+
+func TestDelayWithoutGrowthOrCap(t *testing.T) {
+	constant := NewPolicy(config.RetryPolicyConfig{InitialDelay: time.Minute, Multiplier: 1})
+	for attempts := 1; attempts <= 5; attempts++ {
+		if got := constant.Delay(attempts); got != time.Minute {
+			t.Errorf("constant Delay(%d) = %v, want 1m", attempts, got)
+		}
+	}
+
+	// A multiplier below 1 would shrink the delay and is treated as 1
+	shrinking := NewPolicy(config.RetryPolicyConfig{InitialDelay: time.Minute, Multiplier: 0.5})
+	if got := shrinking.Delay(3); got != time.Minute {
+		t.Errorf("Delay with multiplier 0.5 = %v, want 1m", got)
+	}
+
+	uncapped := NewPolicy(config.RetryPolicyConfig{InitialDelay: time.Second, Multiplier: 10})
+	if got := uncapped.Delay(5); got != 10000*time.Second {
+		t.Errorf("uncapped Delay(5) = %v, want 10000s", got)
+	}
+}
+
+func TestDelayJitterBounds(t *testing.T) {
+	p := NewPolicy(config.RetryPolicyConfig{
+		InitialDelay: 10 * time.Minute,
+		Multiplier:   2,
+		MaxDelay:     time.Hour,
+		Jitter:       0.1,
+	})
+
+	tests := []struct {
+		random float64
+		want   time.Duration
+	}{
+		{0, 9 * time.Minute},    // lower bound: -10%
+		{0.5, 10 * time.Minute}, // no change
+		{0.75, 10*time.Minute + 30*time.Second},
+		{1, 11 * time.Minute}, // upper bound: +10%
+	}
+	for _, tt := range tests {
+		p.random = func() float64 { return tt.random }
+		if got := p.Delay(1); got != tt.want {
+			t.Errorf("Delay(1) with random %v = %v, want %v", tt.random, got, tt.want)
+		}
+	}
+
+	// Jitter applies after the cap, so capped delays vary around the cap
+	p.random = func() float64 { return 1 }
+	if got := p.Delay(10); got != 66*time.Minute {
+		t.Errorf("capped Delay(10) with max jitter = %v, want 66m", got)
+	}
+
+	// With the real random source every delay stays within the bounds
+	p.random = NewPolicy(config.RetryPolicyConfig{}).random
+	for i := 0; i < 1000; i++ {
+		if got := p.Delay(1); got < 9*time.Minute || got > 11*time.Minute {
+			t.Fatalf("Delay(1) = %v, want within 9m..11m", got)
+		}
+	}
+}
+
+func TestScheduleIgnoresJitter(t *testing.T) {
+	p := NewPolicy(config.RetryPolicyConfig{InitialDelay: time.Minute, Multiplier: 3, MaxDelay: 20 * time.Minute, Jitter: 1})
+
+	got := p.Schedule(4)
+	want := []time.Duration{time.Minute, 3 * time.Minute, 9 * time.Minute, 20 * time.Minute}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Schedule(4) = %v, want %v", got, want)
+	}
+}
+
+func TestNextRetryAt(t *testing.T) {
+	p := NewPolicy(config.RetryPolicyConfig{InitialDelay: time.Minute, Multiplier: 2})
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+
+	got := p.NextRetryAt(2, now)
+	if !got.Equal(now.Add(2*time.Minute)) || got.Location() != time.UTC {
+		t.Errorf("NextRetryAt = %v, want %v in UTC", got, now.Add(2*time.Minute))
+	}
+}
+
+func TestPoliciesForRoute(t *testing.T) {
+	policies := NewPolicies(&config.Config{
+		Worker: config.WorkerConfig{RetryPolicy: &config.RetryPolicyConfig{InitialDelay: time.Minute, Multiplier: 1}},
+		Routes: []config.RouteConfig{
+			{Path: "/webhook/orders", RetryPolicy: &config.RetryPolicyConfig{InitialDelay: time.Second, Multiplier: 1}},
+			{Path: "/webhook/events"},
+		},
+	})
+
+	tests := map[string]time.Duration{
+		"/webhook/orders":  time.Second,
+		"/webhook/events":  time.Minute,
+		"/webhook/unknown": time.Minute,
+	}
+	for path, want := range tests {
+		if got := policies.For(path).Delay(1); got != want {
+			t.Errorf("For(%q).Delay(1) = %v, want %v", path, got, want)
+		}
+	}
+
+	if got := DefaultPolicies().For("/webhook/orders"); got.InitialDelay != config.DefaultRetryPolicy().InitialDelay {
+		t.Errorf("default policy initial delay = %v, want %v", got.InitialDelay, config.DefaultRetryPolicy().InitialDelay)
+	}
+}
+
+func TestExhausted(t *testing.T) {
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		cfg      config.RetryPolicyConfig
+		attempts int
+		age      time.Duration
+		want     bool
+	}{
+		{"unlimited", config.RetryPolicyConfig{}, 1000, 365 * 24 * time.Hour, false},
+		{"below max attempts", config.RetryPolicyConfig{MaxAttempts: 3}, 2, 0, false},
+		{"at max attempts", config.RetryPolicyConfig{MaxAttempts: 3}, 3, 0, true},
+		{"above max attempts", config.RetryPolicyConfig{MaxAttempts: 3}, 4, 0, true},
+		{"younger than max age", config.RetryPolicyConfig{MaxAge: 72 * time.Hour}, 1, 71 * time.Hour, false},
+		{"at max age", config.RetryPolicyConfig{MaxAge: 72 * time.Hour}, 1, 72 * time.Hour, true},
+		{"max age reached first", config.RetryPolicyConfig{MaxAttempts: 10, MaxAge: time.Hour}, 2, 2 * time.Hour, true},
+		{"max attempts reached first", config.RetryPolicyConfig{MaxAttempts: 2, MaxAge: time.Hour}, 2, time.Minute, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPolicy(tt.cfg)
+			if got := p.Exhausted(tt.attempts, created, created.Add(tt.age)); got != tt.want {
+				t.Errorf("Exhausted(%d, age %v) = %v, want %v", tt.attempts, tt.age, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPermanent(t *testing.T) {
+	if Permanent(nil) != nil {
+		t.Errorf("Permanent(nil) != nil")
+	}
+
+	cause := errors.New("message too large")
+	err := Permanent(cause)
+	if !IsPermanent(err) {
+		t.Errorf("IsPermanent(Permanent(err)) = false")
+	}
+	if !errors.Is(err, cause) || err.Error() != cause.Error() {
+		t.Errorf("Permanent(err) = %v, want it to wrap %v", err, cause)
+	}
+
+	// Marks survive further wrapping
+	if wrapped := fmt.Errorf("failed to publish: %w", err); !IsPermanent(wrapped) {
+		t.Errorf("IsPermanent of a wrapped permanent error = false")
+	}
+
+	if IsPermanent(cause) || IsPermanent(nil) || IsPermanent(fmt.Errorf("timeout: %w", cause)) {
+		t.Errorf("IsPermanent of an unmarked error = true")
+	}
+}
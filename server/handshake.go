@@ -0,0 +1,84 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+
+	"github.com/expai/messagebridge/config"
+)
+
+// answerUnsignedHandshake answers subscription handshakes that providers send
+// without a signature (Meta hub.challenge, Microsoft Graph validationToken).
+// It returns true if the request was a handshake and has been answered.
+func (s *Server) answerUnsignedHandshake(w http.ResponseWriter, r *http.Request, handshake *config.HandshakeConfig) bool {
+	if handshake == nil {
+		return false
+	}
+
+	switch handshake.Type {
+	case "meta":
+		if r.Method != http.MethodGet {
+			return false
+		}
+
+		query := r.URL.Query()
+		if query.Get("hub.mode") != "subscribe" {
+			http.Error(w, "Unsupported hub.mode", http.StatusBadRequest)
+			return true
+		}
+		if subtle.ConstantTimeCompare([]byte(query.Get("hub.verify_token")), []byte(handshake.VerifyToken)) != 1 {
+			logger.Warn("Meta handshake rejected: verify token mismatch", "route", r.URL.Path, "remote_addr", r.RemoteAddr)
+			http.Error(w, "Invalid verify token", http.StatusForbidden)
+			return true
+		}
+
+		writePlainText(w, query.Get("hub.challenge"))
+		logger.Info("Answered Meta subscription handshake", "route", r.URL.Path)
+		return true
+
+	case "msgraph":
+		token := r.URL.Query().Get("validationToken")
+		if token == "" {
+			return false
+		}
+
+		writePlainText(w, token)
+		logger.Info("Answered Microsoft Graph validation handshake", "route", r.URL.Path)
+		return true
+	}
+
+	return false
+}
+
+// answerSignedHandshake answers handshakes that arrive signed like regular
+// events (Slack url_verification). It must run after signature verification.
+// It returns true if the request was a handshake and has been answered.
+func (s *Server) answerSignedHandshake(w http.ResponseWriter, r *http.Request, handshake *config.HandshakeConfig, body []byte) bool {
+	if handshake == nil || handshake.Type != "slack" {
+		return false
+	}
+
+	var event struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil || event.Type != "url_verification" {
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"challenge": event.Challenge})
+
+	logger.Info("Answered Slack url_verification handshake", "route", r.URL.Path)
+	return true
+}
+
+// writePlainText echoes a handshake challenge as plain text
+func writePlainText(w http.ResponseWriter, value string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(value))
+}
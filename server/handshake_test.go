@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+)
+
+func TestMetaHandshake(t *testing.T) {
+	const path = "/webhook/meta"
+	s, handler := newTestServer(t, config.RouteConfig{
+		Path:      path,
+		Queue:     "meta",
+		Handshake: &config.HandshakeConfig{Type: "meta", VerifyToken: "token"},
+	})
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+		body  string
+	}{
+		{"token matches", "hub.mode=subscribe&hub.verify_token=token&hub.challenge=1158201444", http.StatusOK, "1158201444"},
+		{"token mismatch", "hub.mode=subscribe&hub.verify_token=wrong&hub.challenge=1158201444", http.StatusForbidden, ""},
+		{"token missing", "hub.mode=subscribe&hub.challenge=1158201444", http.StatusForbidden, ""},
+		{"unsupported mode", "hub.mode=unsubscribe&hub.verify_token=token", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(s, httptest.NewRequest(http.MethodGet, path+"?"+tt.query, nil))
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.body)
+			}
+		})
+	}
+
+	if handler.count() != 0 {
+		t.Errorf("handshakes were stored as %d messages", handler.count())
+	}
+}
+
+func TestMSGraphHandshake(t *testing.T) {
+	const path = "/webhook/graph"
+	s, handler := newTestServer(t, config.RouteConfig{
+		Path:      path,
+		Queue:     "graph",
+		Handshake: &config.HandshakeConfig{Type: "msgraph"},
+	})
+
+	w := serve(s, httptest.NewRequest(http.MethodPost, path+"?validationToken=Validation%3A+Testing+client+application", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if got := w.Body.String(); got != "Validation: Testing client application" {
+		t.Errorf("body = %q, want the decoded token", got)
+	}
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+		t.Errorf("Content-Type = %q, want text/plain", got)
+	}
+	if got := w.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("X-Content-Type-Options = %q, want nosniff", got)
+	}
+	if handler.count() != 0 {
+		t.Fatalf("validation request was stored")
+	}
+
+	// Notifications carry no token and are stored
+	if w := serve(s, httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"value":[]}`))); w.Code != http.StatusOK {
+		t.Fatalf("notification status = %d, want 200", w.Code)
+	}
+	if handler.count() != 1 {
+		t.Errorf("notification was not stored")
+	}
+}
+
+func TestSlackHandshake(t *testing.T) {
+	const path = "/webhook/slack"
+	const secret = "8f742231b10e8888abcd99yyyzzz85a5"
+	s, handler := newTestServer(t, config.RouteConfig{
+		Path:      path,
+		Queue:     "slack",
+		Provider:  "slack",
+		Signature: &config.SignatureConfig{Secret: secret},
+		Handshake: &config.HandshakeConfig{Type: "slack"},
+	})
+
+	const body = `{"token":"Jhj5dZrVaK7ZwHHjRyZWjbDl","challenge":"3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P","type":"url_verification"}`
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	request := func(signature string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r.Header.Set("X-Slack-Request-Timestamp", timestamp)
+		r.Header.Set("X-Slack-Signature", signature)
+		return r
+	}
+
+	// The challenge is only answered once the request is verified
+	if w := serve(s, request("v0="+sign("wrong", "v0:"+timestamp+":"+body))); w.Code != http.StatusUnauthorized {
+		t.Fatalf("unsigned status = %d, want 401", w.Code)
+	}
+
+	w := serve(s, request("v0="+sign(secret, "v0:"+timestamp+":"+body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	var response map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("response: %v", err)
+	}
+	if response["challenge"] != "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P" {
+		t.Errorf("response = %v, want the challenge", response)
+	}
+	if handler.count() != 0 {
+		t.Errorf("handshake was stored")
+	}
+}
@@ -7,46 +7,105 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/logging"
+	"github.com/expai/messagebridge/metrics"
 	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/signature"
+	"github.com/expai/messagebridge/tracing"
 
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// logger is the server component logger
+var logger = logging.For("server")
+
 // WebhookHandler interface for processing webhooks
 type WebhookHandler interface {
-	ProcessWebhook(msg *models.WebhookMessage) error
+	ProcessWebhook(ctx context.Context, msg *models.WebhookMessage) error
 }
 
 // Server represents HTTP server for webhook reception
 type Server struct {
-	server   *http.Server
-	router   *mux.Router
-	config   *config.Config
-	handler  WebhookHandler
-	routeMap map[string]string // path -> queue mapping
+	server  *http.Server
+	router  *mux.Router
+	config  *config.Config // listener settings, fixed until restart
+	handler WebhookHandler
+
+	// routes is replaced as a whole when the configuration is reloaded
+	routes atomic.Pointer[routeTable]
+}
+
+// routeTable holds the webhook routes of a configuration
+type routeTable struct {
+	config *config.Config
+	queues map[string]string // path -> queue mapping
+
+	verifiers         map[string]signature.Verifier // path -> signature verifier
+	signatureFailures map[string]*atomic.Uint64     // path -> rejected request count
+	handshakes        map[string]*config.HandshakeConfig
+}
+
+// newRouteTable builds the routes of cfg. Signature failure counts of routes
+// in previous, if any, are carried over.
+func newRouteTable(cfg *config.Config, previous *routeTable) (*routeTable, error) {
+	table := &routeTable{
+		config:            cfg,
+		queues:            make(map[string]string),
+		verifiers:         make(map[string]signature.Verifier),
+		signatureFailures: make(map[string]*atomic.Uint64),
+		handshakes:        make(map[string]*config.HandshakeConfig),
+	}
+
+	for _, route := range cfg.Routes {
+		table.queues[route.Path] = route.Queue
+		if route.Handshake != nil {
+			table.handshakes[route.Path] = route.Handshake
+		}
+
+		if route.Signature != nil {
+			verifier, err := signature.NewVerifier(route.Provider, route.Signature)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create signature verifier for route %s: %w", route.Path, err)
+			}
+			table.verifiers[route.Path] = verifier
+
+			counter := &atomic.Uint64{}
+			if previous != nil && previous.signatureFailures[route.Path] != nil {
+				counter = previous.signatureFailures[route.Path]
+			}
+			table.signatureFailures[route.Path] = counter
+		}
+	}
+
+	return table, nil
 }
 
 // NewServer creates a new HTTP server
-func NewServer(cfg *config.Config, handler WebhookHandler) *Server {
+func NewServer(cfg *config.Config, handler WebhookHandler) (*Server, error) {
 	router := mux.NewRouter()
 
-	// Build route mapping
-	routeMap := make(map[string]string)
-	for _, route := range cfg.Routes {
-		routeMap[route.Path] = route.Queue
+	routes, err := newRouteTable(cfg, nil)
+	if err != nil {
+		return nil, err
 	}
 
 	server := &Server{
-		router:   router,
-		config:   cfg,
-		handler:  handler,
-		routeMap: routeMap,
+		router:  router,
+		config:  cfg,
+		handler: handler,
 	}
+	server.routes.Store(routes)
+	server.logRoutes()
 
 	server.setupRoutes()
 
@@ -59,7 +118,34 @@ func NewServer(cfg *config.Config, handler WebhookHandler) *Server {
 	}
 
 	server.server = httpServer
-	return server
+	return server, nil
+}
+
+// Reload replaces the webhook routes, signature secrets and handshakes with
+// those of cfg. Requests in flight finish with the previous routes. The
+// listener settings of cfg are ignored.
+func (s *Server) Reload(cfg *config.Config) error {
+	routes, err := newRouteTable(cfg, s.routes.Load())
+	if err != nil {
+		return err
+	}
+
+	s.routes.Store(routes)
+	s.logRoutes()
+	return nil
+}
+
+// logRoutes logs the configured webhook routes
+func (s *Server) logRoutes() {
+	for _, route := range s.routes.Load().config.Routes {
+		if route.Provider != "" {
+			logger.Info("Registered webhook route", "route", route.Path, "queue", route.Queue, "provider", route.Provider)
+		} else if route.Signature != nil {
+			logger.Info("Registered webhook route", "route", route.Path, "queue", route.Queue, "signature_header", route.Signature.Header)
+		} else {
+			logger.Info("Registered webhook route", "route", route.Path, "queue", route.Queue)
+		}
+	}
 }
 
 // setupRoutes configures HTTP routes
@@ -70,19 +156,40 @@ func (s *Server) setupRoutes() {
 	// Status endpoint
 	s.router.HandleFunc("/status", s.statusHandler).Methods("GET")
 
-	// Webhook endpoints
-	for _, route := range s.config.Routes {
-		s.router.HandleFunc(route.Path, s.webhookHandler).Methods("POST")
-		log.Printf("Registered webhook route: %s -> queue: %s", route.Path, route.Queue)
-	}
+	// Prometheus metrics endpoint
+	s.router.Handle("/metrics", metrics.Handler()).Methods("GET")
+
+	// Webhook endpoints are looked up per request so that reloaded routes
+	// take effect without re-registering them
+	s.router.PathPrefix("/").HandlerFunc(s.routeWebhook)
 
 	// Middleware
 	s.router.Use(s.loggingMiddleware)
 	s.router.Use(s.recoveryMiddleware)
 }
 
+// routeWebhook passes requests for configured webhook routes to webhookHandler
+func (s *Server) routeWebhook(w http.ResponseWriter, r *http.Request) {
+	route := s.routes.Load().config.FindRoute(r.URL.Path)
+	if route == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Meta verifies subscriptions with a GET carrying hub.challenge
+	metaHandshake := route.Handshake != nil && route.Handshake.Type == "meta"
+	if r.Method != http.MethodPost && !(r.Method == http.MethodGet && metaHandshake) {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.instrument(route.Path, s.webhookHandler)(w, r)
+}
+
 // webhookHandler handles incoming webhook requests
 func (s *Server) webhookHandler(w http.ResponseWriter, r *http.Request) {
+	routes := s.routes.Load()
+
 	// Generate unique message ID
 	msgID, err := generateID()
 	if err != nil {
@@ -93,7 +200,7 @@ func (s *Server) webhookHandler(w http.ResponseWriter, r *http.Request) {
 	// Read request body
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("Failed to read request body for %s: %v", msgID, err)
+		logger.Warn("Failed to read request body", "message_id", msgID, "route", r.URL.Path, "error", err)
 		http.Error(w, "Failed to read request body", http.StatusBadRequest)
 		return
 	}
@@ -108,13 +215,35 @@ func (s *Server) webhookHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get queue for this path
-	queue, exists := s.routeMap[r.URL.Path]
+	queue, exists := routes.queues[r.URL.Path]
 	if !exists {
-		log.Printf("No queue configured for path %s", r.URL.Path)
+		logger.Warn("No queue configured for path", "route", r.URL.Path)
 		http.Error(w, "Path not configured", http.StatusNotFound)
 		return
 	}
 
+	// Some providers send their subscription handshake unsigned
+	handshake := routes.handshakes[r.URL.Path]
+	if s.answerUnsignedHandshake(w, r, handshake) {
+		return
+	}
+
+	// Verify signature before anything is persisted
+	if verifier, ok := routes.verifiers[r.URL.Path]; ok {
+		if err := verifier.Verify(r, body); err != nil {
+			failures := routes.signatureFailures[r.URL.Path].Add(1)
+			logger.Warn("Signature verification failed",
+				"route", r.URL.Path, "remote_addr", r.RemoteAddr, "failures", failures, "error", err)
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// Handshakes are answered directly and never stored as messages
+	if s.answerSignedHandshake(w, r, handshake, body) {
+		return
+	}
+
 	// Create webhook message
 	msg := &models.WebhookMessage{
 		ID:        msgID,
@@ -126,11 +255,17 @@ func (s *Server) webhookHandler(w http.ResponseWriter, r *http.Request) {
 		Status:    models.StatusPending,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
+		// Delivery spans continue this request's trace
+		TraceParent: tracing.TraceParent(r.Context()),
 	}
+	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("messaging.message.id", msgID))
+
+	logger.Debug("Webhook received",
+		"message_id", msgID, "route", msg.Path, "queue", queue, "headers", logging.Headers(headers))
 
 	// Process webhook
-	if err := s.handler.ProcessWebhook(msg); err != nil {
-		log.Printf("Failed to process webhook %s: %v", msgID, err)
+	if err := s.handler.ProcessWebhook(r.Context(), msg); err != nil {
+		logger.Error("Failed to process webhook", "message_id", msgID, "route", msg.Path, "error", err)
 		http.Error(w, "Failed to process webhook", http.StatusInternalServerError)
 		return
 	}
@@ -146,7 +281,7 @@ func (s *Server) webhookHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	json.NewEncoder(w).Encode(response)
-	log.Printf("Webhook %s processed successfully", msgID)
+	logger.Debug("Webhook accepted", "message_id", msgID, "route", msg.Path, "queue", queue)
 }
 
 // healthHandler handles health check requests
@@ -171,16 +306,36 @@ func (s *Server) statusHandler(w http.ResponseWriter, r *http.Request) {
 	response := map[string]interface{}{
 		"status":    "running",
 		"timestamp": time.Now().Format(time.RFC3339),
-		"routes":    s.config.Routes,
+		"routes":    redactedRoutes(s.routes.Load().config.Routes),
 		"server": map[string]interface{}{
 			"host": s.config.Server.Host,
 			"port": s.config.Server.Port,
 		},
+		"signature_failures": s.signatureFailureStats(),
 	}
 
 	json.NewEncoder(w).Encode(response)
 }
 
+// redactedRoutes returns the routes with secrets and URL credentials masked
+func redactedRoutes(routes []config.RouteConfig) []config.RouteConfig {
+	redacted := make([]config.RouteConfig, len(routes))
+	for i, route := range routes {
+		redacted[i] = route.Redacted()
+	}
+	return redacted
+}
+
+// signatureFailureStats returns rejected request counts per route
+func (s *Server) signatureFailureStats() map[string]uint64 {
+	routes := s.routes.Load()
+	stats := make(map[string]uint64, len(routes.signatureFailures))
+	for path, counter := range routes.signatureFailures {
+		stats[path] = counter.Load()
+	}
+	return stats
+}
+
 // loggingMiddleware logs HTTP requests
 func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -191,17 +346,52 @@ func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 
 		next.ServeHTTP(rw, r)
 
-		duration := time.Since(start)
-		log.Printf("%s %s %d %v %s", r.Method, r.URL.Path, rw.statusCode, duration, r.RemoteAddr)
+		// Successful requests are only interesting when debugging
+		level := slog.LevelDebug
+		if rw.statusCode >= http.StatusBadRequest {
+			level = slog.LevelWarn
+		}
+		logger.Log(r.Context(), level, "HTTP request",
+			"method", r.Method, "path", r.URL.Path, "status", rw.statusCode,
+			"duration_ms", time.Since(start).Milliseconds(), "remote_addr", r.RemoteAddr)
 	})
 }
 
+// instrument records request count, status code and ingest latency of a
+// webhook route, and traces the request as a continuation of the caller's
+// trace when it sends a traceparent header
+func (s *Server) instrument(path string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		ctx := tracing.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Start(ctx, "webhook.receive "+path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", path),
+			),
+		)
+		defer span.End()
+
+		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next(rw, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", rw.statusCode))
+		if rw.statusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rw.statusCode))
+		}
+
+		metrics.ObserveWebhook(path, rw.statusCode, start)
+	}
+}
+
 // recoveryMiddleware recovers from panics
 func (s *Server) recoveryMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				log.Printf("Panic recovered: %v", err)
+				logger.Error("Panic recovered", "path", r.URL.Path, "error", err)
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
 			}
 		}()
@@ -212,13 +402,13 @@ func (s *Server) recoveryMiddleware(next http.Handler) http.Handler {
 
 // Start starts the HTTP server
 func (s *Server) Start() error {
-	log.Printf("Starting HTTP server on %s:%d", s.config.Server.Host, s.config.Server.Port)
+	logger.Info("Starting HTTP server", "host", s.config.Server.Host, "port", s.config.Server.Port)
 	return s.server.ListenAndServe()
 }
 
 // Shutdown gracefully shuts down the server
 func (s *Server) Shutdown(ctx context.Context) error {
-	log.Println("Shutting down HTTP server...")
+	logger.Info("Shutting down HTTP server")
 	return s.server.Shutdown(ctx)
 }
 
@@ -241,4 +431,3 @@ func generateID() (string, error) {
 	}
 	return hex.EncodeToString(bytes), nil
 }
- 
\ No newline at end of file
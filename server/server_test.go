@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/metrics"
+	"github.com/expai/messagebridge/models"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// recordingHandler records the webhooks passed to it
+type recordingHandler struct {
+	mu       sync.Mutex
+	messages []*models.WebhookMessage
+}
+
+// ProcessWebhook records msg
+func (h *recordingHandler) ProcessWebhook(_ context.Context, msg *models.WebhookMessage) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.messages = append(h.messages, msg)
+	return nil
+}
+
+// count returns the number of recorded webhooks
+func (h *recordingHandler) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.messages)
+}
+
+// newTestServer creates a server for routes with a recording handler
+func newTestServer(t *testing.T, routes ...config.RouteConfig) (*Server, *recordingHandler) {
+	t.Helper()
+
+	handler := &recordingHandler{}
+	s, err := NewServer(&config.Config{
+		Server: config.ServerConfig{Host: "127.0.0.1", Port: 0},
+		Routes: routes,
+	}, handler)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	return s, handler
+}
+
+// serve sends a request through the server's router
+func serve(s *Server, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+	return w
+}
+
+// sign returns the hex HMAC-SHA256 of body with secret
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestSignatureVerification(t *testing.T) {
+	const path = "/webhook/signed"
+	const secret = "s3cret"
+	const body = `{"event":"paid"}`
+
+	s, handler := newTestServer(t, config.RouteConfig{
+		Path:  path,
+		Queue: "signed",
+		Signature: &config.SignatureConfig{
+			Header:    "X-Signature",
+			Algorithm: "sha256",
+			Encoding:  "hex",
+			Prefix:    "sha256=",
+			Secret:    secret,
+		},
+	})
+
+	tests := []struct {
+		name      string
+		signature string
+		want      int
+	}{
+		{"missing", "", http.StatusUnauthorized},
+		{"wrong secret", "sha256=" + sign("other", body), http.StatusUnauthorized},
+		{"missing prefix", sign(secret, body), http.StatusUnauthorized},
+		{"not hex", "sha256=zz", http.StatusUnauthorized},
+		{"valid", "sha256=" + sign(secret, body), http.StatusOK},
+	}
+
+	rejected := testutil.ToFloat64(metrics.WebhooksReceived.WithLabelValues(path, "401"))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+			if tt.signature != "" {
+				r.Header.Set("X-Signature", tt.signature)
+			}
+
+			if w := serve(s, r); w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+
+	if got := testutil.ToFloat64(metrics.WebhooksReceived.WithLabelValues(path, "401")) - rejected; got != 4 {
+		t.Errorf("webhooks_received_total{code=401} increased by %v, want 4", got)
+	}
+	if got := s.signatureFailureStats()[path]; got != 4 {
+		t.Errorf("signature failures = %d, want 4", got)
+	}
+
+	// Only the valid request reaches the handler
+	if handler.count() != 1 {
+		t.Fatalf("handler got %d messages, want 1", handler.count())
+	}
+	msg := handler.messages[0]
+	if string(msg.Body) != body || msg.Path != path || msg.Queue != "signed" {
+		t.Errorf("message = %q %s %s, want body, path and queue of the request", msg.Body, msg.Path, msg.Queue)
+	}
+}
+
+func TestSignatureFailuresSurviveReload(t *testing.T) {
+	route := config.RouteConfig{
+		Path:      "/webhook/signed",
+		Queue:     "signed",
+		Signature: &config.SignatureConfig{Header: "X-Signature", Secret: "s3cret"},
+	}
+	s, _ := newTestServer(t, route)
+
+	serve(s, httptest.NewRequest(http.MethodPost, route.Path, strings.NewReader("{}")))
+
+	if err := s.Reload(&config.Config{Routes: []config.RouteConfig{route}}); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got := s.signatureFailureStats()[route.Path]; got != 1 {
+		t.Errorf("signature failures after reload = %d, want 1", got)
+	}
+}
+
+func TestUnsignedRoutePassesThrough(t *testing.T) {
+	s, handler := newTestServer(t, config.RouteConfig{Path: "/webhook/open", Queue: "open"})
+
+	w := serve(s, httptest.NewRequest(http.MethodPost, "/webhook/open", strings.NewReader("{}")))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+
+	var response map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("response: %v", err)
+	}
+	if response["status"] != "accepted" || response["message_id"] != handler.messages[0].ID {
+		t.Errorf("response = %v, want accepted with the message ID", response)
+	}
+
+	if w := serve(s, httptest.NewRequest(http.MethodPost, "/webhook/unknown", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("unknown route status = %d, want 404", w.Code)
+	}
+	if w := serve(s, httptest.NewRequest(http.MethodGet, "/webhook/open", nil)); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want 405", w.Code)
+	}
+}
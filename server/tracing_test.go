@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/expai/messagebridge/config"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestWebhookContinuesCallerTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	s, handler := newTestServer(t, config.RouteConfig{Path: "/webhook/open", Queue: "open"})
+
+	const traceID = "0af7651916cd43dd8448eb211c80319c"
+	const callerSpanID = "b7ad6b7169203331"
+	r := httptest.NewRequest(http.MethodPost, "/webhook/open", strings.NewReader("{}"))
+	r.Header.Set("traceparent", "00-"+traceID+"-"+callerSpanID+"-01")
+	if w := serve(s, r); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+
+	// The request span is a child of the caller's span
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("recorded %d spans, want 1", len(spans))
+	}
+	receive := spans[0]
+	if receive.Name() != "webhook.receive /webhook/open" || receive.SpanContext().TraceID().String() != traceID || receive.Parent().SpanID().String() != callerSpanID {
+		t.Errorf("span %q in trace %s with parent %s, want webhook.receive in the caller's trace",
+			receive.Name(), receive.SpanContext().TraceID(), receive.Parent().SpanID())
+	}
+
+	// The stored message continues from the request span
+	want := "00-" + traceID + "-" + receive.SpanContext().SpanID().String() + "-01"
+	if got := handler.messages[0].TraceParent; got != want {
+		t.Errorf("message traceparent = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,212 @@
+package signature
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+)
+
+// Supported provider presets
+const (
+	ProviderStripe  = "stripe"
+	ProviderGitHub  = "github"
+	ProviderShopify = "shopify"
+	ProviderSlack   = "slack"
+	ProviderTwilio  = "twilio"
+)
+
+// defaultProviderTolerance is the replay window used by Stripe and Slack
+const defaultProviderTolerance = 5 * time.Minute
+
+// newProviderVerifier creates a verifier for a provider preset
+func newProviderVerifier(provider string, cfg *config.SignatureConfig) (Verifier, error) {
+	secret := []byte(cfg.Secret)
+
+	tolerance := cfg.Tolerance
+	if tolerance == 0 {
+		tolerance = defaultProviderTolerance
+	}
+
+	switch strings.ToLower(provider) {
+	case ProviderStripe:
+		return &StripeVerifier{
+			secret:    secret,
+			tolerance: tolerance,
+			now:       time.Now,
+		}, nil
+	case ProviderGitHub:
+		return &HMACVerifier{
+			header:   "X-Hub-Signature-256",
+			prefix:   "sha256=",
+			encoding: "hex",
+			secret:   secret,
+			newHash:  sha256.New,
+			now:      time.Now,
+		}, nil
+	case ProviderShopify:
+		return &HMACVerifier{
+			header:   "X-Shopify-Hmac-Sha256",
+			encoding: "base64",
+			secret:   secret,
+			newHash:  sha256.New,
+			now:      time.Now,
+		}, nil
+	case ProviderSlack:
+		return &HMACVerifier{
+			header:          "X-Slack-Signature",
+			prefix:          "v0=",
+			encoding:        "hex",
+			secret:          secret,
+			newHash:         sha256.New,
+			timestampHeader: "X-Slack-Request-Timestamp",
+			tolerance:       tolerance,
+			basestring:      slackBasestring,
+			now:             time.Now,
+		}, nil
+	case ProviderTwilio:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("twilio signatures require the public URL of the route")
+		}
+		return &TwilioVerifier{
+			authToken: secret,
+			publicURL: cfg.URL,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported signature provider: %s", provider)
+	}
+}
+
+// slackBasestring builds Slack's "v0:<timestamp>:<body>" signed payload
+func slackBasestring(timestamp string, body []byte) []byte {
+	return append([]byte("v0:"+timestamp+":"), body...)
+}
+
+// StripeVerifier verifies the Stripe-Signature header ("t=...,v1=...")
+type StripeVerifier struct {
+	secret    []byte
+	tolerance time.Duration
+	now       func() time.Time
+}
+
+// Verify checks the request signature against the body
+func (v *StripeVerifier) Verify(r *http.Request, body []byte) error {
+	header := r.Header.Get("Stripe-Signature")
+	if header == "" {
+		return ErrMissingSignature
+	}
+
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	if err := checkTimestamp(timestamp, v.tolerance, v.now()); err != nil {
+		return err
+	}
+	if len(signatures) == 0 {
+		return ErrMissingSignature
+	}
+
+	// Stripe may send several v1 signatures while a secret is being rolled
+	expected := computeHMAC(sha256.New, v.secret, dotBasestring(timestamp, body))
+	for _, sig := range signatures {
+		if equalEncoded(sig, expected, "hex") {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+// TwilioVerifier verifies the X-Twilio-Signature header, which signs the
+// full request URL followed by the sorted POST parameters
+type TwilioVerifier struct {
+	authToken []byte
+	publicURL string
+}
+
+// Verify checks the request signature against the URL and body
+func (v *TwilioVerifier) Verify(r *http.Request, body []byte) error {
+	provided := r.Header.Get("X-Twilio-Signature")
+	if provided == "" {
+		return ErrMissingSignature
+	}
+
+	requestURL := v.requestURL(r)
+
+	parsed, err := url.Parse(requestURL)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	payload := requestURL
+	if bodyHash := parsed.Query().Get("bodySHA256"); bodyHash != "" {
+		// JSON payloads are signed via a body hash in the query string
+		sum := sha256.Sum256(body)
+		if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(bodyHash))) != 1 {
+			return ErrInvalidSignature
+		}
+	} else if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		params, err := url.ParseQuery(string(body))
+		if err != nil {
+			return ErrInvalidSignature
+		}
+		payload += sortedParams(params)
+	}
+
+	expected := computeHMAC(sha1.New, v.authToken, []byte(payload))
+	if subtle.ConstantTimeCompare([]byte(provided), []byte(base64.StdEncoding.EncodeToString(expected))) != 1 {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// requestURL returns the URL Twilio used to reach us: the configured public
+// URL with the query of the request. Host and forwarded headers are chosen
+// by the client and are not used.
+func (v *TwilioVerifier) requestURL(r *http.Request) string {
+	if r.URL.RawQuery != "" {
+		return v.publicURL + "?" + r.URL.RawQuery
+	}
+	return v.publicURL
+}
+
+// sortedParams concatenates POST parameters as Twilio does: keys sorted,
+// each key immediately followed by its value(s)
+func sortedParams(params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		for _, value := range params[key] {
+			b.WriteString(key)
+			b.WriteString(value)
+		}
+	}
+	return b.String()
+}
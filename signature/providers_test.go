@@ -0,0 +1,259 @@
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+)
+
+// hmacHex returns the hex HMAC-SHA256 of payload with secret
+func hmacHex(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newRequest returns a POST request with body and headers
+func newRequest(target, body string, headers map[string]string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+	return r
+}
+
+func TestStripeVerifier(t *testing.T) {
+	const secret = "whsec_test_secret"
+	const body = `{"id":"evt_1","object":"event","type":"payment_intent.succeeded"}`
+	const timestamp = "1700000000"
+	now := time.Unix(1700000000, 0).Add(time.Minute)
+
+	valid := hmacHex(secret, timestamp+"."+body)
+
+	tests := []struct {
+		name   string
+		header string
+		body   string
+		want   error
+	}{
+		{"valid", "t=" + timestamp + ",v1=" + valid, body, nil},
+		{"valid with v0 and spaces", "t=" + timestamp + ", v0=deadbeef, v1=" + valid, body, nil},
+		{"tampered body", "t=" + timestamp + ",v1=" + valid, strings.Replace(body, "succeeded", "failed", 1), ErrInvalidSignature},
+		{"tampered timestamp", "t=1700000001,v1=" + valid, body, ErrInvalidSignature},
+		{"outside tolerance", "t=1699999000,v1=" + hmacHex(secret, "1699999000."+body), body, ErrTimestampExpired},
+		{"multiple v1, second matches", "t=" + timestamp + ",v1=" + hmacHex("whsec_old", timestamp+"."+body) + ",v1=" + valid, body, nil},
+		{"multiple v1, none matches", "t=" + timestamp + ",v1=" + hmacHex("a", body) + ",v1=" + hmacHex("b", body), body, ErrInvalidSignature},
+		{"no v1", "t=" + timestamp + ",v0=" + valid, body, ErrMissingSignature},
+		{"no timestamp", "v1=" + valid, body, ErrMissingTimestamp},
+		{"missing header", "", body, ErrMissingSignature},
+	}
+
+	verifier, err := NewVerifier(ProviderStripe, &config.SignatureConfig{Secret: secret})
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	verifier.(*StripeVerifier).now = func() time.Time { return now }
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.header != "" {
+				headers["Stripe-Signature"] = tt.header
+			}
+			err := verifier.Verify(newRequest("/stripe", tt.body, headers), []byte(tt.body))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTwilioVerifier(t *testing.T) {
+	// Example from Twilio's webhook security documentation and helper libraries
+	const authToken = "12345"
+	const publicURL = "https://mycompany.com/myapp.php"
+	params := url.Values{
+		"CallSid": {"CA1234567890ABCDE"},
+		"Caller":  {"+14158675309"},
+		"Digits":  {"1234"},
+		"From":    {"+14158675309"},
+		"To":      {"+18005551212"},
+	}
+	const jsonBody = `{"property": "value", "boolean": true}`
+	const bodyHash = "0a1ff7634d9ab3b95db5c9a2dfe9416e41502b283a80c7cf19632632f96e6620"
+
+	form := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+	withSignature := func(headers map[string]string, signature string) map[string]string {
+		merged := map[string]string{"X-Twilio-Signature": signature}
+		for key, value := range headers {
+			merged[key] = value
+		}
+		return merged
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		body    string
+		headers map[string]string
+		want    error
+	}{
+		{"documented example", "/twilio?foo=1&bar=2", params.Encode(), withSignature(form, "RSOYDt4T1cUTdK1PDd93/VVr8B8="), nil},
+		{"tampered parameter", "/twilio?foo=1&bar=2", strings.Replace(params.Encode(), "1234", "4321", 1), withSignature(form, "RSOYDt4T1cUTdK1PDd93/VVr8B8="), ErrInvalidSignature},
+		{"tampered query", "/twilio?foo=2&bar=2", params.Encode(), withSignature(form, "RSOYDt4T1cUTdK1PDd93/VVr8B8="), ErrInvalidSignature},
+		{"json body hash", "/twilio?foo=1&bar=2&bodySHA256=" + bodyHash, jsonBody, withSignature(nil, "a9nBmqA0ju/hNViExpshrM61xv4="), nil},
+		{"json body tampered", "/twilio?foo=1&bar=2&bodySHA256=" + bodyHash, `{"property": "other", "boolean": true}`, withSignature(nil, "a9nBmqA0ju/hNViExpshrM61xv4="), ErrInvalidSignature},
+		{"missing header", "/twilio?foo=1&bar=2", params.Encode(), form, ErrMissingSignature},
+	}
+
+	verifier, err := NewVerifier(ProviderTwilio, &config.SignatureConfig{Secret: authToken, URL: publicURL})
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifier.Verify(newRequest(tt.target, tt.body, tt.headers), []byte(tt.body))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTwilioVerifierRequiresPublicURL(t *testing.T) {
+	if _, err := NewVerifier(ProviderTwilio, &config.SignatureConfig{Secret: "12345"}); err == nil {
+		t.Fatal("NewVerifier without a public URL succeeded")
+	}
+
+	verifier, err := NewVerifier(ProviderTwilio, &config.SignatureConfig{Secret: "12345", URL: "https://mycompany.com/myapp.php"})
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+
+	// A request signed for another host is rejected whatever the client
+	// claims the host to be
+	const body = `{"property": "value", "boolean": true}`
+	target := "/myapp.php?bodySHA256=0a1ff7634d9ab3b95db5c9a2dfe9416e41502b283a80c7cf19632632f96e6620"
+	mac := hmac.New(sha1.New, []byte("12345"))
+	mac.Write([]byte("https://other.example.com" + target))
+	r := newRequest(target, body, map[string]string{
+		"X-Forwarded-Proto":  "https",
+		"X-Forwarded-Host":   "other.example.com",
+		"X-Twilio-Signature": base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+	})
+	r.Host = "other.example.com"
+	if err := verifier.Verify(r, []byte(body)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify = %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestHMACVerifier(t *testing.T) {
+	const secret = "It's a Secret to Everybody"
+	const body = "Hello, World!"
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	rawMAC := mac.Sum(nil)
+	hexMAC := hex.EncodeToString(rawMAC)
+	base64MAC := base64.StdEncoding.EncodeToString(rawMAC)
+
+	tests := []struct {
+		name      string
+		cfg       config.SignatureConfig
+		signature string
+		want      error
+	}{
+		{"hex", config.SignatureConfig{Encoding: "hex"}, hexMAC, nil},
+		{"hex upper case", config.SignatureConfig{Encoding: "hex"}, strings.ToUpper(hexMAC), nil},
+		{"hex wrong", config.SignatureConfig{Encoding: "hex"}, hmacHex("other", body), ErrInvalidSignature},
+		{"hex with prefix", config.SignatureConfig{Encoding: "hex", Prefix: "sha256="}, "sha256=" + hexMAC, nil},
+		{"hex without expected prefix", config.SignatureConfig{Encoding: "hex", Prefix: "sha256="}, hexMAC, ErrInvalidSignature},
+		{"base64", config.SignatureConfig{Encoding: "base64"}, base64MAC, nil},
+		{"base64 wrong", config.SignatureConfig{Encoding: "base64"}, base64.StdEncoding.EncodeToString([]byte("nope")), ErrInvalidSignature},
+		{"base64 with prefix", config.SignatureConfig{Encoding: "base64", Prefix: "v1,"}, "v1," + base64MAC, nil},
+		{"base64 given hex", config.SignatureConfig{Encoding: "base64"}, hexMAC, ErrInvalidSignature},
+		{"missing", config.SignatureConfig{Encoding: "hex"}, "", ErrMissingSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Header = "X-Signature"
+			cfg.Secret = secret
+			verifier, err := NewVerifier("", &cfg)
+			if err != nil {
+				t.Fatalf("NewVerifier: %v", err)
+			}
+
+			headers := map[string]string{}
+			if tt.signature != "" {
+				headers["X-Signature"] = tt.signature
+			}
+			if err := verifier.Verify(newRequest("/hook", body, headers), []byte(body)); !errors.Is(err, tt.want) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitHubVerifier(t *testing.T) {
+	// Example from GitHub's "Validating webhook deliveries" documentation
+	verifier, err := NewVerifier(ProviderGitHub, &config.SignatureConfig{Secret: "It's a Secret to Everybody"})
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+
+	r := newRequest("/github", "Hello, World!", map[string]string{
+		"X-Hub-Signature-256": "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17",
+	})
+	if err := verifier.Verify(r, []byte("Hello, World!")); err != nil {
+		t.Errorf("Verify = %v, want nil", err)
+	}
+	if err := verifier.Verify(r, []byte("Hello, World?")); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify of tampered body = %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestSlackVerifier(t *testing.T) {
+	const secret = "8f742231b10e8888abcd99yyyzzz85a5"
+	const body = "token=xyzz0WbapA4vBCDEbOTZyxIB&team_id=T1DC2JH3J&command=%2Fwebhook-collect&text="
+	headers := map[string]string{
+		"X-Slack-Request-Timestamp": "1531420618",
+		"X-Slack-Signature":         "v0=" + hmacHex(secret, "v0:1531420618:"+body),
+	}
+
+	verifier, err := NewVerifier(ProviderSlack, &config.SignatureConfig{Secret: secret})
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	hmacVerifier := verifier.(*HMACVerifier)
+
+	hmacVerifier.now = func() time.Time { return time.Unix(1531420618, 0).Add(time.Minute) }
+	if err := verifier.Verify(newRequest("/slack", body, headers), []byte(body)); err != nil {
+		t.Errorf("Verify = %v, want nil", err)
+	}
+
+	// The timestamp is part of the signed payload
+	headers["X-Slack-Request-Timestamp"] = "1531420619"
+	if err := verifier.Verify(newRequest("/slack", body, headers), []byte(body)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify with another timestamp = %v, want %v", err, ErrInvalidSignature)
+	}
+
+	headers["X-Slack-Request-Timestamp"] = "1531420618"
+	hmacVerifier.now = func() time.Time { return time.Unix(1531420618, 0).Add(time.Hour) }
+	if err := verifier.Verify(newRequest("/slack", body, headers), []byte(body)); !errors.Is(err, ErrTimestampExpired) {
+		t.Errorf("Verify of a replayed request = %v, want %v", err, ErrTimestampExpired)
+	}
+}
@@ -0,0 +1,175 @@
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+)
+
+var (
+	// ErrMissingSignature is returned when the signature header is absent
+	ErrMissingSignature = errors.New("missing signature")
+	// ErrInvalidSignature is returned when the signature does not match the payload
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrMissingTimestamp is returned when a required timestamp header is absent or malformed
+	ErrMissingTimestamp = errors.New("missing or malformed timestamp")
+	// ErrTimestampExpired is returned when the signed timestamp is outside the tolerance window
+	ErrTimestampExpired = errors.New("timestamp outside tolerance window")
+)
+
+// Verifier verifies the authenticity of an incoming webhook request
+type Verifier interface {
+	Verify(r *http.Request, body []byte) error
+}
+
+// NewVerifier creates a verifier for the given route signature settings.
+// When provider is set the provider preset is used and only the secret
+// (and optionally the tolerance) is taken from cfg.
+func NewVerifier(provider string, cfg *config.SignatureConfig) (Verifier, error) {
+	if provider != "" {
+		return newProviderVerifier(provider, cfg)
+	}
+
+	newHash, err := hashFunc(cfg.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HMACVerifier{
+		header:          cfg.Header,
+		prefix:          cfg.Prefix,
+		encoding:        cfg.Encoding,
+		secret:          []byte(cfg.Secret),
+		newHash:         newHash,
+		timestampHeader: cfg.TimestampHeader,
+		tolerance:       cfg.Tolerance,
+		basestring:      dotBasestring,
+		now:             time.Now,
+	}, nil
+}
+
+// HMACVerifier verifies a generic HMAC signature carried in a request header.
+// When a timestamp header is configured the signed payload is built by
+// basestring (by default "<timestamp>.<body>") and the timestamp must be
+// within the tolerance window.
+type HMACVerifier struct {
+	header          string
+	prefix          string
+	encoding        string
+	secret          []byte
+	newHash         func() hash.Hash
+	timestampHeader string
+	tolerance       time.Duration
+	basestring      func(timestamp string, body []byte) []byte
+	now             func() time.Time
+}
+
+// Verify checks the request signature against the body
+func (v *HMACVerifier) Verify(r *http.Request, body []byte) error {
+	provided := r.Header.Get(v.header)
+	if provided == "" {
+		return ErrMissingSignature
+	}
+	if v.prefix != "" {
+		if !strings.HasPrefix(provided, v.prefix) {
+			return ErrInvalidSignature
+		}
+		provided = strings.TrimPrefix(provided, v.prefix)
+	}
+
+	payload := body
+	if v.timestampHeader != "" {
+		ts := r.Header.Get(v.timestampHeader)
+		if err := checkTimestamp(ts, v.tolerance, v.now()); err != nil {
+			return err
+		}
+		payload = v.basestring(ts, body)
+	}
+
+	expected := computeHMAC(v.newHash, v.secret, payload)
+	if !equalEncoded(provided, expected, v.encoding) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// dotBasestring builds the "<timestamp>.<body>" signed payload
+func dotBasestring(timestamp string, body []byte) []byte {
+	return append([]byte(timestamp+"."), body...)
+}
+
+// hashFunc returns the hash constructor for the configured algorithm
+func hashFunc(algorithm string) (func() hash.Hash, error) {
+	switch strings.ToLower(algorithm) {
+	case "sha1", "hmac-sha1":
+		return sha1.New, nil
+	case "", "sha256", "hmac-sha256":
+		return sha256.New, nil
+	case "sha512", "hmac-sha512":
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported signature algorithm: %s", algorithm)
+	}
+}
+
+// computeHMAC returns the raw HMAC of payload
+func computeHMAC(newHash func() hash.Hash, secret, payload []byte) []byte {
+	mac := hmac.New(newHash, secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// equalEncoded compares an encoded signature with the expected raw MAC in constant time
+func equalEncoded(provided string, expected []byte, encoding string) bool {
+	var decoded []byte
+	var err error
+
+	switch strings.ToLower(encoding) {
+	case "base64":
+		decoded, err = base64.StdEncoding.DecodeString(strings.TrimSpace(provided))
+	default:
+		decoded, err = hex.DecodeString(strings.TrimSpace(provided))
+	}
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(decoded, expected)
+}
+
+// checkTimestamp validates a unix timestamp against the tolerance window
+func checkTimestamp(value string, tolerance time.Duration, now time.Time) error {
+	if value == "" {
+		return ErrMissingTimestamp
+	}
+
+	seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return ErrMissingTimestamp
+	}
+
+	if tolerance > 0 {
+		diff := now.Sub(time.Unix(seconds, 0))
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > tolerance {
+			return ErrTimestampExpired
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/storage"
+)
+
+const statsUsage = `Usage: messagebridge stats -config /path/to/config.yaml [options]
+
+Prints queue counts and per-route delivery activity.
+
+Options:
+  -window  Period delivery activity is reported for (default 24h)
+  -format  table, json or csv (csv lists the per-route rows only)
+`
+
+// statsReport is the data printed by the stats subcommand
+type statsReport struct {
+	Messages    map[string]int       `json:"messages"`
+	DeadLetters int                  `json:"dead_letters"`
+	Routes      []*models.RouteStats `json:"routes"`
+	Window      string               `json:"window"`
+	GeneratedAt time.Time            `json:"generated_at"`
+}
+
+// runStatsCommand implements the "stats" subcommand
+func runStatsCommand(args []string) int {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	configFile := fs.String("config", "", "Path to configuration file (required)")
+	window := fs.Duration("window", 24*time.Hour, "Period delivery activity is reported for")
+	format := fs.String("format", "table", "Output format: table, json or csv")
+
+	if err := fs.Parse(args); err != nil {
+		return ExitFailure
+	}
+
+	if *configFile == "" {
+		fmt.Println("Error: -config flag is required")
+		fmt.Print(statsUsage)
+		return ExitFailure
+	}
+	if *window <= 0 {
+		fmt.Println("Error: -window must be positive")
+		return ExitFailure
+	}
+
+	var output func(*statsReport) error
+	switch *format {
+	case "table":
+		output = printStatsTable
+	case "json":
+		output = printStatsJSON
+	case "csv":
+		output = printStatsCSV
+	default:
+		fmt.Printf("Error: unknown format: %s\n", *format)
+		return ExitFailure
+	}
+
+	cfg, err := config.LoadConfig(*configFile)
+	if err != nil {
+		fmt.Printf("Error: failed to load configuration: %v\n", err)
+		return ExitFailure
+	}
+
+	store, err := openStorage(*configFile)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return ExitFailure
+	}
+	defer store.Close()
+
+	report, err := collectStats(store, cfg, *window)
+	if err == nil {
+		err = output(report)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return ExitFailure
+	}
+
+	return ExitSuccess
+}
+
+// collectStats gathers the report from storage. Configured routes without
+// any stored data are included.
+func collectStats(store storage.Storage, cfg *config.Config, window time.Duration) (*statsReport, error) {
+	messages, err := store.GetMessageStats()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message stats: %w", err)
+	}
+
+	deadLetters, err := store.CountDeadLetters(models.DeadLetterFilter{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count dead letters: %w", err)
+	}
+
+	routes, err := store.GetRouteStats(time.Now().Add(-window))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get route stats: %w", err)
+	}
+
+	known := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		known[route.Path] = true
+	}
+	for _, route := range cfg.Routes {
+		if !known[route.Path] {
+			routes = append(routes, &models.RouteStats{Path: route.Path})
+		}
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Path < routes[j].Path })
+
+	return &statsReport{
+		Messages:    messages,
+		DeadLetters: deadLetters,
+		Routes:      routes,
+		Window:      window.String(),
+		GeneratedAt: time.Now(),
+	}, nil
+}
+
+// statsColumns are the per-route columns of the table and CSV output
+var statsColumns = []string{
+	"route", "pending", "retrying", "cancelled", "dead_letters", "oldest_pending",
+	"delivered", "failed_attempts", "messages", "avg_duration_ms",
+}
+
+// routeRow formats the per-route columns of route
+func routeRow(route *models.RouteStats) []string {
+	oldest := ""
+	if !route.OldestPending.IsZero() {
+		oldest = route.OldestPending.Format(time.RFC3339)
+	}
+
+	return []string{
+		route.Path,
+		strconv.Itoa(route.Pending),
+		strconv.Itoa(route.Retrying),
+		strconv.Itoa(route.Cancelled),
+		strconv.Itoa(route.DeadLetters),
+		oldest,
+		strconv.Itoa(route.Delivered),
+		strconv.Itoa(route.FailedAttempts),
+		strconv.Itoa(route.Messages),
+		strconv.FormatFloat(route.AvgDurationMs, 'f', 1, 64),
+	}
+}
+
+// printStatsTable prints the report for humans
+func printStatsTable(report *statsReport) error {
+	total := 0
+	for _, count := range report.Messages {
+		total += count
+	}
+
+	fmt.Println("Queue")
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "  total\t%d\n", total)
+	for _, status := range []models.MessageStatus{
+		models.StatusPending, models.StatusRetrying, models.StatusSent, models.StatusFailed, models.StatusCancelled,
+	} {
+		fmt.Fprintf(tw, "  %s\t%d\n", status, report.Messages[string(status)])
+	}
+	fmt.Fprintf(tw, "  dead letters\t%d\n", report.DeadLetters)
+	tw.Flush()
+
+	fmt.Printf("\nRoutes (delivery activity in the last %s)\n", report.Window)
+	tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROUTE\tPENDING\tRETRYING\tCANCELLED\tDEAD LETTERS\tOLDEST PENDING\tDELIVERED\tFAILED ATTEMPTS\tMESSAGES\tAVG MS")
+	for _, route := range report.Routes {
+		row := routeRow(route)
+		if row[5] == "" {
+			row[5] = "-"
+		}
+		for i, value := range row {
+			if i > 0 {
+				fmt.Fprint(tw, "\t")
+			}
+			fmt.Fprint(tw, value)
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+// printStatsJSON prints the whole report as JSON
+func printStatsJSON(report *statsReport) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// printStatsCSV prints the per-route rows as CSV
+func printStatsCSV(report *statsReport) error {
+	writer := csv.NewWriter(os.Stdout)
+	if err := writer.Write(statsColumns); err != nil {
+		return err
+	}
+	for _, route := range report.Routes {
+		if err := writer.Write(routeRow(route)); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
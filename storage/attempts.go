@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/expai/messagebridge/models"
+)
+
+// maxAttemptResponse limits how much of a destination response is stored
+const maxAttemptResponse = 4096
+
+// attemptColumns lists the columns read by scanAttempt
+const attemptColumns = `id, message_id, path, target, attempted_at, duration_ms, status_code, kafka_partition, kafka_offset, error, response`
+
+// RecordAttempt stores a delivery attempt in the attempt history
+func (s *SQLiteStorage) RecordAttempt(attempt *models.Attempt) error {
+	targetJSON, err := json.Marshal(attempt.Target)
+	if err != nil {
+		return fmt.Errorf("failed to marshal attempt target: %w", err)
+	}
+
+	response := attempt.Response
+	if len(response) > maxAttemptResponse {
+		response = response[:maxAttemptResponse]
+	}
+
+	var statusCode, partition, offset sql.NullInt64
+	if attempt.StatusCode != 0 {
+		statusCode = sql.NullInt64{Int64: int64(attempt.StatusCode), Valid: true}
+	}
+	if attempt.Partition != nil {
+		partition = sql.NullInt64{Int64: int64(*attempt.Partition), Valid: true}
+	}
+	if attempt.Offset != nil {
+		offset = sql.NullInt64{Int64: *attempt.Offset, Valid: true}
+	}
+
+	query := `
+	INSERT INTO attempts
+	(message_id, path, target_key, target, attempted_at, duration_ms, status_code, kafka_partition, kafka_offset, error, response)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	result, err := s.db.Exec(query,
+		attempt.MessageID, attempt.Path, attempt.Target.Key(), string(targetJSON), attempt.AttemptedAt.UTC(), attempt.DurationMs,
+		statusCode, partition, offset, attempt.Error, response,
+	)
+	if err != nil {
+		return err
+	}
+
+	attempt.ID, err = result.LastInsertId()
+	return err
+}
+
+// GetAttempts returns the attempt history of a message, oldest first
+func (s *SQLiteStorage) GetAttempts(messageID string) ([]*models.Attempt, error) {
+	query := `
+	SELECT ` + attemptColumns + `
+	FROM attempts
+	WHERE message_id = ?
+	ORDER BY id ASC
+	`
+
+	rows, err := s.db.Query(query, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []*models.Attempt
+	for rows.Next() {
+		attempt, err := scanAttempt(rows)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+
+	return attempts, rows.Err()
+}
+
+// scanAttempt scans an attempt row
+func scanAttempt(row rowScanner) (*models.Attempt, error) {
+	attempt := &models.Attempt{}
+
+	var targetJSON string
+	var statusCode, partition, offset sql.NullInt64
+	var path, errMsg, response sql.NullString
+
+	err := row.Scan(&attempt.ID, &attempt.MessageID, &path, &targetJSON, &attempt.AttemptedAt, &attempt.DurationMs,
+		&statusCode, &partition, &offset, &errMsg, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(targetJSON), &attempt.Target); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal attempt target: %w", err)
+	}
+
+	attempt.Path = path.String
+	attempt.StatusCode = int(statusCode.Int64)
+	if partition.Valid {
+		p := int32(partition.Int64)
+		attempt.Partition = &p
+	}
+	if offset.Valid {
+		o := offset.Int64
+		attempt.Offset = &o
+	}
+	attempt.Error = errMsg.String
+	attempt.Response = response.String
+
+	return attempt, nil
+}
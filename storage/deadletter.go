@@ -0,0 +1,334 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/expai/messagebridge/models"
+)
+
+// deadLetterColumns lists the columns read by scanDeadLetter
+const deadLetterColumns = `id, path, queue, body, headers, timestamp, retries, error,
+	       destinations, ordering_key, deliveries, created_at, failed_at, traceparent`
+
+// MoveToDeadLetter moves a message and the state of its deliveries from the
+// queue to the dead-letter table and returns the dead letter
+func (s *SQLiteStorage) MoveToDeadLetter(id string) (*models.DeadLetter, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	msg, err := scanMessage(tx.QueryRow(`SELECT `+messageColumns+` FROM messages WHERE id = ?`, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message: %w", err)
+	}
+
+	deliveries, err := queryDeliveries(tx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load deliveries: %w", err)
+	}
+
+	deadLetter := &models.DeadLetter{
+		WebhookMessage: msg.WebhookMessage,
+		Deliveries:     deliveries,
+		FailedAt:       time.Now().UTC(),
+	}
+
+	headersJSON, err := json.Marshal(msg.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal headers: %w", err)
+	}
+	destinationsJSON, err := json.Marshal(msg.Destinations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal destinations: %w", err)
+	}
+	deliveriesJSON, err := json.Marshal(deliveries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal deliveries: %w", err)
+	}
+
+	query := `
+	INSERT OR REPLACE INTO dead_letters
+	(id, path, queue, body, headers, timestamp, retries, error, destinations, ordering_key, deliveries, created_at, failed_at, traceparent)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err = tx.Exec(query,
+		msg.ID, msg.Path, msg.Queue, msg.Body, string(headersJSON), msg.Timestamp, msg.Retries, msg.Error,
+		string(destinationsJSON), msg.OrderingKey, string(deliveriesJSON), msg.CreatedAt, deadLetter.FailedAt, msg.TraceParent,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save dead letter: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM deliveries WHERE message_id = ?`, id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM messages WHERE id = ?`, id); err != nil {
+		return nil, err
+	}
+
+	return deadLetter, tx.Commit()
+}
+
+// GetFailedMessageIDs returns the IDs of messages that failed permanently but
+// are still in the queue, e.g. failed before the dead-letter table existed
+func (s *SQLiteStorage) GetFailedMessageIDs() ([]string, error) {
+	rows, err := s.db.Query(`SELECT id FROM messages WHERE status = ?`, models.StatusFailed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// ListDeadLetters returns dead letters matching filter, most recent first
+func (s *SQLiteStorage) ListDeadLetters(filter models.DeadLetterFilter, limit, offset int) ([]*models.DeadLetter, error) {
+	where, args := deadLetterWhere(filter)
+
+	query := `SELECT ` + deadLetterColumns + ` FROM dead_letters` + where + ` ORDER BY failed_at DESC LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deadLetters []*models.DeadLetter
+	for rows.Next() {
+		deadLetter, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, err
+		}
+		deadLetters = append(deadLetters, deadLetter)
+	}
+
+	return deadLetters, rows.Err()
+}
+
+// CountDeadLetters returns the number of dead letters matching filter
+func (s *SQLiteStorage) CountDeadLetters(filter models.DeadLetterFilter) (int, error) {
+	where, args := deadLetterWhere(filter)
+
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM dead_letters`+where, args...).Scan(&count)
+	return count, err
+}
+
+// GetDeadLetter returns a single dead letter
+func (s *SQLiteStorage) GetDeadLetter(id string) (*models.DeadLetter, error) {
+	return scanDeadLetter(s.db.QueryRow(`SELECT `+deadLetterColumns+` FROM dead_letters WHERE id = ?`, id))
+}
+
+// ReplayDeadLetters moves dead letters matching filter back to the queue.
+// Only deliveries that failed are attempted again; destinations that already
+// received the message are not sent to twice. It returns the replayed IDs.
+func (s *SQLiteStorage) ReplayDeadLetters(filter models.DeadLetterFilter) ([]string, error) {
+	ids, err := s.deadLetterIDs(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	replayed := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if err := s.replayDeadLetter(id); err != nil {
+			return replayed, fmt.Errorf("failed to replay dead letter %s: %w", id, err)
+		}
+		replayed = append(replayed, id)
+	}
+
+	return replayed, nil
+}
+
+// replayDeadLetter moves a single dead letter back to the queue
+func (s *SQLiteStorage) replayDeadLetter(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	deadLetter, err := scanDeadLetter(tx.QueryRow(`SELECT `+deadLetterColumns+` FROM dead_letters WHERE id = ?`, id))
+	if err != nil {
+		return err
+	}
+
+	headersJSON, err := json.Marshal(deadLetter.Headers)
+	if err != nil {
+		return fmt.Errorf("failed to marshal headers: %w", err)
+	}
+	destinationsJSON, err := json.Marshal(deadLetter.Destinations)
+	if err != nil {
+		return fmt.Errorf("failed to marshal destinations: %w", err)
+	}
+
+	// created_at is reset so the retry policy max_age starts over
+	now := time.Now()
+	query := `
+	INSERT OR REPLACE INTO messages
+	(id, path, queue, body, headers, timestamp, retries, status, error, created_at, updated_at, next_retry_at, destinations, ordering_key, traceparent)
+	VALUES (?, ?, ?, ?, ?, ?, 0, ?, '', ?, ?, NULL, ?, ?, ?)
+	`
+
+	_, err = tx.Exec(query,
+		deadLetter.ID, deadLetter.Path, deadLetter.Queue, deadLetter.Body, string(headersJSON), deadLetter.Timestamp,
+		models.StatusPending, now, now, string(destinationsJSON), deadLetter.OrderingKey, deadLetter.TraceParent,
+	)
+	if err != nil {
+		return err
+	}
+
+	deliveryQuery := `
+	INSERT OR REPLACE INTO deliveries (message_id, target_key, target, status, retries, updated_at)
+	VALUES (?, ?, ?, ?, 0, ?)
+	`
+	for _, delivery := range deadLetter.Deliveries {
+		status := models.StatusPending
+		if delivery.Status == models.StatusSent {
+			status = models.StatusSent
+		}
+
+		targetJSON, err := json.Marshal(delivery.Target)
+		if err != nil {
+			return fmt.Errorf("failed to marshal delivery target: %w", err)
+		}
+
+		if _, err := tx.Exec(deliveryQuery, id, delivery.Target.Key(), string(targetJSON), status, now); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM dead_letters WHERE id = ?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteDeadLetters permanently removes dead letters matching filter and
+// returns the number of removed entries
+func (s *SQLiteStorage) DeleteDeadLetters(filter models.DeadLetterFilter) (int, error) {
+	where, args := deadLetterWhere(filter)
+
+	result, err := s.db.Exec(`DELETE FROM dead_letters`+where, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := result.RowsAffected()
+	return int(count), err
+}
+
+// deadLetterIDs returns the IDs of dead letters matching filter, oldest first
+func (s *SQLiteStorage) deadLetterIDs(filter models.DeadLetterFilter) ([]string, error) {
+	where, args := deadLetterWhere(filter)
+
+	rows, err := s.db.Query(`SELECT id FROM dead_letters`+where+` ORDER BY created_at ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// likeEscaper escapes the LIKE wildcards of a pattern matched literally with
+// ESCAPE '\'
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// deadLetterWhere builds the WHERE clause for a dead-letter filter
+func deadLetterWhere(filter models.DeadLetterFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if len(filter.IDs) > 0 {
+		conditions = append(conditions, "id IN (?"+strings.Repeat(", ?", len(filter.IDs)-1)+")")
+		for _, id := range filter.IDs {
+			args = append(args, id)
+		}
+	}
+	if filter.Path != "" {
+		conditions = append(conditions, "path = ?")
+		args = append(args, filter.Path)
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "failed_at >= ?")
+		args = append(args, filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "failed_at <= ?")
+		args = append(args, filter.To.UTC())
+	}
+	if filter.ErrorPattern != "" {
+		conditions = append(conditions, "error LIKE '%' || ? || '%' ESCAPE '\\'")
+		args = append(args, likeEscaper.Replace(filter.ErrorPattern))
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// scanDeadLetter scans a row selected with deadLetterColumns
+func scanDeadLetter(row rowScanner) (*models.DeadLetter, error) {
+	deadLetter := &models.DeadLetter{
+		WebhookMessage: &models.WebhookMessage{Status: models.StatusFailed},
+	}
+
+	var headersJSON, deliveriesJSON string
+	var errMsg, destinationsJSON, orderingKey, traceParent sql.NullString
+
+	err := row.Scan(
+		&deadLetter.ID, &deadLetter.Path, &deadLetter.Queue, &deadLetter.Body, &headersJSON,
+		&deadLetter.Timestamp, &deadLetter.Retries, &errMsg, &destinationsJSON, &orderingKey,
+		&deliveriesJSON, &deadLetter.CreatedAt, &deadLetter.FailedAt, &traceParent,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(headersJSON), &deadLetter.Headers); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal headers: %w", err)
+	}
+	if destinationsJSON.Valid && destinationsJSON.String != "" {
+		if err := json.Unmarshal([]byte(destinationsJSON.String), &deadLetter.Destinations); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal destinations: %w", err)
+		}
+	}
+	if err := json.Unmarshal([]byte(deliveriesJSON), &deadLetter.Deliveries); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal deliveries: %w", err)
+	}
+
+	deadLetter.Error = errMsg.String
+	deadLetter.OrderingKey = orderingKey.String
+	deadLetter.TraceParent = traceParent.String
+	deadLetter.UpdatedAt = deadLetter.FailedAt
+
+	return deadLetter, nil
+}
@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/expai/messagebridge/metrics"
+	"github.com/expai/messagebridge/models"
+)
+
+// insertDeliveries creates a pending delivery for each target. Existing
+// deliveries keep their state so a message can be saved again safely.
+func insertDeliveries(tx *sql.Tx, messageID string, targets []models.DeliveryTarget) error {
+	query := `
+	INSERT OR IGNORE INTO deliveries (message_id, target_key, target, status, retries, updated_at)
+	VALUES (?, ?, ?, ?, 0, ?)
+	`
+
+	for _, target := range targets {
+		targetJSON, err := json.Marshal(target)
+		if err != nil {
+			return fmt.Errorf("failed to marshal delivery target: %w", err)
+		}
+
+		if _, err := tx.Exec(query, messageID, target.Key(), string(targetJSON), models.StatusPending, time.Now()); err != nil {
+			return fmt.Errorf("failed to save delivery: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// AddDeliveries creates pending deliveries for a message that has none yet,
+// e.g. messages stored before per-destination tracking existed
+func (s *SQLiteStorage) AddDeliveries(messageID string, targets []models.DeliveryTarget) ([]*models.Delivery, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := insertDeliveries(tx, messageID, targets); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetDeliveries(messageID)
+}
+
+// GetDeliveries returns all deliveries of a message
+func (s *SQLiteStorage) GetDeliveries(messageID string) ([]*models.Delivery, error) {
+	return queryDeliveries(s.db, messageID)
+}
+
+// queryDeliveries returns all deliveries of a message
+func queryDeliveries(q querier, messageID string) ([]*models.Delivery, error) {
+	query := `
+	SELECT message_id, target, status, retries, error, next_retry_at, updated_at
+	FROM deliveries
+	WHERE message_id = ?
+	ORDER BY rowid ASC
+	`
+
+	rows, err := q.Query(query, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*models.Delivery
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// loadPendingDeliveries attaches the undelivered destinations to each message
+func (s *SQLiteStorage) loadPendingDeliveries(messages []*models.PendingMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	byID := make(map[string]*models.PendingMessage, len(messages))
+	args := make([]interface{}, 0, len(messages)+2)
+	args = append(args, models.StatusPending, models.StatusRetrying)
+	for _, msg := range messages {
+		byID[msg.ID] = msg
+		args = append(args, msg.ID)
+	}
+
+	query := `
+	SELECT message_id, target, status, retries, error, next_retry_at, updated_at
+	FROM deliveries
+	WHERE status IN (?, ?) AND message_id IN (?` + strings.Repeat(", ?", len(messages)-1) + `)
+	ORDER BY rowid ASC
+	`
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return err
+		}
+		if msg, ok := byID[delivery.MessageID]; ok {
+			msg.Deliveries = append(msg.Deliveries, delivery)
+		}
+	}
+
+	return rows.Err()
+}
+
+// UpdateDeliveryStatus records the outcome of a delivery attempt and
+// returns the resulting overall status of the message. nextRetryAt is only
+// stored for retrying deliveries.
+func (s *SQLiteStorage) UpdateDeliveryStatus(messageID string, target models.DeliveryTarget, status models.MessageStatus, errMsg string, nextRetryAt time.Time) (models.MessageStatus, error) {
+	defer metrics.ObserveStorageWrite("update_delivery", time.Now())
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE deliveries
+	SET status = ?, error = ?, updated_at = ?, retries = retries + 1, next_retry_at = ?
+	WHERE message_id = ? AND target_key = ? AND status != ?
+	`
+
+	// Deliveries cancelled while the attempt was in flight stay cancelled
+	if _, err := tx.Exec(query, status, errMsg, time.Now(), retryTime(status, nextRetryAt), messageID, target.Key(), models.StatusCancelled); err != nil {
+		return "", err
+	}
+
+	messageStatus, err := refreshMessage(tx, messageID, errMsg)
+	if err != nil {
+		return "", err
+	}
+
+	return messageStatus, tx.Commit()
+}
+
+// refreshMessage derives the message status, retry count and next retry
+// time from its deliveries and returns the new message status
+func refreshMessage(tx *sql.Tx, messageID, errMsg string) (models.MessageStatus, error) {
+	query := `
+	UPDATE messages
+	SET status = CASE
+			WHEN EXISTS (SELECT 1 FROM deliveries WHERE message_id = ?1 AND status = 'retrying') THEN 'retrying'
+			WHEN EXISTS (SELECT 1 FROM deliveries WHERE message_id = ?1 AND status = 'pending') THEN 'pending'
+			WHEN EXISTS (SELECT 1 FROM deliveries WHERE message_id = ?1 AND status = 'failed') THEN 'failed'
+			WHEN EXISTS (SELECT 1 FROM deliveries WHERE message_id = ?1 AND status = 'cancelled') THEN 'cancelled'
+			ELSE 'sent'
+		END,
+		retries = (SELECT COALESCE(MAX(retries), 0) FROM deliveries WHERE message_id = ?1),
+		next_retry_at = CASE
+			WHEN EXISTS (SELECT 1 FROM deliveries WHERE message_id = ?1 AND status IN ('pending', 'retrying') AND next_retry_at IS NULL) THEN NULL
+			ELSE (SELECT MIN(next_retry_at) FROM deliveries WHERE message_id = ?1 AND status IN ('pending', 'retrying'))
+		END,
+		error = CASE WHEN ?2 != '' THEN ?2 ELSE error END,
+		updated_at = ?3
+	WHERE id = ?1
+	`
+
+	if _, err := tx.Exec(query, messageID, errMsg, time.Now()); err != nil {
+		return "", err
+	}
+
+	var status models.MessageStatus
+	if err := tx.QueryRow(`SELECT status FROM messages WHERE id = ?`, messageID).Scan(&status); err != nil {
+		return "", err
+	}
+
+	return status, nil
+}
+
+// querier is implemented by *sql.DB and *sql.Tx
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanDelivery scans a delivery row
+func scanDelivery(row rowScanner) (*models.Delivery, error) {
+	delivery := &models.Delivery{}
+
+	var targetJSON string
+	var errMsg sql.NullString
+	var nextRetryAt sql.NullTime
+
+	if err := row.Scan(&delivery.MessageID, &targetJSON, &delivery.Status, &delivery.Retries,
+		&errMsg, &nextRetryAt, &delivery.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(targetJSON), &delivery.Target); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal delivery target: %w", err)
+	}
+
+	delivery.Error = errMsg.String
+	if nextRetryAt.Valid {
+		delivery.NextRetryAt = nextRetryAt.Time
+	}
+
+	return delivery, nil
+}
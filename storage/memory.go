@@ -0,0 +1,910 @@
+package storage
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/retry"
+)
+
+// MemoryStorage keeps the queue in memory. Nothing survives a restart, so it
+// is meant for tests and for trying out routes.
+type MemoryStorage struct {
+	mu          sync.Mutex
+	messages    map[string]*memoryMessage
+	deadLetters map[string]*models.DeadLetter
+	attempts    []*models.Attempt
+	lastAttempt int64
+	// seq orders messages received within the same clock tick
+	seq           int64
+	retryPolicies *retry.Policies
+}
+
+// memoryMessage is a queued message with its deliveries in the order they
+// were created
+type memoryMessage struct {
+	msg         *models.WebhookMessage
+	nextRetryAt time.Time
+	deliveries  []*models.Delivery
+	seq         int64
+}
+
+// NewMemoryStorage creates a new empty in-memory storage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		messages:      make(map[string]*memoryMessage),
+		deadLetters:   make(map[string]*models.DeadLetter),
+		retryPolicies: retry.DefaultPolicies(),
+	}
+}
+
+// SetRetryPolicies sets the policies used to schedule retries of saved messages
+func (s *MemoryStorage) SetRetryPolicies(policies *retry.Policies) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retryPolicies = policies
+}
+
+// SaveMessage saves a message to storage. Existing deliveries keep their
+// state so a message can be saved again safely.
+func (s *MemoryStorage) SaveMessage(msg *models.WebhookMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var nextRetryAt time.Time
+	if msg.Status == models.StatusRetrying {
+		nextRetryAt = s.retryPolicies.For(msg.Path).NextRetryAt(msg.Retries, time.Now())
+	}
+
+	stored := s.messages[msg.ID]
+	if stored == nil {
+		stored = &memoryMessage{seq: s.nextSeq()}
+		s.messages[msg.ID] = stored
+	}
+	stored.msg = copyWebhookMessage(msg)
+	stored.nextRetryAt = nextRetryAt
+	stored.addDeliveries(msg.Destinations)
+
+	return nil
+}
+
+// GetPendingMessages retrieves messages that need retry. Messages waiting
+// behind an older undelivered message with the same ordering key are held
+// back until that message is delivered or fails permanently.
+func (s *MemoryStorage) GetPendingMessages(limit int) ([]*models.PendingMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	undelivered := func(m *memoryMessage) bool {
+		return m.msg.Status == models.StatusPending || m.msg.Status == models.StatusRetrying
+	}
+
+	// Ordering keys with an undelivered message scheduled for later, by
+	// the time the earliest of those messages was created
+	blocked := make(map[string]time.Time)
+	for _, m := range s.messages {
+		if !undelivered(m) || m.msg.OrderingKey == "" || !m.nextRetryAt.After(now) {
+			continue
+		}
+		if created, ok := blocked[m.msg.OrderingKey]; !ok || m.msg.CreatedAt.Before(created) {
+			blocked[m.msg.OrderingKey] = m.msg.CreatedAt
+		}
+	}
+
+	var due []*memoryMessage
+	for _, m := range s.messages {
+		if !undelivered(m) || m.nextRetryAt.After(now) {
+			continue
+		}
+		if created, ok := blocked[m.msg.OrderingKey]; ok && created.Before(m.msg.CreatedAt) {
+			continue
+		}
+		due = append(due, m)
+	}
+	sortMessages(due, false)
+	if limit >= 0 && len(due) > limit {
+		due = due[:limit]
+	}
+
+	messages := make([]*models.PendingMessage, 0, len(due))
+	for _, m := range due {
+		msg := m.pendingMessage()
+		for _, delivery := range m.deliveries {
+			if delivery.Status == models.StatusPending || delivery.Status == models.StatusRetrying {
+				msg.Deliveries = append(msg.Deliveries, copyDelivery(delivery))
+			}
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, nil
+}
+
+// ReleaseMessage does nothing: claimed messages are only leased when the
+// storage is shared by several instances
+func (s *MemoryStorage) ReleaseMessage(id string) error {
+	return nil
+}
+
+// AddDeliveries creates pending deliveries for a message that has none yet
+func (s *MemoryStorage) AddDeliveries(messageID string, targets []models.DeliveryTarget) ([]*models.Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.messages[messageID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	stored.addDeliveries(targets)
+
+	return copyDeliveries(stored.deliveries), nil
+}
+
+// GetDeliveries returns all deliveries of a message
+func (s *MemoryStorage) GetDeliveries(messageID string) ([]*models.Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.messages[messageID]
+	if !ok {
+		return nil, nil
+	}
+	return copyDeliveries(stored.deliveries), nil
+}
+
+// UpdateDeliveryStatus records the outcome of a delivery attempt and
+// returns the resulting overall status of the message. nextRetryAt is only
+// stored for retrying deliveries.
+func (s *MemoryStorage) UpdateDeliveryStatus(messageID string, target models.DeliveryTarget, status models.MessageStatus, errMsg string, nextRetryAt time.Time) (models.MessageStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.messages[messageID]
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	// Deliveries cancelled while the attempt was in flight stay cancelled
+	if delivery := stored.delivery(target.Key()); delivery != nil && delivery.Status != models.StatusCancelled {
+		delivery.Status = status
+		delivery.Error = errMsg
+		delivery.Retries++
+		delivery.NextRetryAt = memoryRetryTime(status, nextRetryAt)
+		delivery.UpdatedAt = time.Now()
+	}
+
+	return stored.refresh(errMsg), nil
+}
+
+// DeleteMessage removes a message and its deliveries from storage
+func (s *MemoryStorage) DeleteMessage(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.messages, id)
+	return nil
+}
+
+// ListMessages returns queued messages matching filter, most recent first
+func (s *MemoryStorage) ListMessages(filter models.MessageFilter, limit, offset int) ([]*models.PendingMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matched := s.matchMessages(filter)
+	sortMessages(matched, true)
+
+	var messages []*models.PendingMessage
+	for _, m := range page(matched, limit, offset) {
+		messages = append(messages, m.pendingMessage())
+	}
+
+	return messages, nil
+}
+
+// ListMessagesAfter returns up to limit messages matching filter after the
+// message created at createdAt with ID id, oldest first
+func (s *MemoryStorage) ListMessagesAfter(filter models.MessageFilter, createdAt time.Time, id string, limit int) ([]*models.PendingMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var matched []*memoryMessage
+	for _, m := range s.matchMessages(filter) {
+		if m.msg.CreatedAt.After(createdAt) || (m.msg.CreatedAt.Equal(createdAt) && m.msg.ID > id) {
+			matched = append(matched, m)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i].msg, matched[j].msg
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+
+	var messages []*models.PendingMessage
+	for _, m := range page(matched, limit, 0) {
+		messages = append(messages, m.pendingMessage())
+	}
+
+	return messages, nil
+}
+
+// CountMessages returns the number of queued messages matching filter
+func (s *MemoryStorage) CountMessages(filter models.MessageFilter) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.matchMessages(filter)), nil
+}
+
+// GetMessage returns a single queued message with all of its deliveries
+func (s *MemoryStorage) GetMessage(id string) (*models.PendingMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.messages[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	msg := stored.pendingMessage()
+	msg.Deliveries = copyDeliveries(stored.deliveries)
+	return msg, nil
+}
+
+// RetryMessage makes the undelivered destinations of a message due
+// immediately, including cancelled ones
+func (s *MemoryStorage) RetryMessage(id string) (models.MessageStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.changeDeliveries(id, func(m *memoryMessage, delivery *models.Delivery) bool {
+		switch delivery.Status {
+		case models.StatusCancelled:
+			delivery.Status = models.StatusPending
+		case models.StatusPending, models.StatusRetrying:
+		default:
+			return false
+		}
+		delivery.NextRetryAt = time.Time{}
+		delivery.UpdatedAt = time.Now()
+		return true
+	})
+}
+
+// CancelMessage stops delivery of a message to the destinations that have
+// not received it yet
+func (s *MemoryStorage) CancelMessage(id string) (models.MessageStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.changeDeliveries(id, func(m *memoryMessage, delivery *models.Delivery) bool {
+		if delivery.Status != models.StatusPending && delivery.Status != models.StatusRetrying {
+			return false
+		}
+		delivery.Status = models.StatusCancelled
+		delivery.NextRetryAt = time.Time{}
+		delivery.UpdatedAt = time.Now()
+		return true
+	})
+}
+
+// RequeueMessages restarts delivery of queued messages matching filter as if
+// they had just been received and returns the requeued IDs
+func (s *MemoryStorage) RequeueMessages(filter models.MessageFilter) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matched := s.matchMessages(filter)
+	sortMessages(matched, false)
+
+	requeued := make([]string, 0, len(matched))
+	for _, m := range matched {
+		now := time.Now()
+		_, err := s.changeDeliveries(m.msg.ID, func(m *memoryMessage, delivery *models.Delivery) bool {
+			if delivery.Status == models.StatusSent {
+				return false
+			}
+			delivery.Status = models.StatusPending
+			delivery.Retries = 0
+			delivery.Error = ""
+			delivery.NextRetryAt = time.Time{}
+			delivery.UpdatedAt = now
+			return true
+		})
+		if err != nil {
+			continue
+		}
+
+		m.msg.CreatedAt = now
+		m.msg.Error = ""
+		requeued = append(requeued, m.msg.ID)
+	}
+
+	return requeued, nil
+}
+
+// ImportMessage stores a message exported from another instance together
+// with the state of its deliveries. It returns false without changing
+// anything if a message with the same ID is already stored.
+func (s *MemoryStorage) ImportMessage(msg *models.PendingMessage) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.messages[msg.ID]; ok {
+		return false, nil
+	}
+
+	stored := &memoryMessage{
+		msg: copyWebhookMessage(msg.WebhookMessage),
+		seq: s.nextSeq(),
+	}
+	stored.msg.Status = models.StatusPending
+
+	if len(msg.Deliveries) == 0 {
+		stored.addDeliveries(msg.Destinations)
+	}
+	for _, delivery := range msg.Deliveries {
+		if stored.delivery(delivery.Target.Key()) != nil {
+			continue
+		}
+		imported := copyDelivery(delivery)
+		imported.MessageID = msg.ID
+		imported.NextRetryAt = memoryRetryTime(imported.Status, imported.NextRetryAt)
+		stored.deliveries = append(stored.deliveries, imported)
+	}
+
+	s.messages[msg.ID] = stored
+	stored.refresh("")
+
+	return true, nil
+}
+
+// MoveToDeadLetter moves a message and the state of its deliveries from the
+// queue to the dead-letter queue and returns the dead letter
+func (s *MemoryStorage) MoveToDeadLetter(id string) (*models.DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.messages[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	deadLetter := &models.DeadLetter{
+		WebhookMessage: copyWebhookMessage(stored.msg),
+		Deliveries:     copyDeliveries(stored.deliveries),
+		FailedAt:       time.Now().UTC(),
+	}
+
+	kept := copyDeadLetter(deadLetter)
+	kept.Status = models.StatusFailed
+	kept.UpdatedAt = kept.FailedAt
+	s.deadLetters[id] = kept
+	delete(s.messages, id)
+
+	return deadLetter, nil
+}
+
+// GetFailedMessageIDs returns the IDs of messages that failed permanently but
+// are still in the queue
+func (s *MemoryStorage) GetFailedMessageIDs() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []string
+	for id, m := range s.messages {
+		if m.msg.Status == models.StatusFailed {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	return ids, nil
+}
+
+// ListDeadLetters returns dead letters matching filter, most recent first
+func (s *MemoryStorage) ListDeadLetters(filter models.DeadLetterFilter, limit, offset int) ([]*models.DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matched := s.matchDeadLetters(filter)
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].FailedAt.After(matched[j].FailedAt) })
+
+	var deadLetters []*models.DeadLetter
+	for _, deadLetter := range page(matched, limit, offset) {
+		deadLetters = append(deadLetters, copyDeadLetter(deadLetter))
+	}
+
+	return deadLetters, nil
+}
+
+// CountDeadLetters returns the number of dead letters matching filter
+func (s *MemoryStorage) CountDeadLetters(filter models.DeadLetterFilter) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.matchDeadLetters(filter)), nil
+}
+
+// GetDeadLetter returns a single dead letter
+func (s *MemoryStorage) GetDeadLetter(id string) (*models.DeadLetter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deadLetter, ok := s.deadLetters[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyDeadLetter(deadLetter), nil
+}
+
+// ReplayDeadLetters moves dead letters matching filter back to the queue.
+// Only deliveries that failed are attempted again. It returns the replayed IDs.
+func (s *MemoryStorage) ReplayDeadLetters(filter models.DeadLetterFilter) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matched := s.matchDeadLetters(filter)
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].CreatedAt.Before(matched[j].CreatedAt) })
+
+	replayed := make([]string, 0, len(matched))
+	for _, deadLetter := range matched {
+		// created_at is reset so the retry policy max_age starts over
+		now := time.Now()
+		msg := copyWebhookMessage(deadLetter.WebhookMessage)
+		msg.Retries = 0
+		msg.Status = models.StatusPending
+		msg.Error = ""
+		msg.CreatedAt = now
+		msg.UpdatedAt = now
+
+		stored := &memoryMessage{msg: msg, seq: s.nextSeq()}
+		for _, delivery := range deadLetter.Deliveries {
+			status := models.StatusPending
+			if delivery.Status == models.StatusSent {
+				status = models.StatusSent
+			}
+			if existing := stored.delivery(delivery.Target.Key()); existing != nil {
+				existing.Status = status
+				continue
+			}
+			stored.deliveries = append(stored.deliveries, &models.Delivery{
+				MessageID: msg.ID,
+				Target:    delivery.Target,
+				Status:    status,
+				UpdatedAt: now,
+			})
+		}
+
+		s.messages[msg.ID] = stored
+		delete(s.deadLetters, deadLetter.ID)
+		replayed = append(replayed, deadLetter.ID)
+	}
+
+	return replayed, nil
+}
+
+// DeleteDeadLetters permanently removes dead letters matching filter and
+// returns the number of removed entries
+func (s *MemoryStorage) DeleteDeadLetters(filter models.DeadLetterFilter) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matched := s.matchDeadLetters(filter)
+	for _, deadLetter := range matched {
+		delete(s.deadLetters, deadLetter.ID)
+	}
+
+	return len(matched), nil
+}
+
+// RecordAttempt stores a delivery attempt in the attempt history
+func (s *MemoryStorage) RecordAttempt(attempt *models.Attempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastAttempt++
+	attempt.ID = s.lastAttempt
+
+	stored := *attempt
+	stored.AttemptedAt = attempt.AttemptedAt.UTC()
+	if len(stored.Response) > maxAttemptResponse {
+		stored.Response = stored.Response[:maxAttemptResponse]
+	}
+	s.attempts = append(s.attempts, &stored)
+
+	return nil
+}
+
+// GetAttempts returns the attempt history of a message, oldest first
+func (s *MemoryStorage) GetAttempts(messageID string) ([]*models.Attempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var attempts []*models.Attempt
+	for _, attempt := range s.attempts {
+		if attempt.MessageID == messageID {
+			copied := *attempt
+			attempts = append(attempts, &copied)
+		}
+	}
+
+	return attempts, nil
+}
+
+// GetMessageStats returns statistics about stored messages
+func (s *MemoryStorage) GetMessageStats() (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make(map[string]int)
+	for _, m := range s.messages {
+		stats[string(m.msg.Status)]++
+	}
+
+	return stats, nil
+}
+
+// GetRouteStats returns per-route queue counts together with delivery
+// activity recorded in the attempt history since the given time
+func (s *MemoryStorage) GetRouteStats(since time.Time) ([]*models.RouteStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	routes := make(map[string]*models.RouteStats)
+	route := func(path string) *models.RouteStats {
+		if routes[path] == nil {
+			routes[path] = &models.RouteStats{Path: path}
+		}
+		return routes[path]
+	}
+
+	for _, m := range s.messages {
+		switch m.msg.Status {
+		case models.StatusPending:
+			route(m.msg.Path).Pending++
+		case models.StatusRetrying:
+			route(m.msg.Path).Retrying++
+		case models.StatusCancelled:
+			route(m.msg.Path).Cancelled++
+			continue
+		default:
+			continue
+		}
+
+		stats := route(m.msg.Path)
+		received := m.msg.Timestamp.UTC()
+		if stats.OldestPending.IsZero() || received.Before(stats.OldestPending) {
+			stats.OldestPending = received
+		}
+	}
+
+	for _, deadLetter := range s.deadLetters {
+		route(deadLetter.Path).DeadLetters++
+	}
+
+	type activity struct {
+		messages map[string]bool
+		duration int64
+		attempts int
+	}
+	activities := make(map[string]*activity)
+	for _, attempt := range s.attempts {
+		if attempt.Path == "" || attempt.AttemptedAt.Before(since) {
+			continue
+		}
+
+		stats := route(attempt.Path)
+		if attempt.Error == "" {
+			stats.Delivered++
+		} else {
+			stats.FailedAttempts++
+		}
+
+		a := activities[attempt.Path]
+		if a == nil {
+			a = &activity{messages: make(map[string]bool)}
+			activities[attempt.Path] = a
+		}
+		a.messages[attempt.MessageID] = true
+		a.duration += attempt.DurationMs
+		a.attempts++
+	}
+	for path, a := range activities {
+		stats := route(path)
+		stats.Messages = len(a.messages)
+		stats.AvgDurationMs = math.Round(float64(a.duration)/float64(a.attempts)*10) / 10
+	}
+
+	result := make([]*models.RouteStats, 0, len(routes))
+	for _, stats := range routes {
+		result = append(result, stats)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+
+	return result, nil
+}
+
+// Cleanup removes old messages based on retention policy
+func (s *MemoryStorage) Cleanup(retentionDays int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+
+	for id, m := range s.messages {
+		if (m.msg.Status == models.StatusSent || m.msg.Status == models.StatusCancelled) && m.msg.CreatedAt.Before(cutoff) {
+			delete(s.messages, id)
+		}
+	}
+
+	// Attempt history outlives delivered messages for the retention period,
+	// and is kept as long as the message is queued or dead-lettered
+	kept := s.attempts[:0]
+	for _, attempt := range s.attempts {
+		_, queued := s.messages[attempt.MessageID]
+		_, deadLettered := s.deadLetters[attempt.MessageID]
+		if queued || deadLettered || !attempt.AttemptedAt.Before(cutoff) {
+			kept = append(kept, attempt)
+		}
+	}
+	s.attempts = kept
+
+	return nil
+}
+
+// Close releases the stored messages
+func (s *MemoryStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = make(map[string]*memoryMessage)
+	s.deadLetters = make(map[string]*models.DeadLetter)
+	s.attempts = nil
+	return nil
+}
+
+// nextSeq returns the next message sequence number
+func (s *MemoryStorage) nextSeq() int64 {
+	s.seq++
+	return s.seq
+}
+
+// changeDeliveries applies change to each delivery of a message and derives
+// the message state from the result. It returns ErrNotFound for unknown
+// messages and ErrNothingChanged when no delivery was changed. Deliveries
+// are only modified when at least one of them changes.
+func (s *MemoryStorage) changeDeliveries(id string, change func(m *memoryMessage, delivery *models.Delivery) bool) (models.MessageStatus, error) {
+	stored, ok := s.messages[id]
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	deliveries := copyDeliveries(stored.deliveries)
+	changed := false
+	for _, delivery := range deliveries {
+		if change(stored, delivery) {
+			changed = true
+		}
+	}
+	if !changed {
+		return stored.msg.Status, ErrNothingChanged
+	}
+
+	stored.deliveries = deliveries
+	return stored.refresh(""), nil
+}
+
+// matchMessages returns the queued messages matching filter
+func (s *MemoryStorage) matchMessages(filter models.MessageFilter) []*memoryMessage {
+	var matched []*memoryMessage
+	for _, m := range s.messages {
+		msg := m.msg
+		switch {
+		case len(filter.IDs) > 0 && !containsString(filter.IDs, msg.ID):
+		case filter.Path != "" && msg.Path != filter.Path:
+		case filter.Queue != "" && msg.Queue != filter.Queue:
+		case filter.Status != "" && msg.Status != filter.Status:
+		case !filter.From.IsZero() && msg.Timestamp.Before(filter.From):
+		case !filter.To.IsZero() && msg.Timestamp.After(filter.To):
+		default:
+			matched = append(matched, m)
+		}
+	}
+	return matched
+}
+
+// matchDeadLetters returns the dead letters matching filter. Errors are
+// matched like SQL LIKE, ignoring the case of ASCII letters only.
+func (s *MemoryStorage) matchDeadLetters(filter models.DeadLetterFilter) []*models.DeadLetter {
+	var matched []*models.DeadLetter
+	for _, deadLetter := range s.deadLetters {
+		switch {
+		case len(filter.IDs) > 0 && !containsString(filter.IDs, deadLetter.ID):
+		case filter.Path != "" && deadLetter.Path != filter.Path:
+		case !filter.From.IsZero() && deadLetter.FailedAt.Before(filter.From):
+		case !filter.To.IsZero() && deadLetter.FailedAt.After(filter.To):
+		case filter.ErrorPattern != "" && !strings.Contains(asciiLower(deadLetter.Error), asciiLower(filter.ErrorPattern)):
+		default:
+			matched = append(matched, deadLetter)
+		}
+	}
+	return matched
+}
+
+// addDeliveries creates a pending delivery for each target that has none
+func (m *memoryMessage) addDeliveries(targets []models.DeliveryTarget) {
+	for _, target := range targets {
+		if m.delivery(target.Key()) != nil {
+			continue
+		}
+		m.deliveries = append(m.deliveries, &models.Delivery{
+			MessageID: m.msg.ID,
+			Target:    target,
+			Status:    models.StatusPending,
+			UpdatedAt: time.Now(),
+		})
+	}
+}
+
+// delivery returns the delivery to the target with the given key
+func (m *memoryMessage) delivery(key string) *models.Delivery {
+	for _, delivery := range m.deliveries {
+		if delivery.Target.Key() == key {
+			return delivery
+		}
+	}
+	return nil
+}
+
+// refresh derives the message status, retry count and next retry time from
+// its deliveries and returns the new message status
+func (m *memoryMessage) refresh(errMsg string) models.MessageStatus {
+	counts := make(map[models.MessageStatus]int)
+	retries := 0
+	var nextRetryAt time.Time
+	dueNow := false
+
+	for _, delivery := range m.deliveries {
+		counts[delivery.Status]++
+		if delivery.Retries > retries {
+			retries = delivery.Retries
+		}
+		if delivery.Status != models.StatusPending && delivery.Status != models.StatusRetrying {
+			continue
+		}
+		if delivery.NextRetryAt.IsZero() {
+			dueNow = true
+		} else if nextRetryAt.IsZero() || delivery.NextRetryAt.Before(nextRetryAt) {
+			nextRetryAt = delivery.NextRetryAt
+		}
+	}
+
+	status := models.StatusSent
+	for _, candidate := range []models.MessageStatus{models.StatusRetrying, models.StatusPending, models.StatusFailed, models.StatusCancelled} {
+		if counts[candidate] > 0 {
+			status = candidate
+			break
+		}
+	}
+
+	m.msg.Status = status
+	m.msg.Retries = retries
+	m.nextRetryAt = nextRetryAt
+	if dueNow {
+		m.nextRetryAt = time.Time{}
+	}
+	if errMsg != "" {
+		m.msg.Error = errMsg
+	}
+	m.msg.UpdatedAt = time.Now()
+
+	return status
+}
+
+// pendingMessage returns a copy of the message without deliveries
+func (m *memoryMessage) pendingMessage() *models.PendingMessage {
+	return &models.PendingMessage{
+		WebhookMessage: copyWebhookMessage(m.msg),
+		NextRetryAt:    m.nextRetryAt,
+	}
+}
+
+// sortMessages sorts messages by the time they were created, then by the
+// order they were stored in
+func sortMessages(messages []*memoryMessage, descending bool) {
+	sort.Slice(messages, func(i, j int) bool {
+		a, b := messages[i], messages[j]
+		if descending {
+			a, b = b, a
+		}
+		if !a.msg.CreatedAt.Equal(b.msg.CreatedAt) {
+			return a.msg.CreatedAt.Before(b.msg.CreatedAt)
+		}
+		return a.seq < b.seq
+	})
+}
+
+// page returns the items selected by limit and offset
+func page[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return nil
+	}
+	items = items[offset:]
+	if limit >= 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items
+}
+
+// memoryRetryTime returns the next retry time kept for a delivery
+func memoryRetryTime(status models.MessageStatus, nextRetryAt time.Time) time.Time {
+	if status != models.StatusRetrying || nextRetryAt.IsZero() {
+		return time.Time{}
+	}
+	return nextRetryAt.UTC()
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// asciiLower returns s with ASCII letters in lower case, like SQLite LOWER
+func asciiLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, s)
+}
+
+// copyWebhookMessage returns a copy of msg that shares no state with it
+func copyWebhookMessage(msg *models.WebhookMessage) *models.WebhookMessage {
+	copied := *msg
+	copied.Body = append([]byte(nil), msg.Body...)
+	copied.Destinations = append([]models.DeliveryTarget(nil), msg.Destinations...)
+	if msg.Headers != nil {
+		copied.Headers = make(map[string]string, len(msg.Headers))
+		for key, value := range msg.Headers {
+			copied.Headers[key] = value
+		}
+	}
+	return &copied
+}
+
+// copyDelivery returns a copy of a delivery
+func copyDelivery(delivery *models.Delivery) *models.Delivery {
+	copied := *delivery
+	return &copied
+}
+
+// copyDeliveries returns copies of deliveries
+func copyDeliveries(deliveries []*models.Delivery) []*models.Delivery {
+	var copied []*models.Delivery
+	for _, delivery := range deliveries {
+		copied = append(copied, copyDelivery(delivery))
+	}
+	return copied
+}
+
+// copyDeadLetter returns a copy of a dead letter
+func copyDeadLetter(deadLetter *models.DeadLetter) *models.DeadLetter {
+	return &models.DeadLetter{
+		WebhookMessage: copyWebhookMessage(deadLetter.WebhookMessage),
+		Deliveries:     copyDeliveries(deadLetter.Deliveries),
+		FailedAt:       deadLetter.FailedAt,
+	}
+}
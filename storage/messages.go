@@ -0,0 +1,319 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/expai/messagebridge/models"
+)
+
+// ErrNothingChanged is returned when a message exists but none of its
+// deliveries is in a state the operation applies to
+var ErrNothingChanged = errors.New("no deliveries changed")
+
+// ListMessages returns queued messages matching filter, most recent first
+func (s *SQLiteStorage) ListMessages(filter models.MessageFilter, limit, offset int) ([]*models.PendingMessage, error) {
+	where, args := messageWhere(filter)
+
+	query := `SELECT ` + messageColumns + ` FROM messages` + where + ` ORDER BY created_at DESC LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	return queryMessages(s.db, query, args...)
+}
+
+// ListMessagesAfter returns up to limit messages matching filter after the
+// message created at createdAt with ID id, oldest first. Like the filter,
+// it compares creation times with julianday.
+func (s *SQLiteStorage) ListMessagesAfter(filter models.MessageFilter, createdAt time.Time, id string, limit int) ([]*models.PendingMessage, error) {
+	where, args := messageWhere(filter)
+
+	keyset := "(julianday(created_at) > julianday(?) OR (julianday(created_at) = julianday(?) AND id > ?))"
+	if where == "" {
+		where = " WHERE " + keyset
+	} else {
+		where += " AND " + keyset
+	}
+	args = append(args, createdAt.UTC(), createdAt.UTC(), id, limit)
+
+	query := `SELECT ` + messageColumns + ` FROM messages` + where + ` ORDER BY julianday(created_at), id LIMIT ?`
+	return queryMessages(s.db, query, args...)
+}
+
+// queryMessages returns the messages selected with messageColumns by query
+func queryMessages(db *sql.DB, query string, args ...interface{}) ([]*models.PendingMessage, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*models.PendingMessage
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages, rows.Err()
+}
+
+// CountMessages returns the number of queued messages matching filter
+func (s *SQLiteStorage) CountMessages(filter models.MessageFilter) (int, error) {
+	where, args := messageWhere(filter)
+
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM messages`+where, args...).Scan(&count)
+	return count, err
+}
+
+// GetMessage returns a single queued message with all of its deliveries
+func (s *SQLiteStorage) GetMessage(id string) (*models.PendingMessage, error) {
+	msg, err := scanMessage(s.db.QueryRow(`SELECT `+messageColumns+` FROM messages WHERE id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+
+	msg.Deliveries, err = queryDeliveries(s.db, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load deliveries: %w", err)
+	}
+
+	return msg, nil
+}
+
+// RetryMessage makes the undelivered destinations of a message due
+// immediately, including cancelled ones. Retry counts are kept, so the
+// retry policy still applies.
+func (s *SQLiteStorage) RetryMessage(id string) (models.MessageStatus, error) {
+	query := `
+	UPDATE deliveries
+	SET status = CASE WHEN status = ?1 THEN ?2 ELSE status END, next_retry_at = NULL, updated_at = ?3
+	WHERE message_id = ?4 AND status IN (?1, ?2, ?5)
+	`
+
+	return s.changeDeliveries(id, func(tx *sql.Tx) (sql.Result, error) {
+		return tx.Exec(query, models.StatusCancelled, models.StatusPending, time.Now(), id, models.StatusRetrying)
+	})
+}
+
+// CancelMessage stops delivery of a message to the destinations that have
+// not received it yet. Cancelled messages stay in storage for inspection
+// until they are removed by Cleanup.
+func (s *SQLiteStorage) CancelMessage(id string) (models.MessageStatus, error) {
+	query := `
+	UPDATE deliveries
+	SET status = ?1, next_retry_at = NULL, updated_at = ?2
+	WHERE message_id = ?3 AND status IN (?4, ?5)
+	`
+
+	return s.changeDeliveries(id, func(tx *sql.Tx) (sql.Result, error) {
+		return tx.Exec(query, models.StatusCancelled, time.Now(), id, models.StatusPending, models.StatusRetrying)
+	})
+}
+
+// RequeueMessages restarts delivery of queued messages matching filter as if
+// they had just been received: undelivered destinations are reset to
+// pending with no retries and created_at is reset so the retry policy
+// max_age starts over. Delivered messages are left alone. It returns the
+// requeued IDs.
+func (s *SQLiteStorage) RequeueMessages(filter models.MessageFilter) ([]string, error) {
+	ids, err := s.messageIDs(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	UPDATE deliveries
+	SET status = ?1, retries = 0, error = NULL, next_retry_at = NULL, updated_at = ?2
+	WHERE message_id = ?3 AND status != ?4
+	`
+
+	requeued := make([]string, 0, len(ids))
+	for _, id := range ids {
+		_, err := s.changeDeliveries(id, func(tx *sql.Tx) (sql.Result, error) {
+			now := time.Now()
+			if _, err := tx.Exec(`UPDATE messages SET created_at = ?, error = '' WHERE id = ?`, now, id); err != nil {
+				return nil, err
+			}
+			return tx.Exec(query, models.StatusPending, now, id, models.StatusSent)
+		})
+		if errors.Is(err, ErrNothingChanged) || errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return requeued, fmt.Errorf("failed to requeue message %s: %w", id, err)
+		}
+		requeued = append(requeued, id)
+	}
+
+	return requeued, nil
+}
+
+// ImportMessage stores a message exported from another instance together
+// with the state of its deliveries. Messages without deliveries get pending
+// deliveries to their destinations. It returns false without changing
+// anything if a message with the same ID is already stored.
+func (s *SQLiteStorage) ImportMessage(msg *models.PendingMessage) (bool, error) {
+	headersJSON, err := json.Marshal(msg.Headers)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal headers: %w", err)
+	}
+
+	destinationsJSON, err := json.Marshal(msg.Destinations)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal destinations: %w", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+	INSERT OR IGNORE INTO messages
+	(id, path, queue, body, headers, timestamp, retries, status, error, created_at, updated_at, destinations, ordering_key, traceparent)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		msg.ID, msg.Path, msg.Queue, msg.Body, string(headersJSON),
+		msg.Timestamp, msg.Retries, models.StatusPending, msg.Error,
+		msg.CreatedAt, msg.UpdatedAt, string(destinationsJSON), msg.OrderingKey, msg.TraceParent,
+	)
+	if err != nil {
+		return false, err
+	}
+	if inserted, err := result.RowsAffected(); err != nil || inserted == 0 {
+		return false, err
+	}
+
+	if len(msg.Deliveries) == 0 {
+		if err := insertDeliveries(tx, msg.ID, msg.Destinations); err != nil {
+			return false, err
+		}
+	}
+
+	query := `
+	INSERT OR IGNORE INTO deliveries (message_id, target_key, target, status, retries, error, next_retry_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	for _, delivery := range msg.Deliveries {
+		targetJSON, err := json.Marshal(delivery.Target)
+		if err != nil {
+			return false, fmt.Errorf("failed to marshal delivery target: %w", err)
+		}
+
+		_, err = tx.Exec(query, msg.ID, delivery.Target.Key(), string(targetJSON), delivery.Status,
+			delivery.Retries, delivery.Error, retryTime(delivery.Status, delivery.NextRetryAt), delivery.UpdatedAt)
+		if err != nil {
+			return false, fmt.Errorf("failed to save delivery: %w", err)
+		}
+	}
+
+	if _, err := refreshMessage(tx, msg.ID, ""); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// changeDeliveries runs update against a message's deliveries and derives
+// the message state from the result. It returns sql.ErrNoRows for unknown
+// messages and ErrNothingChanged when no delivery was updated.
+func (s *SQLiteStorage) changeDeliveries(id string, update func(tx *sql.Tx) (sql.Result, error)) (models.MessageStatus, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var status models.MessageStatus
+	if err := tx.QueryRow(`SELECT status FROM messages WHERE id = ?`, id).Scan(&status); err != nil {
+		return "", err
+	}
+
+	result, err := update(tx)
+	if err != nil {
+		return "", err
+	}
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if changed == 0 {
+		return status, ErrNothingChanged
+	}
+
+	if status, err = refreshMessage(tx, id, ""); err != nil {
+		return "", err
+	}
+
+	return status, tx.Commit()
+}
+
+// messageIDs returns the IDs of queued messages matching filter, oldest first
+func (s *SQLiteStorage) messageIDs(filter models.MessageFilter) ([]string, error) {
+	where, args := messageWhere(filter)
+
+	rows, err := s.db.Query(`SELECT id FROM messages`+where+` ORDER BY created_at ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// messageWhere builds the WHERE clause for a message filter. Receive times
+// are compared with julianday because stored timestamps keep the zone
+// offset of the server that received them.
+func messageWhere(filter models.MessageFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if len(filter.IDs) > 0 {
+		conditions = append(conditions, "id IN (?"+strings.Repeat(", ?", len(filter.IDs)-1)+")")
+		for _, id := range filter.IDs {
+			args = append(args, id)
+		}
+	}
+	if filter.Path != "" {
+		conditions = append(conditions, "path = ?")
+		args = append(args, filter.Path)
+	}
+	if filter.Queue != "" {
+		conditions = append(conditions, "queue = ?")
+		args = append(args, filter.Queue)
+	}
+	if filter.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "julianday(timestamp) >= julianday(?)")
+		args = append(args, filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "julianday(timestamp) <= julianday(?)")
+		args = append(args, filter.To.UTC())
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
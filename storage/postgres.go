@@ -0,0 +1,572 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/metrics"
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/retry"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// Advisory lock keys. The migration lock lets only one instance migrate the
+// schema at a time; the claim lock serializes claims, see GetPendingMessages.
+const (
+	postgresMigrationLock = 0x6d62_0001
+	postgresClaimLock     = 0x6d62_0002
+)
+
+// PostgresStorage represents PostgreSQL storage for messages. Several
+// instances can share the database: a claimed message is leased to the
+// instance delivering it, so it is never delivered by two at once.
+type PostgresStorage struct {
+	db            *sql.DB
+	leaseDuration time.Duration
+	retryPolicies atomic.Pointer[retry.Policies]
+}
+
+// NewPostgresStorage connects to PostgreSQL and migrates the schema
+func NewPostgresStorage(cfg *config.PostgresConfig) (*PostgresStorage, error) {
+	db, err := sql.Open("pgx", cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxOpenConns)
+
+	storage := &PostgresStorage{
+		db:            db,
+		leaseDuration: cfg.LeaseDuration,
+	}
+	storage.retryPolicies.Store(retry.DefaultPolicies())
+
+	if err := storage.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	}
+
+	return storage, nil
+}
+
+// PingPostgres checks that the database in cfg accepts connections
+func PingPostgres(cfg *config.PostgresConfig) error {
+	db, err := sql.Open("pgx", cfg.DSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return db.PingContext(ctx)
+}
+
+// SetRetryPolicies sets the policies used to schedule retries of saved messages
+func (s *PostgresStorage) SetRetryPolicies(policies *retry.Policies) {
+	s.retryPolicies.Store(policies)
+}
+
+// postgresMigrations create and update the schema. Each one runs once, in
+// order, and is recorded in schema_migrations. Applied migrations must not
+// be changed; add a new one instead.
+var postgresMigrations = []string{
+	// 1: initial schema
+	`
+	CREATE TABLE messages (
+		id TEXT PRIMARY KEY,
+		path TEXT NOT NULL,
+		queue TEXT NOT NULL,
+		body BYTEA NOT NULL,
+		headers JSONB NOT NULL,
+		timestamp TIMESTAMPTZ NOT NULL,
+		retries INTEGER NOT NULL DEFAULT 0,
+		status TEXT NOT NULL,
+		error TEXT,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		next_retry_at TIMESTAMPTZ,
+		destinations JSONB,
+		ordering_key TEXT,
+		traceparent TEXT,
+		locked_until TIMESTAMPTZ
+	);
+
+	CREATE INDEX idx_messages_status ON messages(status, created_at);
+	CREATE INDEX idx_messages_queue ON messages(queue);
+	CREATE INDEX idx_messages_ordering_key ON messages(ordering_key);
+
+	CREATE TABLE deliveries (
+		seq BIGSERIAL,
+		message_id TEXT NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+		target_key TEXT NOT NULL,
+		target JSONB NOT NULL,
+		status TEXT NOT NULL,
+		retries INTEGER NOT NULL DEFAULT 0,
+		error TEXT,
+		next_retry_at TIMESTAMPTZ,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (message_id, target_key)
+	);
+
+	CREATE INDEX idx_deliveries_status ON deliveries(status);
+
+	CREATE TABLE dead_letters (
+		id TEXT PRIMARY KEY,
+		path TEXT NOT NULL,
+		queue TEXT NOT NULL,
+		body BYTEA NOT NULL,
+		headers JSONB NOT NULL,
+		timestamp TIMESTAMPTZ NOT NULL,
+		retries INTEGER NOT NULL DEFAULT 0,
+		error TEXT,
+		destinations JSONB,
+		ordering_key TEXT,
+		deliveries JSONB NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		failed_at TIMESTAMPTZ NOT NULL,
+		traceparent TEXT
+	);
+
+	CREATE INDEX idx_dead_letters_path ON dead_letters(path);
+	CREATE INDEX idx_dead_letters_failed_at ON dead_letters(failed_at);
+
+	CREATE TABLE attempts (
+		id BIGSERIAL PRIMARY KEY,
+		message_id TEXT NOT NULL,
+		path TEXT,
+		target_key TEXT NOT NULL,
+		target JSONB NOT NULL,
+		attempted_at TIMESTAMPTZ NOT NULL,
+		duration_ms BIGINT NOT NULL,
+		status_code INTEGER,
+		kafka_partition INTEGER,
+		kafka_offset BIGINT,
+		error TEXT,
+		response TEXT
+	);
+
+	CREATE INDEX idx_attempts_message_id ON attempts(message_id);
+	CREATE INDEX idx_attempts_attempted_at ON attempts(attempted_at);
+	`,
+}
+
+// migrate applies the migrations the database has not seen yet. Instances
+// starting at the same time wait for each other.
+func (s *PostgresStorage) migrate() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, postgresMigrationLock); err != nil {
+		return err
+	}
+
+	query := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)
+	`
+	if _, err := tx.Exec(query); err != nil {
+		return err
+	}
+
+	var version int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(postgresMigrations) {
+		return fmt.Errorf("database schema version %d is newer than this version of messagebridge supports (%d)", version, len(postgresMigrations))
+	}
+
+	for i := version; i < len(postgresMigrations); i++ {
+		if _, err := tx.Exec(postgresMigrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, i+1); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SaveMessage saves a message to storage
+func (s *PostgresStorage) SaveMessage(msg *models.WebhookMessage) error {
+	headersJSON, err := json.Marshal(msg.Headers)
+	if err != nil {
+		return fmt.Errorf("failed to marshal headers: %w", err)
+	}
+
+	destinationsJSON, err := json.Marshal(msg.Destinations)
+	if err != nil {
+		return fmt.Errorf("failed to marshal destinations: %w", err)
+	}
+
+	query := `
+	INSERT INTO messages
+	(id, path, queue, body, headers, timestamp, retries, status, error, created_at, updated_at, next_retry_at, destinations, ordering_key, traceparent)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+	ON CONFLICT (id) DO UPDATE SET
+		path = EXCLUDED.path, queue = EXCLUDED.queue, body = EXCLUDED.body, headers = EXCLUDED.headers,
+		timestamp = EXCLUDED.timestamp, retries = EXCLUDED.retries, status = EXCLUDED.status, error = EXCLUDED.error,
+		created_at = EXCLUDED.created_at, updated_at = EXCLUDED.updated_at, next_retry_at = EXCLUDED.next_retry_at,
+		destinations = EXCLUDED.destinations, ordering_key = EXCLUDED.ordering_key, traceparent = EXCLUDED.traceparent
+	`
+
+	nextRetryAt := sql.NullTime{}
+	if msg.Status == models.StatusRetrying {
+		nextRetryAt = retryTime(msg.Status, s.retryPolicies.Load().For(msg.Path).NextRetryAt(msg.Retries, time.Now()))
+	}
+
+	defer metrics.ObserveStorageWrite("save_message", time.Now())
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(query,
+		msg.ID, msg.Path, msg.Queue, msg.Body, string(headersJSON),
+		msg.Timestamp, msg.Retries, msg.Status, msg.Error,
+		msg.CreatedAt, msg.UpdatedAt, nextRetryAt, string(destinationsJSON), msg.OrderingKey, msg.TraceParent,
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := postgresInsertDeliveries(tx, msg.ID, msg.Destinations); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetPendingMessages claims messages that need delivery and leases them to
+// this instance. Messages waiting behind an older undelivered message with
+// the same ordering key are held back while that message is scheduled for
+// later or leased to another instance.
+//
+// Rows being claimed or updated elsewhere are skipped rather than waited
+// for. Claims themselves are serialized so the ordering key check sees the
+// leases taken by concurrent claims; they only hold the lock for one query.
+func (s *PostgresStorage) GetPendingMessages(limit int) ([]*models.PendingMessage, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, postgresClaimLock); err != nil {
+		return nil, err
+	}
+
+	query := `
+	WITH due AS (
+		SELECT id FROM messages m
+		WHERE status IN ($1, $2) AND (next_retry_at IS NULL OR next_retry_at <= $3)
+		  AND (locked_until IS NULL OR locked_until <= $3)
+		  AND NOT EXISTS (
+			SELECT 1 FROM messages o
+			WHERE o.ordering_key = m.ordering_key AND o.ordering_key != ''
+			  AND o.status IN ($1, $2) AND o.created_at < m.created_at
+			  AND (o.next_retry_at > $3 OR o.locked_until > $3)
+		  )
+		ORDER BY created_at ASC
+		LIMIT $4
+		FOR UPDATE SKIP LOCKED
+	)
+	UPDATE messages SET locked_until = $5
+	WHERE id IN (SELECT id FROM due)
+	RETURNING ` + messageColumns
+
+	now := time.Now()
+	rows, err := tx.Query(query, models.StatusPending, models.StatusRetrying, now, limit, now.Add(s.leaseDuration))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*models.PendingMessage
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// UPDATE returns rows in no particular order
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].CreatedAt.Before(messages[j].CreatedAt) })
+
+	if err := postgresLoadPendingDeliveries(tx, messages); err != nil {
+		return nil, fmt.Errorf("failed to load deliveries: %w", err)
+	}
+
+	return messages, tx.Commit()
+}
+
+// ReleaseMessage ends the lease on a claimed message so any instance can
+// claim it again
+func (s *PostgresStorage) ReleaseMessage(id string) error {
+	_, err := s.db.Exec(`UPDATE messages SET locked_until = NULL WHERE id = $1`, id)
+	return err
+}
+
+// postgresInsertDeliveries creates a pending delivery for each target.
+// Existing deliveries keep their state so a message can be saved again safely.
+func postgresInsertDeliveries(tx *sql.Tx, messageID string, targets []models.DeliveryTarget) error {
+	query := `
+	INSERT INTO deliveries (message_id, target_key, target, status, retries, updated_at)
+	VALUES ($1, $2, $3, $4, 0, $5)
+	ON CONFLICT (message_id, target_key) DO NOTHING
+	`
+
+	for _, target := range targets {
+		targetJSON, err := json.Marshal(target)
+		if err != nil {
+			return fmt.Errorf("failed to marshal delivery target: %w", err)
+		}
+
+		if _, err := tx.Exec(query, messageID, target.Key(), string(targetJSON), models.StatusPending, time.Now()); err != nil {
+			return fmt.Errorf("failed to save delivery: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// AddDeliveries creates pending deliveries for a message that has none yet,
+// e.g. messages imported without deliveries
+func (s *PostgresStorage) AddDeliveries(messageID string, targets []models.DeliveryTarget) ([]*models.Delivery, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := postgresInsertDeliveries(tx, messageID, targets); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetDeliveries(messageID)
+}
+
+// GetDeliveries returns all deliveries of a message
+func (s *PostgresStorage) GetDeliveries(messageID string) ([]*models.Delivery, error) {
+	return postgresQueryDeliveries(s.db, messageID)
+}
+
+// postgresQueryDeliveries returns all deliveries of a message
+func postgresQueryDeliveries(q querier, messageID string) ([]*models.Delivery, error) {
+	query := `
+	SELECT message_id, target, status, retries, error, next_retry_at, updated_at
+	FROM deliveries
+	WHERE message_id = $1
+	ORDER BY seq ASC
+	`
+
+	rows, err := q.Query(query, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*models.Delivery
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// postgresLoadPendingDeliveries attaches the undelivered destinations to each message
+func postgresLoadPendingDeliveries(q querier, messages []*models.PendingMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	byID := make(map[string]*models.PendingMessage, len(messages))
+	ids := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		byID[msg.ID] = msg
+		ids = append(ids, msg.ID)
+	}
+
+	query := `
+	SELECT message_id, target, status, retries, error, next_retry_at, updated_at
+	FROM deliveries
+	WHERE status IN ($1, $2) AND message_id = ANY($3)
+	ORDER BY seq ASC
+	`
+
+	rows, err := q.Query(query, models.StatusPending, models.StatusRetrying, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return err
+		}
+		if msg, ok := byID[delivery.MessageID]; ok {
+			msg.Deliveries = append(msg.Deliveries, delivery)
+		}
+	}
+
+	return rows.Err()
+}
+
+// UpdateDeliveryStatus records the outcome of a delivery attempt and
+// returns the resulting overall status of the message. nextRetryAt is only
+// stored for retrying deliveries. The lease on the message ends once none
+// of its deliveries is due, i.e. when the worker is done with it.
+func (s *PostgresStorage) UpdateDeliveryStatus(messageID string, target models.DeliveryTarget, status models.MessageStatus, errMsg string, nextRetryAt time.Time) (models.MessageStatus, error) {
+	defer metrics.ObserveStorageWrite("update_delivery", time.Now())
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE deliveries
+	SET status = $1, error = $2, updated_at = $3, retries = retries + 1, next_retry_at = $4
+	WHERE message_id = $5 AND target_key = $6 AND status != $7
+	`
+
+	// Deliveries cancelled while the attempt was in flight stay cancelled
+	now := time.Now()
+	if _, err := tx.Exec(query, status, errMsg, now, retryTime(status, nextRetryAt), messageID, target.Key(), models.StatusCancelled); err != nil {
+		return "", err
+	}
+
+	messageStatus, err := postgresRefreshMessage(tx, messageID, errMsg)
+	if err != nil {
+		return "", err
+	}
+
+	query = `
+	UPDATE messages SET locked_until = NULL
+	WHERE id = $1 AND NOT EXISTS (
+		SELECT 1 FROM deliveries
+		WHERE message_id = $1 AND status IN ($2, $3) AND (next_retry_at IS NULL OR next_retry_at <= $4)
+	)
+	`
+	if _, err := tx.Exec(query, messageID, models.StatusPending, models.StatusRetrying, now); err != nil {
+		return "", err
+	}
+
+	return messageStatus, tx.Commit()
+}
+
+// postgresRefreshMessage derives the message status, retry count and next
+// retry time from its deliveries and returns the new message status
+func postgresRefreshMessage(tx *sql.Tx, messageID, errMsg string) (models.MessageStatus, error) {
+	query := `
+	UPDATE messages
+	SET status = CASE
+			WHEN EXISTS (SELECT 1 FROM deliveries WHERE message_id = $1 AND status = 'retrying') THEN 'retrying'
+			WHEN EXISTS (SELECT 1 FROM deliveries WHERE message_id = $1 AND status = 'pending') THEN 'pending'
+			WHEN EXISTS (SELECT 1 FROM deliveries WHERE message_id = $1 AND status = 'failed') THEN 'failed'
+			WHEN EXISTS (SELECT 1 FROM deliveries WHERE message_id = $1 AND status = 'cancelled') THEN 'cancelled'
+			ELSE 'sent'
+		END,
+		retries = (SELECT COALESCE(MAX(retries), 0) FROM deliveries WHERE message_id = $1),
+		next_retry_at = CASE
+			WHEN EXISTS (SELECT 1 FROM deliveries WHERE message_id = $1 AND status IN ('pending', 'retrying') AND next_retry_at IS NULL) THEN NULL
+			ELSE (SELECT MIN(next_retry_at) FROM deliveries WHERE message_id = $1 AND status IN ('pending', 'retrying'))
+		END,
+		error = CASE WHEN $2 != '' THEN $2 ELSE error END,
+		updated_at = $3
+	WHERE id = $1
+	RETURNING status
+	`
+
+	var status models.MessageStatus
+	if err := tx.QueryRow(query, messageID, errMsg, time.Now()).Scan(&status); err != nil {
+		return "", err
+	}
+
+	return status, nil
+}
+
+// DeleteMessage removes a message and, through the foreign key, its
+// deliveries from storage
+func (s *PostgresStorage) DeleteMessage(id string) error {
+	_, err := s.db.Exec(`DELETE FROM messages WHERE id = $1`, id)
+	return err
+}
+
+// GetMessageStats returns statistics about stored messages
+func (s *PostgresStorage) GetMessageStats() (map[string]int, error) {
+	rows, err := s.db.Query(`SELECT status, COUNT(*) FROM messages GROUP BY status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		stats[status] = count
+	}
+
+	return stats, rows.Err()
+}
+
+// Close closes the database connections
+func (s *PostgresStorage) Close() error {
+	return s.db.Close()
+}
+
+// Cleanup removes old messages based on retention policy
+func (s *PostgresStorage) Cleanup(retentionDays int) error {
+	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+
+	query := `DELETE FROM messages WHERE status IN ($1, $2) AND created_at < $3`
+	if _, err := s.db.Exec(query, models.StatusSent, models.StatusCancelled, cutoff); err != nil {
+		return err
+	}
+
+	// Attempt history outlives delivered messages for the retention period,
+	// and is kept as long as the message is queued or dead-lettered
+	query = `
+	DELETE FROM attempts a
+	WHERE attempted_at < $1
+	  AND NOT EXISTS (SELECT 1 FROM messages WHERE id = a.message_id)
+	  AND NOT EXISTS (SELECT 1 FROM dead_letters WHERE id = a.message_id)
+	`
+
+	_, err := s.db.Exec(query, cutoff)
+	return err
+}
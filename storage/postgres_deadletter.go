@@ -0,0 +1,291 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/expai/messagebridge/models"
+)
+
+// MoveToDeadLetter moves a message and the state of its deliveries from the
+// queue to the dead-letter table and returns the dead letter
+func (s *PostgresStorage) MoveToDeadLetter(id string) (*models.DeadLetter, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	msg, err := scanMessage(tx.QueryRow(`SELECT `+messageColumns+` FROM messages WHERE id = $1 FOR UPDATE`, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message: %w", err)
+	}
+
+	deliveries, err := postgresQueryDeliveries(tx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load deliveries: %w", err)
+	}
+
+	deadLetter := &models.DeadLetter{
+		WebhookMessage: msg.WebhookMessage,
+		Deliveries:     deliveries,
+		FailedAt:       time.Now().UTC(),
+	}
+
+	headersJSON, err := json.Marshal(msg.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal headers: %w", err)
+	}
+	destinationsJSON, err := json.Marshal(msg.Destinations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal destinations: %w", err)
+	}
+	deliveriesJSON, err := json.Marshal(deliveries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal deliveries: %w", err)
+	}
+
+	query := `
+	INSERT INTO dead_letters
+	(id, path, queue, body, headers, timestamp, retries, error, destinations, ordering_key, deliveries, created_at, failed_at, traceparent)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+	ON CONFLICT (id) DO UPDATE SET
+		path = EXCLUDED.path, queue = EXCLUDED.queue, body = EXCLUDED.body, headers = EXCLUDED.headers,
+		timestamp = EXCLUDED.timestamp, retries = EXCLUDED.retries, error = EXCLUDED.error,
+		destinations = EXCLUDED.destinations, ordering_key = EXCLUDED.ordering_key, deliveries = EXCLUDED.deliveries,
+		created_at = EXCLUDED.created_at, failed_at = EXCLUDED.failed_at, traceparent = EXCLUDED.traceparent
+	`
+
+	_, err = tx.Exec(query,
+		msg.ID, msg.Path, msg.Queue, msg.Body, string(headersJSON), msg.Timestamp, msg.Retries, msg.Error,
+		string(destinationsJSON), msg.OrderingKey, string(deliveriesJSON), msg.CreatedAt, deadLetter.FailedAt, msg.TraceParent,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save dead letter: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM messages WHERE id = $1`, id); err != nil {
+		return nil, err
+	}
+
+	return deadLetter, tx.Commit()
+}
+
+// GetFailedMessageIDs returns the IDs of messages that failed permanently but
+// are still in the queue
+func (s *PostgresStorage) GetFailedMessageIDs() ([]string, error) {
+	rows, err := s.db.Query(`SELECT id FROM messages WHERE status = $1`, models.StatusFailed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// ListDeadLetters returns dead letters matching filter, most recent first
+func (s *PostgresStorage) ListDeadLetters(filter models.DeadLetterFilter, limit, offset int) ([]*models.DeadLetter, error) {
+	where, args := postgresDeadLetterWhere(filter)
+
+	query := fmt.Sprintf(`SELECT %s FROM dead_letters%s ORDER BY failed_at DESC LIMIT $%d OFFSET $%d`,
+		deadLetterColumns, where, len(args)+1, len(args)+2)
+	args = append(args, limit, offset)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deadLetters []*models.DeadLetter
+	for rows.Next() {
+		deadLetter, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, err
+		}
+		deadLetters = append(deadLetters, deadLetter)
+	}
+
+	return deadLetters, rows.Err()
+}
+
+// CountDeadLetters returns the number of dead letters matching filter
+func (s *PostgresStorage) CountDeadLetters(filter models.DeadLetterFilter) (int, error) {
+	where, args := postgresDeadLetterWhere(filter)
+
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM dead_letters`+where, args...).Scan(&count)
+	return count, err
+}
+
+// GetDeadLetter returns a single dead letter
+func (s *PostgresStorage) GetDeadLetter(id string) (*models.DeadLetter, error) {
+	return scanDeadLetter(s.db.QueryRow(`SELECT `+deadLetterColumns+` FROM dead_letters WHERE id = $1`, id))
+}
+
+// ReplayDeadLetters moves dead letters matching filter back to the queue.
+// Only deliveries that failed are attempted again; destinations that already
+// received the message are not sent to twice. It returns the replayed IDs.
+func (s *PostgresStorage) ReplayDeadLetters(filter models.DeadLetterFilter) ([]string, error) {
+	where, args := postgresDeadLetterWhere(filter)
+
+	rows, err := s.db.Query(`SELECT id FROM dead_letters`+where+` ORDER BY created_at ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	replayed := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if err := s.replayDeadLetter(id); err != nil {
+			return replayed, fmt.Errorf("failed to replay dead letter %s: %w", id, err)
+		}
+		replayed = append(replayed, id)
+	}
+
+	return replayed, nil
+}
+
+// replayDeadLetter moves a single dead letter back to the queue
+func (s *PostgresStorage) replayDeadLetter(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	deadLetter, err := scanDeadLetter(tx.QueryRow(`SELECT `+deadLetterColumns+` FROM dead_letters WHERE id = $1 FOR UPDATE`, id))
+	if err != nil {
+		return err
+	}
+
+	headersJSON, err := json.Marshal(deadLetter.Headers)
+	if err != nil {
+		return fmt.Errorf("failed to marshal headers: %w", err)
+	}
+	destinationsJSON, err := json.Marshal(deadLetter.Destinations)
+	if err != nil {
+		return fmt.Errorf("failed to marshal destinations: %w", err)
+	}
+
+	// created_at is reset so the retry policy max_age starts over
+	now := time.Now()
+	query := `
+	INSERT INTO messages
+	(id, path, queue, body, headers, timestamp, retries, status, error, created_at, updated_at, next_retry_at, destinations, ordering_key, traceparent)
+	VALUES ($1, $2, $3, $4, $5, $6, 0, $7, '', $8, $8, NULL, $9, $10, $11)
+	ON CONFLICT (id) DO UPDATE SET
+		path = EXCLUDED.path, queue = EXCLUDED.queue, body = EXCLUDED.body, headers = EXCLUDED.headers,
+		timestamp = EXCLUDED.timestamp, retries = 0, status = EXCLUDED.status, error = '',
+		created_at = EXCLUDED.created_at, updated_at = EXCLUDED.updated_at, next_retry_at = NULL,
+		destinations = EXCLUDED.destinations, ordering_key = EXCLUDED.ordering_key, traceparent = EXCLUDED.traceparent,
+		locked_until = NULL
+	`
+
+	_, err = tx.Exec(query,
+		deadLetter.ID, deadLetter.Path, deadLetter.Queue, deadLetter.Body, string(headersJSON), deadLetter.Timestamp,
+		models.StatusPending, now, string(destinationsJSON), deadLetter.OrderingKey, deadLetter.TraceParent,
+	)
+	if err != nil {
+		return err
+	}
+
+	deliveryQuery := `
+	INSERT INTO deliveries (message_id, target_key, target, status, retries, updated_at)
+	VALUES ($1, $2, $3, $4, 0, $5)
+	ON CONFLICT (message_id, target_key) DO UPDATE SET
+		target = EXCLUDED.target, status = EXCLUDED.status, retries = 0, error = NULL, next_retry_at = NULL,
+		updated_at = EXCLUDED.updated_at
+	`
+	for _, delivery := range deadLetter.Deliveries {
+		status := models.StatusPending
+		if delivery.Status == models.StatusSent {
+			status = models.StatusSent
+		}
+
+		targetJSON, err := json.Marshal(delivery.Target)
+		if err != nil {
+			return fmt.Errorf("failed to marshal delivery target: %w", err)
+		}
+
+		if _, err := tx.Exec(deliveryQuery, id, delivery.Target.Key(), string(targetJSON), status, now); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM dead_letters WHERE id = $1`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteDeadLetters permanently removes dead letters matching filter and
+// returns the number of removed entries
+func (s *PostgresStorage) DeleteDeadLetters(filter models.DeadLetterFilter) (int, error) {
+	where, args := postgresDeadLetterWhere(filter)
+
+	result, err := s.db.Exec(`DELETE FROM dead_letters`+where, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := result.RowsAffected()
+	return int(count), err
+}
+
+// postgresDeadLetterWhere builds the WHERE clause for a dead-letter filter
+func postgresDeadLetterWhere(filter models.DeadLetterFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if len(filter.IDs) > 0 {
+		conditions = append(conditions, "id = ANY("+arg(filter.IDs)+")")
+	}
+	if filter.Path != "" {
+		conditions = append(conditions, "path = "+arg(filter.Path))
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "failed_at >= "+arg(filter.From))
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "failed_at <= "+arg(filter.To))
+	}
+	if filter.ErrorPattern != "" {
+		// The C collation folds ASCII letters only, like SQLite LIKE
+		conditions = append(conditions, `error COLLATE "C" ILIKE '%' || `+arg(likeEscaper.Replace(filter.ErrorPattern))+`::text || '%' ESCAPE '\'`)
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
@@ -0,0 +1,293 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/expai/messagebridge/models"
+)
+
+// ListMessages returns queued messages matching filter, most recent first
+func (s *PostgresStorage) ListMessages(filter models.MessageFilter, limit, offset int) ([]*models.PendingMessage, error) {
+	where, args := postgresMessageWhere(filter)
+
+	query := fmt.Sprintf(`SELECT %s FROM messages%s ORDER BY created_at DESC LIMIT $%d OFFSET $%d`,
+		messageColumns, where, len(args)+1, len(args)+2)
+	args = append(args, limit, offset)
+
+	return queryMessages(s.db, query, args...)
+}
+
+// ListMessagesAfter returns up to limit messages matching filter after the
+// message created at createdAt with ID id, oldest first
+func (s *PostgresStorage) ListMessagesAfter(filter models.MessageFilter, createdAt time.Time, id string, limit int) ([]*models.PendingMessage, error) {
+	where, args := postgresMessageWhere(filter)
+
+	keyset := fmt.Sprintf("(created_at, id) > ($%d, $%d)", len(args)+1, len(args)+2)
+	if where == "" {
+		where = " WHERE " + keyset
+	} else {
+		where += " AND " + keyset
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM messages%s ORDER BY created_at, id LIMIT $%d`,
+		messageColumns, where, len(args)+3)
+	args = append(args, createdAt, id, limit)
+
+	return queryMessages(s.db, query, args...)
+}
+
+// CountMessages returns the number of queued messages matching filter
+func (s *PostgresStorage) CountMessages(filter models.MessageFilter) (int, error) {
+	where, args := postgresMessageWhere(filter)
+
+	var count int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM messages`+where, args...).Scan(&count)
+	return count, err
+}
+
+// GetMessage returns a single queued message with all of its deliveries
+func (s *PostgresStorage) GetMessage(id string) (*models.PendingMessage, error) {
+	msg, err := scanMessage(s.db.QueryRow(`SELECT `+messageColumns+` FROM messages WHERE id = $1`, id))
+	if err != nil {
+		return nil, err
+	}
+
+	msg.Deliveries, err = postgresQueryDeliveries(s.db, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load deliveries: %w", err)
+	}
+
+	return msg, nil
+}
+
+// RetryMessage makes the undelivered destinations of a message due
+// immediately, including cancelled ones. Retry counts are kept, so the
+// retry policy still applies.
+func (s *PostgresStorage) RetryMessage(id string) (models.MessageStatus, error) {
+	query := `
+	UPDATE deliveries
+	SET status = CASE WHEN status = $1 THEN $2 ELSE status END, next_retry_at = NULL, updated_at = $3
+	WHERE message_id = $4 AND status IN ($1, $2, $5)
+	`
+
+	return s.changeDeliveries(id, func(tx *sql.Tx) (sql.Result, error) {
+		return tx.Exec(query, models.StatusCancelled, models.StatusPending, time.Now(), id, models.StatusRetrying)
+	})
+}
+
+// CancelMessage stops delivery of a message to the destinations that have
+// not received it yet. Cancelled messages stay in storage for inspection
+// until they are removed by Cleanup.
+func (s *PostgresStorage) CancelMessage(id string) (models.MessageStatus, error) {
+	query := `
+	UPDATE deliveries
+	SET status = $1, next_retry_at = NULL, updated_at = $2
+	WHERE message_id = $3 AND status IN ($4, $5)
+	`
+
+	return s.changeDeliveries(id, func(tx *sql.Tx) (sql.Result, error) {
+		return tx.Exec(query, models.StatusCancelled, time.Now(), id, models.StatusPending, models.StatusRetrying)
+	})
+}
+
+// RequeueMessages restarts delivery of queued messages matching filter as if
+// they had just been received: undelivered destinations are reset to
+// pending with no retries and created_at is reset so the retry policy
+// max_age starts over. Delivered messages are left alone. It returns the
+// requeued IDs.
+func (s *PostgresStorage) RequeueMessages(filter models.MessageFilter) ([]string, error) {
+	ids, err := s.messageIDs(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	UPDATE deliveries
+	SET status = $1, retries = 0, error = NULL, next_retry_at = NULL, updated_at = $2
+	WHERE message_id = $3 AND status != $4
+	`
+
+	requeued := make([]string, 0, len(ids))
+	for _, id := range ids {
+		_, err := s.changeDeliveries(id, func(tx *sql.Tx) (sql.Result, error) {
+			now := time.Now()
+			if _, err := tx.Exec(`UPDATE messages SET created_at = $1, error = '' WHERE id = $2`, now, id); err != nil {
+				return nil, err
+			}
+			return tx.Exec(query, models.StatusPending, now, id, models.StatusSent)
+		})
+		if errors.Is(err, ErrNothingChanged) || errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return requeued, fmt.Errorf("failed to requeue message %s: %w", id, err)
+		}
+		requeued = append(requeued, id)
+	}
+
+	return requeued, nil
+}
+
+// ImportMessage stores a message exported from another instance together
+// with the state of its deliveries. Messages without deliveries get pending
+// deliveries to their destinations. It returns false without changing
+// anything if a message with the same ID is already stored.
+func (s *PostgresStorage) ImportMessage(msg *models.PendingMessage) (bool, error) {
+	headersJSON, err := json.Marshal(msg.Headers)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal headers: %w", err)
+	}
+
+	destinationsJSON, err := json.Marshal(msg.Destinations)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal destinations: %w", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+	INSERT INTO messages
+	(id, path, queue, body, headers, timestamp, retries, status, error, created_at, updated_at, destinations, ordering_key, traceparent)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+	ON CONFLICT (id) DO NOTHING
+	`,
+		msg.ID, msg.Path, msg.Queue, msg.Body, string(headersJSON),
+		msg.Timestamp, msg.Retries, models.StatusPending, msg.Error,
+		msg.CreatedAt, msg.UpdatedAt, string(destinationsJSON), msg.OrderingKey, msg.TraceParent,
+	)
+	if err != nil {
+		return false, err
+	}
+	if inserted, err := result.RowsAffected(); err != nil || inserted == 0 {
+		return false, err
+	}
+
+	if len(msg.Deliveries) == 0 {
+		if err := postgresInsertDeliveries(tx, msg.ID, msg.Destinations); err != nil {
+			return false, err
+		}
+	}
+
+	query := `
+	INSERT INTO deliveries (message_id, target_key, target, status, retries, error, next_retry_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	ON CONFLICT (message_id, target_key) DO NOTHING
+	`
+	for _, delivery := range msg.Deliveries {
+		targetJSON, err := json.Marshal(delivery.Target)
+		if err != nil {
+			return false, fmt.Errorf("failed to marshal delivery target: %w", err)
+		}
+
+		_, err = tx.Exec(query, msg.ID, delivery.Target.Key(), string(targetJSON), delivery.Status,
+			delivery.Retries, delivery.Error, retryTime(delivery.Status, delivery.NextRetryAt), delivery.UpdatedAt)
+		if err != nil {
+			return false, fmt.Errorf("failed to save delivery: %w", err)
+		}
+	}
+
+	if _, err := postgresRefreshMessage(tx, msg.ID, ""); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// changeDeliveries runs update against a message's deliveries and derives
+// the message state from the result. It returns ErrNotFound for unknown
+// messages and ErrNothingChanged when no delivery was updated.
+func (s *PostgresStorage) changeDeliveries(id string, update func(tx *sql.Tx) (sql.Result, error)) (models.MessageStatus, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var status models.MessageStatus
+	if err := tx.QueryRow(`SELECT status FROM messages WHERE id = $1 FOR UPDATE`, id).Scan(&status); err != nil {
+		return "", err
+	}
+
+	result, err := update(tx)
+	if err != nil {
+		return "", err
+	}
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if changed == 0 {
+		return status, ErrNothingChanged
+	}
+
+	if status, err = postgresRefreshMessage(tx, id, ""); err != nil {
+		return "", err
+	}
+
+	return status, tx.Commit()
+}
+
+// messageIDs returns the IDs of queued messages matching filter, oldest first
+func (s *PostgresStorage) messageIDs(filter models.MessageFilter) ([]string, error) {
+	where, args := postgresMessageWhere(filter)
+
+	rows, err := s.db.Query(`SELECT id FROM messages`+where+` ORDER BY created_at ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// postgresMessageWhere builds the WHERE clause for a message filter
+func postgresMessageWhere(filter models.MessageFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if len(filter.IDs) > 0 {
+		conditions = append(conditions, "id = ANY("+arg(filter.IDs)+")")
+	}
+	if filter.Path != "" {
+		conditions = append(conditions, "path = "+arg(filter.Path))
+	}
+	if filter.Queue != "" {
+		conditions = append(conditions, "queue = "+arg(filter.Queue))
+	}
+	if filter.Status != "" {
+		conditions = append(conditions, "status = "+arg(filter.Status))
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "timestamp >= "+arg(filter.From))
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "timestamp <= "+arg(filter.To))
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
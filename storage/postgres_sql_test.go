@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"database/sql/driver"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/retry"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// These tests check the statements the PostgreSQL storage sends without a
+// database. TestPostgresStorage runs the storage suite against a real one.
+
+// anyValue passes arguments to the mock database unchanged, as pgx accepts
+// slices for ANY($n)
+type anyValue struct{}
+
+// ConvertValue returns v unchanged
+func (anyValue) ConvertValue(v interface{}) (driver.Value, error) {
+	return v, nil
+}
+
+// timeNear matches a time argument within a second of the expected time
+type timeNear time.Time
+
+// Match reports whether v is a time close to t
+func (t timeNear) Match(v driver.Value) bool {
+	got, ok := v.(time.Time)
+	return ok && got.Sub(time.Time(t)).Abs() < time.Second
+}
+
+// sqlPattern returns a pattern matching a statement that contains the
+// fragments in order
+func sqlPattern(fragments ...string) string {
+	quoted := make([]string, len(fragments))
+	for i, fragment := range fragments {
+		quoted[i] = regexp.QuoteMeta(fragment)
+	}
+	return "(?s)" + strings.Join(quoted, ".*")
+}
+
+// newMockPostgres returns a PostgreSQL storage with a one minute lease on a
+// mock database whose expectations must all be met by the end of the test
+func newMockPostgres(t *testing.T) (*PostgresStorage, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New(sqlmock.ValueConverterOption(anyValue{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+
+	s := &PostgresStorage{db: db, leaseDuration: time.Minute}
+	s.retryPolicies.Store(retry.DefaultPolicies())
+	return s, mock
+}
+
+func TestPostgresMigrate(t *testing.T) {
+	expectVersion := func(mock sqlmock.Sqlmock, version int) {
+		mock.ExpectBegin()
+		mock.ExpectExec(sqlPattern("SELECT pg_advisory_xact_lock($1)")).
+			WithArgs(postgresMigrationLock).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(sqlPattern("CREATE TABLE IF NOT EXISTS schema_migrations")).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(sqlPattern("SELECT COALESCE(MAX(version), 0) FROM schema_migrations")).
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(version))
+	}
+
+	// A new database gets every migration under the migration lock
+	s, mock := newMockPostgres(t)
+	expectVersion(mock, 0)
+	for i := range postgresMigrations {
+		mock.ExpectExec(sqlPattern(strings.TrimSpace(postgresMigrations[i])[:40])).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(sqlPattern("INSERT INTO schema_migrations (version) VALUES ($1)")).
+			WithArgs(i + 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+	if err := s.migrate(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	// A migrated database is left alone
+	s, mock = newMockPostgres(t)
+	expectVersion(mock, len(postgresMigrations))
+	mock.ExpectCommit()
+	if err := s.migrate(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	// A database migrated by a newer version is refused
+	s, mock = newMockPostgres(t)
+	expectVersion(mock, len(postgresMigrations)+1)
+	mock.ExpectRollback()
+	if err := s.migrate(); err == nil || !strings.Contains(err.Error(), "is newer than") {
+		t.Errorf("migrate = %v, want a newer schema error", err)
+	}
+}
+
+func TestPostgresClaimLeasesMessages(t *testing.T) {
+	s, mock := newMockPostgres(t)
+	now := time.Now()
+
+	columns := strings.Split(strings.Join(strings.Fields(messageColumns), ""), ",")
+	row := func(id string, createdAt time.Time) []driver.Value {
+		return []driver.Value{id, "/webhook/orders", "orders", []byte("{}"), `{}`, createdAt, 0, "pending", nil,
+			createdAt, createdAt, nil, `[{"type":"kafka","topic":"orders"}]`, "orders", nil}
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(sqlPattern("SELECT pg_advisory_xact_lock($1)")).
+		WithArgs(postgresClaimLock).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(sqlPattern(
+		"WITH due AS",
+		"AND (locked_until IS NULL OR locked_until <= $3)",
+		"o.ordering_key = m.ordering_key",
+		"(o.next_retry_at > $3 OR o.locked_until > $3)",
+		"ORDER BY created_at ASC", "LIMIT $4", "FOR UPDATE SKIP LOCKED",
+		"UPDATE messages SET locked_until = $5", "WHERE id IN (SELECT id FROM due)",
+		"RETURNING",
+	)).
+		WithArgs(models.StatusPending, models.StatusRetrying, timeNear(now), 10, timeNear(now.Add(time.Minute))).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(row("msg-2", now.Add(-time.Minute))...).
+			AddRow(row("msg-1", now.Add(-time.Hour))...))
+	mock.ExpectQuery(sqlPattern("FROM deliveries", "WHERE status IN ($1, $2) AND message_id = ANY($3)")).
+		WithArgs(models.StatusPending, models.StatusRetrying, []string{"msg-1", "msg-2"}).
+		WillReturnRows(sqlmock.NewRows([]string{"message_id", "target", "status", "retries", "error", "next_retry_at", "updated_at"}).
+			AddRow("msg-2", `{"type":"kafka","topic":"orders"}`, "pending", 0, nil, nil, now).
+			AddRow("msg-1", `{"type":"kafka","topic":"orders"}`, "retrying", 2, "timeout", now, now))
+	mock.ExpectCommit()
+
+	messages, err := s.GetPendingMessages(10)
+	if err != nil {
+		t.Fatalf("GetPendingMessages: %v", err)
+	}
+
+	// Messages come oldest first with their deliveries
+	if len(messages) != 2 || messages[0].ID != "msg-1" || messages[1].ID != "msg-2" {
+		t.Fatalf("claimed %v, want msg-1 and msg-2", messages)
+	}
+	for _, msg := range messages {
+		if len(msg.Deliveries) != 1 || msg.Deliveries[0].MessageID != msg.ID {
+			t.Errorf("%s deliveries = %v, want its own", msg.ID, msg.Deliveries)
+		}
+	}
+	if delivery := messages[0].Deliveries[0]; delivery.Status != models.StatusRetrying || delivery.Retries != 2 || delivery.Error != "timeout" {
+		t.Errorf("msg-1 delivery = %+v, want retrying after 2 attempts", delivery)
+	}
+}
+
+func TestPostgresUpdateEndsLease(t *testing.T) {
+	s, mock := newMockPostgres(t)
+	now := time.Now()
+	target := models.DeliveryTarget{Type: models.TargetKafka, Topic: "orders"}
+	nextRetryAt := now.Add(time.Minute)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(sqlPattern("UPDATE deliveries", "WHERE message_id = $5 AND target_key = $6 AND status != $7")).
+		WithArgs(models.StatusRetrying, "timeout", timeNear(now), sqlmock.AnyArg(), "msg-1", target.Key(), models.StatusCancelled).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(sqlPattern("UPDATE messages", "SET status = CASE", "RETURNING status")).
+		WithArgs("msg-1", "timeout", timeNear(now)).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("retrying"))
+	// The lease ends once no delivery of the message is due
+	mock.ExpectExec(sqlPattern(
+		"UPDATE messages SET locked_until = NULL",
+		"WHERE id = $1 AND NOT EXISTS",
+		"status IN ($2, $3) AND (next_retry_at IS NULL OR next_retry_at <= $4)",
+	)).
+		WithArgs("msg-1", models.StatusPending, models.StatusRetrying, timeNear(now)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	status, err := s.UpdateDeliveryStatus("msg-1", target, models.StatusRetrying, "timeout", nextRetryAt)
+	if err != nil || status != models.StatusRetrying {
+		t.Fatalf("UpdateDeliveryStatus = %s, %v, want retrying", status, err)
+	}
+
+	mock.ExpectExec(sqlPattern("UPDATE messages SET locked_until = NULL WHERE id = $1")).
+		WithArgs("msg-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := s.ReleaseMessage("msg-1"); err != nil {
+		t.Fatalf("ReleaseMessage: %v", err)
+	}
+}
+
+func TestPostgresMessageWhere(t *testing.T) {
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+
+	where, args := postgresMessageWhere(models.MessageFilter{})
+	if where != "" || args != nil {
+		t.Errorf("empty filter = %q %v, want no condition", where, args)
+	}
+
+	where, args = postgresMessageWhere(models.MessageFilter{
+		IDs:    []string{"msg-1", "msg-2"},
+		Path:   "/webhook/orders",
+		Queue:  "orders",
+		Status: models.StatusRetrying,
+		From:   from,
+		To:     to,
+	})
+	want := " WHERE id = ANY($1) AND path = $2 AND queue = $3 AND status = $4 AND timestamp >= $5 AND timestamp <= $6"
+	if where != want {
+		t.Errorf("where = %q, want %q", where, want)
+	}
+	if len(args) != 6 || args[1] != "/webhook/orders" || args[3] != models.StatusRetrying || args[5] != to {
+		t.Errorf("args = %v, want the filter values in order", args)
+	}
+}
+
+func TestPostgresDeadLetterWhere(t *testing.T) {
+	where, args := postgresDeadLetterWhere(models.DeadLetterFilter{Path: "/webhook/orders", ErrorPattern: `100%_\`})
+	want := ` WHERE path = $1 AND error COLLATE "C" ILIKE '%' || $2::text || '%' ESCAPE '\'`
+	if where != want {
+		t.Errorf("where = %q, want %q", where, want)
+	}
+	if len(args) != 2 || args[1] != `100\%\_\\` {
+		t.Errorf("args = %v, want the pattern with its wildcards escaped", args)
+	}
+}
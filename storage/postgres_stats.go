@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/expai/messagebridge/models"
+)
+
+// RecordAttempt stores a delivery attempt in the attempt history
+func (s *PostgresStorage) RecordAttempt(attempt *models.Attempt) error {
+	targetJSON, err := json.Marshal(attempt.Target)
+	if err != nil {
+		return fmt.Errorf("failed to marshal attempt target: %w", err)
+	}
+
+	response := attempt.Response
+	if len(response) > maxAttemptResponse {
+		response = response[:maxAttemptResponse]
+	}
+
+	var statusCode, partition, offset interface{}
+	if attempt.StatusCode != 0 {
+		statusCode = attempt.StatusCode
+	}
+	if attempt.Partition != nil {
+		partition = *attempt.Partition
+	}
+	if attempt.Offset != nil {
+		offset = *attempt.Offset
+	}
+
+	query := `
+	INSERT INTO attempts
+	(message_id, path, target_key, target, attempted_at, duration_ms, status_code, kafka_partition, kafka_offset, error, response)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	RETURNING id
+	`
+
+	return s.db.QueryRow(query,
+		attempt.MessageID, attempt.Path, attempt.Target.Key(), string(targetJSON), attempt.AttemptedAt, attempt.DurationMs,
+		statusCode, partition, offset, attempt.Error, response,
+	).Scan(&attempt.ID)
+}
+
+// GetAttempts returns the attempt history of a message, oldest first
+func (s *PostgresStorage) GetAttempts(messageID string) ([]*models.Attempt, error) {
+	rows, err := s.db.Query(`SELECT `+attemptColumns+` FROM attempts WHERE message_id = $1 ORDER BY id ASC`, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []*models.Attempt
+	for rows.Next() {
+		attempt, err := scanAttempt(rows)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+
+	return attempts, rows.Err()
+}
+
+// GetRouteStats returns per-route queue counts together with delivery
+// activity recorded in the attempt history since the given time
+func (s *PostgresStorage) GetRouteStats(since time.Time) ([]*models.RouteStats, error) {
+	routes := make(map[string]*models.RouteStats)
+	route := func(path string) *models.RouteStats {
+		if routes[path] == nil {
+			routes[path] = &models.RouteStats{Path: path}
+		}
+		return routes[path]
+	}
+
+	query := `
+	SELECT path, status, COUNT(*), MIN(timestamp)
+	FROM messages
+	WHERE status IN ($1, $2, $3)
+	GROUP BY path, status
+	`
+
+	rows, err := s.db.Query(query, models.StatusPending, models.StatusRetrying, models.StatusCancelled)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path string
+		var status models.MessageStatus
+		var count int
+		var oldest time.Time
+		if err := rows.Scan(&path, &status, &count, &oldest); err != nil {
+			return nil, err
+		}
+
+		stats := route(path)
+		switch status {
+		case models.StatusPending:
+			stats.Pending = count
+		case models.StatusRetrying:
+			stats.Retrying = count
+		case models.StatusCancelled:
+			stats.Cancelled = count
+			continue
+		}
+
+		if oldest = oldest.UTC(); stats.OldestPending.IsZero() || oldest.Before(stats.OldestPending) {
+			stats.OldestPending = oldest
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = s.db.Query(`SELECT path, COUNT(*) FROM dead_letters GROUP BY path`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path string
+		var count int
+		if err := rows.Scan(&path, &count); err != nil {
+			return nil, err
+		}
+		route(path).DeadLetters = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	query = `
+	SELECT path,
+	       COUNT(*) FILTER (WHERE COALESCE(error, '') = ''),
+	       COUNT(*) FILTER (WHERE COALESCE(error, '') != ''),
+	       COUNT(DISTINCT message_id),
+	       AVG(duration_ms)::float8
+	FROM attempts
+	WHERE attempted_at >= $1 AND COALESCE(path, '') != ''
+	GROUP BY path
+	`
+
+	rows, err = s.db.Query(query, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path string
+		var delivered, failed, messages int
+		var avgDuration float64
+		if err := rows.Scan(&path, &delivered, &failed, &messages, &avgDuration); err != nil {
+			return nil, err
+		}
+
+		stats := route(path)
+		stats.Delivered = delivered
+		stats.FailedAttempts = failed
+		stats.Messages = messages
+		stats.AvgDurationMs = math.Round(avgDuration*10) / 10
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]*models.RouteStats, 0, len(routes))
+	for _, stats := range routes {
+		result = append(result, stats)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+
+	return result, nil
+}
@@ -6,17 +6,21 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"time"
 
+	"github.com/expai/messagebridge/metrics"
 	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/retry"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 // SQLiteStorage represents SQLite storage for messages
 type SQLiteStorage struct {
-	db   *sql.DB
-	path string
+	db            *sql.DB
+	path          string
+	retryPolicies atomic.Pointer[retry.Policies]
 }
 
 // NewSQLiteStorage creates a new SQLite storage instance
@@ -27,7 +31,7 @@ func NewSQLiteStorage(dbPath string) (*SQLiteStorage, error) {
 		return nil, fmt.Errorf("failed to create database directory: %w", err)
 	}
 
-	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_synchronous=NORMAL&_cache_size=-64000")
+	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_synchronous=NORMAL&_cache_size=-64000&_busy_timeout=5000")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -36,6 +40,7 @@ func NewSQLiteStorage(dbPath string) (*SQLiteStorage, error) {
 		db:   db,
 		path: dbPath,
 	}
+	storage.retryPolicies.Store(retry.DefaultPolicies())
 
 	if err := storage.createTables(); err != nil {
 		db.Close()
@@ -45,6 +50,11 @@ func NewSQLiteStorage(dbPath string) (*SQLiteStorage, error) {
 	return storage, nil
 }
 
+// SetRetryPolicies sets the policies used to schedule retries of saved messages
+func (s *SQLiteStorage) SetRetryPolicies(policies *retry.Policies) {
+	s.retryPolicies.Store(policies)
+}
+
 // createTables creates the necessary tables
 func (s *SQLiteStorage) createTables() error {
 	query := `
@@ -66,9 +76,119 @@ func (s *SQLiteStorage) createTables() error {
 	CREATE INDEX IF NOT EXISTS idx_messages_status ON messages(status);
 	CREATE INDEX IF NOT EXISTS idx_messages_next_retry ON messages(next_retry_at);
 	CREATE INDEX IF NOT EXISTS idx_messages_queue ON messages(queue);
+
+	CREATE TABLE IF NOT EXISTS deliveries (
+		message_id TEXT NOT NULL,
+		target_key TEXT NOT NULL,
+		target TEXT NOT NULL,
+		status TEXT NOT NULL,
+		retries INTEGER DEFAULT 0,
+		error TEXT,
+		next_retry_at DATETIME,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (message_id, target_key)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_deliveries_status ON deliveries(status);
+
+	CREATE TABLE IF NOT EXISTS dead_letters (
+		id TEXT PRIMARY KEY,
+		path TEXT NOT NULL,
+		queue TEXT NOT NULL,
+		body BLOB NOT NULL,
+		headers TEXT NOT NULL,
+		timestamp DATETIME NOT NULL,
+		retries INTEGER DEFAULT 0,
+		error TEXT,
+		destinations TEXT,
+		ordering_key TEXT,
+		deliveries TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		failed_at DATETIME NOT NULL,
+		traceparent TEXT
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_dead_letters_path ON dead_letters(path);
+	CREATE INDEX IF NOT EXISTS idx_dead_letters_failed_at ON dead_letters(failed_at);
+
+	CREATE TABLE IF NOT EXISTS attempts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		message_id TEXT NOT NULL,
+		path TEXT,
+		target_key TEXT NOT NULL,
+		target TEXT NOT NULL,
+		attempted_at DATETIME NOT NULL,
+		duration_ms INTEGER NOT NULL,
+		status_code INTEGER,
+		kafka_partition INTEGER,
+		kafka_offset INTEGER,
+		error TEXT,
+		response TEXT
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_attempts_message_id ON attempts(message_id);
+	CREATE INDEX IF NOT EXISTS idx_attempts_attempted_at ON attempts(attempted_at);
 	`
 
-	_, err := s.db.Exec(query)
+	if _, err := s.db.Exec(query); err != nil {
+		return err
+	}
+
+	return s.migrate()
+}
+
+// migrate adds columns introduced after the initial schema to existing databases
+func (s *SQLiteStorage) migrate() error {
+	if err := s.addColumnIfMissing("messages", "destinations", "TEXT"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("messages", "ordering_key", "TEXT"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("attempts", "path", "TEXT"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("messages", "traceparent", "TEXT"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("dead_letters", "traceparent", "TEXT"); err != nil {
+		return err
+	}
+
+	_, err := s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_messages_ordering_key ON messages(ordering_key)`)
+	return err
+}
+
+// addColumnIfMissing adds a column to a table unless it already exists
+func (s *SQLiteStorage) addColumnIfMissing(table, column, definition string) error {
+	rows, err := s.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
 	return err
 }
 
@@ -79,41 +199,69 @@ func (s *SQLiteStorage) SaveMessage(msg *models.WebhookMessage) error {
 		return fmt.Errorf("failed to marshal headers: %w", err)
 	}
 
+	destinationsJSON, err := json.Marshal(msg.Destinations)
+	if err != nil {
+		return fmt.Errorf("failed to marshal destinations: %w", err)
+	}
+
 	query := `
 	INSERT OR REPLACE INTO messages 
-	(id, path, queue, body, headers, timestamp, retries, status, error, created_at, updated_at, next_retry_at)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	(id, path, queue, body, headers, timestamp, retries, status, error, created_at, updated_at, next_retry_at, destinations, ordering_key, traceparent)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
 	nextRetryAt := sql.NullTime{}
 	if msg.Status == models.StatusRetrying {
-		nextRetryAt = sql.NullTime{
-			Time:  time.Now().Add(time.Duration(msg.Retries*2) * time.Minute),
-			Valid: true,
-		}
+		nextRetryAt = retryTime(msg.Status, s.retryPolicies.Load().For(msg.Path).NextRetryAt(msg.Retries, time.Now()))
+	}
+
+	defer metrics.ObserveStorageWrite("save_message", time.Now())
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
 
-	_, err = s.db.Exec(query,
+	_, err = tx.Exec(query,
 		msg.ID, msg.Path, msg.Queue, msg.Body, string(headersJSON),
 		msg.Timestamp, msg.Retries, msg.Status, msg.Error,
-		msg.CreatedAt, msg.UpdatedAt, nextRetryAt,
+		msg.CreatedAt, msg.UpdatedAt, nextRetryAt, string(destinationsJSON), msg.OrderingKey, msg.TraceParent,
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	if err := insertDeliveries(tx, msg.ID, msg.Destinations); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-// GetPendingMessages retrieves messages that need retry
+// messageColumns lists the columns read by scanMessage
+const messageColumns = `id, path, queue, body, headers, timestamp, retries, status, error,
+	       created_at, updated_at, next_retry_at, destinations, ordering_key, traceparent`
+
+// GetPendingMessages retrieves messages that need retry. Messages waiting
+// behind an older undelivered message with the same ordering key are held
+// back until that message is delivered or fails permanently.
 func (s *SQLiteStorage) GetPendingMessages(limit int) ([]*models.PendingMessage, error) {
 	query := `
-	SELECT id, path, queue, body, headers, timestamp, retries, status, error, 
-	       created_at, updated_at, next_retry_at
-	FROM messages 
-	WHERE status IN (?, ?) AND (next_retry_at IS NULL OR next_retry_at <= ?)
+	SELECT ` + messageColumns + `
+	FROM messages m
+	WHERE status IN (?1, ?2) AND (next_retry_at IS NULL OR next_retry_at <= ?3)
+	  AND NOT EXISTS (
+		SELECT 1 FROM messages o
+		WHERE o.ordering_key = m.ordering_key AND o.ordering_key != ''
+		  AND o.status IN (?1, ?2) AND o.created_at < m.created_at
+		  AND o.next_retry_at > ?3
+	  )
 	ORDER BY created_at ASC
-	LIMIT ?
+	LIMIT ?4
 	`
 
-	rows, err := s.db.Query(query, models.StatusPending, models.StatusRetrying, time.Now(), limit)
+	rows, err := s.db.Query(query, models.StatusPending, models.StatusRetrying, time.Now().UTC(), limit)
 	if err != nil {
 		return nil, err
 	}
@@ -121,57 +269,110 @@ func (s *SQLiteStorage) GetPendingMessages(limit int) ([]*models.PendingMessage,
 
 	var messages []*models.PendingMessage
 	for rows.Next() {
-		msg := &models.PendingMessage{
-			WebhookMessage: &models.WebhookMessage{},
-		}
-
-		var headersJSON string
-		var nextRetryAt sql.NullTime
-
-		err := rows.Scan(
-			&msg.ID, &msg.Path, &msg.Queue, &msg.Body, &headersJSON,
-			&msg.Timestamp, &msg.Retries, &msg.Status, &msg.Error,
-			&msg.CreatedAt, &msg.UpdatedAt, &nextRetryAt,
-		)
+		msg, err := scanMessage(rows)
 		if err != nil {
 			return nil, err
 		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
 
-		if err := json.Unmarshal([]byte(headersJSON), &msg.Headers); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal headers: %w", err)
-		}
+	if err := s.loadPendingDeliveries(messages); err != nil {
+		return nil, fmt.Errorf("failed to load deliveries: %w", err)
+	}
+
+	return messages, nil
+}
+
+// ReleaseMessage does nothing: claimed messages are only leased when the
+// storage is shared by several instances
+func (s *SQLiteStorage) ReleaseMessage(id string) error {
+	return nil
+}
+
+// scanMessage scans a row selected with messageColumns
+func scanMessage(row rowScanner) (*models.PendingMessage, error) {
+	msg := &models.PendingMessage{
+		WebhookMessage: &models.WebhookMessage{},
+	}
 
-		if nextRetryAt.Valid {
-			msg.NextRetryAt = nextRetryAt.Time
+	var headersJSON string
+	var errMsg sql.NullString
+	var nextRetryAt sql.NullTime
+	var destinationsJSON sql.NullString
+	var orderingKey, traceParent sql.NullString
+
+	err := row.Scan(
+		&msg.ID, &msg.Path, &msg.Queue, &msg.Body, &headersJSON,
+		&msg.Timestamp, &msg.Retries, &msg.Status, &errMsg,
+		&msg.CreatedAt, &msg.UpdatedAt, &nextRetryAt, &destinationsJSON, &orderingKey, &traceParent,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(headersJSON), &msg.Headers); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal headers: %w", err)
+	}
+
+	// Messages stored before per-route destinations have none
+	if destinationsJSON.Valid && destinationsJSON.String != "" {
+		if err := json.Unmarshal([]byte(destinationsJSON.String), &msg.Destinations); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal destinations: %w", err)
 		}
+	}
 
-		messages = append(messages, msg)
+	if nextRetryAt.Valid {
+		msg.NextRetryAt = nextRetryAt.Time
 	}
+	msg.Error = errMsg.String
+	msg.OrderingKey = orderingKey.String
+	msg.TraceParent = traceParent.String
 
-	return messages, rows.Err()
+	return msg, nil
 }
 
-// UpdateMessageStatus updates message status
-func (s *SQLiteStorage) UpdateMessageStatus(id string, status models.MessageStatus, error string) error {
+// UpdateMessageStatus updates message status. nextRetryAt is only stored
+// for retrying messages.
+func (s *SQLiteStorage) UpdateMessageStatus(id string, status models.MessageStatus, error string, nextRetryAt time.Time) error {
 	query := `
 	UPDATE messages 
-	SET status = ?, error = ?, updated_at = ?, retries = retries + 1,
-	    next_retry_at = CASE 
-			WHEN ? = 'retrying' THEN datetime('now', '+' || (retries + 1) * 2 || ' minutes')
-			ELSE NULL 
-		END
+	SET status = ?, error = ?, updated_at = ?, retries = retries + 1, next_retry_at = ?
 	WHERE id = ?
 	`
 
-	_, err := s.db.Exec(query, status, error, time.Now(), status, id)
+	_, err := s.db.Exec(query, status, error, time.Now(), retryTime(status, nextRetryAt), id)
 	return err
 }
 
-// DeleteMessage removes a message from storage
+// retryTime returns the value stored in next_retry_at. Times are stored in
+// UTC so they compare correctly as text.
+func retryTime(status models.MessageStatus, nextRetryAt time.Time) sql.NullTime {
+	if status != models.StatusRetrying || nextRetryAt.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: nextRetryAt.UTC(), Valid: true}
+}
+
+// DeleteMessage removes a message and its deliveries from storage
 func (s *SQLiteStorage) DeleteMessage(id string) error {
-	query := `DELETE FROM messages WHERE id = ?`
-	_, err := s.db.Exec(query, id)
-	return err
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM deliveries WHERE message_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM messages WHERE id = ?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // GetMessageStats returns statistics about stored messages
@@ -210,9 +411,27 @@ func (s *SQLiteStorage) Close() error {
 func (s *SQLiteStorage) Cleanup(retentionDays int) error {
 	query := `
 	DELETE FROM messages 
-	WHERE status = ? AND created_at < datetime('now', '-' || ? || ' days')
+	WHERE status IN (?, ?) AND created_at < datetime('now', '-' || ? || ' days')
+	`
+
+	if _, err := s.db.Exec(query, models.StatusSent, models.StatusCancelled, retentionDays); err != nil {
+		return err
+	}
+
+	// Remove deliveries left behind by deleted messages
+	if _, err := s.db.Exec(`DELETE FROM deliveries WHERE message_id NOT IN (SELECT id FROM messages)`); err != nil {
+		return err
+	}
+
+	// Attempt history outlives delivered messages for the retention period,
+	// and is kept as long as the message is queued or dead-lettered
+	query = `
+	DELETE FROM attempts
+	WHERE attempted_at < ?
+	  AND message_id NOT IN (SELECT id FROM messages)
+	  AND message_id NOT IN (SELECT id FROM dead_letters)
 	`
 
-	_, err := s.db.Exec(query, models.StatusSent, retentionDays)
+	_, err := s.db.Exec(query, time.Now().AddDate(0, 0, -retentionDays).UTC())
 	return err
 }
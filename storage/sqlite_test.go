@@ -0,0 +1,19 @@
+package storage_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/expai/messagebridge/storage"
+	"github.com/expai/messagebridge/storage/storagetest"
+)
+
+func TestSQLiteStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		s, err := storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "messages.db"))
+		if err != nil {
+			t.Fatalf("NewSQLiteStorage: %v", err)
+		}
+		return s
+	})
+}
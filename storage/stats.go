@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/expai/messagebridge/models"
+)
+
+// GetRouteStats returns per-route queue counts together with delivery
+// activity recorded in the attempt history since the given time
+func (s *SQLiteStorage) GetRouteStats(since time.Time) ([]*models.RouteStats, error) {
+	routes := make(map[string]*models.RouteStats)
+	route := func(path string) *models.RouteStats {
+		if routes[path] == nil {
+			routes[path] = &models.RouteStats{Path: path}
+		}
+		return routes[path]
+	}
+
+	// Timestamps keep the zone offset they were stored with, so the oldest
+	// one is found by julianday rather than by text
+	query := `
+	SELECT path, status, COUNT(*), MIN(julianday(timestamp))
+	FROM messages
+	WHERE status IN (?, ?, ?)
+	GROUP BY path, status
+	`
+
+	rows, err := s.db.Query(query, models.StatusPending, models.StatusRetrying, models.StatusCancelled)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path string
+		var status models.MessageStatus
+		var count int
+		var oldest float64
+		if err := rows.Scan(&path, &status, &count, &oldest); err != nil {
+			return nil, err
+		}
+
+		stats := route(path)
+		switch status {
+		case models.StatusPending:
+			stats.Pending = count
+		case models.StatusRetrying:
+			stats.Retrying = count
+		case models.StatusCancelled:
+			stats.Cancelled = count
+			continue
+		}
+
+		received := julianTime(oldest)
+		if stats.OldestPending.IsZero() || received.Before(stats.OldestPending) {
+			stats.OldestPending = received
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = s.db.Query(`SELECT path, COUNT(*) FROM dead_letters GROUP BY path`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path string
+		var count int
+		if err := rows.Scan(&path, &count); err != nil {
+			return nil, err
+		}
+		route(path).DeadLetters = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	query = `
+	SELECT path,
+	       SUM(CASE WHEN COALESCE(error, '') = '' THEN 1 ELSE 0 END),
+	       SUM(CASE WHEN COALESCE(error, '') != '' THEN 1 ELSE 0 END),
+	       COUNT(DISTINCT message_id),
+	       AVG(duration_ms)
+	FROM attempts
+	WHERE attempted_at >= ? AND COALESCE(path, '') != ''
+	GROUP BY path
+	`
+
+	rows, err = s.db.Query(query, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path string
+		var delivered, failed, messages int
+		var avgDuration float64
+		if err := rows.Scan(&path, &delivered, &failed, &messages, &avgDuration); err != nil {
+			return nil, err
+		}
+
+		stats := route(path)
+		stats.Delivered = delivered
+		stats.FailedAttempts = failed
+		stats.Messages = messages
+		stats.AvgDurationMs = math.Round(avgDuration*10) / 10
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]*models.RouteStats, 0, len(routes))
+	for _, stats := range routes {
+		result = append(result, stats)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+
+	return result, nil
+}
+
+// julianTime converts a SQLite julian day number to a UTC time
+func julianTime(day float64) time.Time {
+	const unixEpoch = 2440587.5
+	return time.UnixMilli(int64(math.Round((day - unixEpoch) * 86400000))).UTC()
+}
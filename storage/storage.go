@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/retry"
+)
+
+// ErrNotFound is returned for unknown messages and dead letters. It is
+// sql.ErrNoRows so SQL backends can return the error of a missing row as is.
+var ErrNotFound = sql.ErrNoRows
+
+// Storage is the durable queue behind the handler, the worker and the admin
+// API. Every backend must pass the storagetest conformance suite.
+//
+// A message is saved with a pending delivery per destination. The message
+// status is derived from its deliveries: retrying while any delivery is
+// retrying, then pending, failed, cancelled, and sent once all were sent.
+type Storage interface {
+	// SaveMessage stores a received message with a pending delivery to
+	// each of its destinations
+	SaveMessage(msg *models.WebhookMessage) error
+
+	// GetPendingMessages claims up to limit messages that are due, oldest
+	// first, with their undelivered deliveries attached
+	GetPendingMessages(limit int) ([]*models.PendingMessage, error)
+
+	// ReleaseMessage hands a claimed message that will not be processed now
+	// back to the queue. Backends shared by several instances lease claimed
+	// messages; the others need not do anything.
+	ReleaseMessage(id string) error
+
+	// AddDeliveries creates pending deliveries for a message that has none
+	AddDeliveries(messageID string, targets []models.DeliveryTarget) ([]*models.Delivery, error)
+
+	// GetDeliveries returns all deliveries of a message
+	GetDeliveries(messageID string) ([]*models.Delivery, error)
+
+	// UpdateDeliveryStatus records the outcome of a delivery attempt and
+	// returns the resulting status of the message
+	UpdateDeliveryStatus(messageID string, target models.DeliveryTarget, status models.MessageStatus, errMsg string, nextRetryAt time.Time) (models.MessageStatus, error)
+
+	// DeleteMessage removes a message and its deliveries
+	DeleteMessage(id string) error
+
+	// ListMessages, CountMessages and GetMessage inspect the queue
+	ListMessages(filter models.MessageFilter, limit, offset int) ([]*models.PendingMessage, error)
+	CountMessages(filter models.MessageFilter) (int, error)
+	GetMessage(id string) (*models.PendingMessage, error)
+
+	// ListMessagesAfter returns up to limit messages matching filter that
+	// come after the message created at createdAt with ID id, oldest first.
+	// Paging from the zero time with the last message returned visits every
+	// message once, even while messages are added or deleted.
+	ListMessagesAfter(filter models.MessageFilter, createdAt time.Time, id string, limit int) ([]*models.PendingMessage, error)
+
+	// RetryMessage, CancelMessage and RequeueMessages change the deliveries
+	// of queued messages on behalf of an operator
+	RetryMessage(id string) (models.MessageStatus, error)
+	CancelMessage(id string) (models.MessageStatus, error)
+	RequeueMessages(filter models.MessageFilter) ([]string, error)
+
+	// ImportMessage stores a message exported from another instance
+	ImportMessage(msg *models.PendingMessage) (bool, error)
+
+	// MoveToDeadLetter moves a message from the queue to the dead-letter queue
+	MoveToDeadLetter(id string) (*models.DeadLetter, error)
+
+	// GetFailedMessageIDs returns failed messages still in the queue
+	GetFailedMessageIDs() ([]string, error)
+
+	// ListDeadLetters, CountDeadLetters, GetDeadLetter, ReplayDeadLetters and
+	// DeleteDeadLetters manage the dead-letter queue
+	ListDeadLetters(filter models.DeadLetterFilter, limit, offset int) ([]*models.DeadLetter, error)
+	CountDeadLetters(filter models.DeadLetterFilter) (int, error)
+	GetDeadLetter(id string) (*models.DeadLetter, error)
+	ReplayDeadLetters(filter models.DeadLetterFilter) ([]string, error)
+	DeleteDeadLetters(filter models.DeadLetterFilter) (int, error)
+
+	// RecordAttempt and GetAttempts maintain the attempt history
+	RecordAttempt(attempt *models.Attempt) error
+	GetAttempts(messageID string) ([]*models.Attempt, error)
+
+	// GetMessageStats returns the number of queued messages by status
+	GetMessageStats() (map[string]int, error)
+
+	// GetRouteStats returns per-route queue counts and delivery activity
+	// since the given time
+	GetRouteStats(since time.Time) ([]*models.RouteStats, error)
+
+	// Cleanup removes delivered and cancelled messages older than the
+	// retention period, and the attempt history of messages no longer stored
+	Cleanup(retentionDays int) error
+
+	// SetRetryPolicies sets the policies used to schedule retries of saved messages
+	SetRetryPolicies(policies *retry.Policies)
+
+	// Close releases the resources of the backend
+	Close() error
+}
+
+// Backends implement Storage
+var (
+	_ Storage = (*SQLiteStorage)(nil)
+	_ Storage = (*MemoryStorage)(nil)
+	_ Storage = (*PostgresStorage)(nil)
+)
+
+// Open opens the storage backend selected in the configuration
+func Open(cfg *config.Config) (Storage, error) {
+	switch cfg.StorageBackend() {
+	case "postgres":
+		store, err := NewPostgresStorage(cfg.Postgres)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case "sqlite":
+		store, err := NewSQLiteStorage(cfg.SQLite.DatabasePath)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("no storage is configured")
+	}
+}
@@ -0,0 +1,988 @@
+// Package storagetest is the conformance suite every storage backend must
+// pass. Backends run it from their own tests:
+//
+//	func TestMemoryStorage(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) storage.Storage {
+//			return storage.NewMemoryStorage()
+//		})
+//	}
+//
+// The suite describes the behavior the handler, the worker, the admin API
+// and the command-line tools rely on. Backends that several instances share
+// also run RunLeasing.
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/retry"
+	"github.com/expai/messagebridge/storage"
+)
+
+// Factory opens an empty storage for a single test. The suite closes it
+// when the test finishes.
+type Factory func(t *testing.T) storage.Storage
+
+// Run runs the conformance suite against the backend opened by open
+func Run(t *testing.T, open Factory) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s storage.Storage)
+	}{
+		{"SaveAndGet", testSaveAndGet},
+		{"NotFound", testNotFound},
+		{"PendingMessages", testPendingMessages},
+		{"RetrySchedule", testRetrySchedule},
+		{"RetryPolicyReload", testRetryPolicyReload},
+		{"OrderingKey", testOrderingKey},
+		{"DeliveryStatus", testDeliveryStatus},
+		{"FailedDelivery", testFailedDelivery},
+		{"CancelledDelivery", testCancelledDelivery},
+		{"RetryAndCancel", testRetryAndCancel},
+		{"Requeue", testRequeue},
+		{"ListAndCount", testListAndCount},
+		{"ListAfter", testListAfter},
+		{"Import", testImport},
+		{"DeadLetters", testDeadLetters},
+		{"FailedMessageIDs", testFailedMessageIDs},
+		{"Attempts", testAttempts},
+		{"Stats", testStats},
+		{"Cleanup", testCleanup},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := open(t)
+			t.Cleanup(func() { s.Close() })
+			test.run(t, s)
+		})
+	}
+}
+
+// RunLeasing checks that claimed messages are leased, so instances sharing
+// the backend opened by open never deliver a message at the same time
+func RunLeasing(t *testing.T, open Factory) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s storage.Storage)
+	}{
+		{"Claim", testLeaseClaim},
+		{"LeaseEnd", testLeaseEnd},
+		{"LeasedOrderingKey", testLeasedOrderingKey},
+		{"ConcurrentClaims", testConcurrentClaims},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := open(t)
+			t.Cleanup(func() { s.Close() })
+			test.run(t, s)
+		})
+	}
+}
+
+var (
+	kafkaTarget = models.DeliveryTarget{Type: models.TargetKafka, Topic: "payments"}
+	urlTarget   = models.DeliveryTarget{Type: models.TargetRemoteURL, URL: "https://example.com/hook"}
+)
+
+// newMessage returns a pending message of path received age ago
+func newMessage(id, path string, age time.Duration, targets ...models.DeliveryTarget) *models.WebhookMessage {
+	received := time.Now().UTC().Add(-age).Truncate(time.Millisecond)
+	return &models.WebhookMessage{
+		ID:           id,
+		Path:         path,
+		Queue:        strings.TrimPrefix(path, "/"),
+		Body:         []byte(`{"id":"` + id + `"}`),
+		Headers:      map[string]string{"Content-Type": "application/json"},
+		Timestamp:    received,
+		Status:       models.StatusPending,
+		Destinations: targets,
+		OrderingKey:  id,
+		TraceParent:  "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		CreatedAt:    received,
+		UpdatedAt:    received,
+	}
+}
+
+// save stores messages and fails the test on error
+func save(t *testing.T, s storage.Storage, messages ...*models.WebhookMessage) {
+	t.Helper()
+	for _, msg := range messages {
+		if err := s.SaveMessage(msg); err != nil {
+			t.Fatalf("SaveMessage(%s): %v", msg.ID, err)
+		}
+	}
+}
+
+// update records a delivery outcome and checks the resulting message status
+func update(t *testing.T, s storage.Storage, id string, target models.DeliveryTarget, status models.MessageStatus, errMsg string, nextRetryAt time.Time, want models.MessageStatus) {
+	t.Helper()
+	got, err := s.UpdateDeliveryStatus(id, target, status, errMsg, nextRetryAt)
+	if err != nil {
+		t.Fatalf("UpdateDeliveryStatus(%s, %s): %v", id, status, err)
+	}
+	if got != want {
+		t.Fatalf("UpdateDeliveryStatus(%s, %s) = %s, want %s", id, status, got, want)
+	}
+}
+
+// get returns a stored message and fails the test on error
+func get(t *testing.T, s storage.Storage, id string) *models.PendingMessage {
+	t.Helper()
+	msg, err := s.GetMessage(id)
+	if err != nil {
+		t.Fatalf("GetMessage(%s): %v", id, err)
+	}
+	return msg
+}
+
+// pendingIDs returns the IDs of the messages GetPendingMessages returns and
+// releases them again, so backends that lease claimed messages return them
+// on the next call
+func pendingIDs(t *testing.T, s storage.Storage, limit int) []string {
+	t.Helper()
+	messages := claim(t, s, limit)
+	ids := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		ids = append(ids, msg.ID)
+		release(t, s, msg.ID)
+	}
+	return ids
+}
+
+// claim calls GetPendingMessages and fails the test on error
+func claim(t *testing.T, s storage.Storage, limit int) []*models.PendingMessage {
+	t.Helper()
+	messages, err := s.GetPendingMessages(limit)
+	if err != nil {
+		t.Fatalf("GetPendingMessages: %v", err)
+	}
+	return messages
+}
+
+// release hands a claimed message back and fails the test on error
+func release(t *testing.T, s storage.Storage, id string) {
+	t.Helper()
+	if err := s.ReleaseMessage(id); err != nil {
+		t.Fatalf("ReleaseMessage(%s): %v", id, err)
+	}
+}
+
+// checkIDs fails the test unless got and want hold the same IDs in order
+func checkIDs(t *testing.T, what string, got, want []string) {
+	t.Helper()
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("%s = %v, want %v", what, got, want)
+	}
+}
+
+func testSaveAndGet(t *testing.T, s storage.Storage) {
+	msg := newMessage("msg-1", "/webhook/payment", time.Minute, kafkaTarget, urlTarget)
+	save(t, s, msg)
+
+	got := get(t, s, msg.ID)
+	switch {
+	case got.Path != msg.Path || got.Queue != msg.Queue:
+		t.Errorf("route = %s %s, want %s %s", got.Path, got.Queue, msg.Path, msg.Queue)
+	case string(got.Body) != string(msg.Body):
+		t.Errorf("body = %q, want %q", got.Body, msg.Body)
+	case got.Headers["Content-Type"] != "application/json":
+		t.Errorf("headers = %v", got.Headers)
+	case !got.Timestamp.Equal(msg.Timestamp) || !got.CreatedAt.Equal(msg.CreatedAt):
+		t.Errorf("times = %v %v, want %v %v", got.Timestamp, got.CreatedAt, msg.Timestamp, msg.CreatedAt)
+	case got.Status != models.StatusPending:
+		t.Errorf("status = %s, want pending", got.Status)
+	case got.OrderingKey != msg.OrderingKey || got.TraceParent != msg.TraceParent:
+		t.Errorf("ordering key and trace = %q %q", got.OrderingKey, got.TraceParent)
+	case len(got.Destinations) != 2 || got.Destinations[1] != urlTarget:
+		t.Errorf("destinations = %v", got.Destinations)
+	}
+
+	if len(got.Deliveries) != 2 {
+		t.Fatalf("deliveries = %d, want 2", len(got.Deliveries))
+	}
+	for i, target := range []models.DeliveryTarget{kafkaTarget, urlTarget} {
+		delivery := got.Deliveries[i]
+		if delivery.Target != target || delivery.Status != models.StatusPending || delivery.MessageID != msg.ID {
+			t.Errorf("delivery %d = %+v, want pending delivery to %s", i, delivery, target.Key())
+		}
+	}
+
+	// Saving again keeps the state of existing deliveries
+	update(t, s, msg.ID, kafkaTarget, models.StatusSent, "", time.Time{}, models.StatusPending)
+	save(t, s, msg)
+	deliveries, err := s.GetDeliveries(msg.ID)
+	if err != nil {
+		t.Fatalf("GetDeliveries: %v", err)
+	}
+	if len(deliveries) != 2 || deliveries[0].Status != models.StatusSent {
+		t.Errorf("deliveries after saving again = %+v", deliveries)
+	}
+
+	if err := s.DeleteMessage(msg.ID); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	if _, err := s.GetMessage(msg.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetMessage after delete = %v, want ErrNotFound", err)
+	}
+}
+
+func testNotFound(t *testing.T, s storage.Storage) {
+	if _, err := s.GetMessage("missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetMessage = %v, want ErrNotFound", err)
+	}
+	if _, err := s.RetryMessage("missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("RetryMessage = %v, want ErrNotFound", err)
+	}
+	if _, err := s.CancelMessage("missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("CancelMessage = %v, want ErrNotFound", err)
+	}
+	if _, err := s.MoveToDeadLetter("missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("MoveToDeadLetter = %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetDeadLetter("missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetDeadLetter = %v, want ErrNotFound", err)
+	}
+	if err := s.DeleteMessage("missing"); err != nil {
+		t.Errorf("DeleteMessage = %v, want nil", err)
+	}
+}
+
+func testPendingMessages(t *testing.T, s storage.Storage) {
+	save(t, s,
+		newMessage("msg-2", "/a", 2*time.Minute, kafkaTarget, urlTarget),
+		newMessage("msg-1", "/a", 3*time.Minute, kafkaTarget),
+		newMessage("msg-3", "/b", time.Minute, urlTarget),
+	)
+
+	checkIDs(t, "pending", pendingIDs(t, s, 10), []string{"msg-1", "msg-2", "msg-3"})
+	checkIDs(t, "pending with limit", pendingIDs(t, s, 2), []string{"msg-1", "msg-2"})
+
+	// Only undelivered deliveries are attached
+	update(t, s, "msg-2", kafkaTarget, models.StatusSent, "", time.Time{}, models.StatusPending)
+	for _, msg := range claim(t, s, 10) {
+		release(t, s, msg.ID)
+		if msg.ID != "msg-2" {
+			continue
+		}
+		if len(msg.Deliveries) != 1 || msg.Deliveries[0].Target != urlTarget {
+			t.Errorf("deliveries of msg-2 = %+v, want the remote URL only", msg.Deliveries)
+		}
+	}
+
+	// Delivered messages are no longer pending
+	update(t, s, "msg-3", urlTarget, models.StatusSent, "", time.Time{}, models.StatusSent)
+	checkIDs(t, "pending after delivery", pendingIDs(t, s, 10), []string{"msg-1", "msg-2"})
+}
+
+func testRetrySchedule(t *testing.T, s storage.Storage) {
+	save(t, s, newMessage("msg-1", "/a", time.Minute, kafkaTarget, urlTarget))
+
+	later := time.Now().Add(time.Hour)
+	update(t, s, "msg-1", kafkaTarget, models.StatusRetrying, "broker down", later, models.StatusRetrying)
+
+	// The remote URL delivery is still due
+	checkIDs(t, "pending", pendingIDs(t, s, 10), []string{"msg-1"})
+
+	update(t, s, "msg-1", urlTarget, models.StatusRetrying, "timeout", later.Add(time.Hour), models.StatusRetrying)
+	checkIDs(t, "pending with both deliveries scheduled", pendingIDs(t, s, 10), nil)
+
+	msg := get(t, s, "msg-1")
+	if msg.Retries != 1 || msg.Error != "timeout" {
+		t.Errorf("retries and error = %d %q, want 1 %q", msg.Retries, msg.Error, "timeout")
+	}
+	if msg.NextRetryAt.Sub(later).Abs() > time.Second {
+		t.Errorf("next retry = %v, want the earliest delivery retry %v", msg.NextRetryAt, later)
+	}
+
+	// Retries that are due are returned again
+	update(t, s, "msg-1", kafkaTarget, models.StatusRetrying, "broker down", time.Now().Add(-time.Second), models.StatusRetrying)
+	checkIDs(t, "pending with a due retry", pendingIDs(t, s, 10), []string{"msg-1"})
+	if msg := get(t, s, "msg-1"); msg.Retries != 2 {
+		t.Errorf("retries = %d, want the highest delivery retries 2", msg.Retries)
+	}
+}
+
+func testRetryPolicyReload(t *testing.T, s storage.Storage) {
+	policy := func(delay time.Duration) *retry.Policy {
+		return retry.NewPolicy(config.RetryPolicyConfig{InitialDelay: delay, Multiplier: 1})
+	}
+	policies := func(delay time.Duration) *retry.Policies {
+		return &retry.Policies{Default: policy(delay), Routes: map[string]*retry.Policy{"/b": policy(delay / 2)}}
+	}
+
+	// The worker replaces the policies on reload while messages are saved
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := range 200 {
+			s.SetRetryPolicies(policies(time.Duration(i%2+1) * time.Hour))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := range 50 {
+			msg := newMessage(fmt.Sprintf("msg-%02d", i), "/a", time.Minute, kafkaTarget)
+			msg.Status = models.StatusRetrying
+			if err := s.SaveMessage(msg); err != nil {
+				t.Errorf("SaveMessage(%s): %v", msg.ID, err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	// Messages saved afterwards are scheduled with the last policies, using
+	// the override of their route
+	s.SetRetryPolicies(policies(4 * time.Hour))
+	for path, delay := range map[string]time.Duration{"/a": 4 * time.Hour, "/b": 2 * time.Hour} {
+		msg := newMessage("msg-last"+path, path, time.Minute, kafkaTarget)
+		msg.Status = models.StatusRetrying
+		save(t, s, msg)
+
+		want := time.Now().Add(delay)
+		if got := get(t, s, msg.ID).NextRetryAt; got.Sub(want).Abs() > 5*time.Second {
+			t.Errorf("%s next retry = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func testOrderingKey(t *testing.T, s storage.Storage) {
+	first := newMessage("msg-1", "/a", 3*time.Minute, kafkaTarget)
+	second := newMessage("msg-2", "/a", 2*time.Minute, kafkaTarget)
+	other := newMessage("msg-3", "/a", time.Minute, kafkaTarget)
+	first.OrderingKey = "order-1"
+	second.OrderingKey = "order-1"
+	other.OrderingKey = "order-2"
+	save(t, s, first, second, other)
+
+	checkIDs(t, "pending", pendingIDs(t, s, 10), []string{"msg-1", "msg-2", "msg-3"})
+
+	// A later message waits while an older one with the same key is scheduled
+	update(t, s, "msg-1", kafkaTarget, models.StatusRetrying, "broker down", time.Now().Add(time.Hour), models.StatusRetrying)
+	checkIDs(t, "pending while msg-1 waits", pendingIDs(t, s, 10), []string{"msg-3"})
+
+	update(t, s, "msg-1", kafkaTarget, models.StatusSent, "", time.Time{}, models.StatusSent)
+	checkIDs(t, "pending after msg-1", pendingIDs(t, s, 10), []string{"msg-2", "msg-3"})
+}
+
+func testDeliveryStatus(t *testing.T, s storage.Storage) {
+	save(t, s, newMessage("msg-1", "/a", time.Minute, kafkaTarget, urlTarget))
+
+	update(t, s, "msg-1", kafkaTarget, models.StatusSent, "", time.Time{}, models.StatusPending)
+	update(t, s, "msg-1", urlTarget, models.StatusRetrying, "503", time.Now().Add(-time.Second), models.StatusRetrying)
+	update(t, s, "msg-1", urlTarget, models.StatusSent, "", time.Time{}, models.StatusSent)
+
+	msg := get(t, s, "msg-1")
+	if msg.Status != models.StatusSent || msg.Retries != 2 {
+		t.Errorf("status and retries = %s %d, want sent 2", msg.Status, msg.Retries)
+	}
+	for _, delivery := range msg.Deliveries {
+		if delivery.Status != models.StatusSent || !delivery.NextRetryAt.IsZero() {
+			t.Errorf("delivery %s = %s next %v, want sent", delivery.Target.Key(), delivery.Status, delivery.NextRetryAt)
+		}
+	}
+	if msg.Deliveries[1].Retries != 2 || msg.Deliveries[1].Error != "" {
+		t.Errorf("remote URL delivery = %+v, want 2 retries without error", msg.Deliveries[1])
+	}
+}
+
+func testFailedDelivery(t *testing.T, s storage.Storage) {
+	save(t, s, newMessage("msg-1", "/a", time.Minute, kafkaTarget, urlTarget))
+
+	update(t, s, "msg-1", kafkaTarget, models.StatusFailed, "Exceeded max retries", time.Time{}, models.StatusPending)
+	update(t, s, "msg-1", urlTarget, models.StatusSent, "", time.Time{}, models.StatusFailed)
+
+	if msg := get(t, s, "msg-1"); msg.Error != "Exceeded max retries" {
+		t.Errorf("error = %q, want the failed delivery error", msg.Error)
+	}
+	checkIDs(t, "pending", pendingIDs(t, s, 10), nil)
+}
+
+func testCancelledDelivery(t *testing.T, s storage.Storage) {
+	save(t, s, newMessage("msg-1", "/a", time.Minute, kafkaTarget))
+
+	status, err := s.CancelMessage("msg-1")
+	if err != nil || status != models.StatusCancelled {
+		t.Fatalf("CancelMessage = %s, %v, want cancelled", status, err)
+	}
+
+	// An attempt that was in flight does not undo the cancellation
+	update(t, s, "msg-1", kafkaTarget, models.StatusSent, "", time.Time{}, models.StatusCancelled)
+	checkIDs(t, "pending", pendingIDs(t, s, 10), nil)
+}
+
+func testRetryAndCancel(t *testing.T, s storage.Storage) {
+	save(t, s, newMessage("msg-1", "/a", time.Minute, kafkaTarget, urlTarget))
+	update(t, s, "msg-1", kafkaTarget, models.StatusSent, "", time.Time{}, models.StatusPending)
+	update(t, s, "msg-1", urlTarget, models.StatusRetrying, "503", time.Now().Add(time.Hour), models.StatusRetrying)
+
+	status, err := s.RetryMessage("msg-1")
+	if err != nil || status != models.StatusRetrying {
+		t.Fatalf("RetryMessage = %s, %v, want retrying", status, err)
+	}
+	checkIDs(t, "pending after retry", pendingIDs(t, s, 10), []string{"msg-1"})
+
+	status, err = s.CancelMessage("msg-1")
+	if err != nil || status != models.StatusCancelled {
+		t.Fatalf("CancelMessage = %s, %v, want cancelled", status, err)
+	}
+	if _, err := s.CancelMessage("msg-1"); !errors.Is(err, storage.ErrNothingChanged) {
+		t.Errorf("CancelMessage again = %v, want ErrNothingChanged", err)
+	}
+
+	// Cancelled deliveries can be retried, delivered ones stay delivered
+	status, err = s.RetryMessage("msg-1")
+	if err != nil || status != models.StatusPending {
+		t.Fatalf("RetryMessage after cancel = %s, %v, want pending", status, err)
+	}
+	msg := get(t, s, "msg-1")
+	if msg.Deliveries[0].Status != models.StatusSent || msg.Deliveries[1].Retries != 1 {
+		t.Errorf("deliveries = %+v %+v", msg.Deliveries[0], msg.Deliveries[1])
+	}
+
+	update(t, s, "msg-1", urlTarget, models.StatusSent, "", time.Time{}, models.StatusSent)
+	if _, err := s.RetryMessage("msg-1"); !errors.Is(err, storage.ErrNothingChanged) {
+		t.Errorf("RetryMessage of a delivered message = %v, want ErrNothingChanged", err)
+	}
+}
+
+func testRequeue(t *testing.T, s storage.Storage) {
+	save(t, s,
+		newMessage("msg-1", "/a", 2*time.Hour, kafkaTarget, urlTarget),
+		newMessage("msg-2", "/a", time.Hour, kafkaTarget),
+		newMessage("msg-3", "/b", time.Hour, kafkaTarget),
+	)
+	update(t, s, "msg-1", kafkaTarget, models.StatusSent, "", time.Time{}, models.StatusPending)
+	update(t, s, "msg-1", urlTarget, models.StatusRetrying, "503", time.Now().Add(time.Hour), models.StatusRetrying)
+	update(t, s, "msg-2", kafkaTarget, models.StatusSent, "", time.Time{}, models.StatusSent)
+
+	requeued, err := s.RequeueMessages(models.MessageFilter{Path: "/a"})
+	if err != nil {
+		t.Fatalf("RequeueMessages: %v", err)
+	}
+	checkIDs(t, "requeued", requeued, []string{"msg-1"})
+
+	msg := get(t, s, "msg-1")
+	switch {
+	case msg.Status != models.StatusPending || msg.Error != "":
+		t.Errorf("message = %s error %q, want pending without error", msg.Status, msg.Error)
+	case time.Since(msg.CreatedAt) > time.Minute:
+		t.Errorf("created at = %v, want now", msg.CreatedAt)
+	case msg.Deliveries[0].Status != models.StatusSent || msg.Deliveries[1].Status != models.StatusPending:
+		t.Errorf("deliveries = %s %s, want sent pending", msg.Deliveries[0].Status, msg.Deliveries[1].Status)
+	case msg.Deliveries[1].Retries != 0 || !msg.Deliveries[1].NextRetryAt.IsZero():
+		t.Errorf("requeued delivery = %+v, want no retries", msg.Deliveries[1])
+	}
+}
+
+func testListAndCount(t *testing.T, s storage.Storage) {
+	old := newMessage("msg-1", "/a", 3*time.Hour, kafkaTarget)
+	recent := newMessage("msg-2", "/a", time.Hour, kafkaTarget)
+	other := newMessage("msg-3", "/b", 2*time.Hour, urlTarget)
+	save(t, s, old, recent, other)
+	update(t, s, "msg-3", urlTarget, models.StatusRetrying, "503", time.Now().Add(time.Hour), models.StatusRetrying)
+
+	tests := []struct {
+		filter models.MessageFilter
+		want   []string
+	}{
+		{models.MessageFilter{}, []string{"msg-2", "msg-3", "msg-1"}},
+		{models.MessageFilter{Path: "/a"}, []string{"msg-2", "msg-1"}},
+		{models.MessageFilter{Queue: "b"}, []string{"msg-3"}},
+		{models.MessageFilter{Status: models.StatusRetrying}, []string{"msg-3"}},
+		{models.MessageFilter{IDs: []string{"msg-1", "msg-3"}}, []string{"msg-3", "msg-1"}},
+		{models.MessageFilter{From: time.Now().Add(-150 * time.Minute)}, []string{"msg-2", "msg-3"}},
+		{models.MessageFilter{To: time.Now().Add(-90 * time.Minute)}, []string{"msg-3", "msg-1"}},
+	}
+
+	for _, test := range tests {
+		messages, err := s.ListMessages(test.filter, 10, 0)
+		if err != nil {
+			t.Fatalf("ListMessages(%+v): %v", test.filter, err)
+		}
+		var ids []string
+		for _, msg := range messages {
+			ids = append(ids, msg.ID)
+		}
+		checkIDs(t, "ListMessages", ids, test.want)
+
+		count, err := s.CountMessages(test.filter)
+		if err != nil {
+			t.Fatalf("CountMessages(%+v): %v", test.filter, err)
+		}
+		if count != len(test.want) {
+			t.Errorf("CountMessages(%+v) = %d, want %d", test.filter, count, len(test.want))
+		}
+	}
+
+	messages, err := s.ListMessages(models.MessageFilter{}, 1, 1)
+	if err != nil {
+		t.Fatalf("ListMessages with offset: %v", err)
+	}
+	if len(messages) != 1 || messages[0].ID != "msg-3" {
+		t.Errorf("ListMessages(limit 1, offset 1) = %v, want msg-3", messages)
+	}
+}
+
+func testListAfter(t *testing.T, s storage.Storage) {
+	// msg-b and msg-c are received at the same time and ordered by ID
+	first := newMessage("msg-d", "/a", 3*time.Hour, kafkaTarget)
+	tieB := newMessage("msg-b", "/a", 2*time.Hour, kafkaTarget)
+	tieC := newMessage("msg-c", "/b", 2*time.Hour, kafkaTarget)
+	tieC.CreatedAt, tieC.Timestamp = tieB.CreatedAt, tieB.Timestamp
+	last := newMessage("msg-a", "/a", time.Hour, kafkaTarget)
+	save(t, s, last, tieC, first, tieB)
+
+	// listAll pages through the messages matching filter two at a time and
+	// calls between after each page
+	listAll := func(filter models.MessageFilter, between func()) []string {
+		var ids []string
+		var createdAt time.Time
+		var id string
+		for {
+			messages, err := s.ListMessagesAfter(filter, createdAt, id, 2)
+			if err != nil {
+				t.Fatalf("ListMessagesAfter(%+v): %v", filter, err)
+			}
+			if len(messages) == 0 {
+				return ids
+			}
+			for _, msg := range messages {
+				ids = append(ids, msg.ID)
+			}
+			createdAt, id = messages[len(messages)-1].CreatedAt, messages[len(messages)-1].ID
+			between()
+		}
+	}
+
+	checkIDs(t, "ListMessagesAfter", listAll(models.MessageFilter{}, func() {}), []string{"msg-d", "msg-b", "msg-c", "msg-a"})
+	checkIDs(t, "ListMessagesAfter route /a", listAll(models.MessageFilter{Path: "/a"}, func() {}), []string{"msg-d", "msg-b", "msg-a"})
+
+	// Deleting listed messages does not shift the following pages
+	deleted := false
+	got := listAll(models.MessageFilter{}, func() {
+		if !deleted {
+			deleted = true
+			for _, id := range []string{"msg-d", "msg-b"} {
+				if err := s.DeleteMessage(id); err != nil {
+					t.Fatalf("DeleteMessage(%s): %v", id, err)
+				}
+			}
+		}
+	})
+	checkIDs(t, "ListMessagesAfter with deletes", got, []string{"msg-d", "msg-b", "msg-c", "msg-a"})
+}
+
+func testImport(t *testing.T, s storage.Storage) {
+	exported := &models.PendingMessage{
+		WebhookMessage: newMessage("msg-1", "/a", time.Hour, kafkaTarget, urlTarget),
+		Deliveries: []*models.Delivery{
+			{MessageID: "msg-1", Target: kafkaTarget, Status: models.StatusSent, Retries: 1, UpdatedAt: time.Now().UTC()},
+			{MessageID: "msg-1", Target: urlTarget, Status: models.StatusRetrying, Retries: 3, Error: "503",
+				NextRetryAt: time.Now().Add(-time.Second).UTC(), UpdatedAt: time.Now().UTC()},
+		},
+	}
+
+	imported, err := s.ImportMessage(exported)
+	if err != nil || !imported {
+		t.Fatalf("ImportMessage = %v, %v, want true", imported, err)
+	}
+
+	msg := get(t, s, "msg-1")
+	if msg.Status != models.StatusRetrying || msg.Retries != 3 {
+		t.Errorf("status and retries = %s %d, want retrying 3", msg.Status, msg.Retries)
+	}
+	if len(msg.Deliveries) != 2 || msg.Deliveries[0].Status != models.StatusSent || msg.Deliveries[1].Error != "503" {
+		t.Errorf("deliveries = %+v", msg.Deliveries)
+	}
+	checkIDs(t, "pending", pendingIDs(t, s, 10), []string{"msg-1"})
+
+	imported, err = s.ImportMessage(exported)
+	if err != nil || imported {
+		t.Errorf("ImportMessage again = %v, %v, want false", imported, err)
+	}
+
+	// Messages exported without deliveries are delivered to their destinations
+	bare := &models.PendingMessage{WebhookMessage: newMessage("msg-2", "/a", time.Minute, kafkaTarget)}
+	if imported, err := s.ImportMessage(bare); err != nil || !imported {
+		t.Fatalf("ImportMessage without deliveries = %v, %v, want true", imported, err)
+	}
+	if msg := get(t, s, "msg-2"); len(msg.Deliveries) != 1 || msg.Deliveries[0].Status != models.StatusPending {
+		t.Errorf("deliveries = %+v, want a pending delivery", msg.Deliveries)
+	}
+}
+
+func testDeadLetters(t *testing.T, s storage.Storage) {
+	save(t, s,
+		newMessage("msg-1", "/a", 2*time.Minute, kafkaTarget, urlTarget),
+		newMessage("msg-2", "/b", time.Minute, kafkaTarget),
+	)
+	update(t, s, "msg-1", kafkaTarget, models.StatusSent, "", time.Time{}, models.StatusPending)
+	update(t, s, "msg-1", urlTarget, models.StatusFailed, "Exceeded max retries: 503", time.Time{}, models.StatusFailed)
+	update(t, s, "msg-2", kafkaTarget, models.StatusFailed, "Exceeded max retries: broker down (Überlauf, 100% of queue_size)", time.Time{}, models.StatusFailed)
+
+	for _, id := range []string{"msg-1", "msg-2"} {
+		deadLetter, err := s.MoveToDeadLetter(id)
+		if err != nil {
+			t.Fatalf("MoveToDeadLetter(%s): %v", id, err)
+		}
+		if deadLetter.ID != id || deadLetter.FailedAt.IsZero() {
+			t.Errorf("dead letter = %s failed at %v", deadLetter.ID, deadLetter.FailedAt)
+		}
+		if _, err := s.GetMessage(id); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("GetMessage(%s) after dead-lettering = %v, want ErrNotFound", id, err)
+		}
+	}
+
+	deadLetter, err := s.GetDeadLetter("msg-1")
+	if err != nil {
+		t.Fatalf("GetDeadLetter: %v", err)
+	}
+	switch {
+	case deadLetter.Status != models.StatusFailed || deadLetter.Error != "Exceeded max retries: 503":
+		t.Errorf("dead letter = %s %q", deadLetter.Status, deadLetter.Error)
+	case string(deadLetter.Body) != `{"id":"msg-1"}` || deadLetter.TraceParent == "":
+		t.Errorf("dead letter message = %q %q", deadLetter.Body, deadLetter.TraceParent)
+	case len(deadLetter.Deliveries) != 2 || deadLetter.Deliveries[0].Status != models.StatusSent:
+		t.Errorf("dead letter deliveries = %+v", deadLetter.Deliveries)
+	}
+
+	tests := []struct {
+		filter models.DeadLetterFilter
+		want   int
+	}{
+		{models.DeadLetterFilter{}, 2},
+		{models.DeadLetterFilter{Path: "/a"}, 1},
+		{models.DeadLetterFilter{ErrorPattern: "BROKER"}, 1},
+		// Wildcards are matched literally and only ASCII letters match in
+		// either case, as in every backend
+		{models.DeadLetterFilter{ErrorPattern: "%"}, 1},
+		{models.DeadLetterFilter{ErrorPattern: "d_m"}, 0},
+		{models.DeadLetterFilter{ErrorPattern: "queue_size"}, 1},
+		{models.DeadLetterFilter{ErrorPattern: `\`}, 0},
+		{models.DeadLetterFilter{ErrorPattern: "Überlauf"}, 1},
+		{models.DeadLetterFilter{ErrorPattern: "ÜBERLAUF"}, 1},
+		{models.DeadLetterFilter{ErrorPattern: "überlauf"}, 0},
+		{models.DeadLetterFilter{IDs: []string{"msg-2", "msg-3"}}, 1},
+		{models.DeadLetterFilter{From: time.Now().Add(time.Minute)}, 0},
+		{models.DeadLetterFilter{To: time.Now().Add(time.Minute)}, 2},
+	}
+	for _, test := range tests {
+		count, err := s.CountDeadLetters(test.filter)
+		if err != nil {
+			t.Fatalf("CountDeadLetters(%+v): %v", test.filter, err)
+		}
+		deadLetters, err := s.ListDeadLetters(test.filter, 10, 0)
+		if err != nil {
+			t.Fatalf("ListDeadLetters(%+v): %v", test.filter, err)
+		}
+		if count != test.want || len(deadLetters) != test.want {
+			t.Errorf("dead letters matching %+v = %d listed %d, want %d", test.filter, count, len(deadLetters), test.want)
+		}
+	}
+
+	// Replay only retries the failed deliveries
+	replayed, err := s.ReplayDeadLetters(models.DeadLetterFilter{Path: "/a"})
+	if err != nil {
+		t.Fatalf("ReplayDeadLetters: %v", err)
+	}
+	checkIDs(t, "replayed", replayed, []string{"msg-1"})
+
+	msg := get(t, s, "msg-1")
+	switch {
+	case msg.Status != models.StatusPending || msg.Retries != 0 || msg.Error != "":
+		t.Errorf("replayed message = %s retries %d error %q", msg.Status, msg.Retries, msg.Error)
+	case len(msg.Deliveries) != 2 || msg.Deliveries[0].Status != models.StatusSent || msg.Deliveries[1].Status != models.StatusPending:
+		t.Errorf("replayed deliveries = %+v", msg.Deliveries)
+	}
+	checkIDs(t, "pending after replay", pendingIDs(t, s, 10), []string{"msg-1"})
+
+	deleted, err := s.DeleteDeadLetters(models.DeadLetterFilter{})
+	if err != nil || deleted != 1 {
+		t.Errorf("DeleteDeadLetters = %d, %v, want 1", deleted, err)
+	}
+	if count, _ := s.CountDeadLetters(models.DeadLetterFilter{}); count != 0 {
+		t.Errorf("dead letters after delete = %d", count)
+	}
+}
+
+func testFailedMessageIDs(t *testing.T, s storage.Storage) {
+	save(t, s,
+		newMessage("msg-1", "/a", time.Minute, kafkaTarget),
+		newMessage("msg-2", "/a", time.Minute, kafkaTarget),
+	)
+	update(t, s, "msg-2", kafkaTarget, models.StatusFailed, "Exceeded max retries", time.Time{}, models.StatusFailed)
+
+	ids, err := s.GetFailedMessageIDs()
+	if err != nil {
+		t.Fatalf("GetFailedMessageIDs: %v", err)
+	}
+	checkIDs(t, "failed", ids, []string{"msg-2"})
+}
+
+func testAttempts(t *testing.T, s storage.Storage) {
+	partition, offset := int32(2), int64(42)
+	attempts := []*models.Attempt{
+		{MessageID: "msg-1", Path: "/a", Target: urlTarget, AttemptedAt: time.Now().Add(-time.Minute), DurationMs: 120,
+			Error: "503", DeliveryResult: models.DeliveryResult{StatusCode: 503, Response: strings.Repeat("x", 10000)}},
+		{MessageID: "msg-1", Path: "/a", Target: kafkaTarget, AttemptedAt: time.Now(), DurationMs: 8,
+			DeliveryResult: models.DeliveryResult{Partition: &partition, Offset: &offset}},
+		{MessageID: "msg-2", Path: "/b", Target: kafkaTarget, AttemptedAt: time.Now(), DurationMs: 5},
+	}
+
+	var lastID int64
+	for _, attempt := range attempts {
+		if err := s.RecordAttempt(attempt); err != nil {
+			t.Fatalf("RecordAttempt: %v", err)
+		}
+		if attempt.ID <= lastID {
+			t.Errorf("attempt ID = %d, want increasing IDs", attempt.ID)
+		}
+		lastID = attempt.ID
+	}
+
+	got, err := s.GetAttempts("msg-1")
+	if err != nil {
+		t.Fatalf("GetAttempts: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("attempts = %d, want 2", len(got))
+	}
+	switch {
+	case got[0].ID != attempts[0].ID || got[0].StatusCode != 503 || got[0].Error != "503" || got[0].Target != urlTarget:
+		t.Errorf("first attempt = %+v", got[0])
+	case len(got[0].Response) >= 10000 || got[0].Response == "":
+		t.Errorf("response length = %d, want it truncated", len(got[0].Response))
+	case got[1].Partition == nil || *got[1].Partition != 2 || got[1].Offset == nil || *got[1].Offset != 42:
+		t.Errorf("second attempt = %+v", got[1])
+	case got[1].DurationMs != 8 || got[1].Path != "/a" || got[1].AttemptedAt.Sub(attempts[1].AttemptedAt).Abs() > time.Millisecond:
+		t.Errorf("second attempt = %+v", got[1])
+	}
+}
+
+func testStats(t *testing.T, s storage.Storage) {
+	oldest := newMessage("msg-1", "/a", 2*time.Hour, kafkaTarget)
+	save(t, s,
+		oldest,
+		newMessage("msg-2", "/a", time.Hour, kafkaTarget),
+		newMessage("msg-3", "/b", time.Hour, kafkaTarget),
+		newMessage("msg-4", "/b", time.Hour, kafkaTarget),
+	)
+	update(t, s, "msg-2", kafkaTarget, models.StatusRetrying, "broker down", time.Now().Add(time.Hour), models.StatusRetrying)
+	if _, err := s.CancelMessage("msg-3"); err != nil {
+		t.Fatalf("CancelMessage: %v", err)
+	}
+	update(t, s, "msg-4", kafkaTarget, models.StatusFailed, "Exceeded max retries", time.Time{}, models.StatusFailed)
+	if _, err := s.MoveToDeadLetter("msg-4"); err != nil {
+		t.Fatalf("MoveToDeadLetter: %v", err)
+	}
+
+	stats, err := s.GetMessageStats()
+	if err != nil {
+		t.Fatalf("GetMessageStats: %v", err)
+	}
+	if stats["pending"] != 1 || stats["retrying"] != 1 || stats["cancelled"] != 1 || len(stats) != 3 {
+		t.Errorf("message stats = %v", stats)
+	}
+
+	for _, attempt := range []*models.Attempt{
+		{MessageID: "msg-2", Path: "/a", Target: kafkaTarget, AttemptedAt: time.Now(), DurationMs: 10, Error: "broker down"},
+		{MessageID: "msg-5", Path: "/a", Target: kafkaTarget, AttemptedAt: time.Now(), DurationMs: 25},
+		{MessageID: "msg-6", Path: "/a", Target: kafkaTarget, AttemptedAt: time.Now().Add(-48 * time.Hour), DurationMs: 1000},
+	} {
+		if err := s.RecordAttempt(attempt); err != nil {
+			t.Fatalf("RecordAttempt: %v", err)
+		}
+	}
+
+	routes, err := s.GetRouteStats(time.Now().Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatalf("GetRouteStats: %v", err)
+	}
+	if len(routes) != 2 || routes[0].Path != "/a" || routes[1].Path != "/b" {
+		t.Fatalf("route stats = %+v, want /a and /b", routes)
+	}
+
+	a, b := routes[0], routes[1]
+	switch {
+	case a.Pending != 1 || a.Retrying != 1 || a.Cancelled != 0 || a.DeadLetters != 0:
+		t.Errorf("queue of /a = %+v", a)
+	case a.OldestPending.Sub(oldest.Timestamp).Abs() > time.Second:
+		t.Errorf("oldest pending of /a = %v, want %v", a.OldestPending, oldest.Timestamp)
+	case a.Delivered != 1 || a.FailedAttempts != 1 || a.Messages != 2 || a.AvgDurationMs != 17.5:
+		t.Errorf("activity of /a = %+v", a)
+	case b.Pending != 0 || b.Cancelled != 1 || b.DeadLetters != 1 || !b.OldestPending.IsZero():
+		t.Errorf("queue of /b = %+v", b)
+	}
+}
+
+func testCleanup(t *testing.T, s storage.Storage) {
+	save(t, s,
+		newMessage("msg-1", "/a", 10*24*time.Hour, kafkaTarget),
+		newMessage("msg-2", "/a", 10*24*time.Hour, kafkaTarget),
+		newMessage("msg-3", "/a", 10*24*time.Hour, kafkaTarget),
+		newMessage("msg-4", "/a", time.Hour, kafkaTarget),
+	)
+	update(t, s, "msg-1", kafkaTarget, models.StatusSent, "", time.Time{}, models.StatusSent)
+	if _, err := s.CancelMessage("msg-2"); err != nil {
+		t.Fatalf("CancelMessage: %v", err)
+	}
+	update(t, s, "msg-4", kafkaTarget, models.StatusSent, "", time.Time{}, models.StatusSent)
+
+	old := time.Now().Add(-10 * 24 * time.Hour)
+	for _, attempt := range []*models.Attempt{
+		{MessageID: "msg-1", Path: "/a", Target: kafkaTarget, AttemptedAt: old},
+		{MessageID: "msg-3", Path: "/a", Target: kafkaTarget, AttemptedAt: old},
+		{MessageID: "msg-9", Path: "/a", Target: kafkaTarget, AttemptedAt: time.Now()},
+	} {
+		if err := s.RecordAttempt(attempt); err != nil {
+			t.Fatalf("RecordAttempt: %v", err)
+		}
+	}
+
+	if err := s.Cleanup(7); err != nil {
+		t.Fatalf("Cleanup: %v", err)
+	}
+
+	var ids []string
+	messages, err := s.ListMessages(models.MessageFilter{}, 10, 0)
+	if err != nil {
+		t.Fatalf("ListMessages: %v", err)
+	}
+	for _, msg := range messages {
+		ids = append(ids, msg.ID)
+	}
+	checkIDs(t, "messages after cleanup", ids, []string{"msg-4", "msg-3"})
+
+	for id, want := range map[string]int{"msg-1": 0, "msg-3": 1, "msg-9": 1} {
+		attempts, err := s.GetAttempts(id)
+		if err != nil {
+			t.Fatalf("GetAttempts(%s): %v", id, err)
+		}
+		if len(attempts) != want {
+			t.Errorf("attempts of %s after cleanup = %d, want %d", id, len(attempts), want)
+		}
+	}
+}
+
+func testLeaseClaim(t *testing.T, s storage.Storage) {
+	save(t, s,
+		newMessage("msg-1", "/a", 2*time.Minute, kafkaTarget),
+		newMessage("msg-2", "/a", time.Minute, kafkaTarget),
+	)
+
+	if messages := claim(t, s, 10); len(messages) != 2 {
+		t.Fatalf("claimed %d messages, want 2", len(messages))
+	}
+	if messages := claim(t, s, 10); len(messages) != 0 {
+		t.Fatalf("claimed %d leased messages, want none", len(messages))
+	}
+
+	// Released messages can be claimed again
+	release(t, s, "msg-2")
+	checkIDs(t, "pending after release", pendingIDs(t, s, 10), []string{"msg-2"})
+}
+
+func testLeaseEnd(t *testing.T, s storage.Storage) {
+	save(t, s, newMessage("msg-1", "/a", time.Minute, kafkaTarget, urlTarget))
+	claim(t, s, 10)
+
+	// The lease is kept while a delivery of the claimed message is still due
+	update(t, s, "msg-1", kafkaTarget, models.StatusSent, "", time.Time{}, models.StatusPending)
+	checkIDs(t, "pending while msg-1 is delivered", pendingIDs(t, s, 10), nil)
+
+	// and ends once the worker is done with it
+	update(t, s, "msg-1", urlTarget, models.StatusRetrying, "timeout", time.Now().Add(time.Hour), models.StatusRetrying)
+	if _, err := s.RetryMessage("msg-1"); err != nil {
+		t.Fatalf("RetryMessage: %v", err)
+	}
+	checkIDs(t, "pending after retry", pendingIDs(t, s, 10), []string{"msg-1"})
+}
+
+func testLeasedOrderingKey(t *testing.T, s storage.Storage) {
+	first := newMessage("msg-1", "/a", 3*time.Minute, kafkaTarget)
+	second := newMessage("msg-2", "/a", 2*time.Minute, kafkaTarget)
+	other := newMessage("msg-3", "/a", time.Minute, kafkaTarget)
+	first.OrderingKey = "order-1"
+	second.OrderingKey = "order-1"
+	other.OrderingKey = "order-2"
+	save(t, s, first, second, other)
+
+	messages := claim(t, s, 1)
+	if len(messages) != 1 || messages[0].ID != "msg-1" {
+		t.Fatalf("claimed %v, want msg-1", messages)
+	}
+
+	// A later message waits while an older one with the same key is leased
+	checkIDs(t, "pending while msg-1 is leased", pendingIDs(t, s, 10), []string{"msg-3"})
+
+	update(t, s, "msg-1", kafkaTarget, models.StatusSent, "", time.Time{}, models.StatusSent)
+	checkIDs(t, "pending after msg-1", pendingIDs(t, s, 10), []string{"msg-2", "msg-3"})
+}
+
+func testConcurrentClaims(t *testing.T, s storage.Storage) {
+	const count = 50
+	for i := range count {
+		save(t, s, newMessage(fmt.Sprintf("msg-%02d", i), "/a", time.Duration(count-i)*time.Second, kafkaTarget))
+	}
+
+	// A backend that hands out leased messages again would keep the claimers
+	// busy forever, so they give up after the deadline
+	deadline := time.Now().Add(10 * time.Second)
+	var timedOut atomic.Bool
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	claimed := make(map[string]int)
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if time.Now().After(deadline) {
+					timedOut.Store(true)
+					return
+				}
+
+				messages, err := s.GetPendingMessages(3)
+				if err != nil {
+					t.Errorf("GetPendingMessages: %v", err)
+					return
+				}
+				if len(messages) == 0 {
+					return
+				}
+
+				mu.Lock()
+				for _, msg := range messages {
+					claimed[msg.ID]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if timedOut.Load() {
+		t.Fatalf("claims did not finish within 10s, claimed %d distinct messages of %d", len(claimed), count)
+	}
+	if len(claimed) != count {
+		t.Errorf("claimed %d messages, want %d", len(claimed), count)
+	}
+	for id, claims := range claimed {
+		if claims != 1 {
+			t.Errorf("%s claimed %d times, want once", id, claims)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/expai/messagebridge/models"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider recording every span for the
+// duration of the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestMessageContextContinuesTrace(t *testing.T) {
+	recorder := recordSpans(t)
+
+	ctx, ingest := Start(context.Background(), "webhook.receive")
+	msg := &models.WebhookMessage{ID: "msg-1", TraceParent: TraceParent(ctx)}
+	ingest.End()
+
+	_, deliver := Start(MessageContext(msg), "worker.deliver")
+	End(deliver, errors.New("timeout"))
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d spans, want 2", len(spans))
+	}
+	receive, delivery := spans[0], spans[1]
+	if delivery.SpanContext().TraceID() != receive.SpanContext().TraceID() || delivery.Parent().SpanID() != receive.SpanContext().SpanID() {
+		t.Errorf("delivery span parent = %v, want the ingest span %v", delivery.Parent(), receive.SpanContext())
+	}
+	if !delivery.Parent().IsRemote() {
+		t.Error("delivery span parent is not remote")
+	}
+	if delivery.Status().Code != codes.Error || len(delivery.Events()) != 1 {
+		t.Errorf("delivery span status = %v with %d events, want the recorded error", delivery.Status(), len(delivery.Events()))
+	}
+}
+
+func TestMessageContextWithoutTrace(t *testing.T) {
+	ctx := MessageContext(&models.WebhookMessage{ID: "msg-1"})
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		t.Error("context of a message without traceparent carries a span")
+	}
+	if got := TraceParent(context.Background()); got != "" {
+		t.Errorf("TraceParent without a span = %q, want empty", got)
+	}
+}
+
+func TestHeaderCarrierReplacesTraceParent(t *testing.T) {
+	recordSpans(t)
+
+	ctx, span := Start(context.Background(), "worker.deliver")
+	defer span.End()
+
+	// The webhook was received with the caller's traceparent
+	headers := HeaderCarrier{"Traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", "X-Event": "paid"}
+	Inject(ctx, headers)
+
+	if len(headers) != 2 || headers["traceparent"] != TraceParent(ctx) {
+		t.Errorf("headers = %v, want the traceparent of the delivery span only", headers)
+	}
+	if got := headers.Get("TRACEPARENT"); got != TraceParent(ctx) {
+		t.Errorf("Get = %q, want a case-insensitive match", got)
+	}
+
+	extracted := trace.SpanContextFromContext(Extract(context.Background(), headers))
+	if extracted.SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("extracted span = %v, want %v", extracted.SpanID(), span.SpanContext().SpanID())
+	}
+}
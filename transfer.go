@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/storage"
+)
+
+const exportUsage = `Usage: messagebridge export -config /path/to/config.yaml [options]
+
+Writes queued messages with their deliveries as NDJSON, one message per line.
+
+Options:
+  -output  File to write to (default stdout)
+  -id, -route, -queue, -status, -from, -to
+           Filters, as for "messages list"
+`
+
+const importUsage = `Usage: messagebridge import -config /path/to/config.yaml [-input file]
+
+Reads messages written by "export" (default from stdin). Messages that are
+already stored are skipped; delivered destinations are not delivered again.
+Gzip-compressed input such as archive files (.ndjson.gz) is decompressed;
+their messages are delivered again to every destination but the archive.
+`
+
+// exportPageSize is the number of messages read from storage at a time
+const exportPageSize = 500
+
+// maxImportLine is the longest NDJSON line accepted by import
+const maxImportLine = 64 << 20
+
+// runExportCommand implements the "export" subcommand
+func runExportCommand(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	configFile := fs.String("config", "", "Path to configuration file (required)")
+	outputFile := fs.String("output", "", "File to write to (default stdout)")
+	filterFlags := newMessageFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return ExitFailure
+	}
+
+	if *configFile == "" {
+		fmt.Fprintln(os.Stderr, "Error: -config flag is required")
+		fmt.Fprint(os.Stderr, exportUsage)
+		return ExitFailure
+	}
+
+	filter, err := filterFlags.filter()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ExitFailure
+	}
+
+	store, err := openStorage(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ExitFailure
+	}
+	defer store.Close()
+
+	// Messages go to stdout by default, so everything else goes to stderr
+	output := os.Stdout
+	if *outputFile != "" {
+		if output, err = os.Create(*outputFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return ExitFailure
+		}
+	}
+
+	count, err := exportMessages(store, filter, output)
+	if output != os.Stdout {
+		if closeErr := output.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ExitFailure
+	}
+
+	fmt.Fprintf(os.Stderr, "Exported %d messages\n", count)
+	return ExitSuccess
+}
+
+// exportMessages writes the messages matching filter to w, oldest first.
+// Pages are read by creation time and ID, so messages deleted or received
+// while exporting do not shift the pages; deleted ones are left out.
+func exportMessages(store storage.Storage, filter models.MessageFilter, w io.Writer) (int, error) {
+	writer := bufio.NewWriter(w)
+	encoder := json.NewEncoder(writer)
+
+	count := 0
+	var createdAt time.Time
+	var id string
+	for {
+		messages, err := store.ListMessagesAfter(filter, createdAt, id, exportPageSize)
+		if err != nil {
+			return count, err
+		}
+		if len(messages) == 0 {
+			break
+		}
+		createdAt, id = messages[len(messages)-1].CreatedAt, messages[len(messages)-1].ID
+
+		for _, listed := range messages {
+			msg, err := store.GetMessage(listed.ID)
+			if errors.Is(err, storage.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return count, fmt.Errorf("failed to get message %s: %w", listed.ID, err)
+			}
+			if err := encoder.Encode(msg); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+
+	return count, writer.Flush()
+}
+
+// runImportCommand implements the "import" subcommand
+func runImportCommand(args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	configFile := fs.String("config", "", "Path to configuration file (required)")
+	inputFile := fs.String("input", "", "File to read from (default stdin)")
+
+	if err := fs.Parse(args); err != nil {
+		return ExitFailure
+	}
+
+	if *configFile == "" {
+		fmt.Fprintln(os.Stderr, "Error: -config flag is required")
+		fmt.Fprint(os.Stderr, importUsage)
+		return ExitFailure
+	}
+
+	input := os.Stdin
+	if *inputFile != "" {
+		file, err := os.Open(*inputFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return ExitFailure
+		}
+		defer file.Close()
+		input = file
+	}
+
+	store, err := openStorage(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ExitFailure
+	}
+	defer store.Close()
+
+	// Like export, the summary goes to stderr so stdout stays free for data
+	imported, skipped, err := importMessages(store, input)
+	fmt.Fprintf(os.Stderr, "Imported %d messages, skipped %d already stored\n", imported, skipped)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ExitFailure
+	}
+
+	return ExitSuccess
+}
+
+// gzipMagic starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// importMessages stores the NDJSON messages read from r, which may be
+// gzip-compressed. It stops at the first invalid line; messages before it
+// stay imported.
+func importMessages(store storage.Storage, r io.Reader) (imported, skipped int, err error) {
+	buffered := bufio.NewReader(r)
+	if magic, _ := buffered.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		decompressed, err := gzip.NewReader(buffered)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer decompressed.Close()
+		r = decompressed
+	} else {
+		r = buffered
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportLine)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var msg models.PendingMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return imported, skipped, fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := validateImport(&msg); err != nil {
+			return imported, skipped, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		stored, err := store.ImportMessage(&msg)
+		if err != nil {
+			return imported, skipped, fmt.Errorf("line %d: failed to import message %s: %w", line, msg.ID, err)
+		}
+		if stored {
+			imported++
+		} else {
+			skipped++
+		}
+	}
+
+	return imported, skipped, scanner.Err()
+}
+
+// validateImport checks that an imported message can be delivered
+func validateImport(msg *models.PendingMessage) error {
+	if msg.WebhookMessage == nil || msg.ID == "" {
+		return fmt.Errorf("message id is required")
+	}
+	if msg.Path == "" {
+		return fmt.Errorf("message %s: path is required", msg.ID)
+	}
+	if len(msg.Deliveries) == 0 && len(msg.Destinations) == 0 {
+		return fmt.Errorf("message %s: destinations are required", msg.ID)
+	}
+
+	for _, delivery := range msg.Deliveries {
+		switch delivery.Status {
+		case models.StatusPending, models.StatusRetrying, models.StatusSent, models.StatusFailed, models.StatusCancelled:
+		default:
+			return fmt.Errorf("message %s: invalid delivery status: %s", msg.ID, delivery.Status)
+		}
+	}
+
+	return nil
+}
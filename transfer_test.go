@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/expai/messagebridge/archive"
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/storage"
+)
+
+var (
+	kafkaTarget   = models.DeliveryTarget{Type: models.TargetKafka, Topic: "orders"}
+	archiveTarget = models.DeliveryTarget{Type: models.TargetArchive}
+)
+
+// newTestMessage returns a pending message received age ago
+func newTestMessage(id string, age time.Duration, targets ...models.DeliveryTarget) *models.WebhookMessage {
+	received := time.Now().UTC().Add(-age).Truncate(time.Millisecond)
+	return &models.WebhookMessage{
+		ID:           id,
+		Path:         "/webhook/orders",
+		Queue:        "orders",
+		Body:         []byte(`{"id":"` + id + `"}`),
+		Headers:      map[string]string{"Content-Type": "application/json"},
+		Timestamp:    received,
+		Status:       models.StatusPending,
+		Destinations: targets,
+		CreatedAt:    received,
+		UpdatedAt:    received,
+	}
+}
+
+// writeSQLiteConfig writes a configuration using a new SQLite database and
+// returns its path
+func writeSQLiteConfig(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	content := fmt.Sprintf(`
+server:
+  host: "127.0.0.1"
+  port: 8080
+sqlite:
+  database_path: %q
+routes:
+  - path: "/webhook/orders"
+    queue: "orders"
+`, filepath.Join(dir, "messages.db"))
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// openSQLite opens the database of the configuration at path
+func openSQLite(t *testing.T, path string) storage.Storage {
+	t.Helper()
+
+	store, err := openStorage(path)
+	if err != nil {
+		t.Fatalf("openStorage: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// captureOutput runs fn and returns what it wrote to stdout and stderr
+func captureOutput(t *testing.T, fn func()) (stdout, stderr string) {
+	t.Helper()
+
+	read := func(target **os.File) func() string {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		original := *target
+		*target = w
+
+		done := make(chan string)
+		go func() {
+			data, _ := io.ReadAll(r)
+			done <- string(data)
+		}()
+		return func() string {
+			*target = original
+			w.Close()
+			return <-done
+		}
+	}
+
+	stopStdout, stopStderr := read(&os.Stdout), read(&os.Stderr)
+	fn()
+	return stopStdout(), stopStderr()
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	sourceConfig := writeSQLiteConfig(t)
+	source := openSQLite(t, sourceConfig)
+
+	for i, msg := range []*models.WebhookMessage{
+		newTestMessage("msg-1", 3*time.Hour, kafkaTarget),
+		newTestMessage("msg-2", 2*time.Hour, kafkaTarget, archiveTarget),
+		newTestMessage("msg-3", time.Hour, kafkaTarget),
+	} {
+		if err := source.SaveMessage(msg); err != nil {
+			t.Fatalf("SaveMessage %d: %v", i, err)
+		}
+	}
+	if _, err := source.UpdateDeliveryStatus("msg-2", archiveTarget, models.StatusSent, "", time.Time{}); err != nil {
+		t.Fatalf("UpdateDeliveryStatus: %v", err)
+	}
+
+	exported := filepath.Join(t.TempDir(), "messages.ndjson")
+	stdout, stderr := captureOutput(t, func() {
+		if code := runExportCommand([]string{"-config", sourceConfig, "-output", exported}); code != ExitSuccess {
+			t.Errorf("export exit code = %d", code)
+		}
+	})
+	if stdout != "" || !strings.Contains(stderr, "Exported 3 messages") {
+		t.Errorf("export stdout = %q, stderr = %q", stdout, stderr)
+	}
+
+	// Messages are exported oldest first with their deliveries
+	data, err := os.ReadFile(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var msg models.PendingMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			t.Fatalf("invalid line %s: %v", line, err)
+		}
+		ids = append(ids, msg.ID)
+	}
+	if strings.Join(ids, ",") != "msg-1,msg-2,msg-3" {
+		t.Errorf("exported %v, want msg-1,msg-2,msg-3", ids)
+	}
+
+	targetConfig := writeSQLiteConfig(t)
+	for _, want := range []string{"Imported 3 messages, skipped 0", "Imported 0 messages, skipped 3"} {
+		stdout, stderr := captureOutput(t, func() {
+			if code := runImportCommand([]string{"-config", targetConfig, "-input", exported}); code != ExitSuccess {
+				t.Errorf("import exit code = %d", code)
+			}
+		})
+		if stdout != "" || !strings.Contains(stderr, want) {
+			t.Errorf("import stdout = %q, stderr = %q, want %q", stdout, stderr, want)
+		}
+	}
+
+	target := openSQLite(t, targetConfig)
+	msg, err := target.GetMessage("msg-2")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if string(msg.Body) != `{"id":"msg-2"}` || len(msg.Deliveries) != 2 {
+		t.Fatalf("imported message = %s with %d deliveries", msg.Body, len(msg.Deliveries))
+	}
+	for _, delivery := range msg.Deliveries {
+		want := models.StatusPending
+		if delivery.Target.Type == models.TargetArchive {
+			want = models.StatusSent
+		}
+		if delivery.Status != want {
+			t.Errorf("%s delivery = %s, want %s", delivery.Target.Type, delivery.Status, want)
+		}
+	}
+}
+
+func TestImportArchive(t *testing.T) {
+	dir := t.TempDir()
+	writer, err := archive.NewWriter(&config.ArchiveConfig{Directory: dir, MaxFileSize: 64 << 20, MaxFileAge: time.Hour})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	for _, id := range []string{"msg-1", "msg-2"} {
+		if _, err := writer.Send(context.Background(), archiveTarget, newTestMessage(id, time.Minute, kafkaTarget, archiveTarget)); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*", "*.ndjson.gz"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("archive files = %v, %v, want one", files, err)
+	}
+	input, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	store := storage.NewMemoryStorage()
+	imported, skipped, err := importMessages(store, input)
+	if err != nil || imported != 2 || skipped != 0 {
+		t.Fatalf("importMessages = %d, %d, %v, want 2 imported", imported, skipped, err)
+	}
+
+	// Archived messages are delivered again everywhere but the archive
+	pending, err := store.GetPendingMessages(10)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("pending = %d, %v, want 2", len(pending), err)
+	}
+	for _, msg := range pending {
+		for _, delivery := range msg.Deliveries {
+			want := models.StatusPending
+			if delivery.Target.Type == models.TargetArchive {
+				want = models.StatusSent
+			}
+			if delivery.Status != want {
+				t.Errorf("%s: %s delivery = %s, want %s", msg.ID, delivery.Target.Type, delivery.Status, want)
+			}
+		}
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	input := strings.Join([]string{
+		`{"id":"msg-1","path":"/webhook/orders","destinations":[{"type":"kafka","topic":"orders"}]}`,
+		``,
+		`{"id":"msg-2","path":"/webhook/orders"}`,
+		`{"id":"msg-3","path":"/webhook/orders","destinations":[{"type":"kafka","topic":"orders"}]}`,
+	}, "\n")
+
+	imported, _, err := importMessages(store, strings.NewReader(input))
+	if err == nil || !strings.Contains(err.Error(), "line 3: message msg-2: destinations are required") {
+		t.Errorf("importMessages error = %v, want the invalid line", err)
+	}
+	if imported != 1 {
+		t.Errorf("imported %d messages before the invalid line, want 1", imported)
+	}
+
+	stdout, stderr := captureOutput(t, func() {
+		if code := runImportCommand(nil); code != ExitFailure {
+			t.Errorf("exit code without -config = %d, want %d", code, ExitFailure)
+		}
+	})
+	if stdout != "" || !strings.Contains(stderr, "-config flag is required") || !strings.Contains(stderr, "Usage:") {
+		t.Errorf("stdout = %q, stderr = %q, want the error and usage on stderr", stdout, stderr)
+	}
+}
+
+// deletingStorage deletes messages the first time a page is listed, as
+// another instance or an operator would while an export runs
+type deletingStorage struct {
+	storage.Storage
+	deleted []string
+	done    bool
+}
+
+// ListMessagesAfter lists a page and then deletes s.deleted once
+func (s *deletingStorage) ListMessagesAfter(filter models.MessageFilter, createdAt time.Time, id string, limit int) ([]*models.PendingMessage, error) {
+	messages, err := s.Storage.ListMessagesAfter(filter, createdAt, id, limit)
+	if !s.done {
+		s.done = true
+		for _, id := range s.deleted {
+			if err := s.Storage.DeleteMessage(id); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return messages, err
+}
+
+func TestExportWithConcurrentDeletes(t *testing.T) {
+	const count = 2*exportPageSize + 100
+
+	store := &deletingStorage{Storage: storage.NewMemoryStorage()}
+	var want []string
+	for i := range count {
+		msg := newTestMessage(fmt.Sprintf("msg-%04d", i), time.Duration(count-i)*time.Second, kafkaTarget)
+		if err := store.SaveMessage(msg); err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+
+		// Every third message is deleted, some of them in the page being
+		// exported and the rest in later ones
+		if i%3 == 0 {
+			store.deleted = append(store.deleted, msg.ID)
+		} else {
+			want = append(want, msg.ID)
+		}
+	}
+
+	var output bytes.Buffer
+	exported, err := exportMessages(store, models.MessageFilter{}, &output)
+	if err != nil {
+		t.Fatalf("exportMessages: %v", err)
+	}
+
+	var got []string
+	scanner := bufio.NewScanner(&output)
+	for scanner.Scan() {
+		var msg models.PendingMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, msg.ID)
+	}
+	if exported != len(want) || strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("exported %d messages, want each of the %d remaining once in order", exported, len(want))
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/signature"
+	"github.com/expai/messagebridge/storage"
+)
+
+const validateUsage = `Usage: messagebridge validate -config /path/to/config.yaml [-offline]
+
+Validates the configuration, including signature settings and the SQLite
+and archive directories, and checks that Kafka brokers, Redis, NATS servers,
+the AMQP and MQTT brokers, the archive S3 endpoint, remote URLs, the tracing
+endpoint and PostgreSQL accept connections. -offline skips the network checks.
+`
+
+// dialTimeout limits each reachability check
+const dialTimeout = 5 * time.Second
+
+// validateCheck is a single named check run by the validate subcommand
+type validateCheck struct {
+	name string
+	run  func() error
+}
+
+// runValidateCommand implements the "validate" subcommand
+func runValidateCommand(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	configFile := fs.String("config", "", "Path to configuration file (required)")
+	offline := fs.Bool("offline", false, "Skip broker and URL reachability checks")
+
+	if err := fs.Parse(args); err != nil {
+		return ExitFailure
+	}
+
+	if *configFile == "" {
+		fmt.Println("Error: -config flag is required")
+		fmt.Print(validateUsage)
+		return ExitFailure
+	}
+
+	cfg, err := config.LoadConfig(*configFile)
+	if err != nil {
+		fmt.Printf("FAIL  configuration: %v\n", err)
+		return ExitFailure
+	}
+	fmt.Printf("OK    configuration %s\n", *configFile)
+
+	checks := localChecks(cfg)
+	if !*offline {
+		checks = append(checks, networkChecks(cfg)...)
+	}
+
+	failed := 0
+	for _, check := range checks {
+		if err := check.run(); err != nil {
+			fmt.Printf("FAIL  %s: %v\n", check.name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("OK    %s\n", check.name)
+	}
+
+	if failed > 0 {
+		fmt.Printf("\n%d of %d checks failed\n", failed, len(checks)+1)
+		return ExitFailure
+	}
+	return ExitSuccess
+}
+
+// localChecks returns the checks that need no network access
+func localChecks(cfg *config.Config) []validateCheck {
+	var checks []validateCheck
+
+	for _, route := range cfg.Routes {
+		if route.Signature == nil {
+			continue
+		}
+		checks = append(checks, validateCheck{
+			name: "signature of route " + route.Path,
+			run: func() error {
+				_, err := signature.NewVerifier(route.Provider, route.Signature)
+				return err
+			},
+		})
+	}
+
+	if cfg.SQLite != nil {
+		checks = append(checks, validateCheck{
+			name: "sqlite " + cfg.SQLite.DatabasePath,
+			run:  func() error { return checkWritable(cfg.SQLite.DatabasePath) },
+		})
+	}
+
+	if cfg.Archive != nil {
+		checks = append(checks, validateCheck{
+			name: "archive " + cfg.Archive.Directory,
+			run:  func() error { return checkDirWritable(cfg.Archive.Directory) },
+		})
+	}
+
+	return checks
+}
+
+// networkChecks returns a reachability check per Kafka broker, Redis server,
+// NATS server, AMQP and MQTT broker, S3 endpoint, remote URL host and tracing endpoint, and a connection check for PostgreSQL
+func networkChecks(cfg *config.Config) []validateCheck {
+	var checks []validateCheck
+
+	if cfg.Postgres != nil {
+		checks = append(checks, validateCheck{
+			name: "postgres",
+			run:  func() error { return storage.PingPostgres(cfg.Postgres) },
+		})
+	}
+
+	if cfg.Kafka != nil {
+		for _, broker := range cfg.Kafka.Brokers {
+			checks = append(checks, validateCheck{
+				name: "kafka broker " + broker,
+				run:  func() error { return checkDial(broker) },
+			})
+		}
+	}
+
+	if cfg.Redis != nil {
+		checks = append(checks, validateCheck{
+			name: "redis " + cfg.Redis.Address,
+			run:  func() error { return checkDial(cfg.Redis.Address) },
+		})
+	}
+
+	if cfg.NATS != nil {
+		for _, server := range cfg.NATS.Servers {
+			checks = append(checks, validateCheck{
+				name: "nats server " + natsAddress(server),
+				run:  func() error { return checkDial(natsAddress(server)) },
+			})
+		}
+	}
+
+	var urls []string
+	if cfg.AMQP != nil {
+		urls = append(urls, cfg.AMQP.URL)
+	}
+	if cfg.MQTT != nil {
+		urls = append(urls, cfg.MQTT.Broker)
+	}
+	if cfg.Archive != nil && cfg.Archive.S3 != nil {
+		urls = append(urls, cfg.Archive.S3.Endpoint)
+	}
+	if cfg.RemoteURL != nil && cfg.RemoteURL.URL != "" {
+		urls = append(urls, cfg.RemoteURL.URL)
+	}
+	for _, route := range cfg.Routes {
+		for _, dest := range route.Destinations {
+			if dest.URL != "" {
+				urls = append(urls, dest.URL)
+			}
+		}
+	}
+	if cfg.Tracing != nil {
+		urls = append(urls, cfg.Tracing.Endpoint)
+	}
+
+	// Destinations often share a host, each one is dialed once
+	seen := make(map[string]bool)
+	for _, rawURL := range urls {
+		address, err := urlAddress(rawURL)
+		if err != nil {
+			checks = append(checks, validateCheck{
+				name: "url " + config.RedactURL(rawURL),
+				run:  func() error { return err },
+			})
+			continue
+		}
+		if seen[address] {
+			continue
+		}
+		seen[address] = true
+
+		checks = append(checks, validateCheck{
+			name: "host " + address,
+			run:  func() error { return checkDial(address) },
+		})
+	}
+
+	return checks
+}
+
+// urlAddress returns the host:port an http, https, amqp or mqtt URL connects to
+func urlAddress(rawURL string) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	port := parsed.Port()
+	switch {
+	case port != "":
+	case parsed.Scheme == "https":
+		port = "443"
+	case parsed.Scheme == "http":
+		port = "80"
+	case parsed.Scheme == "amqps":
+		port = "5671"
+	case parsed.Scheme == "amqp":
+		port = "5672"
+	case parsed.Scheme == "mqtts", parsed.Scheme == "ssl", parsed.Scheme == "tls":
+		port = "8883"
+	case parsed.Scheme == "mqtt", parsed.Scheme == "tcp":
+		port = "1883"
+	default:
+		return "", fmt.Errorf("unsupported scheme: %q", parsed.Scheme)
+	}
+
+	return net.JoinHostPort(parsed.Hostname(), port), nil
+}
+
+// natsAddress returns the host and port of a NATS server URL such as
+// nats://host:4222, tls://host or host:4222
+func natsAddress(server string) string {
+	if !strings.Contains(server, "://") {
+		server = "nats://" + server
+	}
+
+	parsed, err := url.Parse(server)
+	if err != nil || parsed.Host == "" {
+		return server
+	}
+	if parsed.Port() == "" {
+		return net.JoinHostPort(parsed.Hostname(), "4222")
+	}
+	return parsed.Host
+}
+
+// checkDial checks that address accepts TCP connections
+func checkDial(address string) error {
+	conn, err := net.DialTimeout("tcp", address, dialTimeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// checkWritable checks that the database file, or the directory it will be
+// created in, is writable
+func checkWritable(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		file, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		return file.Close()
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), ".messagebridge-validate-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// checkDirWritable checks that files can be created in dir, or in the
+// nearest existing directory above it if dir is yet to be created
+func checkDirWritable(dir string) error {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	file, err := os.CreateTemp(dir, ".messagebridge-validate-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
@@ -2,37 +2,86 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"hash/fnv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/expai/messagebridge/config"
 	"github.com/expai/messagebridge/httpclient"
 	"github.com/expai/messagebridge/kafka"
+	"github.com/expai/messagebridge/logging"
+	"github.com/expai/messagebridge/metrics"
 	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/retry"
 	"github.com/expai/messagebridge/storage"
+	"github.com/expai/messagebridge/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// logger is the worker component logger
+var logger = logging.For("worker")
+
+// Sink delivers messages to a type of destination other than Kafka and
+// remote URLs. Errors marked with retry.Permanent fail the delivery right
+// away; any other error schedules a retry.
+type Sink interface {
+	Send(ctx context.Context, target models.DeliveryTarget, msg *models.WebhookMessage) (*models.DeliveryResult, error)
+}
+
 // Worker handles retry logic for failed messages
 type Worker struct {
-	storage       *storage.SQLiteStorage
+	storage       storage.Storage
 	kafkaProducer *kafka.Producer
 	httpClient    *httpclient.Client
-	config        *config.Config
+	sinks         map[models.TargetType]Sink
+	config        atomic.Pointer[config.Config]
 	running       bool
 	stopCh        chan struct{}
+	notifyCh      chan struct{}
 	wg            sync.WaitGroup
 }
 
 // NewWorker creates a new worker instance
-func NewWorker(cfg *config.Config, storage *storage.SQLiteStorage, kafkaProducer *kafka.Producer, httpClient *httpclient.Client) *Worker {
-	return &Worker{
+func NewWorker(cfg *config.Config, storage storage.Storage, kafkaProducer *kafka.Producer, httpClient *httpclient.Client) *Worker {
+	w := &Worker{
 		storage:       storage,
 		kafkaProducer: kafkaProducer,
 		httpClient:    httpClient,
-		config:        cfg,
+		sinks:         make(map[models.TargetType]Sink),
 		stopCh:        make(chan struct{}),
+		notifyCh:      make(chan struct{}, 1),
+	}
+	w.config.Store(cfg)
+	return w
+}
+
+// Reload replaces the worker settings, retry policies and destinations.
+// Messages already being delivered finish with the previous settings.
+func (w *Worker) Reload(cfg *config.Config) {
+	w.config.Store(cfg)
+	w.storage.SetRetryPolicies(retry.NewPolicies(cfg))
+
+	// Wake up the loop so a changed retry interval takes effect
+	w.Notify()
+}
+
+// SetSink sets the sink delivering to destinations of the given type. It
+// must be called before the worker is started.
+func (w *Worker) SetSink(targetType models.TargetType, sink Sink) {
+	w.sinks[targetType] = sink
+}
+
+// Notify wakes up the worker to deliver newly stored messages immediately.
+// Notifications are coalesced and never block the caller.
+func (w *Worker) Notify() {
+	select {
+	case w.notifyCh <- struct{}{}:
+	default:
 	}
 }
 
@@ -50,7 +99,7 @@ func (w *Worker) Start(ctx context.Context) {
 		w.run(ctx)
 	}()
 
-	log.Println("Worker started")
+	logger.Info("Worker started", "concurrency", w.concurrency())
 }
 
 // Stop stops the worker
@@ -62,16 +111,21 @@ func (w *Worker) Stop() {
 	w.running = false
 	close(w.stopCh)
 	w.wg.Wait()
-	log.Println("Worker stopped")
+	logger.Info("Worker stopped")
 }
 
-// run is the main worker loop
+// run is the main worker loop. New messages are dispatched as soon as the
+// handler reports them; the ticker is a fallback sweep for scheduled retries.
 func (w *Worker) run(ctx context.Context) {
-	ticker := time.NewTicker(w.config.Worker.RetryInterval)
+	interval := w.config.Load().Worker.RetryInterval
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	// Messages that failed before the dead-letter queue existed
+	w.deadLetterFailed()
+
 	// Initial run
-	w.processRetries()
+	w.processAvailable(ctx)
 
 	for {
 		select {
@@ -79,96 +133,382 @@ func (w *Worker) run(ctx context.Context) {
 			return
 		case <-w.stopCh:
 			return
+		case <-w.notifyCh:
+			w.processAvailable(ctx)
 		case <-ticker.C:
-			w.processRetries()
+			w.processAvailable(ctx)
+		}
+
+		if current := w.config.Load().Worker.RetryInterval; current != interval {
+			interval = current
+			ticker.Reset(interval)
+		}
+	}
+}
+
+// processAvailable processes batches until no due messages are left
+func (w *Worker) processAvailable(ctx context.Context) {
+	for w.processRetries() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.stopCh:
+			return
+		default:
 		}
 	}
 }
 
-// processRetries processes pending messages for retry
-func (w *Worker) processRetries() {
-	messages, err := w.storage.GetPendingMessages(w.config.Worker.BatchSize)
+// processRetries processes pending messages for retry. It returns true if a
+// full batch was processed without errors and more messages may be waiting.
+func (w *Worker) processRetries() bool {
+	batchSize := w.config.Load().Worker.BatchSize
+	messages, err := w.storage.GetPendingMessages(batchSize)
 	if err != nil {
-		log.Printf("Failed to get pending messages: %v", err)
-		return
+		logger.Error("Failed to get pending messages", "error", err)
+		return false
 	}
 
 	if len(messages) == 0 {
-		return
+		return false
 	}
 
-	log.Printf("Processing %d pending messages", len(messages))
+	logger.Debug("Processing pending messages", "count", len(messages))
 
+	// Spread messages over lanes by ordering key. Lanes deliver in parallel,
+	// messages within a lane are delivered in order.
+	lanes := make([][]*models.PendingMessage, w.concurrency())
 	for _, msg := range messages {
-		if err := w.processMessage(msg); err != nil {
-			log.Printf("Failed to process message %s: %v", msg.ID, err)
+		lane := laneFor(orderingKey(msg), len(lanes))
+		lanes[lane] = append(lanes[lane], msg)
+	}
+
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	for _, lane := range lanes {
+		if len(lane) == 0 {
+			continue
 		}
+
+		wg.Add(1)
+		go func(lane []*models.PendingMessage) {
+			defer wg.Done()
+			if !w.processLane(lane) {
+				failed.Store(true)
+			}
+		}(lane)
 	}
+	wg.Wait()
+
+	return !failed.Load() && len(messages) == batchSize
 }
 
-// processMessage processes a single message
-func (w *Worker) processMessage(msg *models.PendingMessage) error {
-	// Check if we've exceeded max retries (0 means unlimited retries)
-	if w.config.Worker.MaxRetries > 0 && msg.Retries >= w.config.Worker.MaxRetries {
-		log.Printf("Message %s exceeded max retries (%d), marking as failed", msg.ID, w.config.Worker.MaxRetries)
-		return w.storage.UpdateMessageStatus(msg.ID, models.StatusFailed, "Exceeded max retries")
+// processLane delivers messages in order. Once a message of an ordering key
+// is not fully delivered, later messages with the same key are held back.
+// It returns false if any message could not be processed.
+func (w *Worker) processLane(messages []*models.PendingMessage) bool {
+	ok := true
+	blocked := make(map[string]bool)
+
+	for _, msg := range messages {
+		key := orderingKey(msg)
+		if blocked[key] {
+			// Let another instance sharing the storage pick it up later
+			if err := w.storage.ReleaseMessage(msg.ID); err != nil {
+				logger.Error("Failed to release message", "message_id", msg.ID, "route", msg.Path, "error", err)
+			}
+			continue
+		}
+
+		status, err := w.processMessage(msg)
+		if err != nil {
+			logger.Error("Failed to process message", "message_id", msg.ID, "route", msg.Path, "error", err)
+			ok = false
+			if err := w.storage.ReleaseMessage(msg.ID); err != nil {
+				logger.Error("Failed to release message", "message_id", msg.ID, "route", msg.Path, "error", err)
+			}
+		}
+		if err != nil || status != models.StatusSent {
+			blocked[key] = true
+		}
 	}
 
-	// Log retry attempt
-	if w.config.Worker.MaxRetries == 0 {
-		log.Printf("Retrying message %s (attempt %d, unlimited retries enabled)", msg.ID, msg.Retries+1)
-	} else {
-		log.Printf("Retrying message %s (attempt %d/%d)", msg.ID, msg.Retries+1, w.config.Worker.MaxRetries)
+	return ok
+}
+
+// concurrency returns the number of delivery lanes
+func (w *Worker) concurrency() int {
+	concurrency := w.config.Load().Worker.Concurrency
+	if concurrency < 1 {
+		return 1
+	}
+	return concurrency
+}
+
+// orderingKey returns the key messages are ordered by; messages without one
+// (stored before ordering keys existed) are independent of each other
+func orderingKey(msg *models.PendingMessage) string {
+	if msg.OrderingKey != "" {
+		return msg.OrderingKey
 	}
+	return msg.ID
+}
+
+// laneFor maps an ordering key to a lane
+func laneFor(key string, lanes int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(lanes))
+}
+
+// processMessage processes a single message, attempting each of its
+// undelivered destinations independently, and returns the resulting status.
+// The work is traced as part of the trace the message was received in.
+func (w *Worker) processMessage(msg *models.PendingMessage) (models.MessageStatus, error) {
+	ctx, span := tracing.Start(tracing.MessageContext(msg.WebhookMessage), "worker.process",
+		trace.WithAttributes(
+			attribute.String("messaging.message.id", msg.ID),
+			attribute.String("http.route", msg.Path),
+			attribute.Int("messagebridge.retries", msg.Retries),
+		),
+	)
+
+	status, err := w.deliverMessage(ctx, msg)
+
+	span.SetAttributes(attribute.String("messagebridge.status", string(status)))
+	tracing.End(span, err)
 
-	// Determine delivery target
-	target := w.getDeliveryTarget()
+	return status, err
+}
+
+// deliverMessage attempts the due deliveries of a message and completes it
+// once every destination has received it or one has failed permanently
+func (w *Worker) deliverMessage(ctx context.Context, msg *models.PendingMessage) (models.MessageStatus, error) {
+	deliveries := msg.Deliveries
+	if len(deliveries) == 0 {
+		// Messages stored before per-destination tracking have no deliveries yet.
+		// Those stored before per-route destinations fall back to the global target.
+		targets := msg.Destinations
+		if len(targets) == 0 {
+			targets = []models.DeliveryTarget{*w.getDeliveryTarget()}
+		}
 
+		var err error
+		deliveries, err = w.storage.AddDeliveries(msg.ID, targets)
+		if err != nil {
+			return msg.Status, fmt.Errorf("failed to create deliveries: %w", err)
+		}
+	}
+
+	now := time.Now()
+	status := msg.Status
+	attempted := false
+	for _, delivery := range deliveries {
+		if delivery.Status == models.StatusSent || delivery.Status == models.StatusFailed || !delivery.Due(now) {
+			continue
+		}
+
+		var err error
+		attempted = true
+		status, err = w.processDelivery(ctx, msg.WebhookMessage, delivery)
+		if err != nil {
+			return status, err
+		}
+	}
+
+	if status == models.StatusFailed {
+		return status, w.deadLetter(msg.ID)
+	}
+	if status != models.StatusSent {
+		if !attempted {
+			// No delivery was due, so no status update ended the lease
+			return status, w.storage.ReleaseMessage(msg.ID)
+		}
+		return status, nil
+	}
+
+	// Success - every destination received the message
+	logger.Debug("Message delivered to all destinations, removing from storage", "message_id", msg.ID, "route", msg.Path)
+	return status, w.storage.DeleteMessage(msg.ID)
+}
+
+// processDelivery attempts a single destination of a message and returns
+// the resulting overall status of the message
+func (w *Worker) processDelivery(ctx context.Context, msg *models.WebhookMessage, delivery *models.Delivery) (models.MessageStatus, error) {
+	target := delivery.Target
+	policy := retry.NewPolicy(w.config.Load().RetryPolicyFor(msg.Path))
+
+	// Check if the retry policy gives up on this delivery
+	if policy.Exhausted(delivery.Retries, msg.CreatedAt, time.Now()) {
+		logger.Warn("Delivery exceeded retry policy, marking as failed",
+			"message_id", msg.ID, "route", msg.Path, "destination", metrics.Destination(metricsTarget(msg, target)), "attempt", delivery.Retries)
+		reason := "Exceeded max retries"
+		if delivery.Error != "" {
+			reason += ": " + delivery.Error
+		}
+		metrics.ObserveDelivery(metricsTarget(msg, target), models.StatusFailed)
+		return w.storage.UpdateDeliveryStatus(msg.ID, target, models.StatusFailed, reason, time.Time{})
+	}
+
+	// Log retry attempt
+	logger.Debug("Delivering message",
+		"message_id", msg.ID, "route", msg.Path, "queue", msg.Queue, "destination", metrics.Destination(metricsTarget(msg, target)),
+		"attempt", delivery.Retries+1, "max_attempts", policy.MaxAttempts)
+
+	ctx, span := tracing.Start(ctx, "worker.deliver",
+		trace.WithAttributes(
+			attribute.String("messagebridge.destination.type", string(target.Type)),
+			attribute.String("messagebridge.destination", metrics.Destination(metricsTarget(msg, target))),
+			attribute.Int("messagebridge.attempt", delivery.Retries+1),
+		),
+	)
+
+	start := time.Now()
+
+	var result *models.DeliveryResult
 	var err error
 	switch target.Type {
-	case models.TargetKafka:
-		err = w.sendToKafka(msg.WebhookMessage)
 	case models.TargetRemoteURL:
-		err = w.sendToRemoteURL(msg.WebhookMessage)
+		result, err = w.sendToRemoteURL(ctx, target.URL, msg)
+	case models.TargetKafka, "":
+		result, err = w.sendToKafka(ctx, target.Topic, msg) // Default to Kafka
 	default:
-		err = w.sendToKafka(msg.WebhookMessage) // Default to Kafka
+		result, err = w.sendToSink(ctx, target, msg)
+	}
+
+	tracing.End(span, err)
+	w.recordAttempt(msg, target, start, result, err)
+	metrics.ObserveAttempt(metricsTarget(msg, target), start, err)
+
+	if retry.IsPermanent(err) {
+		logger.Warn("Delivery failed permanently",
+			"message_id", msg.ID, "route", msg.Path, "queue", msg.Queue, "destination", metrics.Destination(metricsTarget(msg, target)),
+			"attempt", delivery.Retries+1, "duration_ms", time.Since(start).Milliseconds(), "error", err)
+		metrics.ObserveDelivery(metricsTarget(msg, target), models.StatusFailed)
+		return w.storage.UpdateDeliveryStatus(msg.ID, target, models.StatusFailed, err.Error(), time.Time{})
+	}
+	if err != nil {
+		nextRetryAt := policy.NextRetryAt(delivery.Retries+1, time.Now())
+		logger.Warn("Delivery failed",
+			"message_id", msg.ID, "route", msg.Path, "queue", msg.Queue, "destination", metrics.Destination(metricsTarget(msg, target)),
+			"attempt", delivery.Retries+1, "duration_ms", time.Since(start).Milliseconds(),
+			"next_attempt", nextRetryAt.Format(time.RFC3339), "error", err)
+		return w.storage.UpdateDeliveryStatus(msg.ID, target, models.StatusRetrying, err.Error(), nextRetryAt)
+	}
+
+	metrics.ObserveDelivery(metricsTarget(msg, target), models.StatusSent)
+	return w.storage.UpdateDeliveryStatus(msg.ID, target, models.StatusSent, "", time.Time{})
+}
+
+// metricsTarget returns the target as reported in metrics, with the topic
+// Kafka deliveries without one are sent to
+func metricsTarget(msg *models.WebhookMessage, target models.DeliveryTarget) models.DeliveryTarget {
+	if target.Type != models.TargetRemoteURL && target.Topic == "" {
+		target.Topic = msg.Queue
+	}
+	return target
+}
+
+// deadLetter moves a permanently failed message to the dead-letter queue
+func (w *Worker) deadLetter(id string) error {
+	deadLetter, err := w.storage.MoveToDeadLetter(id)
+	if err != nil {
+		return fmt.Errorf("failed to move message to dead-letter queue: %w", err)
 	}
 
+	logger.Warn("Message moved to dead-letter queue", "message_id", id, "route", deadLetter.Path, "error", deadLetter.Error)
+
+	// The table is the source of truth, the topic is a best-effort copy
+	if cfg := w.config.Load(); cfg.DeadLetter != nil && cfg.DeadLetter.KafkaTopic != "" && w.kafkaProducer != nil {
+		if err := w.kafkaProducer.SendDeadLetter(cfg.DeadLetter.KafkaTopic, deadLetter); err != nil {
+			logger.Error("Failed to publish dead letter to Kafka", "message_id", id, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// deadLetterFailed moves failed messages left in the queue to the dead-letter queue
+func (w *Worker) deadLetterFailed() {
+	ids, err := w.storage.GetFailedMessageIDs()
 	if err != nil {
-		log.Printf("Failed to send message %s: %v", msg.ID, err)
-		return w.storage.UpdateMessageStatus(msg.ID, models.StatusRetrying, err.Error())
+		logger.Error("Failed to get failed messages", "error", err)
+		return
+	}
+
+	for _, id := range ids {
+		err := w.deadLetter(id)
+		if errors.Is(err, storage.ErrNotFound) {
+			// Already moved by another instance sharing the storage
+			continue
+		}
+		if err != nil {
+			logger.Error("Failed to dead-letter message", "message_id", id, "error", err)
+		}
 	}
+}
 
-	// Success - remove from storage or mark as sent
-	log.Printf("Message %s sent successfully, removing from storage", msg.ID)
-	return w.storage.DeleteMessage(msg.ID)
+// recordAttempt stores the outcome of a delivery attempt in the attempt history
+func (w *Worker) recordAttempt(msg *models.WebhookMessage, target models.DeliveryTarget, start time.Time, result *models.DeliveryResult, sendErr error) {
+	attempt := &models.Attempt{
+		MessageID:   msg.ID,
+		Path:        msg.Path,
+		Target:      target,
+		AttemptedAt: start,
+		DurationMs:  time.Since(start).Milliseconds(),
+	}
+	if result != nil {
+		attempt.DeliveryResult = *result
+	}
+	if sendErr != nil {
+		attempt.Error = sendErr.Error()
+	}
+
+	if err := w.storage.RecordAttempt(attempt); err != nil {
+		logger.Error("Failed to record delivery attempt", "message_id", msg.ID, "destination", metrics.Destination(metricsTarget(msg, target)), "error", err)
+	}
 }
 
-// sendToKafka sends message to Kafka
-func (w *Worker) sendToKafka(msg *models.WebhookMessage) error {
+// sendToKafka sends message to Kafka, using the message queue when topic is empty
+func (w *Worker) sendToKafka(ctx context.Context, topic string, msg *models.WebhookMessage) (*models.DeliveryResult, error) {
 	if w.kafkaProducer == nil {
-		return fmt.Errorf("Kafka producer not available")
+		return nil, fmt.Errorf("Kafka producer not available")
 	}
 
-	return w.kafkaProducer.SendMessage(msg)
+	if topic == "" {
+		topic = msg.Queue
+	}
+	return w.kafkaProducer.SendMessageToTopic(ctx, topic, msg)
 }
 
-// sendToRemoteURL sends message to remote URL
-func (w *Worker) sendToRemoteURL(msg *models.WebhookMessage) error {
+// sendToRemoteURL sends message to remote URL, using the global URL when url is empty
+func (w *Worker) sendToRemoteURL(ctx context.Context, url string, msg *models.WebhookMessage) (*models.DeliveryResult, error) {
 	if w.httpClient == nil {
-		return fmt.Errorf("HTTP client not available")
+		return nil, fmt.Errorf("HTTP client not available")
 	}
 
-	return w.httpClient.SendMessage(msg)
+	if url == "" {
+		url = w.config.Load().RemoteURL.URL
+	}
+	return w.httpClient.SendMessageToURL(ctx, url, msg)
+}
+
+// sendToSink sends message to a destination delivered by a sink
+func (w *Worker) sendToSink(ctx context.Context, target models.DeliveryTarget, msg *models.WebhookMessage) (*models.DeliveryResult, error) {
+	sink, ok := w.sinks[target.Type]
+	if !ok {
+		return nil, fmt.Errorf("no sink available for %s destinations", target.Type)
+	}
+	return sink.Send(ctx, target, msg)
 }
 
 // getDeliveryTarget determines where to send the message
 func (w *Worker) getDeliveryTarget() *models.DeliveryTarget {
 	// If remote URL is configured, prefer it
-	if w.config.RemoteURL != nil && w.config.RemoteURL.URL != "" {
+	if cfg := w.config.Load(); cfg.RemoteURL != nil && cfg.RemoteURL.URL != "" {
 		return &models.DeliveryTarget{
 			Type: models.TargetRemoteURL,
-			URL:  w.config.RemoteURL.URL,
+			URL:  cfg.RemoteURL.URL,
 		}
 	}
 
@@ -185,18 +525,28 @@ func (w *Worker) GetStats() (map[string]interface{}, error) {
 		return nil, err
 	}
 
+	cfg := w.config.Load()
+	policy := cfg.RetryPolicyFor("")
+
+	var schedule []string
+	for _, delay := range retry.NewPolicy(policy).Schedule(5) {
+		schedule = append(schedule, delay.String())
+	}
+
 	var maxRetriesDisplay interface{}
-	if w.config.Worker.MaxRetries == 0 {
+	if policy.MaxAttempts == 0 {
 		maxRetriesDisplay = "unlimited"
 	} else {
-		maxRetriesDisplay = w.config.Worker.MaxRetries
+		maxRetriesDisplay = policy.MaxAttempts
 	}
 
 	stats := map[string]interface{}{
 		"running":        w.running,
-		"retry_interval": w.config.Worker.RetryInterval.String(),
-		"batch_size":     w.config.Worker.BatchSize,
+		"retry_interval": cfg.Worker.RetryInterval.String(),
+		"batch_size":     cfg.Worker.BatchSize,
+		"concurrency":    w.concurrency(),
 		"max_retries":    maxRetriesDisplay,
+		"retry_schedule": schedule,
 		"message_stats":  messageStats,
 	}
 
@@ -207,10 +557,10 @@ func (w *Worker) GetStats() (map[string]interface{}, error) {
 func (w *Worker) Cleanup() error {
 	// Clean up old sent messages (keep for 7 days)
 	if err := w.storage.Cleanup(7); err != nil {
-		log.Printf("Failed to cleanup old messages: %v", err)
+		logger.Error("Failed to cleanup old messages", "error", err)
 		return err
 	}
 
-	log.Println("Cleanup completed successfully")
+	logger.Info("Cleanup completed successfully")
 	return nil
 }
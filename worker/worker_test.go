@@ -0,0 +1,465 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/expai/messagebridge/config"
+	"github.com/expai/messagebridge/metrics"
+	"github.com/expai/messagebridge/models"
+	"github.com/expai/messagebridge/retry"
+	"github.com/expai/messagebridge/storage"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	redisTarget = models.DeliveryTarget{Type: models.TargetRedis, Topic: "orders"}
+	natsTarget  = models.DeliveryTarget{Type: models.TargetNATS, Topic: "orders"}
+)
+
+// fakeSink records the messages sent to it and fails those it was told to
+type fakeSink struct {
+	mu       sync.Mutex
+	sent     []string
+	contexts []context.Context
+	errs     map[string]error
+}
+
+// Send records msg and returns the error set for it, if any
+func (s *fakeSink) Send(ctx context.Context, target models.DeliveryTarget, msg *models.WebhookMessage) (*models.DeliveryResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sent = append(s.sent, msg.ID)
+	s.contexts = append(s.contexts, ctx)
+	if err := s.errs[msg.ID]; err != nil {
+		return nil, err
+	}
+	return &models.DeliveryResult{Response: target.Topic + "-" + msg.ID}, nil
+}
+
+// fail makes deliveries of the message with id fail with err, or succeed
+// again when err is nil
+func (s *fakeSink) fail(id string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.errs == nil {
+		s.errs = make(map[string]error)
+	}
+	s.errs[id] = err
+}
+
+// sentIDs returns the IDs of the messages sent, in order
+func (s *fakeSink) sentIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.sent)
+}
+
+// newSinks sets a Redis and a NATS sink on w and returns them
+func newSinks(w *Worker) (*fakeSink, *fakeSink) {
+	redis, nats := &fakeSink{}, &fakeSink{}
+	w.SetSink(models.TargetRedis, redis)
+	w.SetSink(models.TargetNATS, nats)
+	return redis, nats
+}
+
+// recordingStorage records the messages released back to the queue
+type recordingStorage struct {
+	storage.Storage
+
+	mu       sync.Mutex
+	released []string
+}
+
+// ReleaseMessage records id and releases the message
+func (s *recordingStorage) ReleaseMessage(id string) error {
+	s.mu.Lock()
+	s.released = append(s.released, id)
+	s.mu.Unlock()
+	return s.Storage.ReleaseMessage(id)
+}
+
+// releasedIDs returns the IDs of the released messages
+func (s *recordingStorage) releasedIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.released)
+}
+
+// newTestWorker returns a worker on in-memory storage with the default
+// retry policy
+func newTestWorker(t *testing.T) (*Worker, *recordingStorage) {
+	t.Helper()
+
+	cfg := &config.Config{Worker: config.WorkerConfig{BatchSize: 10, Concurrency: 1, RetryPolicy: &config.RetryPolicyConfig{}}}
+	store := &recordingStorage{Storage: storage.NewMemoryStorage()}
+	return NewWorker(cfg, store, nil, nil), store
+}
+
+// saveMessage stores a message for targets received age ago and returns it
+// as claimed by the worker
+func saveMessage(t *testing.T, s storage.Storage, id, orderingKey string, age time.Duration, targets ...models.DeliveryTarget) *models.PendingMessage {
+	t.Helper()
+
+	received := time.Now().Add(-age)
+	msg := &models.WebhookMessage{
+		ID:           id,
+		Path:         "/webhook/orders",
+		Queue:        "orders",
+		Body:         []byte(`{"id":"` + id + `"}`),
+		Headers:      map[string]string{},
+		Timestamp:    received,
+		Status:       models.StatusPending,
+		Destinations: targets,
+		OrderingKey:  orderingKey,
+		CreatedAt:    received,
+		UpdatedAt:    received,
+	}
+	if err := s.SaveMessage(msg); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	pending, err := s.GetMessage(id)
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	return pending
+}
+
+func TestLaneReleasesMessagesWithoutDueDeliveries(t *testing.T) {
+	w, store := newTestWorker(t)
+	target := models.DeliveryTarget{Type: models.TargetKafka, Topic: "orders"}
+
+	// The only delivery is scheduled for later, e.g. claimed just before
+	// another instance rescheduled it
+	first := saveMessage(t, store, "msg-1", "orders", time.Hour, target)
+	if _, err := store.UpdateDeliveryStatus(first.ID, target, models.StatusRetrying, "timeout", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("UpdateDeliveryStatus: %v", err)
+	}
+	first, _ = store.GetMessage(first.ID)
+	second := saveMessage(t, store, "msg-2", "orders", time.Minute, target)
+
+	if !w.processLane([]*models.PendingMessage{first, second}) {
+		t.Fatal("processLane reported an error")
+	}
+
+	// Nothing was attempted, so both leases end: the first message's because
+	// no update ended it, the second's because it waits behind the first
+	if released := store.releasedIDs(); !slices.Equal(released, []string{"msg-1", "msg-2"}) {
+		t.Errorf("released %v, want msg-1 and msg-2", released)
+	}
+	if msg, err := store.GetMessage("msg-1"); err != nil || msg.Deliveries[0].Retries != 1 {
+		t.Errorf("msg-1 = %v, %v, want its delivery untouched", msg, err)
+	}
+}
+
+func TestFanOutDeliversEachDestinationOnce(t *testing.T) {
+	w, store := newTestWorker(t)
+	redis, nats := newSinks(w)
+	nats.fail("msg-1", errors.New("nats unavailable"))
+
+	msg := saveMessage(t, store, "msg-1", "orders", time.Minute, redisTarget, natsTarget)
+	status, err := w.processMessage(msg)
+	if err != nil || status != models.StatusRetrying {
+		t.Fatalf("processMessage = %s, %v, want retrying", status, err)
+	}
+
+	// Each destination has its own state
+	msg, err = store.GetMessage("msg-1")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if sent := msg.Deliveries[0]; sent.Status != models.StatusSent || sent.Retries != 1 {
+		t.Errorf("redis delivery = %+v, want sent after 1 attempt", sent)
+	}
+	if retrying := msg.Deliveries[1]; retrying.Status != models.StatusRetrying || retrying.Retries != 1 || retrying.Error != "nats unavailable" {
+		t.Errorf("nats delivery = %+v, want retrying after 1 attempt", retrying)
+	}
+
+	// Only the failed destination is attempted again, and the message is
+	// removed once both have received it
+	nats.fail("msg-1", nil)
+	if status, err := w.processMessage(msg); err != nil || status != models.StatusSent {
+		t.Fatalf("processMessage = %s, %v, want sent", status, err)
+	}
+	if sent := redis.sentIDs(); !slices.Equal(sent, []string{"msg-1"}) {
+		t.Errorf("redis received %v, want msg-1 once", sent)
+	}
+	if sent := nats.sentIDs(); !slices.Equal(sent, []string{"msg-1", "msg-1"}) {
+		t.Errorf("nats received %v, want msg-1 twice", sent)
+	}
+	if _, err := store.GetMessage("msg-1"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetMessage after delivery = %v, want %v", err, storage.ErrNotFound)
+	}
+}
+
+func TestPermanentFailureDeadLettersAfterOtherDestinations(t *testing.T) {
+	w, store := newTestWorker(t)
+	redis, nats := newSinks(w)
+	redis.fail("msg-1", retry.Permanent(errors.New("WRONGTYPE")))
+
+	msg := saveMessage(t, store, "msg-1", "orders", time.Minute, redisTarget, natsTarget)
+	if status, err := w.processMessage(msg); err != nil || status != models.StatusFailed {
+		t.Fatalf("processMessage = %s, %v, want failed", status, err)
+	}
+
+	// The other destination still received the message
+	if sent := nats.sentIDs(); !slices.Equal(sent, []string{"msg-1"}) {
+		t.Errorf("nats received %v, want msg-1", sent)
+	}
+
+	deadLetter, err := store.GetDeadLetter("msg-1")
+	if err != nil {
+		t.Fatalf("GetDeadLetter: %v", err)
+	}
+	if len(deadLetter.Deliveries) != 2 || deadLetter.Deliveries[0].Status != models.StatusFailed || deadLetter.Deliveries[1].Status != models.StatusSent {
+		t.Errorf("dead letter deliveries = %+v, want failed redis and sent nats", deadLetter.Deliveries)
+	}
+	if deadLetter.Error != "WRONGTYPE" {
+		t.Errorf("dead letter error = %q, want the permanent error", deadLetter.Error)
+	}
+}
+
+func TestExhaustedRetriesFailDelivery(t *testing.T) {
+	w, store := newTestWorker(t)
+	w.config.Load().Worker.RetryPolicy.MaxAttempts = 2
+	redis, _ := newSinks(w)
+	redis.fail("msg-1", errors.New("timeout"))
+
+	msg := saveMessage(t, store, "msg-1", "orders", time.Minute, redisTarget)
+	for range 2 {
+		if status, err := w.processMessage(msg); err != nil || status != models.StatusRetrying {
+			t.Fatalf("processMessage = %s, %v, want retrying", status, err)
+		}
+		msg, _ = store.GetMessage("msg-1")
+	}
+
+	// The policy gives up without another attempt
+	if status, err := w.processMessage(msg); err != nil || status != models.StatusFailed {
+		t.Fatalf("processMessage = %s, %v, want failed", status, err)
+	}
+	if sent := redis.sentIDs(); len(sent) != 2 {
+		t.Errorf("redis received %v, want 2 attempts", sent)
+	}
+	if deadLetter, err := store.GetDeadLetter("msg-1"); err != nil || deadLetter.Error != "Exceeded max retries: timeout" {
+		t.Errorf("dead letter = %v, %v, want exceeded max retries", deadLetter, err)
+	}
+}
+
+// waitForSent waits until sink has received want messages
+func waitForSent(t *testing.T, sink *fakeSink, want int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sink.sentIDs()) < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("sink received %v, want %d messages", sink.sentIDs(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNotifyDeliversWithoutWaitingForTheInterval(t *testing.T) {
+	w, store := newTestWorker(t)
+	w.config.Load().Worker.RetryInterval = time.Hour
+	redis, _ := newSinks(w)
+
+	saveMessage(t, store, "msg-1", "msg-1", time.Minute, redisTarget)
+	w.Start(context.Background())
+	t.Cleanup(w.Stop)
+
+	// Messages stored before the start are delivered right away
+	waitForSent(t, redis, 1)
+
+	// Later ones as soon as the worker is notified, not on the next sweep
+	saveMessage(t, store, "msg-2", "msg-2", 0, redisTarget)
+	w.Notify()
+	waitForSent(t, redis, 2)
+
+	// Notifications are coalesced and never block
+	for range 100 {
+		w.Notify()
+	}
+}
+
+func TestLaneHoldsBackKeyAfterUndeliveredMessage(t *testing.T) {
+	w, store := newTestWorker(t)
+	redis, _ := newSinks(w)
+	redis.fail("msg-1", errors.New("timeout"))
+
+	messages := []*models.PendingMessage{
+		saveMessage(t, store, "msg-1", "order-1", 4*time.Minute, redisTarget),
+		saveMessage(t, store, "msg-2", "order-1", 3*time.Minute, redisTarget),
+		saveMessage(t, store, "msg-3", "order-2", 2*time.Minute, redisTarget),
+		// Messages stored without an ordering key do not wait for each other
+		saveMessage(t, store, "msg-4", "", time.Minute, redisTarget),
+	}
+	redis.fail("msg-4", errors.New("timeout"))
+	messages = append(messages, saveMessage(t, store, "msg-5", "", 0, redisTarget))
+
+	if !w.processLane(messages) {
+		t.Fatal("processLane reported an error")
+	}
+
+	// msg-2 waits for msg-1 and is handed back untouched
+	if sent := redis.sentIDs(); !slices.Equal(sent, []string{"msg-1", "msg-3", "msg-4", "msg-5"}) {
+		t.Errorf("sent %v, want msg-1, msg-3, msg-4 and msg-5", sent)
+	}
+	if released := store.releasedIDs(); !slices.Equal(released, []string{"msg-2"}) {
+		t.Errorf("released %v, want msg-2", released)
+	}
+	if msg, err := store.GetMessage("msg-2"); err != nil || msg.Status != models.StatusPending || msg.Deliveries[0].Retries != 0 {
+		t.Errorf("msg-2 = %v, %v, want it pending without attempts", msg, err)
+	}
+}
+
+func TestLanesKeepOrderPerKey(t *testing.T) {
+	w, store := newTestWorker(t)
+	w.config.Load().Worker.Concurrency = 4
+	w.config.Load().Worker.BatchSize = 100
+	redis, _ := newSinks(w)
+
+	keys := []string{"order-1", "order-2", "order-3", "order-4", "order-5"}
+	want := make(map[string][]string)
+	keyOf := make(map[string]string)
+	for i := range 40 {
+		key := keys[i%len(keys)]
+		id := fmt.Sprintf("msg-%02d", i)
+		saveMessage(t, store, id, key, time.Duration(40-i)*time.Second, redisTarget)
+		want[key] = append(want[key], id)
+		keyOf[id] = key
+	}
+
+	for w.processRetries() {
+	}
+
+	// Lanes run in parallel, but each key is delivered in the order received
+	got := make(map[string][]string)
+	for _, id := range redis.sentIDs() {
+		got[keyOf[id]] = append(got[keyOf[id]], id)
+	}
+	for _, key := range keys {
+		if !slices.Equal(got[key], want[key]) {
+			t.Errorf("%s delivered as %v, want %v", key, got[key], want[key])
+		}
+	}
+
+	// A key always maps to the same lane
+	for _, key := range keys {
+		if lane := laneFor(key, 4); lane < 0 || lane >= 4 || laneFor(key, 4) != lane {
+			t.Errorf("laneFor(%q, 4) = %d, want a stable lane below 4", key, lane)
+		}
+	}
+}
+
+func TestAttemptsAreRecorded(t *testing.T) {
+	w, store := newTestWorker(t)
+	redis, _ := newSinks(w)
+	redis.fail("msg-1", errors.New("timeout"))
+
+	msg := saveMessage(t, store, "msg-1", "orders", time.Minute, redisTarget)
+	w.processMessage(msg)
+	redis.fail("msg-1", nil)
+	msg, _ = store.GetMessage("msg-1")
+	w.processMessage(msg)
+
+	// The history outlives the delivered message
+	attempts, err := store.GetAttempts("msg-1")
+	if err != nil {
+		t.Fatalf("GetAttempts: %v", err)
+	}
+	if len(attempts) != 2 {
+		t.Fatalf("got %d attempts, want 2", len(attempts))
+	}
+	for _, attempt := range attempts {
+		if attempt.Path != "/webhook/orders" || attempt.Target != redisTarget || attempt.AttemptedAt.IsZero() || attempt.DurationMs < 0 {
+			t.Errorf("attempt = %+v, want the route, destination and time", attempt)
+		}
+	}
+	if failed := attempts[0]; failed.Error != "timeout" || failed.Response != "" {
+		t.Errorf("first attempt = %+v, want the error", failed)
+	}
+	if sent := attempts[1]; sent.Error != "" || sent.Response != "orders-msg-1" {
+		t.Errorf("second attempt = %+v, want the sink result", sent)
+	}
+}
+
+func TestDeliveryMetricsLabels(t *testing.T) {
+	w, store := newTestWorker(t)
+	redis, _ := newSinks(w)
+	redis.fail("msg-1", errors.New("timeout"))
+
+	count := func(c *prometheus.CounterVec, labels ...string) float64 {
+		return testutil.ToFloat64(c.WithLabelValues(labels...))
+	}
+	redisErrors := count(metrics.DeliveryAttempts, "redis", "orders", "error")
+	redisSent := count(metrics.Deliveries, "redis", "orders", "sent")
+	kafkaErrors := count(metrics.DeliveryAttempts, "kafka", "orders", "error")
+
+	// Kafka deliveries without a topic are reported under the route queue
+	msg := saveMessage(t, store, "msg-1", "orders", time.Minute, redisTarget, models.DeliveryTarget{Type: models.TargetKafka})
+	w.processMessage(msg)
+	redis.fail("msg-1", nil)
+	msg, _ = store.GetMessage("msg-1")
+	w.processMessage(msg)
+
+	if got := count(metrics.DeliveryAttempts, "redis", "orders", "error") - redisErrors; got != 1 {
+		t.Errorf("redis error attempts = %v, want 1", got)
+	}
+	if got := count(metrics.Deliveries, "redis", "orders", "sent") - redisSent; got != 1 {
+		t.Errorf("redis sent deliveries = %v, want 1", got)
+	}
+	if got := count(metrics.DeliveryAttempts, "kafka", "orders", "error") - kafkaErrors; got != 2 {
+		t.Errorf("kafka error attempts = %v, want 2", got)
+	}
+}
+
+func TestDeliveryContinuesIngestTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	w, store := newTestWorker(t)
+	redis, _ := newSinks(w)
+
+	const traceID = "0af7651916cd43dd8448eb211c80319c"
+	const ingestSpanID = "b7ad6b7169203331"
+	msg := saveMessage(t, store, "msg-1", "orders", time.Minute, redisTarget)
+	msg.TraceParent = "00-" + traceID + "-" + ingestSpanID + "-01"
+	if status, err := w.processMessage(msg); err != nil || status != models.StatusSent {
+		t.Fatalf("processMessage = %s, %v, want sent", status, err)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	process, deliver := spans["worker.process"], spans["worker.deliver"]
+	if process == nil || deliver == nil {
+		t.Fatalf("recorded spans %v, want worker.process and worker.deliver", spans)
+	}
+	if process.SpanContext().TraceID().String() != traceID || process.Parent().SpanID().String() != ingestSpanID {
+		t.Errorf("worker.process parent = %v, want the ingest span", process.Parent())
+	}
+	if deliver.Parent().SpanID() != process.SpanContext().SpanID() {
+		t.Errorf("worker.deliver parent = %v, want worker.process", deliver.Parent())
+	}
+
+	// The sink sends within the delivery span
+	if got := trace.SpanContextFromContext(redis.contexts[0]); got.SpanID() != deliver.SpanContext().SpanID() {
+		t.Errorf("sink context span = %v, want worker.deliver", got.SpanID())
+	}
+}